@@ -2,6 +2,7 @@ package app
 
 import (
 	"crane-system/config"
+	"crane-system/controllers"
 	"crane-system/database"
 	"crane-system/router"
 	"fmt"
@@ -77,5 +78,9 @@ func BootstrapServer() (*config.Config, *http.Server, error) {
 
 	engine := BuildHTTPServer()
 	srv := BuildAppServer(engine, ServerAddress(cfg))
+	srv.RegisterOnShutdown(controllers.StartReportScheduler())
+	srv.RegisterOnShutdown(controllers.StartImportWatcher())
+	srv.RegisterOnShutdown(controllers.StartJobWorkers())
+	srv.RegisterOnShutdown(controllers.StartPermissionGrantExpiry())
 	return cfg, srv, nil
 }
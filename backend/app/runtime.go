@@ -7,7 +7,10 @@ import (
 )
 
 func EnsureRuntimeDirs(cfg *config.Config) error {
-	for _, dir := range []string{cfg.Storage.UploadsDir, cfg.Backup.Dir} {
+	for _, dir := range []string{cfg.Storage.UploadsDir, cfg.Storage.BatchImportDir, cfg.Backup.Dir, cfg.Report.Dir} {
+		if dir == "" {
+			continue
+		}
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return fmt.Errorf("create runtime dir %s: %w", dir, err)
 		}
@@ -5,7 +5,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -31,25 +33,52 @@ type AuthSection struct {
 }
 
 type StorageSection struct {
-	UploadsDir    string
-	MaxUploadSize int64
+	UploadsDir     string
+	MaxUploadSize  int64
+	BatchImportDir string // 保留批量导入的压缩包，供失败条目重试
 }
 
 type BackupSection struct {
 	Dir string
 }
 
+// ReportSection 是定时报表的投递配置；目录投递只能写入 Dir 之下。
+// webhook 只能投递到公网地址，WebhookAllowedHosts 中的主机不受此限制，用于内网接收端。
+type ReportSection struct {
+	Dir                 string
+	SMTPHost            string
+	SMTPPort            string
+	SMTPUsername        string
+	SMTPPassword        string
+	SMTPFrom            string
+	WebhookAllowedHosts []string
+}
+
+// ImportWatchSection 是服务器目录监视导入的配置；Roots 为空时不启用，监视目录只能位于 Roots 之下。
+type ImportWatchSection struct {
+	Roots    []string
+	Interval time.Duration
+}
+
+// JobsSection 是后台任务队列的配置；多个实例共享同一数据库时各自启动 Workers 个执行者。
+type JobsSection struct {
+	Workers int
+}
+
 type CORSSection struct {
 	AllowedOrigins []string
 }
 
 type Config struct {
-	App      AppSection
-	Database DatabaseSection
-	Auth     AuthSection
-	Storage  StorageSection
-	Backup   BackupSection
-	CORS     CORSSection
+	App         AppSection
+	Database    DatabaseSection
+	Auth        AuthSection
+	Storage     StorageSection
+	Backup      BackupSection
+	Report      ReportSection
+	ImportWatch ImportWatchSection
+	Jobs        JobsSection
+	CORS        CORSSection
 }
 
 var AppConfig *Config
@@ -112,12 +141,29 @@ func LoadConfig() error {
 			DefaultPassword: os.Getenv("DEFAULT_PASSWORD"),
 		},
 		Storage: StorageSection{
-			UploadsDir:    cleanPath(getEnv("UPLOADS_DIR", "./uploads")),
-			MaxUploadSize: 100 * 1024 * 1024,
+			UploadsDir:     cleanPath(getEnv("UPLOADS_DIR", "./uploads")),
+			MaxUploadSize:  100 * 1024 * 1024,
+			BatchImportDir: cleanPath(getEnv("BATCH_IMPORT_DIR", "./batch-imports")),
 		},
 		Backup: BackupSection{
 			Dir: cleanPath(getEnv("BACKUPS_DIR", "./backups")),
 		},
+		Report: ReportSection{
+			Dir:                 cleanPath(getEnv("REPORTS_DIR", "./reports")),
+			SMTPHost:            os.Getenv("SMTP_HOST"),
+			SMTPPort:            getEnv("SMTP_PORT", "25"),
+			SMTPUsername:        os.Getenv("SMTP_USERNAME"),
+			SMTPPassword:        os.Getenv("SMTP_PASSWORD"),
+			SMTPFrom:            os.Getenv("SMTP_FROM"),
+			WebhookAllowedHosts: splitCSV(strings.ToLower(os.Getenv("REPORT_WEBHOOK_ALLOWED_HOSTS"))),
+		},
+		ImportWatch: ImportWatchSection{
+			Roots:    cleanPaths(splitCSV(os.Getenv("IMPORT_WATCH_ROOTS"))),
+			Interval: time.Duration(getEnvInt("IMPORT_WATCH_INTERVAL", 60)) * time.Second,
+		},
+		Jobs: JobsSection{
+			Workers: getEnvInt("JOB_WORKERS", 2),
+		},
 		CORS: CORSSection{
 			AllowedOrigins: splitCSV(corsAllowedOrigins),
 		},
@@ -187,6 +233,14 @@ func getEnvBool(key string, defaultValue bool) bool {
 	}
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(strings.TrimSpace(os.Getenv(key)))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
 func splitCSV(value string) []string {
 	parts := strings.Split(value, ",")
 	origins := make([]string, 0, len(parts))
@@ -206,3 +260,13 @@ func cleanPath(value string) string {
 	}
 	return filepath.Clean(trimmed)
 }
+
+func cleanPaths(values []string) []string {
+	cleaned := make([]string, 0, len(values))
+	for _, value := range values {
+		if path := cleanPath(value); path != "" {
+			cleaned = append(cleaned, path)
+		}
+	}
+	return cleaned
+}
@@ -0,0 +1,324 @@
+package controllers
+
+import (
+	"crane-system/database"
+	"crane-system/models"
+	"crane-system/services"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errAccessRequestHandled = errors.New("申请已处理")
+
+type createAccessRequestRequest struct {
+	ProductionLineID uint       `json:"production_line_id" binding:"required"`
+	Actions          []string   `json:"actions" binding:"required"`
+	Reason           string     `json:"reason"`
+	ValidUntil       *time.Time `json:"valid_until"`
+}
+
+type reviewAccessRequestRequest struct {
+	Comment string `json:"comment"`
+}
+
+type accessRequestReviewer struct {
+	UserID uint   `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// accessRequestView 附带申请所在产线的审批人。
+type accessRequestView struct {
+	models.AccessRequest
+	Reviewers []accessRequestReviewer `json:"reviewers"`
+}
+
+// accessRequestReviewers 返回产线的产线管理员；没有时由系统管理员审批，返回空列表。
+func accessRequestReviewers(productionLineID uint) ([]accessRequestReviewer, error) {
+	var assignments []models.LineAdminAssignment
+	if err := database.DB.Preload("User").Where("production_line_id = ?", productionLineID).Order("user_id ASC").Find(&assignments).Error; err != nil {
+		return nil, err
+	}
+	reviewers := make([]accessRequestReviewer, 0, len(assignments))
+	for _, assignment := range assignments {
+		if assignment.User.Role != "line_admin" || assignment.User.Status != "active" {
+			continue
+		}
+		reviewers = append(reviewers, accessRequestReviewer{UserID: assignment.UserID, Name: assignment.User.Name})
+	}
+	return reviewers, nil
+}
+
+func accessRequestActions(request models.AccessRequest) []string {
+	return strings.Split(request.Actions, ",")
+}
+
+// CreateAccessRequest 由当前用户申请某条产线上的动作权限，已拥有的动作会被忽略。
+// 产线管理员不能分配管理权限，因此只能申请 view / download / upload。
+func CreateAccessRequest(c *gin.Context) {
+	var req createAccessRequestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var line models.ProductionLine
+	if err := database.DB.Select("id", "name").First(&line, req.ProductionLineID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "产线不存在"})
+		return
+	}
+	if req.ValidUntil != nil && !req.ValidUntil.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "到期时间必须晚于当前时间"})
+		return
+	}
+
+	userID := currentUserID(c)
+	actions := make([]string, 0, len(req.Actions))
+	seen := map[string]bool{}
+	for _, action := range req.Actions {
+		action = strings.TrimSpace(action)
+		switch action {
+		case models.PermissionActionView, models.PermissionActionDownload, models.PermissionActionUpload:
+		case models.PermissionActionManage:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不能申请管理权限"})
+			return
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "action参数无效"})
+			return
+		}
+		if seen[action] || services.CheckLineAction(userID, currentUserRole(c), line.ID, services.LineAction(action)).Allowed {
+			continue
+		}
+		seen[action] = true
+		actions = append(actions, action)
+	}
+	if len(actions) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "已拥有所申请的权限"})
+		return
+	}
+
+	var pending int64
+	if err := database.DB.Model(&models.AccessRequest{}).
+		Where("requester_id = ? AND production_line_id = ? AND status = ?", userID, line.ID, models.AccessRequestPending).
+		Count(&pending).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	if pending > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "该产线已有待审批的申请"})
+		return
+	}
+
+	request := models.AccessRequest{
+		RequesterID:      userID,
+		ProductionLineID: line.ID,
+		Actions:          strings.Join(actions, ","),
+		Reason:           strings.TrimSpace(req.Reason),
+		ValidUntil:       req.ValidUntil,
+		Status:           models.AccessRequestPending,
+	}
+	if err := database.DB.Create(&request).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建申请失败"})
+		return
+	}
+	reviewers, err := accessRequestReviewers(line.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	request.ProductionLine = line
+	c.JSON(http.StatusOK, accessRequestView{AccessRequest: request, Reviewers: reviewers})
+}
+
+// GetAccessRequests 默认列出当前用户的申请；scope=review 列出当前用户可审批的申请，
+// 产线管理员只看到所管理产线的申请。
+func GetAccessRequests(c *gin.Context) {
+	query := database.DB.Preload("Requester").Preload("ProductionLine").Preload("Reviewer")
+	switch strings.TrimSpace(c.Query("scope")) {
+	case "", "mine":
+		query = query.Where("requester_id = ?", currentUserID(c))
+	case "review":
+		role := currentAccountRole(c)
+		if !services.IsSystemAdminRole(role) {
+			if role != "line_admin" {
+				c.JSON(http.StatusForbidden, gin.H{"error": "无权审批权限申请"})
+				return
+			}
+			perms, err := services.GetUserPermissions(currentUserID(c))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "查询权限失败"})
+				return
+			}
+			if len(perms.ManagedLineIDs) == 0 {
+				c.JSON(http.StatusOK, []models.AccessRequest{})
+				return
+			}
+			query = query.Where("production_line_id IN ?", perms.ManagedLineIDs)
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope参数无效"})
+		return
+	}
+	if status := strings.TrimSpace(c.Query("status")); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var requests []models.AccessRequest
+	if err := query.Order("created_at DESC, id DESC").Find(&requests).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, requests)
+}
+
+func ApproveAccessRequest(c *gin.Context) {
+	reviewAccessRequest(c, true)
+}
+
+func RejectAccessRequest(c *gin.Context) {
+	reviewAccessRequest(c, false)
+}
+
+// loadPendingAccessRequest 读取路径中的申请，已处理的申请返回 409。
+func loadPendingAccessRequest(c *gin.Context) (models.AccessRequest, bool) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return models.AccessRequest{}, false
+	}
+	var request models.AccessRequest
+	if err := database.DB.First(&request, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "申请不存在"})
+		return models.AccessRequest{}, false
+	}
+	if request.Status != models.AccessRequestPending {
+		c.JSON(http.StatusConflict, gin.H{"error": errAccessRequestHandled.Error()})
+		return models.AccessRequest{}, false
+	}
+	return request, true
+}
+
+// markAccessRequestTx 以条件更新把待审批的申请改为 status，并发审批时只有一次成功。
+func markAccessRequestTx(tx *gorm.DB, request models.AccessRequest, status string, updates map[string]any) error {
+	updates["status"] = status
+	result := tx.Model(&models.AccessRequest{}).Where("id = ? AND status = ?", request.ID, models.AccessRequestPending).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errAccessRequestHandled
+	}
+	return nil
+}
+
+// reviewAccessRequest 由产线的产线管理员或系统管理员审批申请。
+// 批准时在同一事务中以申请人为主体写入允许规则，审计日志记录申请 ID。
+func reviewAccessRequest(c *gin.Context, approve bool) {
+	request, ok := loadPendingAccessRequest(c)
+	if !ok {
+		return
+	}
+	if !authorizeLineAdminScope(c, request.ProductionLineID) {
+		return
+	}
+	reviewerID := currentUserID(c)
+	if request.RequesterID == reviewerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不能审批自己的申请"})
+		return
+	}
+	var req reviewAccessRequestRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	updates := map[string]any{"reviewer_id": reviewerID, "reviewed_at": now, "review_comment": strings.TrimSpace(req.Comment)}
+	if !approve {
+		if err := database.DB.Transaction(func(tx *gorm.DB) error {
+			return markAccessRequestTx(tx, request, models.AccessRequestRejected, updates)
+		}); err != nil {
+			writeAccessRequestError(c, err)
+			return
+		}
+		returnAccessRequest(c, request.ID)
+		return
+	}
+
+	if request.ValidUntil != nil && !request.ValidUntil.After(now) {
+		c.JSON(http.StatusConflict, gin.H{"error": "申请的到期时间已过"})
+		return
+	}
+	subject := services.PermissionSubject{Type: models.PermissionSubjectUser, ID: request.RequesterID}
+	changes := make([]services.PermissionRuleChange, 0, len(accessRequestActions(request)))
+	for _, action := range accessRequestActions(request) {
+		changes = append(changes, services.PermissionRuleChange{
+			ResourceType: models.PermissionResourceProductionLine,
+			ResourceID:   request.ProductionLineID,
+			Action:       action,
+			Decision:     models.PermissionDecisionAllow,
+			ValidUntil:   request.ValidUntil,
+		})
+	}
+	before, err := services.CurrentPermissionRuleDecisions(subject, changes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询权限失败"})
+		return
+	}
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := markAccessRequestTx(tx, request, models.AccessRequestApproved, updates); err != nil {
+			return err
+		}
+		return services.SavePermissionRuleChangesTx(tx, subject, changes)
+	}); err != nil {
+		writeAccessRequestError(c, err)
+		return
+	}
+	services.InvalidateUserCache(request.RequesterID)
+	recordAuditChange(c, "permission_rule", fmt.Sprintf("%s:%d:", subject.Type, subject.ID), before, gin.H{
+		"access_request_id": request.ID,
+		"changes":           changes,
+	})
+	returnAccessRequest(c, request.ID)
+}
+
+// CancelAccessRequest 由申请人撤回待审批的申请。
+func CancelAccessRequest(c *gin.Context) {
+	request, ok := loadPendingAccessRequest(c)
+	if !ok {
+		return
+	}
+	if request.RequesterID != currentUserID(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能撤回自己的申请"})
+		return
+	}
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return markAccessRequestTx(tx, request, models.AccessRequestCanceled, map[string]any{})
+	}); err != nil {
+		writeAccessRequestError(c, err)
+		return
+	}
+	returnAccessRequest(c, request.ID)
+}
+
+func writeAccessRequestError(c *gin.Context, err error) {
+	if errors.Is(err, errAccessRequestHandled) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "审批失败"})
+}
+
+func returnAccessRequest(c *gin.Context, id uint) {
+	var request models.AccessRequest
+	if err := database.DB.Preload("Requester").Preload("ProductionLine").Preload("Reviewer").First(&request, id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, request)
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+	"crane-system/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupAccessRequestTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	api.Use(middleware.AuditLog())
+	{
+		api.GET("/access-requests", GetAccessRequests)
+		api.POST("/access-requests", CreateAccessRequest)
+		api.POST("/access-requests/:id/approve", ApproveAccessRequest)
+		api.POST("/access-requests/:id/reject", RejectAccessRequest)
+		api.POST("/access-requests/:id/cancel", CancelAccessRequest)
+	}
+	return r
+}
+
+func TestAccessRequestApprovalWritesUserRule(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	_, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	other := models.ProductionLine{Name: "产线B", Code: "LINE-002", Type: "upper", Status: "active"}
+	operator := models.User{Name: "Operator", Password: "hashed", EmployeeID: "EMP-AR-OP", Role: "operator", Status: "active"}
+	lineAdmin := models.User{Name: "LineAdmin", Password: "hashed", EmployeeID: "EMP-AR-LA", Role: "line_admin", Status: "active"}
+	otherAdmin := models.User{Name: "OtherAdmin", Password: "hashed", EmployeeID: "EMP-AR-LB", Role: "line_admin", Status: "active"}
+	for _, row := range []any{&other, &operator, &lineAdmin, &otherAdmin} {
+		if err := database.DB.Create(row).Error; err != nil {
+			t.Fatalf("seed %T: %v", row, err)
+		}
+	}
+	for _, assignment := range []models.LineAdminAssignment{{UserID: lineAdmin.ID, ProductionLineID: line.ID}, {UserID: otherAdmin.ID, ProductionLineID: other.ID}} {
+		if err := database.DB.Create(&assignment).Error; err != nil {
+			t.Fatalf("seed assignment: %v", err)
+		}
+	}
+	if err := services.SavePermissionRuleChanges(services.PermissionSubject{Type: models.PermissionSubjectUser, ID: operator.ID}, []services.PermissionRuleChange{
+		{ResourceID: line.ID, Action: models.PermissionActionView, Decision: models.PermissionDecisionAllow},
+	}); err != nil {
+		t.Fatalf("seed view rule: %v", err)
+	}
+	operatorToken := createUserTokenForTest(t, operator.ID, operator.Role)
+	lineAdminToken := createUserTokenForTest(t, lineAdmin.ID, lineAdmin.Role)
+	otherAdminToken := createUserTokenForTest(t, otherAdmin.ID, otherAdmin.Role)
+	r := setupAccessRequestTestRouter()
+
+	if resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/access-requests", operatorToken,
+		map[string]any{"production_line_id": line.ID, "actions": []string{"manage"}}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("manage request: status = %d", resp.Code)
+	}
+	// 已有的 view 被忽略，只申请 download
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/access-requests", operatorToken,
+		map[string]any{"production_line_id": line.ID, "actions": []string{"view", "download"}, "reason": "调试"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("create: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	created := decodeProductionLineCustomFieldResponse[accessRequestView](t, resp)
+	if created.Actions != "download" || created.Status != models.AccessRequestPending || len(created.Reviewers) != 1 || created.Reviewers[0].UserID != lineAdmin.ID {
+		t.Fatalf("created = %+v", created)
+	}
+	if resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/access-requests", operatorToken,
+		map[string]any{"production_line_id": line.ID, "actions": []string{"upload"}}); resp.Code != http.StatusConflict {
+		t.Fatalf("duplicate pending: status = %d", resp.Code)
+	}
+
+	// 只有该产线的管理员能看到和审批
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, "/api/access-requests?scope=review", otherAdminToken, nil)
+	if queue := decodeProductionLineCustomFieldResponse[[]models.AccessRequest](t, resp); len(queue) != 0 {
+		t.Fatalf("other admin queue = %+v", queue)
+	}
+	approvePath := fmt.Sprintf("/api/access-requests/%d/approve", created.ID)
+	if resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, approvePath, otherAdminToken, nil); resp.Code != http.StatusForbidden {
+		t.Fatalf("other admin approve: status = %d", resp.Code)
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, "/api/access-requests?scope=review&status=pending", lineAdminToken, nil)
+	if queue := decodeProductionLineCustomFieldResponse[[]models.AccessRequest](t, resp); len(queue) != 1 || queue[0].Requester.Name != operator.Name {
+		t.Fatalf("line admin queue = %+v", queue)
+	}
+
+	if services.UserHasLinePermission(operator.ID, line.ID, models.PermissionActionDownload) {
+		t.Fatalf("download granted before approval")
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, approvePath, lineAdminToken, map[string]any{"comment": "同意"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("approve: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	approved := decodeProductionLineCustomFieldResponse[models.AccessRequest](t, resp)
+	if approved.Status != models.AccessRequestApproved || approved.ReviewerID == nil || *approved.ReviewerID != lineAdmin.ID || approved.ReviewComment != "同意" {
+		t.Fatalf("approved = %+v", approved)
+	}
+	if !services.UserHasLinePermission(operator.ID, line.ID, models.PermissionActionDownload) {
+		t.Fatalf("download not granted after approval")
+	}
+	if resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, approvePath, lineAdminToken, nil); resp.Code != http.StatusConflict {
+		t.Fatalf("approve twice: status = %d", resp.Code)
+	}
+
+	var entry models.AuditLog
+	if err := database.DB.Where("resource_type = ? AND action LIKE ?", "permission_rule", "%/approve").First(&entry).Error; err != nil {
+		t.Fatalf("load approval audit: %v", err)
+	}
+	var after struct {
+		AccessRequestID uint `json:"access_request_id"`
+	}
+	if err := json.Unmarshal(entry.After, &after); err != nil || after.AccessRequestID != created.ID || entry.ResourceID != fmt.Sprintf("user:%d:", operator.ID) {
+		t.Fatalf("approval audit = %+v", entry)
+	}
+
+	// 驳回与撤回
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/access-requests", operatorToken,
+		map[string]any{"production_line_id": line.ID, "actions": []string{"upload"}})
+	second := decodeProductionLineCustomFieldResponse[accessRequestView](t, resp)
+	if resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, fmt.Sprintf("/api/access-requests/%d/cancel", second.ID), lineAdminToken, nil); resp.Code != http.StatusForbidden {
+		t.Fatalf("cancel by reviewer: status = %d", resp.Code)
+	}
+	if resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, fmt.Sprintf("/api/access-requests/%d/reject", second.ID), lineAdminToken, nil); resp.Code != http.StatusOK {
+		t.Fatalf("reject: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	if services.UserHasLinePermission(operator.ID, line.ID, models.PermissionActionUpload) {
+		t.Fatalf("upload granted by rejected request")
+	}
+}
@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+	"crane-system/services"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type createServiceAccountRequest struct {
+	EmployeeID   string `json:"employee_id"`
+	Name         string `json:"name"`
+	DepartmentID *uint  `json:"department_id"`
+	Role         string `json:"role"`
+}
+
+// rejectAPITokenRequest 令牌只能由登录会话管理，避免泄露的令牌为自己续期或创建新令牌。
+func rejectAPITokenRequest(c *gin.Context) bool {
+	if middleware.CurrentAPITokenScope(c) == nil {
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "API 令牌不能管理令牌"})
+	return true
+}
+
+// ListMyAPITokens 返回当前用户的个人访问令牌。
+func ListMyAPITokens(c *gin.Context) {
+	if rejectAPITokenRequest(c) {
+		return
+	}
+	tokens, err := services.ListAPITokens(currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// CreateMyAPIToken 为当前用户创建个人访问令牌，范围不能超出本人权限。
+func CreateMyAPIToken(c *gin.Context) {
+	if rejectAPITokenRequest(c) {
+		return
+	}
+	var owner models.User
+	if err := database.DB.First(&owner, currentUserID(c)).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+		return
+	}
+	createAPITokenFor(c, owner)
+}
+
+// RevokeMyAPIToken 吊销当前用户自己的令牌。
+func RevokeMyAPIToken(c *gin.Context) {
+	if rejectAPITokenRequest(c) {
+		return
+	}
+	revokeAPITokenOf(c, currentUserID(c), c.Param("id"))
+}
+
+// ListServiceAccounts 返回全部服务账号。
+func ListServiceAccounts(c *gin.Context) {
+	var accounts []models.User
+	if err := database.DB.Preload("Department").Where("account_type = ?", models.UserAccountTypeService).Order("id ASC").Find(&accounts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, accounts)
+}
+
+// CreateServiceAccount 创建不能用密码登录的服务账号，权限仍按角色、部门和规则配置。
+func CreateServiceAccount(c *gin.Context) {
+	if rejectAPITokenRequest(c) {
+		return
+	}
+	var req createServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.EmployeeID = strings.TrimSpace(req.EmployeeID)
+	req.Name = strings.TrimSpace(req.Name)
+	req.Role = strings.TrimSpace(req.Role)
+	if req.Role == "" {
+		req.Role = "viewer"
+	}
+	if req.EmployeeID == "" || req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user payload"})
+		return
+	}
+	if err := validateUserRoleValue(req.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if services.IsSystemAdminRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "服务账号不能使用管理员角色"})
+		return
+	}
+	if req.DepartmentID != nil {
+		if err := validateDepartmentExists(*req.DepartmentID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	var count int64
+	if err := database.DB.Model(&models.User{}).Where("employee_id = ?", req.EmployeeID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "工号已存在"})
+		return
+	}
+
+	// 服务账号不使用密码，写入不可知的随机密码哈希以满足非空约束
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "password hash failed"})
+		return
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(raw)), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "password hash failed"})
+		return
+	}
+
+	account := models.User{
+		EmployeeID:   req.EmployeeID,
+		Name:         req.Name,
+		DepartmentID: req.DepartmentID,
+		Role:         req.Role,
+		RoleID:       resolveRoleID(req.Role),
+		Password:     string(hashedPassword),
+		Status:       "active",
+		AccountType:  models.UserAccountTypeService,
+	}
+	if err := database.DB.Create(&account).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "create failed"})
+		return
+	}
+	recordAuditChange(c, "service_account", account.ID, nil, account)
+	c.JSON(http.StatusCreated, account)
+}
+
+// ListServiceAccountTokens 返回服务账号的令牌。
+func ListServiceAccountTokens(c *gin.Context) {
+	account, ok := loadServiceAccount(c)
+	if !ok {
+		return
+	}
+	tokens, err := services.ListAPITokens(account.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// CreateServiceAccountToken 为服务账号创建令牌，范围不能超出服务账号的权限。
+func CreateServiceAccountToken(c *gin.Context) {
+	if rejectAPITokenRequest(c) {
+		return
+	}
+	account, ok := loadServiceAccount(c)
+	if !ok {
+		return
+	}
+	createAPITokenFor(c, account)
+}
+
+// RevokeServiceAccountToken 吊销服务账号的令牌。
+func RevokeServiceAccountToken(c *gin.Context) {
+	if rejectAPITokenRequest(c) {
+		return
+	}
+	account, ok := loadServiceAccount(c)
+	if !ok {
+		return
+	}
+	revokeAPITokenOf(c, account.ID, c.Param("token_id"))
+}
+
+func loadServiceAccount(c *gin.Context) (models.User, bool) {
+	var account models.User
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return account, false
+	}
+	if err := database.DB.Where("id = ? AND account_type = ?", id, models.UserAccountTypeService).First(&account).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "服务账号不存在"})
+			return account, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return account, false
+	}
+	return account, true
+}
+
+// createAPITokenFor 创建令牌并返回明文，明文只在此响应中出现一次。
+func createAPITokenFor(c *gin.Context, owner models.User) {
+	var input services.APITokenInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	token, plain, err := services.CreateAPIToken(owner, input, currentUserID(c), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	recordAuditChange(c, "api_token", token.ID, nil, token)
+	c.JSON(http.StatusCreated, gin.H{"token": plain, "api_token": token})
+}
+
+func revokeAPITokenOf(c *gin.Context, ownerID uint, rawTokenID string) {
+	tokenID, err := parseUintParam(rawTokenID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var token models.APIToken
+	if err := database.DB.Where("id = ? AND user_id = ?", tokenID, ownerID).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "令牌不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	if err := services.RevokeAPIToken(token.ID, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "吊销失败"})
+		return
+	}
+	recordAuditChange(c, "api_token", token.ID, token, gin.H{"revoked": true})
+	c.JSON(http.StatusOK, gin.H{"message": "令牌已吊销"})
+}
@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+	"crane-system/services"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type apiTokenCreateResponse struct {
+	Token    string          `json:"token"`
+	APIToken models.APIToken `json:"api_token"`
+}
+
+func setupAPITokenTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/api/login", Login)
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	{
+		api.GET("/programs", GetPrograms)
+		api.GET("/programs/:id", GetProgram)
+		api.GET("/api-tokens", ListMyAPITokens)
+		api.POST("/api-tokens", CreateMyAPIToken)
+		api.DELETE("/api-tokens/:id", RevokeMyAPIToken)
+		api.GET("/service-accounts", middleware.RequirePermission("page:user_management"), ListServiceAccounts)
+		api.POST("/service-accounts", middleware.RequirePermission("op:user_create"), CreateServiceAccount)
+		api.POST("/service-accounts/:id/tokens", middleware.RequirePermission("op:user_edit"), CreateServiceAccountToken)
+		api.GET("/permissions/program-overrides", middleware.RequirePermission("page:permissions"), ListProgramPermissionOverrides)
+		api.GET("/jobs", GetJobs)
+		api.GET("/jobs/:id", GetJob)
+		api.POST("/jobs/:id/cancel", CancelJob)
+	}
+	return r
+}
+
+func TestAPITokensAreScopedAndRevocable(t *testing.T) {
+	db := openProductionLineCustomFieldTestDB(t)
+	database.DB = db
+	adminToken, lineA := seedProductionLineCustomFieldAuthData(t, db)
+	lineB := models.ProductionLine{Name: "产线B", Code: "LINE-002", Type: "upper", Status: "active"}
+	if err := db.Create(&lineB).Error; err != nil {
+		t.Fatalf("create line: %v", err)
+	}
+	programA := models.Program{Name: "A线程序", Code: "PRG-A", ProductionLineID: lineA.ID}
+	programB := models.Program{Name: "B线程序", Code: "PRG-B", ProductionLineID: lineB.ID}
+	for _, program := range []*models.Program{&programA, &programB} {
+		if err := db.Create(program).Error; err != nil {
+			t.Fatalf("create program: %v", err)
+		}
+	}
+	for _, code := range []string{"page:user_management", "page:permissions"} {
+		if err := db.Create(&models.Permission{Code: code, Name: code, Type: "page"}).Error; err != nil {
+			t.Fatalf("create permission: %v", err)
+		}
+	}
+	viewer := models.User{Name: "Script Owner", Password: "hashed", EmployeeID: "VIEW-001", Role: "viewer", Status: "active"}
+	if err := db.Create(&viewer).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := services.SavePermissionRuleChanges(services.PermissionSubject{Type: models.PermissionSubjectUser, ID: viewer.ID}, []services.PermissionRuleChange{
+		{ResourceType: models.PermissionResourceProductionLine, ResourceID: lineA.ID, Action: models.PermissionActionView, Decision: models.PermissionDecisionAllow},
+		{ResourceType: models.PermissionResourceProductionLine, ResourceID: lineB.ID, Action: models.PermissionActionView, Decision: models.PermissionDecisionAllow},
+	}); err != nil {
+		t.Fatalf("save user rules: %v", err)
+	}
+	services.InvalidateAllCache()
+	router := setupAPITokenTestRouter()
+	viewerJWT := createUserTokenForTest(t, viewer.ID, "viewer")
+
+	recorder := performProductionLineCustomFieldRequest(t, router, http.MethodPost, "/api/api-tokens", viewerJWT, map[string]any{
+		"name": "上传脚本", "line_actions": []string{models.PermissionActionUpload},
+	})
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected token scope beyond owner permissions to be rejected, got %d", recorder.Code)
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodPost, "/api/api-tokens", viewerJWT, map[string]any{
+		"name": "取程序脚本", "line_actions": []string{models.PermissionActionView}, "production_line_ids": []uint{lineA.ID},
+	})
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("create token: %d %s", recorder.Code, recorder.Body.String())
+	}
+	created := decodeProductionLineCustomFieldResponse[apiTokenCreateResponse](t, recorder)
+	if !strings.HasPrefix(created.Token, services.APITokenPrefix) || !strings.Contains(recorder.Body.String(), created.APIToken.TokenPrefix) {
+		t.Fatalf("unexpected token response: %s", recorder.Body.String())
+	}
+	var stored models.APIToken
+	if err := db.First(&stored, created.APIToken.ID).Error; err != nil {
+		t.Fatalf("load token: %v", err)
+	}
+	if stored.TokenHash == created.Token || stored.TokenHash != services.HashAPIToken(created.Token) {
+		t.Fatal("token should be stored hashed")
+	}
+
+	// 令牌限定在 A 线，即使所有者能看 B 线也只返回 A 线程序
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, "/api/programs", created.Token, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("list programs with token: %d %s", recorder.Code, recorder.Body.String())
+	}
+	programs := decodeProductionLineCustomFieldResponse[[]models.Program](t, recorder)
+	if len(programs) != 1 || programs[0].ID != programA.ID {
+		t.Fatalf("expected only line A program, got %+v", programs)
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, fmt.Sprintf("/api/programs/%d", programB.ID), created.Token, nil)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected line B program to be outside token scope, got %d", recorder.Code)
+	}
+	if err := db.First(&stored, created.APIToken.ID).Error; err != nil {
+		t.Fatalf("reload token: %v", err)
+	}
+	if stored.LastUsedAt == nil || stored.LastUsedIP == "" {
+		t.Fatalf("expected last used tracking, got %+v", stored)
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodPost, "/api/api-tokens", created.Token, map[string]any{
+		"name": "续期", "line_actions": []string{models.PermissionActionView},
+	})
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected token to be unable to create tokens, got %d", recorder.Code)
+	}
+
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodDelete, fmt.Sprintf("/api/api-tokens/%d", created.APIToken.ID), viewerJWT, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("revoke token: %d %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, "/api/programs", created.Token, nil)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked token to be rejected, got %d", recorder.Code)
+	}
+
+	expiredPlain := services.APITokenPrefix + "expired"
+	expiredAt := time.Now().Add(-time.Hour)
+	if err := db.Create(&models.APIToken{UserID: viewer.ID, Name: "过期", TokenPrefix: "crn_expired", TokenHash: services.HashAPIToken(expiredPlain), LineActions: []string{models.PermissionActionView}, ExpiresAt: &expiredAt}).Error; err != nil {
+		t.Fatalf("create expired token: %v", err)
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, "/api/programs", expiredPlain, nil)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected expired token to be rejected, got %d", recorder.Code)
+	}
+
+	// 管理员的令牌同样只能使用范围内的功能权限码
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodPost, "/api/api-tokens", adminToken, map[string]any{
+		"name": "账号巡检", "function_codes": []string{"page:user_management"},
+	})
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("create admin token: %d %s", recorder.Code, recorder.Body.String())
+	}
+	adminAPIToken := decodeProductionLineCustomFieldResponse[apiTokenCreateResponse](t, recorder).Token
+	if recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, "/api/service-accounts", adminAPIToken, nil); recorder.Code != http.StatusOK {
+		t.Fatalf("expected scoped function to be allowed, got %d", recorder.Code)
+	}
+	if recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, "/api/permissions/program-overrides", adminAPIToken, nil); recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected function outside token scope to be rejected, got %d", recorder.Code)
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, "/api/programs", adminAPIToken, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("list programs with admin token: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if programs := decodeProductionLineCustomFieldResponse[[]models.Program](t, recorder); len(programs) != 0 {
+		t.Fatalf("expected token without line actions to see no programs, got %+v", programs)
+	}
+
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodPost, "/api/service-accounts", adminToken, map[string]any{"employee_id": "SVC-LINE-A", "name": "A线下发服务"})
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("create service account: %d %s", recorder.Code, recorder.Body.String())
+	}
+	account := decodeProductionLineCustomFieldResponse[models.User](t, recorder)
+	if account.AccountType != models.UserAccountTypeService {
+		t.Fatalf("expected service account type, got %q", account.AccountType)
+	}
+	if err := services.SavePermissionRuleChanges(services.PermissionSubject{Type: models.PermissionSubjectUser, ID: account.ID}, []services.PermissionRuleChange{
+		{ResourceType: models.PermissionResourceProductionLine, ResourceID: lineA.ID, Action: models.PermissionActionDownload, Decision: models.PermissionDecisionAllow},
+	}); err != nil {
+		t.Fatalf("save service account rules: %v", err)
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodPost, fmt.Sprintf("/api/service-accounts/%d/tokens", account.ID), adminToken, map[string]any{
+		"name": "A线下发", "line_actions": []string{models.PermissionActionDownload},
+	})
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("create service account token: %d %s", recorder.Code, recorder.Body.String())
+	}
+	serviceToken := decodeProductionLineCustomFieldResponse[apiTokenCreateResponse](t, recorder)
+	if serviceToken.APIToken.UserID != account.ID {
+		t.Fatalf("expected token owned by service account, got %+v", serviceToken.APIToken)
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, "/api/programs", serviceToken.Token, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("list programs with service token: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// 服务账号即使知道密码也不能登录
+	hashed, err := bcrypt.GenerateFromPassword([]byte("secret-123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	if err := db.Model(&models.User{}).Where("id = ?", account.ID).Update("password", string(hashed)).Error; err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodPost, "/api/login", "", map[string]any{"employee_id": "SVC-LINE-A", "password": "secret-123"})
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected service account login to be rejected, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestAdminOwnedAPITokenHasNoAdminBypass(t *testing.T) {
+	db := openProductionLineCustomFieldTestDB(t)
+	database.DB = db
+	adminToken, _ := seedProductionLineCustomFieldAuthData(t, db)
+	if err := db.Create(&models.Permission{Code: "page:user_management", Name: "用户管理", Type: "page"}).Error; err != nil {
+		t.Fatalf("create permission: %v", err)
+	}
+	other := models.User{Name: "Other", Password: "hashed", EmployeeID: "OTHER-001", Role: "viewer", Status: "active"}
+	if err := db.Create(&other).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	job := models.Job{Type: "program_excel_import", Status: jobStatusQueued, OwnerID: other.ID}
+	if err := db.Create(&job).Error; err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	services.InvalidateAllCache()
+	router := setupAPITokenTestRouter()
+
+	recorder := performProductionLineCustomFieldRequest(t, router, http.MethodGet, "/api/jobs?all=1", adminToken, nil)
+	if jobs := decodeProductionLineCustomFieldResponse[[]models.Job](t, recorder); len(jobs) != 1 {
+		t.Fatalf("admin session should see other users' jobs, got %+v", jobs)
+	}
+
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodPost, "/api/api-tokens", adminToken, map[string]any{
+		"name": "账号巡检", "function_codes": []string{"page:user_management"},
+	})
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("create admin token: %d %s", recorder.Code, recorder.Body.String())
+	}
+	adminAPIToken := decodeProductionLineCustomFieldResponse[apiTokenCreateResponse](t, recorder).Token
+
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, "/api/jobs?all=1", adminAPIToken, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("list jobs with token: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if jobs := decodeProductionLineCustomFieldResponse[[]models.Job](t, recorder); len(jobs) != 0 {
+		t.Fatalf("admin-owned token should only see its owner's jobs, got %+v", jobs)
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, fmt.Sprintf("/api/jobs/%d", job.ID), adminAPIToken, nil)
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected other user's job to be hidden from token, got %d", recorder.Code)
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodPost, fmt.Sprintf("/api/jobs/%d/cancel", job.ID), adminAPIToken, nil)
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected token to be unable to cancel other user's job, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"crane-system/database"
+	"crane-system/models"
+	"crane-system/services"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const auditLogExportBatchSize = 500
+
+// recordAuditChange 登记本次请求的审计资源和前后快照，由审计中间件在请求结束后写入。
+func recordAuditChange(c *gin.Context, resourceType string, resourceID any, before, after any) {
+	c.Set(services.AuditChangeKey, services.AuditChange{
+		ResourceType: resourceType,
+		ResourceID:   fmt.Sprint(resourceID),
+		Before:       before,
+		After:        after,
+	})
+}
+
+// applyAuditLogFilters 按操作人、动作、资源、请求 ID、状态和日期范围过滤审计日志。
+func applyAuditLogFilters(c *gin.Context, query *gorm.DB) (*gorm.DB, error) {
+	if actorID := strings.TrimSpace(c.Query("actor_id")); actorID != "" {
+		id, err := parseUintParam(actorID)
+		if err != nil {
+			return nil, errors.New("actor_id参数格式错误")
+		}
+		query = query.Where("actor_id = ?", id)
+	}
+	if action := strings.TrimSpace(c.Query("action")); action != "" {
+		query = query.Where("action LIKE ?", "%"+action+"%")
+	}
+	if method := strings.TrimSpace(c.Query("method")); method != "" {
+		query = query.Where("method = ?", strings.ToUpper(method))
+	}
+	if resourceType := strings.TrimSpace(c.Query("resource_type")); resourceType != "" {
+		query = query.Where("resource_type = ?", resourceType)
+	}
+	if resourceID := strings.TrimSpace(c.Query("resource_id")); resourceID != "" {
+		query = query.Where("resource_id = ?", resourceID)
+	}
+	if requestID := strings.TrimSpace(c.Query("request_id")); requestID != "" {
+		query = query.Where("request_id = ?", requestID)
+	}
+	switch strings.TrimSpace(c.Query("result")) {
+	case "":
+	case "success":
+		query = query.Where("status_code < ?", http.StatusBadRequest)
+	case "failed":
+		query = query.Where("status_code >= ?", http.StatusBadRequest)
+	default:
+		return nil, errors.New("result参数只能为success或failed")
+	}
+	if dateFrom := strings.TrimSpace(c.Query("date_from")); dateFrom != "" {
+		parsedDate, err := time.ParseInLocation("2006-01-02", dateFrom, time.Local)
+		if err != nil {
+			return nil, errors.New("date_from参数格式错误")
+		}
+		query = query.Where("created_at >= ?", parsedDate)
+	}
+	if dateTo := strings.TrimSpace(c.Query("date_to")); dateTo != "" {
+		parsedDate, err := time.ParseInLocation("2006-01-02", dateTo, time.Local)
+		if err != nil {
+			return nil, errors.New("date_to参数格式错误")
+		}
+		query = query.Where("created_at < ?", parsedDate.AddDate(0, 0, 1))
+	}
+	return query, nil
+}
+
+// GetAuditLogs 分页查询审计日志，按序号倒序。
+func GetAuditLogs(c *gin.Context) {
+	page, err := parsePositiveIntQuery(c.Query("page"), 1, 0, "page")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pageSize, err := parsePositiveIntQuery(c.Query("page_size"), 20, 200, "page_size")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query, err := applyAuditLogFilters(c, database.DB.Model(&models.AuditLog{}))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	var items []models.AuditLog
+	if err := query.Order("sequence DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":     items,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// ExportAuditLogs 以 CSV 下载符合条件的全部审计日志，按写入顺序分批输出，bom=true 时写入 UTF-8 BOM。
+func ExportAuditLogs(c *gin.Context) {
+	query, err := applyAuditLogFilters(c, database.DB.Model(&models.AuditLog{}))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fileName := fmt.Sprintf("audit_logs_%s.csv", time.Now().Format("20060102_150405"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename=\""+fileName+"\"; filename*=UTF-8''"+url.QueryEscape(fileName))
+	c.Status(http.StatusOK)
+	if strings.TrimSpace(c.Query("bom")) == "true" {
+		_, _ = c.Writer.Write([]byte("\xEF\xBB\xBF"))
+	}
+
+	writer := csv.NewWriter(c.Writer)
+	_ = writer.Write([]string{"序号", "时间", "操作人ID", "操作人", "动作", "路径", "资源类型", "资源ID", "状态码", "变更前", "变更后", "IP", "请求ID", "哈希"})
+	var entries []models.AuditLog
+	query.FindInBatches(&entries, auditLogExportBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, entry := range entries {
+			_ = writer.Write([]string{
+				strconv.FormatUint(entry.Sequence, 10),
+				entry.CreatedAt.Format("2006-01-02 15:04:05"),
+				strconv.FormatUint(uint64(entry.ActorID), 10),
+				entry.ActorName,
+				entry.Action,
+				entry.Path,
+				entry.ResourceType,
+				entry.ResourceID,
+				strconv.Itoa(entry.StatusCode),
+				string(entry.Before),
+				string(entry.After),
+				entry.IP,
+				entry.RequestID,
+				entry.Hash,
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	writer.Flush()
+}
+
+// VerifyAuditLogs 校验审计日志哈希链是否完整。
+func VerifyAuditLogs(c *gin.Context) {
+	report, err := services.VerifyAuditChain()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "校验失败"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -80,6 +80,10 @@ func Login(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "工号或密码错误"})
 		return
 	}
+	if user.AccountType == models.UserAccountTypeService {
+		c.JSON(http.StatusForbidden, gin.H{"error": "服务账号只能使用 API 令牌访问"})
+		return
+	}
 
 	claims := &middleware.Claims{
 		UserID: user.ID,
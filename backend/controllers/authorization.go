@@ -1,9 +1,11 @@
 package controllers
 
 import (
+	"crane-system/middleware"
 	"crane-system/models"
 	"crane-system/services"
 	"net/http"
+	"net/http/httptest"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -29,27 +31,62 @@ type resolvedLinePermission struct {
 
 // authorizeOwnerOrAdmin 用于"本人可访问、管理员可访问"的账号级接口。
 func authorizeOwnerOrAdmin(c *gin.Context, targetUserID uint) bool {
-	return writeAuthDecision(c, services.AuthorizeOwnerOrAdmin(currentUserID(c), currentUserRole(c), targetUserID))
+	return writeAuthDecision(c, services.AuthorizeOwnerOrAdmin(currentUserID(c), currentAccountRole(c), targetUserID))
 }
 
-// authorizeProgramAction 将程序级操作转换为产线权限判断。
-// 程序本身不直接存权限，所有业务授权都回到 ProductionLineID。
+// apiTokenScopeDenied 是令牌范围不包含所需产线动作时的统一拒绝结果。
+func apiTokenScopeDenied() (bool, int, string) {
+	return false, http.StatusForbidden, "令牌范围不包含该产线操作"
+}
+
+// authorizeProgramAction 将程序级操作转换为权限判断。
+// 业务授权回到 ProductionLineID，车型规则按 VehicleModelID 细化，程序规则最后覆盖。
 func authorizeProgramAction(c *gin.Context, tx *gorm.DB, programID uint, action linePermissionAction) bool {
+	allowed, statusCode, message := checkProgramAction(c, tx, programID, action)
+	if !allowed {
+		c.JSON(statusCode, gin.H{"error": message})
+		return false
+	}
+	return true
+}
+
+// checkProgramAction 与 authorizeProgramAction 判断逻辑一致，但不写响应。
+// 批量接口需要逐条收集授权结果，而不是遇到第一条失败就终止请求。
+func checkProgramAction(c *gin.Context, tx *gorm.DB, programID uint, action linePermissionAction) (bool, int, string) {
 	var program models.Program
-	if err := tx.Select("id", "production_line_id").First(&program, programID).Error; err != nil {
+	if err := tx.Select("id", "production_line_id", "vehicle_model_id").First(&program, programID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "程序不存在"})
-			return false
+			return false, http.StatusNotFound, "程序不存在"
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return false, http.StatusInternalServerError, "查询失败"
+	}
+	return checkProgramScopeAction(c, program.ID, program.ProductionLineID, program.VehicleModelID, action)
+}
+
+// checkProgramScopeAction 按程序本身、所在产线和车型判断权限，已加载程序的接口直接使用。
+func checkProgramScopeAction(c *gin.Context, programID, productionLineID, vehicleModelID uint, action linePermissionAction) (bool, int, string) {
+	if !middleware.CurrentAPITokenScope(c).AllowsLineAction(productionLineID, action) {
+		return apiTokenScopeDenied()
+	}
+	decision := services.CheckProgramScopeAction(currentUserID(c), currentUserRole(c), programID, productionLineID, vehicleModelID, action)
+	return decision.Allowed, decision.StatusCode, decision.Message
+}
+
+func authorizeProgramScopeAction(c *gin.Context, programID, productionLineID, vehicleModelID uint, action linePermissionAction) bool {
+	allowed, statusCode, message := checkProgramScopeAction(c, programID, productionLineID, vehicleModelID, action)
+	if !allowed {
+		c.JSON(statusCode, gin.H{"error": message})
 		return false
 	}
-	return authorizeLineAction(c, program.ProductionLineID, action)
+	return true
 }
 
 // checkLineAction 返回可直接用于接口响应的授权结果。
 // system_admin 硬编码绕过；line_admin 对负责产线全权；普通用户按用户覆盖→角色权限解析。
 func checkLineAction(c *gin.Context, productionLineID uint, action linePermissionAction) (bool, int, string) {
+	if !middleware.CurrentAPITokenScope(c).AllowsLineAction(productionLineID, action) {
+		return apiTokenScopeDenied()
+	}
 	decision := services.CheckLineAction(currentUserID(c), currentUserRole(c), productionLineID, action)
 	return decision.Allowed, decision.StatusCode, decision.Message
 }
@@ -61,7 +98,17 @@ func resolveAuthorizedLineIDs(c *gin.Context, action linePermissionAction) (map[
 	if !decision.Allowed {
 		return nil, decision.StatusCode, decision.Message
 	}
-	return allowedLineIDs, 0, ""
+	return middleware.CurrentAPITokenScope(c).RestrictLineIDs(allowedLineIDs, action), 0, ""
+}
+
+// resolveAuthorizedProgramScope 返回当前用户可访问的程序范围（产线加车型规则）。
+// 管理员返回 nil 表示不需要追加过滤条件。
+func resolveAuthorizedProgramScope(c *gin.Context, action linePermissionAction) (*services.ProgramScope, int, string) {
+	scope, decision := services.ResolveAuthorizedProgramScope(currentUserID(c), currentUserRole(c), action)
+	if !decision.Allowed {
+		return nil, decision.StatusCode, decision.Message
+	}
+	return middleware.CurrentAPITokenScope(c).RestrictProgramScope(scope, action), 0, ""
 }
 
 func permissionAllowsAction(canView, canDownload, canUpload, canManage bool, action linePermissionAction) bool {
@@ -91,10 +138,44 @@ func currentUserRole(c *gin.Context) string {
 	return role
 }
 
+// currentAccountRole 返回用于管理员绕过判断的角色（查看他人数据、分配权限、受限状态流转等）。
+// API 令牌请求不享有管理员绕过，管理员角色按无角色处理；产线动作由令牌范围单独收窄，仍使用 currentUserRole。
+func currentAccountRole(c *gin.Context) string {
+	role := currentUserRole(c)
+	if middleware.CurrentAPITokenScope(c) != nil && services.IsSystemAdminRole(role) {
+		return ""
+	}
+	return role
+}
+
 func currentUserID(c *gin.Context) uint {
 	return c.GetUint("user_id")
 }
 
+// currentUserHasPermission 与 middleware.RequirePermission 口径一致，
+// 用于同一接口内按数据内容（如导入中的新建行）追加功能权限判断。
+func currentUserHasPermission(c *gin.Context, code string) bool {
+	if !middleware.CurrentAPITokenScope(c).AllowsFunction(code) {
+		return false
+	}
+	role := currentUserRole(c)
+	if role == "admin" || role == "system_admin" {
+		return true
+	}
+	return services.UserHasPermission(currentUserID(c), code)
+}
+
+// newUserContext 为后台任务构造以 user 身份执行的请求上下文，复用接口的权限判断。
+func newUserContext(user models.User, request *http.Request) (*gin.Context, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = request
+	ctx.Set("user_id", user.ID)
+	ctx.Set("user_role", user.Role)
+	ctx.Set("user", user)
+	return ctx, recorder
+}
+
 func writeAuthDecision(c *gin.Context, decision services.AuthDecision) bool {
 	if decision.Allowed {
 		return true
@@ -5,6 +5,8 @@ import (
 	"context"
 	"crane-system/config"
 	"crane-system/utils"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -50,8 +52,78 @@ var defaultZipExtractionLimits = zipExtractionLimits{
 	MaxTotalSize: 5 * 1024 * 1024 * 1024,
 }
 
+const (
+	backupKindDatabase = "database"
+	backupKindFiles    = "files"
+	backupKindFull     = "full"
+)
+
+var errBackupUploadsMissing = errors.New("文件目录不存在")
+
+// backupJobPayload 是备份任务的参数。
+type backupJobPayload struct {
+	Kind string `json:"kind"`
+}
+
+// enqueueBackupJob 提交备份任务，完成后任务结果即为备份文件信息。
+func enqueueBackupJob(c *gin.Context, kind, message string) {
+	job, err := enqueueJob(jobTypeBackup, currentUserID(c), backupJobPayload{Kind: kind}, 1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交备份任务失败"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": message, "job_id": job.ID})
+}
+
 // CreateDatabaseBackup 创建数据库备份
 func CreateDatabaseBackup(c *gin.Context) {
+	enqueueBackupJob(c, backupKindDatabase, "数据库备份任务已提交")
+}
+
+// CreateFilesBackup 创建文件系统备份
+func CreateFilesBackup(c *gin.Context) {
+	// 检查上传目录是否存在
+	if !utils.FileExists(utils.UploadDir()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errBackupUploadsMissing.Error()})
+		return
+	}
+	enqueueBackupJob(c, backupKindFiles, "文件备份任务已提交")
+}
+
+// CreateFullBackup 创建完整系统备份
+func CreateFullBackup(c *gin.Context) {
+	enqueueBackupJob(c, backupKindFull, "完整系统备份任务已提交")
+}
+
+// runBackupJob 执行备份；上传目录缺失以外的失败可能是数据库或磁盘暂时不可用，按可重试处理。
+func runBackupJob(run *jobRun) error {
+	var payload backupJobPayload
+	if err := json.Unmarshal(run.job.Payload, &payload); err != nil {
+		return err
+	}
+	var info utils.BackupInfo
+	var err error
+	switch payload.Kind {
+	case backupKindDatabase:
+		info, err = createDatabaseBackupFile()
+	case backupKindFiles:
+		info, err = createFilesBackupFile()
+	case backupKindFull:
+		info, err = createFullBackupFile()
+	default:
+		return fmt.Errorf("未知的备份类型 %s", payload.Kind)
+	}
+	if errors.Is(err, errBackupUploadsMissing) {
+		return err
+	}
+	if err != nil {
+		return retryableJobError{err: err}
+	}
+	run.setResult(info)
+	return nil
+}
+
+func createDatabaseBackupFile() (utils.BackupInfo, error) {
 	// 生成备份文件名
 	timestamp := time.Now().Format("20060102_150405")
 	backupFileName := fmt.Sprintf("database_backup_%s.sql", timestamp)
@@ -59,39 +131,29 @@ func CreateDatabaseBackup(c *gin.Context) {
 
 	// 确保备份目录存在
 	if err := utils.EnsureDirectoryExists(backupDir()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建备份目录失败"})
-		return
+		return utils.BackupInfo{}, errors.New("创建备份目录失败")
 	}
 
 	// 使用mysqldump备份数据库
 	if err := createMySQLDump(backupFilePath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库备份失败: " + err.Error()})
-		return
+		return utils.BackupInfo{}, fmt.Errorf("数据库备份失败: %w", err)
 	}
 
 	// 获取文件大小
 	fileSize, _ := utils.GetFileSize(backupFilePath)
 
-	backupInfo := utils.BackupInfo{
+	return utils.BackupInfo{
 		Name:      backupFileName,
 		Path:      backupFilePath,
 		Size:      fileSize,
 		CreatedAt: time.Now().Format(time.RFC3339),
-		Type:      "database",
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "数据库备份成功",
-		"backup":  backupInfo,
-	})
+		Type:      backupKindDatabase,
+	}, nil
 }
 
-// CreateFilesBackup 创建文件系统备份
-func CreateFilesBackup(c *gin.Context) {
-	// 检查上传目录是否存在
+func createFilesBackupFile() (utils.BackupInfo, error) {
 	if !utils.FileExists(utils.UploadDir()) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "文件目录不存在"})
-		return
+		return utils.BackupInfo{}, errBackupUploadsMissing
 	}
 
 	// 生成备份文件名
@@ -102,32 +164,24 @@ func CreateFilesBackup(c *gin.Context) {
 	// 获取目录大小
 	dirSize, err := utils.GetDirectorySize(utils.UploadDir())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取文件大小失败"})
-		return
+		return utils.BackupInfo{}, errors.New("获取文件大小失败")
 	}
 
 	// 创建ZIP备份文件
 	if err := createZipBackup(utils.UploadDir(), backupFilePath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "文件备份失败: " + err.Error()})
-		return
+		return utils.BackupInfo{}, fmt.Errorf("文件备份失败: %w", err)
 	}
 
-	backupInfo := utils.BackupInfo{
+	return utils.BackupInfo{
 		Name:      backupFileName,
 		Path:      backupFilePath,
 		Size:      dirSize,
 		CreatedAt: time.Now().Format(time.RFC3339),
-		Type:      "files",
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "文件备份成功",
-		"backup":  backupInfo,
-	})
+		Type:      backupKindFiles,
+	}, nil
 }
 
-// CreateFullBackup 创建完整系统备份
-func CreateFullBackup(c *gin.Context) {
+func createFullBackupFile() (utils.BackupInfo, error) {
 	timestamp := time.Now().Format("20060102_150405")
 	backupFileName := fmt.Sprintf("full_backup_%s.zip", timestamp)
 	backupFilePath := filepath.Join(backupDir(), backupFileName)
@@ -135,8 +189,7 @@ func CreateFullBackup(c *gin.Context) {
 	// 创建临时目录用于备份
 	tempDir := filepath.Join(backupDir(), "temp", timestamp)
 	if err := utils.EnsureDirectoryExists(tempDir); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建临时目录失败"})
-		return
+		return utils.BackupInfo{}, errors.New("创建临时目录失败")
 	}
 	defer os.RemoveAll(tempDir) // 清理临时目录
 
@@ -144,8 +197,7 @@ func CreateFullBackup(c *gin.Context) {
 	dbBackupName := fmt.Sprintf("database_%s.sql", timestamp)
 	dbBackupPath := filepath.Join(tempDir, dbBackupName)
 	if err := createMySQLDump(dbBackupPath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库备份失败: " + err.Error()})
-		return
+		return utils.BackupInfo{}, fmt.Errorf("数据库备份失败: %w", err)
 	}
 
 	// 备份文件目录
@@ -155,38 +207,30 @@ func CreateFullBackup(c *gin.Context) {
 	// 检查上传目录是否存在，如果不存在或为空则创建空ZIP文件
 	if utils.FileExists(utils.UploadDir()) {
 		if err := createZipBackup(utils.UploadDir(), filesBackupPath); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "文件备份失败: " + err.Error()})
-			return
+			return utils.BackupInfo{}, fmt.Errorf("文件备份失败: %w", err)
 		}
 	} else {
 		// 创建空的ZIP文件
 		if err := createEmptyZip(filesBackupPath); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建空备份文件失败: " + err.Error()})
-			return
+			return utils.BackupInfo{}, fmt.Errorf("创建空备份文件失败: %w", err)
 		}
 	}
 
 	// 创建包含所有备份的ZIP文件
 	if err := createFullSystemBackup(tempDir, backupFilePath, timestamp); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "完整备份失败: " + err.Error()})
-		return
+		return utils.BackupInfo{}, fmt.Errorf("完整备份失败: %w", err)
 	}
 
 	// 获取备份文件大小
 	fileSize, _ := utils.GetFileSize(backupFilePath)
 
-	backupInfo := utils.BackupInfo{
+	return utils.BackupInfo{
 		Name:      backupFileName,
 		Path:      backupFilePath,
 		Size:      fileSize,
 		CreatedAt: time.Now().Format(time.RFC3339),
-		Type:      "full",
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "完整系统备份成功",
-		"backup":  backupInfo,
-	})
+		Type:      backupKindFull,
+	}, nil
 }
 
 // GetBackupList 获取备份文件列表
@@ -338,6 +382,7 @@ func RestoreDatabase(c *gin.Context) {
 		return
 	}
 
+	recordAuditChange(c, "backup", backupName, nil, gin.H{"restored": "database", "rollback_backup": currentBackupName})
 	c.JSON(http.StatusOK, gin.H{
 		"message":         "数据库恢复成功",
 		"rollback_backup": currentBackupName,
@@ -437,6 +482,7 @@ func RestoreFiles(c *gin.Context) {
 		_ = os.RemoveAll(rollbackDir)
 	}
 
+	recordAuditChange(c, "backup", backupName, nil, gin.H{"restored": "files", "rollback_backup": currentBackupName})
 	c.JSON(http.StatusOK, gin.H{
 		"message":         "文件系统恢复成功",
 		"rollback_backup": currentBackupName,
@@ -691,6 +737,8 @@ func createMySQLDump(backupPath string) error {
 		"--single-transaction",
 		"--quick",
 		"--lock-tables=false",
+		// 审计日志只追加，不随数据库恢复回退
+		fmt.Sprintf("--ignore-table=%s.audit_logs", config.AppConfig.Database.Name),
 		config.AppConfig.Database.Name,
 	)
 
@@ -0,0 +1,412 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"crane-system/database"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	batchImportLayoutDefault = "default"
+
+	batchLayoutRoleLine         = "line"
+	batchLayoutRoleVehicleModel = "vehicle_model"
+	batchLayoutRoleWorkstation  = "workstation"
+	batchLayoutRoleProgram      = "program"
+	batchLayoutRoleIgnore       = "ignore"
+
+	maxBatchImportLayoutSegments = 10
+)
+
+var batchImportLayoutCodePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,49}$`)
+
+// batchLayoutPatternGroups 是文件名正则允许使用的命名分组。
+var batchLayoutPatternGroups = map[string]bool{"workstation": true, "program": true, "code": true, "version": true}
+
+// builtinBatchImportLayouts 是始终可用的内置布局，对应历史上固定的 工位/程序/文件 结构。
+func builtinBatchImportLayouts() []models.BatchImportLayout {
+	return []models.BatchImportLayout{
+		{
+			Code:     batchImportLayoutDefault,
+			Name:     "工位/程序/文件",
+			Segments: []string{batchLayoutRoleWorkstation, batchLayoutRoleProgram},
+			Builtin:  true,
+		},
+	}
+}
+
+// batchImportLayoutRules 是校验并编译后的布局。
+type batchImportLayoutRules struct {
+	layout  models.BatchImportLayout
+	pattern *regexp.Regexp
+}
+
+// batchLayoutEntry 是按布局从一个文件路径中解析出的信息。
+type batchLayoutEntry struct {
+	Line         string
+	VehicleModel string
+	Workstation  string
+	Program      string
+	Code         string
+	Version      string
+}
+
+// groupKey 是预览中的工位分组名；布局包含产线或车型目录时一并拼入，避免不同产线的同名工位被合并。
+func (entry batchLayoutEntry) groupKey() string {
+	parts := make([]string, 0, 3)
+	for _, value := range []string{entry.Line, entry.VehicleModel, entry.Workstation} {
+		if value != "" {
+			parts = append(parts, value)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func compileBatchImportLayout(layout models.BatchImportLayout) (*batchImportLayoutRules, error) {
+	if len(layout.Segments) > maxBatchImportLayoutSegments {
+		return nil, fmt.Errorf("目录层级不能超过 %d 级", maxBatchImportLayoutSegments)
+	}
+	roles := map[string]bool{}
+	for _, role := range layout.Segments {
+		switch role {
+		case batchLayoutRoleIgnore:
+			continue
+		case batchLayoutRoleLine, batchLayoutRoleVehicleModel, batchLayoutRoleWorkstation, batchLayoutRoleProgram:
+		default:
+			return nil, fmt.Errorf("未知的目录角色: %s", role)
+		}
+		if roles[role] {
+			return nil, fmt.Errorf("目录角色 %s 重复", role)
+		}
+		roles[role] = true
+	}
+
+	rules := &batchImportLayoutRules{layout: layout}
+	if strings.TrimSpace(layout.FilePattern) != "" {
+		pattern, err := regexp.Compile(layout.FilePattern)
+		if err != nil {
+			return nil, fmt.Errorf("文件名正则无效: %v", err)
+		}
+		for _, name := range pattern.SubexpNames()[1:] {
+			if name == "" {
+				continue
+			}
+			if !batchLayoutPatternGroups[name] {
+				return nil, fmt.Errorf("文件名正则不支持分组 %s，只能使用 workstation、program、code、version", name)
+			}
+			roles[name] = true
+		}
+		rules.pattern = pattern
+	}
+
+	if !roles[batchLayoutRoleProgram] && !roles["code"] {
+		return nil, errors.New("布局需要通过目录或文件名分组确定程序")
+	}
+	if !roles[batchLayoutRoleWorkstation] && strings.TrimSpace(layout.DefaultWorkstation) == "" {
+		return nil, errors.New("布局不含工位时需要填写默认工位")
+	}
+	return rules, nil
+}
+
+// classify 解析一个文件路径；无法按布局识别时返回跳过原因。
+// 文件名分组提取的值优先于目录；程序名缺省时使用提取到的编号。
+func (rules *batchImportLayoutRules) classify(path string) (batchLayoutEntry, string) {
+	var entry batchLayoutEntry
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	dirs, fileName := parts[:len(parts)-1], parts[len(parts)-1]
+	if len(dirs) < len(rules.layout.Segments) {
+		return entry, fmt.Sprintf("目录层级不足，布局 %s 需要至少 %d 级目录", rules.layout.Code, len(rules.layout.Segments))
+	}
+
+	assign := func(role, value string) {
+		switch role {
+		case batchLayoutRoleLine:
+			entry.Line = value
+		case batchLayoutRoleVehicleModel:
+			entry.VehicleModel = value
+		case batchLayoutRoleWorkstation:
+			entry.Workstation = value
+		case batchLayoutRoleProgram:
+			entry.Program = value
+		case "code":
+			entry.Code = value
+		case "version":
+			entry.Version = value
+		}
+	}
+	for i, role := range rules.layout.Segments {
+		value := strings.TrimSpace(dirs[i])
+		if value == "" && role != batchLayoutRoleIgnore {
+			return entry, "目录名为空"
+		}
+		assign(role, value)
+	}
+	if rules.pattern != nil {
+		match := rules.pattern.FindStringSubmatch(fileName)
+		if match == nil {
+			return entry, "文件名不符合布局规则"
+		}
+		for i, name := range rules.pattern.SubexpNames() {
+			if name != "" && strings.TrimSpace(match[i]) != "" {
+				assign(name, strings.TrimSpace(match[i]))
+			}
+		}
+	}
+
+	if entry.Program == "" {
+		entry.Program = entry.Code
+	}
+	if entry.Workstation == "" {
+		entry.Workstation = strings.TrimSpace(rules.layout.DefaultWorkstation)
+	}
+	if entry.Program == "" {
+		return entry, "无法确定所属程序"
+	}
+	if entry.Workstation == "" {
+		return entry, "无法确定所属工位"
+	}
+	return entry, ""
+}
+
+// loadBatchImportLayout 按编码加载布局，空编码使用内置默认布局。
+func loadBatchImportLayout(tx *gorm.DB, code string) (*batchImportLayoutRules, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		code = batchImportLayoutDefault
+	}
+	for _, layout := range builtinBatchImportLayouts() {
+		if layout.Code == code {
+			return compileBatchImportLayout(layout)
+		}
+	}
+	var layout models.BatchImportLayout
+	if err := tx.Where("code = ?", code).First(&layout).Error; err != nil {
+		return nil, fmt.Errorf("导入布局 %s 不存在", code)
+	}
+	return compileBatchImportLayout(layout)
+}
+
+// GetBatchImportLayouts 返回全部可选的导入布局，内置布局在前。
+func GetBatchImportLayouts(c *gin.Context) {
+	var layouts []models.BatchImportLayout
+	if err := database.DB.Order("code ASC").Find(&layouts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, append(builtinBatchImportLayouts(), layouts...))
+}
+
+type batchImportLayoutRequest struct {
+	Code               string    `json:"code"`
+	Name               *string   `json:"name"`
+	Segments           *[]string `json:"segments"`
+	FilePattern        *string   `json:"file_pattern"`
+	DefaultWorkstation *string   `json:"default_workstation"`
+	Description        *string   `json:"description"`
+}
+
+func (req batchImportLayoutRequest) apply(layout *models.BatchImportLayout) {
+	if req.Name != nil {
+		layout.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Segments != nil {
+		segments := make([]string, 0, len(*req.Segments))
+		for _, role := range *req.Segments {
+			segments = append(segments, strings.TrimSpace(role))
+		}
+		layout.Segments = segments
+	}
+	if req.FilePattern != nil {
+		layout.FilePattern = strings.TrimSpace(*req.FilePattern)
+	}
+	if req.DefaultWorkstation != nil {
+		layout.DefaultWorkstation = strings.TrimSpace(*req.DefaultWorkstation)
+	}
+	if req.Description != nil {
+		layout.Description = *req.Description
+	}
+}
+
+func validateBatchImportLayout(layout models.BatchImportLayout) string {
+	if layout.Name == "" {
+		return "布局名称不能为空"
+	}
+	if _, err := compileBatchImportLayout(layout); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func CreateBatchImportLayout(c *gin.Context) {
+	var req batchImportLayoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	layout := models.BatchImportLayout{Code: strings.TrimSpace(req.Code)}
+	if !batchImportLayoutCodePattern.MatchString(layout.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "布局编码只能包含小写字母、数字、下划线和连字符，且以字母开头"})
+		return
+	}
+	req.apply(&layout)
+	if msg := validateBatchImportLayout(layout); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	var count int64
+	if err := database.DB.Model(&models.BatchImportLayout{}).Where("code = ?", layout.Code).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	if count > 0 || layout.Code == batchImportLayoutDefault {
+		c.JSON(http.StatusConflict, gin.H{"error": "布局编码已存在"})
+		return
+	}
+
+	if err := database.DB.Create(&layout).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建导入布局失败"})
+		return
+	}
+	c.JSON(http.StatusCreated, layout)
+}
+
+// UpdateBatchImportLayout 编码创建后不可修改。
+func UpdateBatchImportLayout(c *gin.Context) {
+	layoutID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "布局ID格式错误"})
+		return
+	}
+	var layout models.BatchImportLayout
+	if err := database.DB.First(&layout, layoutID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "导入布局不存在"})
+		return
+	}
+
+	var req batchImportLayoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if code := strings.TrimSpace(req.Code); code != "" && code != layout.Code {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "布局编码不可修改"})
+		return
+	}
+	req.apply(&layout)
+	if msg := validateBatchImportLayout(layout); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := database.DB.Model(&layout).
+		Select("name", "segments", "file_pattern", "default_workstation", "description").
+		Updates(&layout).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新导入布局失败"})
+		return
+	}
+	c.JSON(http.StatusOK, layout)
+}
+
+func DeleteBatchImportLayout(c *gin.Context) {
+	layoutID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "布局ID格式错误"})
+		return
+	}
+	if err := database.DB.Delete(&models.BatchImportLayout{}, layoutID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除导入布局失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "导入布局已删除"})
+}
+
+// suggestBatchImportMappings 按布局解析出的产线、车型目录名匹配现有主数据，作为映射建议。
+func suggestBatchImportMappings(tx *gorm.DB, workstations []batchUploadWorkstation, hints map[string]batchLayoutEntry) {
+	for i := range workstations {
+		hint := hints[workstations[i].Name]
+		if hint.Line != "" {
+			var line models.ProductionLine
+			if err := tx.Where("name = ? OR code = ?", hint.Line, hint.Line).First(&line).Error; err == nil {
+				workstations[i].SuggestedProductionLineID = &line.ID
+			}
+		}
+		if hint.VehicleModel != "" {
+			var vehicleModel models.VehicleModel
+			if err := tx.Where("name = ? OR code = ?", hint.VehicleModel, hint.VehicleModel).First(&vehicleModel).Error; err == nil {
+				workstations[i].SuggestedVehicleModelID = &vehicleModel.ID
+			}
+		}
+	}
+}
+
+// batchArchiveEntry 是待按布局归组的一个文件，Path 使用 / 分隔。
+type batchArchiveEntry struct {
+	Path string
+	Size int64
+}
+
+// buildBatchUploadPreview 按布局把文件归组为 工位/程序，无法识别的文件列入 Skipped。
+func buildBatchUploadPreview(tx *gorm.DB, rules *batchImportLayoutRules, entries []batchArchiveEntry) batchUploadPreview {
+	workstations := map[string]map[string]*batchUploadProgram{}
+	hints := map[string]batchLayoutEntry{}
+	preview := batchUploadPreview{Layout: rules.layout.Code, Skipped: []batchUploadSkippedEntry{}}
+
+	for _, archiveEntry := range entries {
+		entryPath := strings.TrimPrefix(archiveEntry.Path, "/")
+		entry, reason := rules.classify(entryPath)
+		if reason != "" {
+			preview.Skipped = append(preview.Skipped, batchUploadSkippedEntry{Path: entryPath, Reason: reason})
+			continue
+		}
+
+		wsName := entry.groupKey()
+		if _, ok := workstations[wsName]; !ok {
+			workstations[wsName] = map[string]*batchUploadProgram{}
+			hints[wsName] = entry
+		}
+		// 提取到编号时按编号归组，同一编号的文件即使程序目录不同也属于同一程序
+		programKey := entry.Program
+		if entry.Code != "" {
+			programKey = entry.Code
+		}
+		program, ok := workstations[wsName][programKey]
+		if !ok {
+			program = &batchUploadProgram{Name: entry.Program, Code: entry.Code}
+			workstations[wsName][programKey] = program
+		}
+		program.Files = append(program.Files, batchUploadProgramFile{
+			Name:    path.Base(archiveEntry.Path),
+			Size:    archiveEntry.Size,
+			Path:    archiveEntry.Path,
+			Version: entry.Version,
+		})
+		preview.TotalFiles++
+	}
+
+	for wsName, programs := range workstations {
+		ws := batchUploadWorkstation{Name: wsName}
+		for _, program := range programs {
+			ws.Programs = append(ws.Programs, *program)
+			preview.TotalPrograms++
+		}
+		sort.Slice(ws.Programs, func(i, j int) bool { return ws.Programs[i].Name < ws.Programs[j].Name })
+		preview.Workstations = append(preview.Workstations, ws)
+	}
+	sort.Slice(preview.Workstations, func(i, j int) bool { return preview.Workstations[i].Name < preview.Workstations[j].Name })
+	suggestBatchImportMappings(tx, preview.Workstations, hints)
+	// 跳过列表按路径稳定排序，便于对照压缩包
+	sort.Slice(preview.Skipped, func(i, j int) bool { return preview.Skipped[i].Path < preview.Skipped[j].Path })
+	return preview
+}
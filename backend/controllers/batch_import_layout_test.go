@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+
+	"crane-system/database"
+	"crane-system/models"
+)
+
+func TestBatchImportLayoutsDriveZipParsing(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	adminToken, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupBatchImportPlanTestRouter()
+
+	useBatchImportTestStorage(t)
+
+	for _, body := range []map[string]any{
+		{"code": "bad-role", "name": "坏角色", "segments": []string{"workstation", "station"}},
+		{"code": "bad-group", "name": "坏分组", "segments": []string{"workstation"}, "file_pattern": `^(?P<line>\w+)`},
+		{"code": "no-program", "name": "无程序", "segments": []string{"workstation"}},
+		{"code": "no-station", "name": "无工位", "file_pattern": `^(?P<code>\w+)_`},
+		{"code": "default", "name": "覆盖内置", "segments": []string{"workstation", "program"}},
+	} {
+		resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/batch-import-layouts", adminToken, body)
+		if resp.Code != http.StatusBadRequest && resp.Code != http.StatusConflict {
+			t.Fatalf("layout %v: status = %d, body = %s", body["code"], resp.Code, resp.Body.String())
+		}
+	}
+	for _, body := range []map[string]any{
+		{"code": "vendor", "name": "产线/车型/工位/程序", "segments": []string{"line", "vehicle_model", "workstation", "program"}},
+		{"code": "flat", "name": "平铺编号", "file_pattern": `^(?P<code>[A-Z]+-\d+)_(?P<version>v\d+)_`, "default_workstation": "默认工位"},
+	} {
+		resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/batch-import-layouts", adminToken, body)
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("create layout %v: status = %d, body = %s", body["code"], resp.Code, resp.Body.String())
+		}
+	}
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodGet, "/api/batch-import-layouts", adminToken, nil)
+	if layouts := decodeProductionLineCustomFieldResponse[[]models.BatchImportLayout](t, resp); len(layouts) != 3 || !layouts[0].Builtin {
+		t.Fatalf("layouts = %+v", layouts)
+	}
+
+	// 默认布局：层级不足的文件出现在跳过列表中
+	preview := uploadBatchImportZip(t, r, adminToken, "", map[string]string{"WS/P1/a.nc": "a", "loose.nc": "b"})
+	if preview.TotalFiles != 1 || len(preview.Skipped) != 1 || preview.Skipped[0].Path != "loose.nc" {
+		t.Fatalf("default preview = %+v", preview)
+	}
+
+	// 多级目录布局：更深的子目录归入程序，产线目录名给出映射建议
+	preview = uploadBatchImportZip(t, r, adminToken, "vendor", map[string]string{
+		"产线A/车型X/ST10/PRG/main.nc":     "m",
+		"产线A/车型X/ST10/PRG/sub/util.nc": "u",
+		"产线A/车型X/short.nc":             "s",
+	})
+	if len(preview.Workstations) != 1 || preview.Workstations[0].Name != "产线A/车型X/ST10" || len(preview.Skipped) != 1 {
+		t.Fatalf("vendor preview = %+v", preview)
+	}
+	ws := preview.Workstations[0]
+	if ws.SuggestedProductionLineID == nil || *ws.SuggestedProductionLineID != line.ID || len(ws.Programs) != 1 || len(ws.Programs[0].Files) != 2 {
+		t.Fatalf("vendor workstation = %+v", ws)
+	}
+
+	// 平铺布局：从文件名提取编号和版本，按提取的编号和版本创建程序
+	preview = uploadBatchImportZip(t, r, adminToken, "flat", map[string]string{
+		"P-100_v3_main.nc": "m",
+		"P-100_v3_sub.nc":  "s",
+		"readme.txt":       "r",
+	})
+	if preview.Layout != "flat" || preview.TotalPrograms != 1 || len(preview.Skipped) != 1 || preview.Skipped[0].Reason != "文件名不符合布局规则" {
+		t.Fatalf("flat preview = %+v", preview)
+	}
+	payload := map[string]any{
+		"preview_id": preview.PreviewID,
+		"mappings":   []map[string]any{{"workstation_name": "默认工位", "production_line_id": line.ID}},
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/batch/import", adminToken, payload)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("flat import: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	taskID := int64(decodeProductionLineCustomFieldResponse[map[string]any](t, resp)["task_id"].(float64))
+	if status := waitProgramExcelImportTask(t, taskID); status.Status != "completed" || status.Success != 1 {
+		t.Fatalf("flat import status = %+v", status)
+	}
+	var program models.Program
+	if err := database.DB.Where("code = ?", "P-100").First(&program).Error; err != nil || program.Version != "v3" {
+		t.Fatalf("imported program = %+v, err = %v", program, err)
+	}
+}
@@ -0,0 +1,385 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"crane-system/database"
+	"crane-system/models"
+	"crane-system/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// batchImportArchiveRetention 是失败条目可重试的期限，过期后保留的压缩包会被清理。
+const batchImportArchiveRetention = 7 * 24 * time.Hour
+
+const (
+	batchImportItemPending = "pending"
+	batchImportItemSuccess = "success"
+	batchImportItemError   = "error"
+)
+
+// batchImportStoredPlan 是写入 BatchImportTaskItem.Plan 的内容，重试时据此重新生成计划。
+type batchImportStoredPlan struct {
+	Source  batchUploadProgram `json:"source"`
+	Mapping batchImportMapping `json:"mapping"`
+}
+
+// moveBatchImportArchive 把预览的压缩包移入保留目录；跨文件系统时退回复制。
+func moveBatchImportArchive(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	reader, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	writer, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		_ = writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// createBatchImportRecord 持久化导入任务和逐条计划，并把压缩包移入保留目录。
+func createBatchImportRecord(ownerID uint, layout string, plan []batchImportPlanItem, tempDir string) (record models.BatchImportTask, err error) {
+	cleanupExpiredBatchImportArchives(time.Now())
+	defer func() {
+		if err != nil && record.ID != 0 {
+			_ = database.DB.Model(&models.BatchImportTask{}).Where("id = ?", record.ID).Update("status", "failed").Error
+		}
+	}()
+
+	record = models.BatchImportTask{
+		OwnerID:   ownerID,
+		Layout:    layout,
+		Status:    "running",
+		Total:     len(plan),
+		Attempts:  1,
+		ExpiresAt: time.Now().Add(batchImportArchiveRetention),
+	}
+	if err := database.DB.Create(&record).Error; err != nil {
+		return record, err
+	}
+
+	archiveDir := filepath.Join(utils.BatchImportDir(), strconv.FormatUint(uint64(record.ID), 10))
+	if err := utils.EnsureDirectoryExists(archiveDir); err != nil {
+		return record, err
+	}
+	if err := moveBatchImportArchive(filepath.Join(tempDir, "batch.zip"), filepath.Join(archiveDir, "batch.zip")); err != nil {
+		_ = os.RemoveAll(archiveDir)
+		return record, err
+	}
+	record.ArchiveDir = archiveDir
+	if err := database.DB.Model(&record).Update("archive_dir", archiveDir).Error; err != nil {
+		return record, err
+	}
+
+	items := make([]models.BatchImportTaskItem, 0, len(plan))
+	for index, item := range plan {
+		stored, err := json.Marshal(batchImportStoredPlan{Source: item.source, Mapping: item.mapping})
+		if err != nil {
+			return record, err
+		}
+		items = append(items, models.BatchImportTaskItem{
+			TaskID:      record.ID,
+			ItemNo:      index + 1,
+			Workstation: item.Workstation,
+			Program:     item.Program,
+			ProgramCode: item.ProgramCode,
+			Action:      item.Action,
+			Status:      batchImportItemPending,
+			Attempts:    1,
+			Plan:        string(stored),
+		})
+	}
+	if len(items) > 0 {
+		if err := database.DB.CreateInBatches(&items, 200).Error; err != nil {
+			return record, err
+		}
+	}
+	return record, nil
+}
+
+func saveBatchImportItemResult(itemID uint, programCode string, importErr error) {
+	updates := map[string]any{"status": batchImportItemSuccess, "error": ""}
+	if programCode != "" {
+		updates["program_code"] = programCode
+	}
+	if importErr != nil {
+		updates["status"] = batchImportItemError
+		updates["error"] = importErr.Error()
+	}
+	_ = database.DB.Model(&models.BatchImportTaskItem{}).Where("id = ?", itemID).Updates(updates).Error
+}
+
+// failPendingBatchImportItems 把仍待处理的条目记为失败并汇总任务状态。
+func failPendingBatchImportItems(recordID uint, message string) {
+	_ = database.DB.Model(&models.BatchImportTaskItem{}).
+		Where("task_id = ? AND status = ?", recordID, batchImportItemPending).
+		Updates(map[string]any{"status": batchImportItemError, "error": message}).Error
+	finishBatchImportRecord(recordID)
+}
+
+// finishBatchImportRecord 按条目结果汇总任务状态；没有失败条目时不再需要保留压缩包。
+func finishBatchImportRecord(recordID uint) {
+	var record models.BatchImportTask
+	if err := database.DB.First(&record, recordID).Error; err != nil {
+		return
+	}
+	var success, failed int64
+	database.DB.Model(&models.BatchImportTaskItem{}).Where("task_id = ? AND status = ?", recordID, batchImportItemSuccess).Count(&success)
+	database.DB.Model(&models.BatchImportTaskItem{}).Where("task_id = ? AND status <> ?", recordID, batchImportItemSuccess).Count(&failed)
+
+	status := "partial"
+	switch {
+	case failed == 0:
+		status = "completed"
+	case success == 0:
+		status = "failed"
+	}
+	now := time.Now()
+	updates := map[string]any{"status": status, "success": success, "failed": failed, "finished_at": &now}
+	if failed == 0 && record.ArchiveDir != "" {
+		_ = os.RemoveAll(record.ArchiveDir)
+		updates["archive_dir"] = ""
+	}
+	_ = database.DB.Model(&models.BatchImportTask{}).Where("id = ?", recordID).Updates(updates).Error
+}
+
+// cleanupExpiredBatchImportArchives 删除超过保留期的压缩包，任务记录和条目结果继续保留。
+func cleanupExpiredBatchImportArchives(now time.Time) {
+	var expired []models.BatchImportTask
+	if err := database.DB.Where("archive_dir <> ? AND expires_at < ? AND status <> ?", "", now, "running").Find(&expired).Error; err != nil {
+		return
+	}
+	for _, record := range expired {
+		_ = os.RemoveAll(record.ArchiveDir)
+		_ = database.DB.Model(&models.BatchImportTask{}).Where("id = ?", record.ID).Update("archive_dir", "").Error
+	}
+}
+
+func batchImportRetryable(record models.BatchImportTask) bool {
+	return record.Status != "running" && record.Failed > 0 && record.ArchiveDir != "" && record.ExpiresAt.After(time.Now())
+}
+
+// loadAccessibleBatchImport 加载当前用户发起的导入任务；管理员可以查看全部。
+func loadAccessibleBatchImport(c *gin.Context) (models.BatchImportTask, bool) {
+	var record models.BatchImportTask
+	recordID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "导入任务ID格式错误"})
+		return record, false
+	}
+	role := currentAccountRole(c)
+	if err := database.DB.First(&record, recordID).Error; err != nil ||
+		(record.OwnerID != currentUserID(c) && role != "admin" && role != "system_admin") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "导入任务不存在"})
+		return record, false
+	}
+	record.Retryable = batchImportRetryable(record)
+	return record, true
+}
+
+// GetBatchImports 返回当前用户最近的批量导入记录；管理员返回全部。
+func GetBatchImports(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+	query := database.DB.Preload("Owner").Order("id DESC").Limit(limit)
+	if role := currentAccountRole(c); role != "admin" && role != "system_admin" {
+		query = query.Where("owner_id = ?", currentUserID(c))
+	}
+	var records []models.BatchImportTask
+	if err := query.Find(&records).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	for i := range records {
+		records[i].Retryable = batchImportRetryable(records[i])
+	}
+	c.JSON(http.StatusOK, records)
+}
+
+// GetBatchImport 返回导入任务及全部条目结果，status=error 只返回失败条目。
+func GetBatchImport(c *gin.Context) {
+	record, ok := loadAccessibleBatchImport(c)
+	if !ok {
+		return
+	}
+	query := database.DB.Where("task_id = ?", record.ID)
+	if status := strings.TrimSpace(c.Query("status")); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Order("item_no ASC").Find(&record.Items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, record)
+}
+
+// RetryBatchImport 使用保留的压缩包重新导入失败条目；计划按当前数据重新生成，
+// 期间其他人已导入的程序会变为追加版本或无变化。
+func RetryBatchImport(c *gin.Context) {
+	record, ok := loadAccessibleBatchImport(c)
+	if !ok {
+		return
+	}
+	if !record.Retryable {
+		c.JSON(http.StatusConflict, gin.H{"error": "导入任务没有可重试的失败条目，或保留的压缩包已过期"})
+		return
+	}
+
+	var failedItems []models.BatchImportTaskItem
+	if err := database.DB.Where("task_id = ? AND status <> ?", record.ID, batchImportItemSuccess).Order("item_no ASC").Find(&failedItems).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	for _, item := range failedItems {
+		var stored batchImportStoredPlan
+		if err := json.Unmarshal([]byte(item.Plan), &stored); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("第 %d 条的导入计划已损坏", item.ItemNo)})
+			return
+		}
+		if stored.Mapping.ProductionLineID != nil && !authorizeLineAction(c, *stored.Mapping.ProductionLineID, lineActionManage) {
+			return
+		}
+	}
+	plan, err := planStoredBatchImportItems(c, record, failedItems)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 条件更新抢占任务，避免同一任务被并发重试
+	claim := database.DB.Model(&models.BatchImportTask{}).
+		Where("id = ? AND status <> ?", record.ID, "running").
+		Updates(map[string]any{"status": "running", "attempts": record.Attempts + 1, "finished_at": nil})
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "导入任务正在执行"})
+		return
+	}
+	for i := range failedItems {
+		failedItems[i].Attempts++
+		if err := database.DB.Model(&failedItems[i]).Updates(map[string]any{
+			"action": plan[i].Action, "status": batchImportItemPending, "error": "", "attempts": failedItems[i].Attempts,
+		}).Error; err != nil {
+			finishBatchImportRecord(record.ID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新导入条目失败"})
+			return
+		}
+	}
+
+	job, err := enqueueJob(jobTypeBatchImport, currentUserID(c), batchImportJobPayload{ImportID: record.ID}, len(plan))
+	if err != nil {
+		failPendingBatchImportItems(record.ID, "提交导入任务失败")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交导入任务失败"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"task_id": job.ID, "import_id": record.ID, "summary": summarizeBatchImportPlan(plan)})
+}
+
+// planStoredBatchImportItems 按条目中保存的来源和映射重新生成计划，返回的计划与 items 按顺序一一对应。
+// 同一工位的条目合并计划，保证多个条目指向同一程序时仍会被识别为冲突。
+func planStoredBatchImportItems(c *gin.Context, record models.BatchImportTask, items []models.BatchImportTaskItem) ([]batchImportPlanItem, error) {
+	preview := batchUploadPreview{Layout: record.Layout}
+	mappings := map[string]batchImportMapping{}
+	positions := map[string]int{}
+	for _, item := range items {
+		var stored batchImportStoredPlan
+		if err := json.Unmarshal([]byte(item.Plan), &stored); err != nil {
+			return nil, fmt.Errorf("第 %d 条的导入计划已损坏", item.ItemNo)
+		}
+		position, ok := positions[item.Workstation]
+		if !ok {
+			position = len(preview.Workstations)
+			positions[item.Workstation] = position
+			preview.Workstations = append(preview.Workstations, batchUploadWorkstation{Name: item.Workstation})
+			mappings[item.Workstation] = stored.Mapping
+		}
+		preview.Workstations[position].Programs = append(preview.Workstations[position].Programs, stored.Source)
+		preview.TotalPrograms++
+		preview.TotalFiles += len(stored.Source.Files)
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	zr, archiveFiles, err := openBatchImportArchive(record.ArchiveDir)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	planned, err := planBatchImport(c, preview, archiveFiles, mappings)
+	if err != nil {
+		return nil, errors.New("生成导入计划失败")
+	}
+	byKey := make(map[string]batchImportPlanItem, len(planned))
+	for _, item := range planned {
+		byKey[item.Workstation+"\x00"+item.Program] = item
+	}
+	plan := make([]batchImportPlanItem, 0, len(items))
+	for _, item := range items {
+		planItem, ok := byKey[item.Workstation+"\x00"+item.Program]
+		if !ok {
+			return nil, fmt.Errorf("第 %d 条未能生成导入计划", item.ItemNo)
+		}
+		plan = append(plan, planItem)
+	}
+	return plan, nil
+}
+
+// ExportBatchImportResults 以 CSV 下载逐条导入结果，bom=true 时写入 UTF-8 BOM。
+func ExportBatchImportResults(c *gin.Context) {
+	record, ok := loadAccessibleBatchImport(c)
+	if !ok {
+		return
+	}
+	var items []models.BatchImportTaskItem
+	if err := database.DB.Where("task_id = ?", record.ID).Order("item_no ASC").Find(&items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	fileName := fmt.Sprintf("batch_import_%d_results.csv", record.ID)
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename=\""+fileName+"\"; filename*=UTF-8''"+url.QueryEscape(fileName))
+	c.Status(http.StatusOK)
+	if strings.TrimSpace(c.Query("bom")) == "true" {
+		_, _ = c.Writer.Write([]byte("\xEF\xBB\xBF"))
+	}
+
+	writer := csv.NewWriter(c.Writer)
+	_ = writer.Write([]string{"序号", "工位", "程序", "程序编号", "处理方式", "结果", "错误信息", "尝试次数"})
+	for _, item := range items {
+		_ = writer.Write([]string{
+			strconv.Itoa(item.ItemNo),
+			item.Workstation,
+			item.Program,
+			item.ProgramCode,
+			item.Action,
+			item.Status,
+			item.Error,
+			strconv.Itoa(item.Attempts),
+		})
+	}
+	writer.Flush()
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"crane-system/database"
+	"crane-system/models"
+)
+
+func TestBatchImportPersistsResultsAndRetriesFailedItems(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	adminToken, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupBatchImportPlanTestRouter()
+	useBatchImportTestStorage(t)
+
+	// 两个同名程序让 DUP 在首次导入时冲突
+	seedBatchImportProgram(t, line.ID, "DUP", "P-DUP1", map[string]string{"a.nc": "old"})
+	blocker := seedBatchImportProgram(t, line.ID, "DUP", "P-DUP2", nil)
+
+	preview := uploadBatchImportZip(t, r, adminToken, "", map[string]string{
+		"WS/NEW/x.nc": "fresh",
+		"WS/DUP/a.nc": "new",
+	})
+	payload := map[string]any{
+		"preview_id": preview.PreviewID,
+		"mappings":   []map[string]any{{"workstation_name": "WS", "production_line_id": line.ID}},
+	}
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/batch/import", adminToken, payload)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("import: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	started := decodeProductionLineCustomFieldResponse[map[string]any](t, resp)
+	importID := uint(started["import_id"].(float64))
+	waitProgramExcelImportTask(t, int64(started["task_id"].(float64)))
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/batch-imports/%d", importID), adminToken, nil)
+	record := decodeProductionLineCustomFieldResponse[models.BatchImportTask](t, resp)
+	if record.Status != "partial" || record.Success != 1 || record.Failed != 1 || !record.Retryable || len(record.Items) != 2 {
+		t.Fatalf("record after first run = %+v", record)
+	}
+	var stored models.BatchImportTask
+	database.DB.First(&stored, importID)
+	if _, err := os.Stat(stored.ArchiveDir); err != nil {
+		t.Fatalf("archive not retained: %v", err)
+	}
+
+	// 清除冲突后重试，只处理失败条目
+	if err := database.DB.Delete(&models.Program{}, blocker.ID).Error; err != nil {
+		t.Fatalf("delete blocker: %v", err)
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, fmt.Sprintf("/api/batch-imports/%d/retry", importID), adminToken, nil)
+	if resp.Code != http.StatusAccepted {
+		t.Fatalf("retry: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	retried := decodeProductionLineCustomFieldResponse[map[string]any](t, resp)
+	if status := waitProgramExcelImportTask(t, int64(retried["task_id"].(float64))); status.Total != 1 || status.Success != 1 {
+		t.Fatalf("retry task = %+v", status)
+	}
+
+	database.DB.First(&stored, importID)
+	if stored.Status != "completed" || stored.Attempts != 2 || stored.ArchiveDir != "" {
+		t.Fatalf("record after retry = %+v", stored)
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, fmt.Sprintf("/api/batch-imports/%d/retry", importID), adminToken, nil)
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("retry completed import: status = %d", resp.Code)
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/batch-imports/%d/results.csv", importID), adminToken, nil)
+	rows, err := csv.NewReader(strings.NewReader(resp.Body.String())).ReadAll()
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("results csv = %q, err = %v", resp.Body.String(), err)
+	}
+	for _, row := range rows[1:] {
+		if row[5] != batchImportItemSuccess {
+			t.Fatalf("csv row = %v", row)
+		}
+	}
+	if rows[1][2] != "DUP" || rows[1][4] != batchPlanNewVersion || rows[1][7] != "2" {
+		t.Fatalf("retried csv row = %v", rows[1])
+	}
+
+	other := models.User{Name: "Other", Password: "hashed", EmployeeID: "EMP-OTHER", Role: "user", Status: "active"}
+	if err := database.DB.Create(&other).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	otherToken := createUserTokenForTest(t, other.ID, other.Role)
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/batch-imports/%d", importID), otherToken, nil)
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("other user: status = %d", resp.Code)
+	}
+}
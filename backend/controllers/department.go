@@ -3,6 +3,8 @@ package controllers
 import (
 	"crane-system/database"
 	"crane-system/models"
+	"crane-system/services"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
@@ -88,18 +90,38 @@ func GetDepartment(c *gin.Context) {
 	c.JSON(http.StatusOK, department)
 }
 
+// GetDepartmentTree 返回按上下级组装的部门树。
+func GetDepartmentTree(c *gin.Context) {
+	var departments []models.Department
+	query := database.DB
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Find(&departments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取部门列表失败，请稍后重试"})
+		return
+	}
+	c.JSON(http.StatusOK, services.BuildDepartmentTree(departments))
+}
+
 func CreateDepartment(c *gin.Context) {
 	var department models.Department
 	if err := c.ShouldBindJSON(&department); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "请检查输入信息是否完整"})
 		return
 	}
+	department.Children = nil
+	if err := services.ValidateDepartmentParent(database.DB, 0, department.ParentID); err != nil {
+		writeDepartmentParentError(c, err)
+		return
+	}
 
 	if err := database.DB.Create(&department).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建部门失败，请检查部门名称是否重复"})
 		return
 	}
 
+	recordAuditChange(c, "department", department.ID, nil, department)
 	c.JSON(http.StatusCreated, department)
 }
 
@@ -153,23 +175,80 @@ func UpdateDepartment(c *gin.Context) {
 		return
 	}
 
+	before := department
 	if err := database.DB.Model(&department).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新部门信息失败，请稍后重试"})
 		return
 	}
 
+	recordAuditChange(c, "department", department.ID, before, department)
+	c.JSON(http.StatusOK, department)
+}
+
+type moveDepartmentRequest struct {
+	ParentID *uint `json:"parent_id"`
+}
+
+// MoveDepartment 修改部门的上级部门，parent_id 为空时移动为顶级部门。
+// 部门规则沿部门树继承，移动后所有用户的权限缓存都需要失效。
+func MoveDepartment(c *gin.Context) {
+	departmentID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "部门ID格式错误"})
+		return
+	}
+
+	var department models.Department
+	if err := database.DB.First(&department, departmentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到该部门信息"})
+		return
+	}
+
+	var req moveDepartmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请检查输入信息是否正确"})
+		return
+	}
+	if req.ParentID != nil && *req.ParentID == 0 {
+		req.ParentID = nil
+	}
+
+	if err := services.MoveDepartment(database.DB, department.ID, req.ParentID); err != nil {
+		writeDepartmentParentError(c, err)
+		return
+	}
+	services.InvalidateAllCache()
+
+	before := department
+	department.ParentID = req.ParentID
+	recordAuditChange(c, "department", department.ID, before, department)
 	c.JSON(http.StatusOK, department)
 }
 
+func writeDepartmentParentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrDepartmentParentNotFound), errors.Is(err, services.ErrDepartmentCycle):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新部门信息失败，请稍后重试"})
+	}
+}
+
 func DeleteDepartment(c *gin.Context) {
 	departmentID, err := parseUintParam(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "部门ID格式错误"})
 		return
 	}
+	var department models.Department
+	if err := database.DB.First(&department, departmentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到该部门信息"})
+		return
+	}
 
 	if dependency, err := findMasterDataDependency([]masterDataDependencyCheck{
 		{Model: &models.User{}, Where: "department_id = ?", Args: []any{departmentID}, Label: "users"},
+		{Model: &models.Department{}, Where: "parent_id = ?", Args: []any{departmentID}, Label: "child departments"},
 	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "dependency check failed"})
 		return
@@ -197,5 +276,6 @@ func DeleteDepartment(c *gin.Context) {
 		return
 	}
 
+	recordAuditChange(c, "department", department.ID, department, nil)
 	c.JSON(http.StatusOK, gin.H{"message": "部门已成功删除"})
 }
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+	"crane-system/services"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupDepartmentTreeTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	{
+		api.GET("/departments/tree", GetDepartmentTree)
+		api.POST("/departments", CreateDepartment)
+		api.PUT("/departments/:id/move", MoveDepartment)
+		api.DELETE("/departments/:id", DeleteDepartment)
+	}
+	return r
+}
+
+func TestDepartmentTreeInheritsRulesAndRejectsCycles(t *testing.T) {
+	db := openProductionLineCustomFieldTestDB(t)
+	database.DB = db
+	adminToken, line := seedProductionLineCustomFieldAuthData(t, db)
+	services.InvalidateAllCache()
+	router := setupDepartmentTreeTestRouter()
+
+	createDepartment := func(name string, parentID *uint) models.Department {
+		t.Helper()
+		recorder := performProductionLineCustomFieldRequest(t, router, http.MethodPost, "/api/departments", adminToken, map[string]any{"name": name, "parent_id": parentID})
+		if recorder.Code != http.StatusCreated {
+			t.Fatalf("create department %s: %d %s", name, recorder.Code, recorder.Body.String())
+		}
+		return decodeProductionLineCustomFieldResponse[models.Department](t, recorder)
+	}
+	workshop := createDepartment("焊接车间", nil)
+	team := createDepartment("A线班组", &workshop.ID)
+
+	missingParent := uint(9999)
+	recorder := performProductionLineCustomFieldRequest(t, router, http.MethodPost, "/api/departments", adminToken, map[string]any{"name": "孤立班组", "parent_id": missingParent})
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected missing parent to be rejected, got %d", recorder.Code)
+	}
+
+	member := models.User{Name: "Welder", Password: "hashed", EmployeeID: "WELD-001", Role: "viewer", Status: "active", DepartmentID: &team.ID}
+	if err := db.Create(&member).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	// 车间配置的规则向下继承到班组，班组自己的规则覆盖车间的同一单元格
+	if err := services.SavePermissionRuleChanges(services.PermissionSubject{Type: models.PermissionSubjectDepartment, ID: workshop.ID}, []services.PermissionRuleChange{
+		{ResourceType: models.PermissionResourceProductionLine, ResourceID: line.ID, Action: models.PermissionActionView, Decision: models.PermissionDecisionAllow},
+		{ResourceType: models.PermissionResourceProductionLine, ResourceID: line.ID, Action: models.PermissionActionUpload, Decision: models.PermissionDecisionAllow},
+	}); err != nil {
+		t.Fatalf("save workshop rules: %v", err)
+	}
+	if err := services.SavePermissionRuleChanges(services.PermissionSubject{Type: models.PermissionSubjectDepartmentDefault, ID: workshop.ID}, []services.PermissionRuleChange{
+		{ResourceType: models.PermissionResourceProductionLine, ResourceID: line.ID, Action: models.PermissionActionDownload, Decision: models.PermissionDecisionAllow},
+	}); err != nil {
+		t.Fatalf("save workshop default rules: %v", err)
+	}
+	if err := services.SavePermissionRuleChanges(services.PermissionSubject{Type: models.PermissionSubjectDepartment, ID: team.ID}, []services.PermissionRuleChange{
+		{ResourceType: models.PermissionResourceProductionLine, ResourceID: line.ID, Action: models.PermissionActionUpload, Decision: models.PermissionDecisionDeny},
+	}); err != nil {
+		t.Fatalf("save team rules: %v", err)
+	}
+
+	for action, want := range map[services.LineAction]bool{
+		services.LineActionView:     true,
+		services.LineActionDownload: true,
+		services.LineActionUpload:   false,
+	} {
+		if got := services.UserHasLinePermission(member.ID, line.ID, string(action)); got != want {
+			t.Fatalf("inherited %s = %v, want %v", action, got, want)
+		}
+	}
+	simulation, err := services.SimulatePermissionRuleChanges(services.PermissionSubject{Type: models.PermissionSubjectDepartment, ID: workshop.ID}, []services.PermissionRuleChange{
+		{ResourceType: models.PermissionResourceProductionLine, ResourceID: line.ID, Action: models.PermissionActionView, Decision: "unset"},
+	})
+	if err != nil {
+		t.Fatalf("simulate workshop rule change: %v", err)
+	}
+	if simulation.AffectedUsers != 1 || simulation.Users[0].UserID != member.ID {
+		t.Fatalf("expected team member to be affected by workshop rule change, got %+v", simulation)
+	}
+
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodPut, fmt.Sprintf("/api/departments/%d/move", workshop.ID), adminToken, map[string]any{"parent_id": team.ID})
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected moving a department under its child to be rejected, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodPut, fmt.Sprintf("/api/departments/%d/move", team.ID), adminToken, map[string]any{"parent_id": team.ID})
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected moving a department under itself to be rejected, got %d", recorder.Code)
+	}
+
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, "/api/departments/tree", adminToken, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("get department tree: %d %s", recorder.Code, recorder.Body.String())
+	}
+	tree := decodeProductionLineCustomFieldResponse[[]models.Department](t, recorder)
+	var workshopNode *models.Department
+	for i := range tree {
+		if tree[i].ID == workshop.ID {
+			workshopNode = &tree[i]
+		}
+	}
+	if workshopNode == nil || len(workshopNode.Children) != 1 || workshopNode.Children[0].ID != team.ID {
+		t.Fatalf("expected team to be nested under workshop, got %+v", tree)
+	}
+
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodDelete, fmt.Sprintf("/api/departments/%d", workshop.ID), adminToken, nil)
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("expected deleting a department with children to conflict, got %d", recorder.Code)
+	}
+
+	// 移动为顶级部门后不再继承车间的规则
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodPut, fmt.Sprintf("/api/departments/%d/move", team.ID), adminToken, map[string]any{"parent_id": nil})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("move team to root: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if services.UserHasLinePermission(member.ID, line.ID, models.PermissionActionView) {
+		t.Fatal("team member should lose inherited view after the team leaves the workshop")
+	}
+}
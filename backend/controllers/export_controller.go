@@ -30,6 +30,7 @@ type exportColumnDef struct {
 
 func builtinExportColumns() []exportColumnDef {
 	return []exportColumnDef{
+		{Key: "id", Label: "程序ID", Group: "基本信息"},
 		{Key: "name", Label: "程序名称", Group: "基本信息"},
 		{Key: "code", Label: "程序编号", Group: "基本信息"},
 		{Key: "production_line", Label: "生产线", Group: "归属"},
@@ -45,29 +46,37 @@ func builtinExportColumns() []exportColumnDef {
 
 // GetExportColumns 返回当前用户可见的所有可用列定义。
 func GetExportColumns(c *gin.Context) {
-	allowedLineIDs, statusCode, msg := resolveAuthorizedLineIDs(c, lineActionView)
+	cfColumns, statusCode, msg := loadVisibleCustomFieldColumns(c)
 	if statusCode != 0 {
 		c.JSON(statusCode, gin.H{"error": msg})
 		return
 	}
 
+	c.JSON(http.StatusOK, gin.H{
+		"builtin_fields": builtinExportColumns(),
+		"custom_fields":  cfColumns,
+	})
+}
+
+// loadVisibleCustomFieldColumns 返回当前用户有查看权限的产线（含按车型授权的产线）下启用的自定义字段列。
+func loadVisibleCustomFieldColumns(c *gin.Context) ([]exportColumnDef, int, string) {
+	scope, statusCode, msg := resolveAuthorizedProgramScope(c, lineActionView)
+	if statusCode != 0 {
+		return nil, statusCode, msg
+	}
+
 	// 查询自定义字段
 	var customFields []models.ProductionLineCustomField
 	query := database.DB.Where("enabled = ?", true)
-	if allowedLineIDs != nil {
-		lineIDs := make([]uint, 0, len(allowedLineIDs))
-		for id := range allowedLineIDs {
-			lineIDs = append(lineIDs, id)
-		}
+	if scope != nil {
+		lineIDs := scope.VisibleLineIDs()
 		if len(lineIDs) == 0 {
-			customFields = nil
-		} else {
-			query = query.Where("production_line_id IN ?", lineIDs)
+			return []exportColumnDef{}, 0, ""
 		}
+		query = query.Where("production_line_id IN ?", lineIDs)
 	}
 	if err := query.Order("sort_order ASC, id ASC").Find(&customFields).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询自定义字段失败"})
-		return
+		return nil, http.StatusInternalServerError, "查询自定义字段失败"
 	}
 
 	cfColumns := make([]exportColumnDef, 0, len(customFields))
@@ -80,11 +89,7 @@ func GetExportColumns(c *gin.Context) {
 			ProductionLineID: cf.ProductionLineID,
 		})
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"builtin_fields": builtinExportColumns(),
-		"custom_fields":  cfColumns,
-	})
+	return cfColumns, 0, ""
 }
 
 // ──────────────────────────────────────────────────────────────
@@ -131,45 +136,56 @@ func parseLineIDs(c *gin.Context) []uint {
 	return ids
 }
 
-// exportQueryPrograms 构建带权限 + 筛选的程序查询。
+// exportSortColumns 是导出允许的排序字段，映射到 programs 表的列。
+var exportSortColumns = map[string]string{
+	"id":              "programs.id",
+	"name":            "programs.name",
+	"code":            "programs.code",
+	"status":          "programs.status",
+	"version":         "programs.version",
+	"created_at":      "programs.created_at",
+	"production_line": "programs.production_line_id",
+	"vehicle_model":   "programs.vehicle_model_id",
+}
+
+// parseExportSort 解析 sort_by / sort_order 参数，返回 ORDER BY 子句；默认按 ID 倒序。
+// 非 ID 排序时追加 ID 作为次级排序，保证分批读取时顺序稳定。
+func parseExportSort(c *gin.Context) (string, error) {
+	sortBy := strings.TrimSpace(c.Query("sort_by"))
+	sortOrder := strings.ToUpper(strings.TrimSpace(c.DefaultQuery("sort_order", "desc")))
+	if sortOrder != "ASC" && sortOrder != "DESC" {
+		return "", fmt.Errorf("invalid sort_order")
+	}
+	if sortBy == "" {
+		sortBy = "id"
+	}
+	column, ok := exportSortColumns[sortBy]
+	if !ok {
+		return "", fmt.Errorf("invalid sort_by")
+	}
+	if sortBy == "id" {
+		return column + " " + sortOrder, nil
+	}
+	return column + " " + sortOrder + ", programs.id DESC", nil
+}
+
+// exportQueryPrograms 构建带权限 + 筛选的程序查询，权限范围包含车型规则。
 func exportQueryPrograms(c *gin.Context, extraLineIDs []uint) (*gorm.DB, []uint, int, string) {
-	allowedLineIDs, statusCode, msg := resolveAuthorizedLineIDs(c, lineActionView)
+	scope, statusCode, msg := resolveAuthorizedProgramScope(c, lineActionView)
 	if statusCode != 0 {
 		return nil, nil, statusCode, msg
 	}
 
 	query := database.DB.Model(&models.Program{})
 
-	// 合并权限产线 + 用户指定产线
-	if allowedLineIDs != nil {
-		lineIDs := make([]uint, 0, len(allowedLineIDs))
-		for id := range allowedLineIDs {
-			lineIDs = append(lineIDs, id)
-		}
-		if len(lineIDs) == 0 {
-			query = query.Where("1 = 0")
-		} else if len(extraLineIDs) > 0 {
-			// 取交集
-			allowed := make(map[uint]struct{}, len(lineIDs))
-			for _, id := range lineIDs {
-				allowed[id] = struct{}{}
-			}
-			var intersection []uint
-			for _, id := range extraLineIDs {
-				if _, ok := allowed[id]; ok {
-					intersection = append(intersection, id)
-				}
-			}
-			if len(intersection) == 0 {
-				query = query.Where("1 = 0")
-			} else {
-				query = query.Where("production_line_id IN ?", intersection)
-			}
-		} else {
-			query = query.Where("production_line_id IN ?", lineIDs)
-		}
-	} else if len(extraLineIDs) > 0 {
-		query = query.Where("production_line_id IN ?", extraLineIDs)
+	// 用户指定产线与权限范围取交集
+	if len(extraLineIDs) > 0 {
+		query = query.Where("programs.production_line_id IN ?", extraLineIDs)
+	}
+	if scope.Empty() {
+		query = query.Where("1 = 0")
+	} else {
+		query = scope.Apply(query, "programs.")
 	}
 
 	filterQuery, filterErr := applyProgramRequestFilters(c, query)
@@ -179,10 +195,8 @@ func exportQueryPrograms(c *gin.Context, extraLineIDs []uint) (*gorm.DB, []uint,
 
 	// 返回合并后的产线 ID 列表（用于后续列元数据过滤）
 	var mergedLineIDs []uint
-	if allowedLineIDs != nil {
-		for id := range allowedLineIDs {
-			mergedLineIDs = append(mergedLineIDs, id)
-		}
+	if scope != nil {
+		mergedLineIDs = scope.VisibleLineIDs()
 	} else {
 		mergedLineIDs = extraLineIDs
 	}
@@ -194,8 +208,12 @@ func exportQueryPrograms(c *gin.Context, extraLineIDs []uint) (*gorm.DB, []uint,
 // 预览接口
 // ──────────────────────────────────────────────────────────────
 
-// ExportPreview 返回预览数据（JSON），与导出共享查询逻辑。
+// ExportPreview 返回预览数据（JSON），与导出共享查询逻辑；template 参数可套用已保存的导出模板。
 func ExportPreview(c *gin.Context) {
+	if statusCode, msg := applyExportTemplate(c); statusCode != 0 {
+		c.JSON(statusCode, gin.H{"error": msg})
+		return
+	}
 	extraLineIDs := parseLineIDs(c)
 	query, _, statusCode, msg := exportQueryPrograms(c, extraLineIDs)
 	if statusCode != 0 {
@@ -213,6 +231,11 @@ func ExportPreview(c *gin.Context) {
 	}
 
 	columnKeys := parseColumnKeys(c)
+	orderClause, err := parseExportSort(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	var total int64
 	if err := query.Count(&total).Error; err != nil {
@@ -226,7 +249,7 @@ func ExportPreview(c *gin.Context) {
 		Preload("VehicleModel").
 		Preload("CustomFieldValues").
 		Preload("CustomFieldValues.ProductionLineCustomField").
-		Order("id DESC").
+		Order(orderClause).
 		Offset((page - 1) * pageSize).
 		Limit(pageSize).
 		Find(&programs).Error; err != nil {
@@ -252,10 +275,16 @@ func ExportPreview(c *gin.Context) {
 	// 构建列头信息
 	columnHeaders := buildColumnHeaders(columnKeys, cfDefMap)
 
+	statusCatalog, err := loadProgramStatusCatalog(database.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
 	// 构建行数据
 	items := make([]map[string]any, 0, len(programs))
 	for _, p := range programs {
-		row := buildExportRow(p, columnKeys)
+		row := buildExportRow(p, columnKeys, statusCatalog)
 		items = append(items, row)
 	}
 
@@ -274,6 +303,10 @@ func ExportPreview(c *gin.Context) {
 
 // ExportStats 返回程序统计数据，用于前端统计卡片。
 func ExportStats(c *gin.Context) {
+	if statusCode, msg := applyExportTemplate(c); statusCode != 0 {
+		c.JSON(statusCode, gin.H{"error": msg})
+		return
+	}
 	extraLineIDs := parseLineIDs(c)
 	query, _, statusCode, msg := exportQueryPrograms(c, extraLineIDs)
 	if statusCode != 0 {
@@ -297,8 +330,15 @@ func ExportStats(c *gin.Context) {
 		return
 	}
 
+	statusCatalog, err := loadProgramStatusCatalog(database.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "统计查询失败"})
+		return
+	}
+
 	totalPrograms := 0
 	completedPrograms := 0
+	statusTotals := make(map[string]int)
 	lineTotal := make(map[uint]int)
 	lineCompleted := make(map[uint]int)
 	modelTotal := make(map[uint]int)
@@ -310,7 +350,8 @@ func ExportStats(c *gin.Context) {
 		lineTotal[r.LineID] += r.Cnt
 		modelTotal[r.ModelID] += r.Cnt
 		cellSet[fmt.Sprintf("%d:%d", r.LineID, r.ModelID)] = true
-		if r.Status == "completed" {
+		statusTotals[r.Status] += r.Cnt
+		if statusCatalog.completed(r.LineID, r.Status) {
 			completedPrograms += r.Cnt
 			lineCompleted[r.LineID] += r.Cnt
 			modelCompleted[r.ModelID] += r.Cnt
@@ -321,11 +362,8 @@ func ExportStats(c *gin.Context) {
 	var allLines []models.ProductionLine
 	{
 		lineQuery := database.DB.Model(&models.ProductionLine{})
-		if allowedLineIDs, statusCode, _ := resolveAuthorizedLineIDs(c, lineActionView); statusCode == 0 && allowedLineIDs != nil {
-			ids := make([]uint, 0, len(allowedLineIDs))
-			for id := range allowedLineIDs {
-				ids = append(ids, id)
-			}
+		if scope, statusCode, _ := resolveAuthorizedProgramScope(c, lineActionView); statusCode == 0 && scope != nil {
+			ids := scope.VisibleLineIDs()
 			if len(ids) > 0 {
 				if len(extraLineIDs) > 0 {
 					allowed := make(map[uint]struct{}, len(ids))
@@ -387,6 +425,20 @@ func ExportStats(c *gin.Context) {
 		modelRates = append(modelRates, rateItem{Name: m.Name, Rate: rate, Total: total})
 	}
 
+	type statusCountItem struct {
+		Status string `json:"status"`
+		Name   string `json:"name"`
+		Count  int    `json:"count"`
+	}
+	statusCodeSet := make(map[string]struct{}, len(statusTotals))
+	for code := range statusTotals {
+		statusCodeSet[code] = struct{}{}
+	}
+	statusCounts := make([]statusCountItem, 0, len(statusTotals))
+	for _, code := range statusCatalog.orderedStatusCodes(statusCodeSet) {
+		statusCounts = append(statusCounts, statusCountItem{Status: code, Name: statusCatalog.label(0, code), Count: statusTotals[code]})
+	}
+
 	totalPairs := len(allLines) * len(allModels)
 	overallRate := 0
 	if totalPairs > 0 {
@@ -402,6 +454,7 @@ func ExportStats(c *gin.Context) {
 		"overall_rate":        overallRate,
 		"line_rates":          lineRates,
 		"model_rates":         modelRates,
+		"status_counts":       statusCounts,
 	})
 }
 
@@ -441,19 +494,8 @@ func buildColumnHeaders(keys []string, cfDefMap map[uint]models.ProductionLineCu
 	return headers
 }
 
-func statusLabel(status string) string {
-	switch status {
-	case "completed":
-		return "已完成"
-	case "in_progress":
-		return "进行中"
-	default:
-		return status
-	}
-}
-
 // buildExportRow 按列 key 组装一行数据。
-func buildExportRow(p models.Program, keys []string) map[string]any {
+func buildExportRow(p models.Program, keys []string, statuses programStatusCatalog) map[string]any {
 	// 预建自定义字段值映射
 	cfMap := make(map[uint]string, len(p.CustomFieldValues))
 	for _, v := range p.CustomFieldValues {
@@ -465,6 +507,8 @@ func buildExportRow(p models.Program, keys []string) map[string]any {
 	row := make(map[string]any, len(keys))
 	for _, key := range keys {
 		switch key {
+		case "id":
+			row[key] = p.ID
 		case "name":
 			row[key] = p.Name
 		case "code":
@@ -474,7 +518,7 @@ func buildExportRow(p models.Program, keys []string) map[string]any {
 		case "vehicle_model":
 			row[key] = p.VehicleModel.Name
 		case "status":
-			row[key] = statusLabel(p.Status)
+			row[key] = statuses.label(p.ProductionLineID, p.Status)
 		case "version":
 			row[key] = p.Version
 		case "description":
@@ -507,6 +551,10 @@ func buildExportRow(p models.Program, keys []string) map[string]any {
 
 // ExportProgramsExcelDynamic 支持动态列选择的 Excel 导出。
 func ExportProgramsExcelDynamic(c *gin.Context) {
+	if statusCode, msg := applyExportTemplate(c); statusCode != 0 {
+		c.JSON(statusCode, gin.H{"error": msg})
+		return
+	}
 	extraLineIDs := parseLineIDs(c)
 	query, _, statusCode, msg := exportQueryPrograms(c, extraLineIDs)
 	if statusCode != 0 {
@@ -516,6 +564,12 @@ func ExportProgramsExcelDynamic(c *gin.Context) {
 
 	columnKeys := parseColumnKeys(c)
 	includeStats := strings.TrimSpace(c.Query("include_stats")) == "true"
+	includeRelations := strings.TrimSpace(c.Query("include_relations")) == "true"
+	orderClause, err := parseExportSort(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	var programs []models.Program
 	if err := query.
@@ -523,7 +577,7 @@ func ExportProgramsExcelDynamic(c *gin.Context) {
 		Preload("VehicleModel").
 		Preload("CustomFieldValues").
 		Preload("CustomFieldValues.ProductionLineCustomField").
-		Order("id DESC").
+		Order(orderClause).
 		Find(&programs).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
 		return
@@ -543,6 +597,11 @@ func ExportProgramsExcelDynamic(c *gin.Context) {
 
 	// 查询自定义字段定义用于表头
 	cfDefMap := loadCustomFieldDefMap()
+	statusCatalog, err := loadProgramStatusCatalog(database.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
 
 	f := excelize.NewFile()
 	defer func() { _ = f.Close() }()
@@ -570,7 +629,7 @@ func ExportProgramsExcelDynamic(c *gin.Context) {
 	// 写数据行
 	for rowIdx, program := range programs {
 		row := rowIdx + 2
-		rowData := buildExportRow(program, columnKeys)
+		rowData := buildExportRow(program, columnKeys, statusCatalog)
 		for colIdx, key := range columnKeys {
 			cell, _ := excelize.CoordinatesToCellName(colIdx+1, row)
 			val := rowData[key]
@@ -596,7 +655,27 @@ func ExportProgramsExcelDynamic(c *gin.Context) {
 
 	// 完成率统计 sheet
 	if includeStats {
-		writeCompletionStatsSheet(f, programs)
+		writeCompletionStatsSheet(f, programs, statusCatalog)
+	}
+
+	// 程序关联 sheet
+	if includeRelations {
+		relationTypes, err := loadProgramRelationTypeCatalog(database.DB)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+		typeCodes, err := parseProgramRelationTypeFilter(c.Query("relation_type"), relationTypes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		relations, statusCode, msg := loadExportProgramRelations(c, programIDs, typeCodes)
+		if statusCode != 0 {
+			c.JSON(statusCode, gin.H{"error": msg})
+			return
+		}
+		writeProgramRelationsSheet(f, relations, relationTypes)
 	}
 
 	buffer, err := f.WriteToBuffer()
@@ -633,23 +712,56 @@ func columnKeyToLabel(key string, cfDefMap map[uint]models.ProductionLineCustomF
 }
 
 // writeCompletionStatsSheet 生成产线×车型完成率统计 sheet。
-func writeCompletionStatsSheet(f *excelize.File, programs []models.Program) {
+// 单元格为该组合下计为完成的程序占比，完成口径取各产线的状态配置；下方附各产线的状态分布。
+func writeCompletionStatsSheet(f *excelize.File, programs []models.Program, statuses programStatusCatalog) {
 	sheetName := "完成率统计"
 	_, _ = f.NewSheet(sheetName)
 
+	type completionCounter struct {
+		total     int
+		completed int
+	}
+	addProgram := func(counters map[string]*completionCounter, key string, completed bool) {
+		counter, ok := counters[key]
+		if !ok {
+			counter = &completionCounter{}
+			counters[key] = counter
+		}
+		counter.total++
+		if completed {
+			counter.completed++
+		}
+	}
+	formatRate := func(counter *completionCounter) string {
+		if counter == nil || counter.total == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.0f%%", float64(counter.completed)/float64(counter.total)*100)
+	}
+
 	// 收集所有产线和车型
 	lineSet := make(map[uint]string)
 	modelSet := make(map[uint]string)
-	cellMap := make(map[string]bool) // "lineID:modelID" -> has program
+	counters := make(map[string]*completionCounter) // "lineID:modelID" / "line:ID" / "model:ID" / "all"
+	lineStatusCounts := make(map[uint]map[string]int)
+	statusCodeSet := make(map[string]struct{})
 
 	for _, p := range programs {
 		if p.ProductionLineID > 0 {
 			lineSet[p.ProductionLineID] = p.ProductionLine.Name
+			if lineStatusCounts[p.ProductionLineID] == nil {
+				lineStatusCounts[p.ProductionLineID] = make(map[string]int)
+			}
+			lineStatusCounts[p.ProductionLineID][p.Status]++
+			statusCodeSet[p.Status] = struct{}{}
 		}
 		if p.VehicleModelID > 0 {
 			modelSet[p.VehicleModelID] = p.VehicleModel.Name
-			key := fmt.Sprintf("%d:%d", p.ProductionLineID, p.VehicleModelID)
-			cellMap[key] = true
+			completed := statuses.completed(p.ProductionLineID, p.Status)
+			addProgram(counters, fmt.Sprintf("%d:%d", p.ProductionLineID, p.VehicleModelID), completed)
+			addProgram(counters, fmt.Sprintf("line:%d", p.ProductionLineID), completed)
+			addProgram(counters, fmt.Sprintf("model:%d", p.VehicleModelID), completed)
+			addProgram(counters, "all", completed)
 		}
 	}
 
@@ -693,27 +805,12 @@ func writeCompletionStatsSheet(f *excelize.File, programs []models.Program) {
 	for rowIdx, line := range lines {
 		row := rowIdx + 2
 		_ = f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), line.Name)
-
-		completed := 0
 		for colIdx, m := range vehicleModels {
 			cell, _ := excelize.CoordinatesToCellName(colIdx+2, row)
-			key := fmt.Sprintf("%d:%d", line.ID, m.ID)
-			if cellMap[key] {
-				_ = f.SetCellValue(sheetName, cell, "100%")
-				completed++
-			} else {
-				_ = f.SetCellValue(sheetName, cell, "0%")
-			}
+			_ = f.SetCellValue(sheetName, cell, formatRate(counters[fmt.Sprintf("%d:%d", line.ID, m.ID)]))
 		}
-
-		// 完成率
 		totalCell, _ := excelize.CoordinatesToCellName(len(vehicleModels)+2, row)
-		if len(vehicleModels) > 0 {
-			rate := float64(completed) / float64(len(vehicleModels)) * 100
-			_ = f.SetCellValue(sheetName, totalCell, fmt.Sprintf("%.0f%%", rate))
-		} else {
-			_ = f.SetCellValue(sheetName, totalCell, "N/A")
-		}
+		_ = f.SetCellValue(sheetName, totalCell, formatRate(counters[fmt.Sprintf("line:%d", line.ID)]))
 	}
 
 	// 汇总行
@@ -721,42 +818,134 @@ func writeCompletionStatsSheet(f *excelize.File, programs []models.Program) {
 	_ = f.SetCellValue(sheetName, fmt.Sprintf("A%d", summaryRow), "完成率")
 	for colIdx, m := range vehicleModels {
 		cell, _ := excelize.CoordinatesToCellName(colIdx+2, summaryRow)
-		completed := 0
-		for _, line := range lines {
-			key := fmt.Sprintf("%d:%d", line.ID, m.ID)
-			if cellMap[key] {
-				completed++
-			}
-		}
-		if len(lines) > 0 {
-			rate := float64(completed) / float64(len(lines)) * 100
-			_ = f.SetCellValue(sheetName, cell, fmt.Sprintf("%.0f%%", rate))
-		}
+		_ = f.SetCellValue(sheetName, cell, formatRate(counters[fmt.Sprintf("model:%d", m.ID)]))
 	}
 
 	// 总体完成率
 	totalRow := summaryRow + 1
 	_ = f.SetCellValue(sheetName, fmt.Sprintf("A%d", totalRow), "总体完成率")
-	totalPairs := len(lines) * len(vehicleModels)
-	totalCompleted := 0
-	for _, line := range lines {
-		for _, m := range vehicleModels {
-			key := fmt.Sprintf("%d:%d", line.ID, m.ID)
-			if cellMap[key] {
-				totalCompleted++
+	totalRateCell, _ := excelize.CoordinatesToCellName(len(vehicleModels)+2, totalRow)
+	_ = f.SetCellValue(sheetName, totalRateCell, formatRate(counters["all"]))
+
+	// 状态分布：产线 × 状态的程序数量
+	statusCodes := statuses.orderedStatusCodes(statusCodeSet)
+	distributionRow := totalRow + 2
+	_ = f.SetCellValue(sheetName, fmt.Sprintf("A%d", distributionRow), "状态分布")
+	_ = f.SetCellStyle(sheetName, fmt.Sprintf("A%d", distributionRow), fmt.Sprintf("A%d", distributionRow), headerStyle)
+	for i, code := range statusCodes {
+		label := statuses.label(0, code)
+		for _, line := range lines {
+			if definition, ok := statuses.find(line.ID, code); ok {
+				label = definition.Name
+				break
 			}
 		}
+		cell, _ := excelize.CoordinatesToCellName(i+2, distributionRow)
+		_ = f.SetCellValue(sheetName, cell, label)
+		_ = f.SetCellStyle(sheetName, cell, cell, headerStyle)
 	}
-	if totalPairs > 0 {
-		rate := float64(totalCompleted) / float64(totalPairs) * 100
-		totalCell, _ := excelize.CoordinatesToCellName(len(vehicleModels)+2, totalRow)
-		_ = f.SetCellValue(sheetName, totalCell, fmt.Sprintf("%.0f%%", rate))
+	sumHeaderCell, _ := excelize.CoordinatesToCellName(len(statusCodes)+2, distributionRow)
+	_ = f.SetCellValue(sheetName, sumHeaderCell, "合计")
+	_ = f.SetCellStyle(sheetName, sumHeaderCell, sumHeaderCell, headerStyle)
+	for rowIdx, line := range lines {
+		row := distributionRow + rowIdx + 1
+		_ = f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), line.Name)
+		sum := 0
+		for colIdx, code := range statusCodes {
+			count := lineStatusCounts[line.ID][code]
+			sum += count
+			cell, _ := excelize.CoordinatesToCellName(colIdx+2, row)
+			_ = f.SetCellValue(sheetName, cell, count)
+		}
+		sumCell, _ := excelize.CoordinatesToCellName(len(statusCodes)+2, row)
+		_ = f.SetCellValue(sheetName, sumCell, sum)
 	}
 
 	// 列宽
 	_ = f.SetColWidth(sheetName, "A", "A", 20)
-	for i := range vehicleModels {
-		colName, _ := excelize.CoordinatesToCellName(i+2, 1)
+	maxColumns := len(vehicleModels) + 1
+	if len(statusCodes)+1 > maxColumns {
+		maxColumns = len(statusCodes) + 1
+	}
+	for i := 0; i < maxColumns; i++ {
+		colName, _ := excelize.ColumnNumberToName(i + 2)
 		_ = f.SetColWidth(sheetName, colName, colName, 15)
 	}
 }
+
+// loadExportProgramRelations 查询与导出程序相关的关联，另一端程序不可见时跳过。
+func loadExportProgramRelations(c *gin.Context, programIDs []uint, typeCodes []string) ([]models.ProgramRelation, int, string) {
+	if len(programIDs) == 0 {
+		return nil, 0, ""
+	}
+	query := database.DB.
+		Preload("SourceProgram").
+		Preload("SourceProgram.ProductionLine").
+		Preload("RelatedProgram").
+		Preload("RelatedProgram.ProductionLine").
+		Where("source_program_id IN ? OR related_program_id IN ?", programIDs, programIDs)
+	if len(typeCodes) > 0 {
+		query = query.Where("relation_type IN ?", typeCodes)
+	}
+	var relations []models.ProgramRelation
+	if err := query.Order("source_program_id ASC, id ASC").Find(&relations).Error; err != nil {
+		return nil, http.StatusInternalServerError, "查询失败"
+	}
+
+	visibleRelations := make([]models.ProgramRelation, 0, len(relations))
+	for _, relation := range relations {
+		visible, statusCode, message := programRelationVisible(c, relation)
+		if statusCode != 0 {
+			return nil, statusCode, message
+		}
+		if visible {
+			visibleRelations = append(visibleRelations, relation)
+		}
+	}
+	return visibleRelations, 0, ""
+}
+
+// writeProgramRelationsSheet 生成程序关联 sheet，每条关联一行。
+func writeProgramRelationsSheet(f *excelize.File, relations []models.ProgramRelation, relationTypes programRelationTypeCatalog) {
+	sheetName := "程序关联"
+	_, _ = f.NewSheet(sheetName)
+
+	headerStyle, _ := f.NewStyle(&excelize.Style{
+		Font:      &excelize.Font{Bold: true},
+		Fill:      excelize.Fill{Type: "pattern", Color: []string{"#EBEEF0"}, Pattern: 1},
+		Alignment: &excelize.Alignment{Horizontal: "center"},
+	})
+	headers := []string{"源程序编号", "源程序名称", "源程序生产线", "关联类型", "方向", "关联程序编号", "关联程序名称", "关联程序生产线", "描述"}
+	for i, header := range headers {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		_ = f.SetCellValue(sheetName, cell, header)
+		_ = f.SetCellStyle(sheetName, cell, cell, headerStyle)
+	}
+
+	for rowIdx, relation := range relations {
+		direction := "对称"
+		if relationTypes.directed(relation.RelationType) {
+			direction = "有向"
+		}
+		values := []any{
+			relation.SourceProgram.Code,
+			relation.SourceProgram.Name,
+			relation.SourceProgram.ProductionLine.Name,
+			relationTypes.label(relation.RelationType, false),
+			direction,
+			relation.RelatedProgram.Code,
+			relation.RelatedProgram.Name,
+			relation.RelatedProgram.ProductionLine.Name,
+			relation.Description,
+		}
+		for colIdx, value := range values {
+			cell, _ := excelize.CoordinatesToCellName(colIdx+1, rowIdx+2)
+			_ = f.SetCellValue(sheetName, cell, value)
+		}
+	}
+
+	for i := range headers {
+		colName, _ := excelize.ColumnNumberToName(i + 1)
+		_ = f.SetColWidth(sheetName, colName, colName, 18)
+	}
+}
@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"crane-system/database"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// exportStreamBatchSize 流式导出每批读取的程序数，按批预加载关联数据并刷新输出。
+var exportStreamBatchSize = 500
+
+const (
+	exportStreamFormatCSV   = "csv"
+	exportStreamFormatJSONL = "jsonl"
+)
+
+// exportStreamWriter 负责把一行数据写成具体格式。
+type exportStreamWriter interface {
+	writeHeader(keys []string, labels []string) error
+	writeRow(keys []string, row map[string]any) error
+	flush() error
+}
+
+type csvExportStreamWriter struct {
+	w *csv.Writer
+}
+
+func (s *csvExportStreamWriter) writeHeader(keys []string, labels []string) error {
+	return s.w.Write(labels)
+}
+
+func (s *csvExportStreamWriter) writeRow(keys []string, row map[string]any) error {
+	record := make([]string, len(keys))
+	for i, key := range keys {
+		if val := row[key]; val != nil {
+			record[i] = fmt.Sprint(val)
+		}
+	}
+	return s.w.Write(record)
+}
+
+func (s *csvExportStreamWriter) flush() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+type jsonlExportStreamWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func (s *jsonlExportStreamWriter) writeHeader(keys []string, labels []string) error {
+	return nil
+}
+
+// writeRow 每行一个 JSON 对象，键为列 key，未选中的列不输出。
+func (s *jsonlExportStreamWriter) writeRow(keys []string, row map[string]any) error {
+	for _, key := range keys {
+		if _, ok := row[key]; !ok {
+			row[key] = nil
+		}
+	}
+	return s.enc.Encode(row)
+}
+
+func (s *jsonlExportStreamWriter) flush() error {
+	return s.buf.Flush()
+}
+
+// ExportProgramsCSV 以 CSV 流式导出程序，bom=true 时写入 UTF-8 BOM 便于 Excel 打开，
+// header=key 时表头使用列 key 而非中文名称。
+func ExportProgramsCSV(c *gin.Context) {
+	exportProgramsStream(c, exportStreamFormatCSV)
+}
+
+// ExportProgramsJSONL 以 JSON Lines 流式导出程序，每行一个对象。
+func ExportProgramsJSONL(c *gin.Context) {
+	exportProgramsStream(c, exportStreamFormatJSONL)
+}
+
+// exportProgramsStream 与 Excel 导出共享列选择、排序和产线权限过滤，分批读取并边查边写，不在内存中聚合全量结果。
+func exportProgramsStream(c *gin.Context, format string) {
+	if statusCode, msg := applyExportTemplate(c); statusCode != 0 {
+		c.JSON(statusCode, gin.H{"error": msg})
+		return
+	}
+	extraLineIDs := parseLineIDs(c)
+	query, _, statusCode, msg := exportQueryPrograms(c, extraLineIDs)
+	if statusCode != 0 {
+		c.JSON(statusCode, gin.H{"error": msg})
+		return
+	}
+
+	columnKeys := parseColumnKeys(c)
+	orderClause, err := parseExportSort(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cfDefMap := loadCustomFieldDefMap()
+	statusCatalog, err := loadProgramStatusCatalog(database.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	// 先取第一批，查询出错时仍可返回 JSON 错误
+	base := query.Order(orderClause).Session(&gorm.Session{})
+	batch, err := loadExportStreamBatch(base, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	var fileName, contentType string
+	out := bufio.NewWriter(c.Writer)
+	var writer exportStreamWriter
+	switch format {
+	case exportStreamFormatJSONL:
+		fileName, contentType = "programs_export.jsonl", "application/x-ndjson; charset=utf-8"
+		enc := json.NewEncoder(out)
+		enc.SetEscapeHTML(false)
+		writer = &jsonlExportStreamWriter{buf: out, enc: enc}
+	default:
+		fileName, contentType = "programs_export.csv", "text/csv; charset=utf-8"
+		writer = &csvExportStreamWriter{w: csv.NewWriter(out)}
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", "attachment; filename=\""+fileName+"\"; filename*=UTF-8''"+url.QueryEscape(fileName))
+	c.Status(http.StatusOK)
+
+	if format == exportStreamFormatCSV && strings.TrimSpace(c.Query("bom")) == "true" {
+		_, _ = out.WriteString("\xEF\xBB\xBF")
+	}
+
+	labels := make([]string, len(columnKeys))
+	for i, key := range columnKeys {
+		if strings.TrimSpace(c.Query("header")) == "key" {
+			labels[i] = key
+		} else {
+			labels[i] = columnKeyToLabel(key, cfDefMap)
+		}
+	}
+
+	// 响应头已发出，之后的错误只能中断输出
+	if err := writer.writeHeader(columnKeys, labels); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	for offset := 0; len(batch) > 0; {
+		for _, program := range batch {
+			if err := writer.writeRow(columnKeys, buildExportRow(program, columnKeys, statusCatalog)); err != nil {
+				_ = c.Error(err)
+				return
+			}
+		}
+		if err := writer.flush(); err != nil {
+			_ = c.Error(err)
+			return
+		}
+		c.Writer.Flush()
+
+		if len(batch) < exportStreamBatchSize {
+			break
+		}
+		offset += len(batch)
+		if batch, err = loadExportStreamBatch(base, offset); err != nil {
+			_ = c.Error(err)
+			return
+		}
+	}
+	if err := writer.flush(); err != nil {
+		_ = c.Error(err)
+	}
+}
+
+// loadExportStreamBatch 按既定排序读取从 offset 开始的一批程序，并补齐版本数、文件数。
+func loadExportStreamBatch(base *gorm.DB, offset int) ([]models.Program, error) {
+	query := base.
+		Preload("ProductionLine").
+		Preload("VehicleModel").
+		Preload("CustomFieldValues").
+		Preload("CustomFieldValues.ProductionLineCustomField")
+	var programs []models.Program
+	if err := query.Offset(offset).Limit(exportStreamBatchSize).Find(&programs).Error; err != nil {
+		return nil, err
+	}
+
+	programIDs := make([]uint, 0, len(programs))
+	for _, p := range programs {
+		programIDs = append(programIDs, p.ID)
+	}
+	versionCounts, err := buildProgramVersionCountMap(database.DB, programIDs)
+	if err != nil {
+		return nil, err
+	}
+	fileCounts, err := buildProgramFileCountMap(database.DB, programIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range programs {
+		programs[i].OwnVersionCount = versionCounts[programs[i].ID]
+		programs[i].OwnFileCount = fileCounts[programs[i].ID]
+	}
+	return programs, nil
+}
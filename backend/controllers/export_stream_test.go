@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+	"crane-system/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupExportStreamTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	{
+		api.GET("/programs/export/csv", ExportProgramsCSV)
+		api.GET("/programs/export/jsonl", ExportProgramsJSONL)
+	}
+	return r
+}
+
+func TestExportProgramsStreamFormats(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	adminToken, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupExportStreamTestRouter()
+
+	previousBatchSize := exportStreamBatchSize
+	exportStreamBatchSize = 2
+	t.Cleanup(func() { exportStreamBatchSize = previousBatchSize })
+
+	otherLine := models.ProductionLine{Name: "产线B", Code: "LINE-002", Status: "active"}
+	if err := database.DB.Create(&otherLine).Error; err != nil {
+		t.Fatalf("create line: %v", err)
+	}
+	for i := 1; i <= 5; i++ {
+		program := models.Program{Name: fmt.Sprintf("程序%d", i), Code: fmt.Sprintf("CSV-%03d", i), ProductionLineID: line.ID, Status: "in_progress", Description: "含,逗号"}
+		if err := database.DB.Create(&program).Error; err != nil {
+			t.Fatalf("create program: %v", err)
+		}
+	}
+	if err := database.DB.Create(&models.Program{Name: "其他", Code: "CSV-OTHER", ProductionLineID: otherLine.ID}).Error; err != nil {
+		t.Fatalf("create program: %v", err)
+	}
+
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodGet, "/api/programs/export/csv?columns=code,production_line,description&bom=true", adminToken, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("csv: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	body := resp.Body.Bytes()
+	if !bytes.HasPrefix(body, []byte("\xEF\xBB\xBF")) {
+		t.Fatalf("missing BOM: %q", body[:8])
+	}
+	records, err := csv.NewReader(bytes.NewReader(body[3:])).ReadAll()
+	if err != nil {
+		t.Fatalf("parse csv: %v", err)
+	}
+	if len(records) != 7 || fmt.Sprint(records[0]) != "[程序编号 生产线 描述]" {
+		t.Fatalf("unexpected csv: %v", records)
+	}
+	if records[1][0] != "CSV-OTHER" || records[2][0] != "CSV-005" || records[6][0] != "CSV-001" || records[2][2] != "含,逗号" {
+		t.Fatalf("unexpected csv order or values: %v", records)
+	}
+
+	user := models.User{Name: "Exporter", Password: "hashed", EmployeeID: "EMP-CSV-001", Role: "user", Status: "active"}
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	saveProductionLineRuleForTest(t, services.PermissionSubject{Type: models.PermissionSubjectUser, ID: user.ID}, otherLine.ID, true, false, false, false)
+	userToken := createUserTokenForTest(t, user.ID, "user")
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, "/api/programs/export/jsonl?columns=code,status", userToken, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("jsonl: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	var rows []map[string]any
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var row map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("parse jsonl line %q: %v", scanner.Text(), err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) != 1 || rows[0]["code"] != "CSV-OTHER" || len(rows[0]) != 2 {
+		t.Fatalf("unexpected filtered jsonl: %v", rows)
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, "/api/programs/export/csv?columns=code&header=key", userToken, nil)
+	if got := resp.Body.String(); got != "code\nCSV-OTHER\n" {
+		t.Fatalf("key header csv = %q", got)
+	}
+}
+
+func TestExportProgramsStreamFailsWhenCountsCannotBeLoaded(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	adminToken, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupExportStreamTestRouter()
+
+	if err := database.DB.Create(&models.Program{Name: "程序", Code: "CSV-001", ProductionLineID: line.ID, Status: "in_progress"}).Error; err != nil {
+		t.Fatalf("create program: %v", err)
+	}
+	// 文件数查询失败时不能把 0 写入导出
+	if err := database.DB.Migrator().DropTable(&models.ProgramFile{}); err != nil {
+		t.Fatalf("drop program files: %v", err)
+	}
+
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodGet, "/api/programs/export/csv?columns=code,file_count", adminToken, nil)
+	if resp.Code != http.StatusInternalServerError {
+		t.Fatalf("expected count failure to stop the export, got %d %s", resp.Code, resp.Body.String())
+	}
+}
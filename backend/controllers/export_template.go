@@ -0,0 +1,336 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"crane-system/database"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportTemplateFilterKeys 是模板可以保存的筛选参数，与 applyProgramRequestFilters / parseLineIDs 读取的参数一致。
+var exportTemplateFilterKeys = map[string]bool{
+	"production_line_id": true,
+	"vehicle_model_id":   true,
+	"status":             true,
+	"keyword":            true,
+	"date_from":          true,
+	"date_to":            true,
+	"line_ids":           true,
+}
+
+type exportTemplateRequest struct {
+	Name      *string           `json:"name"`
+	Shared    *bool             `json:"shared"`
+	Columns   []string          `json:"columns"`
+	Filters   map[string]string `json:"filters"`
+	SortBy    *string           `json:"sort_by"`
+	SortOrder *string           `json:"sort_order"`
+}
+
+// exportTemplateView 在模板上附带当前用户已不可见的列，便于前端提示。
+type exportTemplateView struct {
+	models.ExportTemplate
+	UnavailableColumns []string `json:"unavailable_columns"`
+}
+
+// loadVisibleExportColumnKeys 返回当前用户可导出的列 key 集合，口径与 GetExportColumns 一致。
+func loadVisibleExportColumnKeys(c *gin.Context) (map[string]bool, int, string) {
+	cfColumns, statusCode, msg := loadVisibleCustomFieldColumns(c)
+	if statusCode != 0 {
+		return nil, statusCode, msg
+	}
+	keys := make(map[string]bool, len(cfColumns)+len(builtinExportColumns()))
+	for _, col := range builtinExportColumns() {
+		keys[col.Key] = true
+	}
+	for _, col := range cfColumns {
+		keys[col.Key] = true
+	}
+	return keys, 0, ""
+}
+
+func unavailableExportColumns(columns []string, visible map[string]bool) []string {
+	missing := make([]string, 0)
+	for _, key := range columns {
+		if !visible[key] {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+func canManageExportTemplate(c *gin.Context, template models.ExportTemplate) bool {
+	role := currentAccountRole(c)
+	return template.OwnerID == currentUserID(c) || role == "admin" || role == "system_admin"
+}
+
+// loadAccessibleExportTemplate 加载当前用户自己的或共享的导出模板；他人的私有模板按不存在处理。
+func loadAccessibleExportTemplate(c *gin.Context, id uint) (models.ExportTemplate, int, string) {
+	var template models.ExportTemplate
+	if err := database.DB.First(&template, id).Error; err != nil {
+		return template, http.StatusNotFound, "导出模板不存在"
+	}
+	if !template.Shared && !canManageExportTemplate(c, template) {
+		return template, http.StatusNotFound, "导出模板不存在"
+	}
+	return template, 0, ""
+}
+
+// validateExportTemplate 校验列、筛选和排序；列必须是当前用户通过 GetExportColumns 可见的列。
+func validateExportTemplate(template *models.ExportTemplate, visible map[string]bool) string {
+	if template.Name == "" || len([]rune(template.Name)) > 100 {
+		return "模板名称不能为空且不超过100个字符"
+	}
+	if len(template.Columns) == 0 {
+		return "至少选择一列"
+	}
+	seen := make(map[string]bool, len(template.Columns))
+	for _, key := range template.Columns {
+		if seen[key] {
+			return "导出列重复: " + key
+		}
+		seen[key] = true
+	}
+	if missing := unavailableExportColumns(template.Columns, visible); len(missing) > 0 {
+		return "以下列不存在或无权导出: " + strings.Join(missing, ",")
+	}
+
+	for key, value := range template.Filters {
+		switch {
+		case exportTemplateFilterKeys[key]:
+			if (key == "date_from" || key == "date_to") && value != "" {
+				if _, err := time.Parse("2006-01-02", value); err != nil {
+					return "invalid " + key
+				}
+			}
+		case strings.HasPrefix(key, "custom_field_"):
+			if !visible["cf_"+strings.TrimPrefix(key, "custom_field_")] {
+				return "自定义字段筛选不存在或无权使用: " + key
+			}
+		default:
+			return "不支持的筛选参数: " + key
+		}
+	}
+
+	template.SortOrder = strings.ToLower(template.SortOrder)
+	if template.SortBy != "" {
+		if _, ok := exportSortColumns[template.SortBy]; !ok {
+			return "invalid sort_by"
+		}
+	}
+	if template.SortOrder != "" && template.SortOrder != "asc" && template.SortOrder != "desc" {
+		return "invalid sort_order"
+	}
+	return ""
+}
+
+// applyExportTemplate 把 template 参数指向的模板展开为导出查询参数，请求中显式传入的参数优先。
+// 必须在读取任何查询参数之前调用，gin 会缓存首次解析的查询参数。
+// 模板中当前用户已不可见的列会被跳过，并通过 X-Export-Skipped-Columns 响应头告知。
+func applyExportTemplate(c *gin.Context) (int, string) {
+	values := c.Request.URL.Query()
+	raw := strings.TrimSpace(values.Get("template"))
+	if raw == "" {
+		return 0, ""
+	}
+	templateID, err := parseUintParam(raw)
+	if err != nil {
+		return http.StatusBadRequest, "导出模板ID格式错误"
+	}
+	template, statusCode, msg := loadAccessibleExportTemplate(c, templateID)
+	if statusCode != 0 {
+		return statusCode, msg
+	}
+	visible, statusCode, msg := loadVisibleExportColumnKeys(c)
+	if statusCode != 0 {
+		return statusCode, msg
+	}
+
+	columns := make([]string, 0, len(template.Columns))
+	for _, key := range template.Columns {
+		if visible[key] {
+			columns = append(columns, key)
+		}
+	}
+	if len(columns) == 0 {
+		return http.StatusConflict, "模板中的列均已不可用，请更新模板"
+	}
+	if skipped := unavailableExportColumns(template.Columns, visible); len(skipped) > 0 {
+		c.Header("X-Export-Skipped-Columns", strings.Join(skipped, ","))
+	}
+
+	setDefault := func(key, value string) {
+		if value != "" && !values.Has(key) {
+			values.Set(key, value)
+		}
+	}
+	setDefault("columns", strings.Join(columns, ","))
+	for key, value := range template.Filters {
+		setDefault(key, value)
+	}
+	setDefault("sort_by", template.SortBy)
+	setDefault("sort_order", template.SortOrder)
+	values.Del("template")
+	c.Request.URL.RawQuery = values.Encode()
+	return 0, ""
+}
+
+// GetExportTemplates 返回当前用户自己的模板和共享模板。
+func GetExportTemplates(c *gin.Context) {
+	visible, statusCode, msg := loadVisibleExportColumnKeys(c)
+	if statusCode != 0 {
+		c.JSON(statusCode, gin.H{"error": msg})
+		return
+	}
+
+	var templates []models.ExportTemplate
+	if err := database.DB.
+		Preload("Owner").
+		Where("owner_id = ? OR shared = ?", currentUserID(c), true).
+		Order("name ASC, id ASC").
+		Find(&templates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	items := make([]exportTemplateView, 0, len(templates))
+	for _, template := range templates {
+		items = append(items, exportTemplateView{ExportTemplate: template, UnavailableColumns: unavailableExportColumns(template.Columns, visible)})
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func CreateExportTemplate(c *gin.Context) {
+	var req exportTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	visible, statusCode, msg := loadVisibleExportColumnKeys(c)
+	if statusCode != 0 {
+		c.JSON(statusCode, gin.H{"error": msg})
+		return
+	}
+
+	template := models.ExportTemplate{OwnerID: currentUserID(c), Columns: req.Columns, Filters: req.Filters}
+	if req.Name != nil {
+		template.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Shared != nil {
+		template.Shared = *req.Shared
+	}
+	if req.SortBy != nil {
+		template.SortBy = strings.TrimSpace(*req.SortBy)
+	}
+	if req.SortOrder != nil {
+		template.SortOrder = strings.TrimSpace(*req.SortOrder)
+	}
+	if msg := validateExportTemplate(&template, visible); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := database.DB.Create(&template).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存导出模板失败"})
+		return
+	}
+	recordAuditChange(c, "export_template", template.ID, nil, template)
+	c.JSON(http.StatusCreated, template)
+}
+
+// UpdateExportTemplate 只有创建人和管理员可以修改；未提供的字段保持不变。
+func UpdateExportTemplate(c *gin.Context) {
+	templateID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "导出模板ID格式错误"})
+		return
+	}
+	template, statusCode, msg := loadAccessibleExportTemplate(c, templateID)
+	if statusCode != 0 {
+		c.JSON(statusCode, gin.H{"error": msg})
+		return
+	}
+	if !canManageExportTemplate(c, template) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能修改自己创建的导出模板"})
+		return
+	}
+
+	var req exportTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	before := template
+	if req.Name != nil {
+		template.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Shared != nil {
+		template.Shared = *req.Shared
+	}
+	if req.Columns != nil {
+		template.Columns = req.Columns
+	}
+	if req.Filters != nil {
+		template.Filters = req.Filters
+	}
+	if req.SortBy != nil {
+		template.SortBy = strings.TrimSpace(*req.SortBy)
+	}
+	if req.SortOrder != nil {
+		template.SortOrder = strings.TrimSpace(*req.SortOrder)
+	}
+
+	visible, statusCode, msg := loadVisibleExportColumnKeys(c)
+	if statusCode != 0 {
+		c.JSON(statusCode, gin.H{"error": msg})
+		return
+	}
+	if msg := validateExportTemplate(&template, visible); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := database.DB.Model(&template).Select("name", "shared", "columns", "filters", "sort_by", "sort_order").Updates(&template).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存导出模板失败"})
+		return
+	}
+	recordAuditChange(c, "export_template", template.ID, before, template)
+	c.JSON(http.StatusOK, template)
+}
+
+func DeleteExportTemplate(c *gin.Context) {
+	templateID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "导出模板ID格式错误"})
+		return
+	}
+	template, statusCode, msg := loadAccessibleExportTemplate(c, templateID)
+	if statusCode != 0 {
+		c.JSON(statusCode, gin.H{"error": msg})
+		return
+	}
+	if !canManageExportTemplate(c, template) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能删除自己创建的导出模板"})
+		return
+	}
+	if dependency, err := findMasterDataDependency([]masterDataDependencyCheck{
+		{Model: &models.ReportSchedule{}, Where: "export_template_id = ?", Args: []any{template.ID}, Label: "report schedules"},
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "dependency check failed"})
+		return
+	} else if dependency != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "export template is in use by " + dependency})
+		return
+	}
+
+	if err := database.DB.Delete(&models.ExportTemplate{}, template.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除导出模板失败"})
+		return
+	}
+	recordAuditChange(c, "export_template", template.ID, template, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "导出模板已删除"})
+}
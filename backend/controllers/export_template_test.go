@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+	"crane-system/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+func setupExportTemplateTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	{
+		api.GET("/programs/export/templates", GetExportTemplates)
+		api.POST("/programs/export/templates", CreateExportTemplate)
+		api.PUT("/programs/export/templates/:id", UpdateExportTemplate)
+		api.DELETE("/programs/export/templates/:id", DeleteExportTemplate)
+		api.GET("/programs/export/excel", ExportProgramsExcelDynamic)
+		api.GET("/programs/export/csv", ExportProgramsCSV)
+	}
+	return r
+}
+
+func TestExportTemplatesApplyColumnsFiltersAndSort(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	adminToken, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupExportTemplateTestRouter()
+
+	otherLine := models.ProductionLine{Name: "产线B", Code: "LINE-002", Status: "active"}
+	if err := database.DB.Create(&otherLine).Error; err != nil {
+		t.Fatalf("create line: %v", err)
+	}
+	field := models.ProductionLineCustomField{ProductionLineID: line.ID, Name: "工位", FieldType: "text", Enabled: true}
+	if err := database.DB.Create(&field).Error; err != nil {
+		t.Fatalf("create custom field: %v", err)
+	}
+	programs := []models.Program{
+		{Name: "B", Code: "TPL-B", ProductionLineID: line.ID, Status: "completed"},
+		{Name: "A", Code: "TPL-A", ProductionLineID: line.ID, Status: "completed"},
+		{Name: "C", Code: "TPL-C", ProductionLineID: line.ID, Status: "in_progress"},
+		{Name: "D", Code: "TPL-D", ProductionLineID: otherLine.ID, Status: "completed"},
+	}
+	for i := range programs {
+		if err := database.DB.Create(&programs[i]).Error; err != nil {
+			t.Fatalf("create program: %v", err)
+		}
+	}
+
+	cfKey := fmt.Sprintf("cf_%d", field.ID)
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/programs/export/templates", adminToken, map[string]any{
+		"name": "周报", "columns": []string{"code", "missing"},
+	})
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("unknown column: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/programs/export/templates", adminToken, map[string]any{
+		"name": "周报", "columns": []string{"code", cfKey}, "filters": map[string]string{"bad": "1"},
+	})
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("unknown filter: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/programs/export/templates", adminToken, map[string]any{
+		"name":       "周报",
+		"columns":    []string{"code", cfKey},
+		"filters":    map[string]string{"status": "completed"},
+		"sort_by":    "code",
+		"sort_order": "ASC",
+	})
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create template: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	template := decodeProductionLineCustomFieldResponse[models.ExportTemplate](t, resp)
+	if template.SortOrder != "asc" {
+		t.Fatalf("sort order not normalized: %+v", template)
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/programs/export/csv?template=%d&header=key", template.ID), adminToken, nil)
+	if got := resp.Body.String(); got != "code,"+cfKey+"\nTPL-A,\nTPL-B,\nTPL-D,\n" {
+		t.Fatalf("template csv = %q", got)
+	}
+	// 请求参数优先于模板
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/programs/export/csv?template=%d&header=key&columns=code&sort_order=desc&status=in_progress", template.ID), adminToken, nil)
+	if got := resp.Body.String(); got != "code\nTPL-C\n" {
+		t.Fatalf("override csv = %q", got)
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/programs/export/excel?template=%d", template.ID), adminToken, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("excel: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	f, err := excelize.OpenReader(resp.Body)
+	if err != nil {
+		t.Fatalf("open workbook: %v", err)
+	}
+	rows, _ := f.GetRows("Programs")
+	_ = f.Close()
+	if len(rows) != 4 || fmt.Sprint(rows[0]) != "[程序编号 工位]" || rows[1][0] != "TPL-A" {
+		t.Fatalf("excel rows = %v", rows)
+	}
+
+	user := models.User{Name: "Exporter", Password: "hashed", EmployeeID: "EMP-TPL-001", Role: "user", Status: "active"}
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	saveProductionLineRuleForTest(t, services.PermissionSubject{Type: models.PermissionSubjectUser, ID: user.ID}, otherLine.ID, true, false, false, false)
+	userToken := createUserTokenForTest(t, user.ID, "user")
+
+	csvPath := fmt.Sprintf("/api/programs/export/csv?template=%d&header=key", template.ID)
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, csvPath, userToken, nil)
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("private template: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPut, fmt.Sprintf("/api/programs/export/templates/%d", template.ID), adminToken, map[string]any{"shared": true})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("share template: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, "/api/programs/export/templates", userToken, nil)
+	views := decodeProductionLineCustomFieldResponse[[]exportTemplateView](t, resp)
+	if len(views) != 1 || len(views[0].UnavailableColumns) != 1 || views[0].UnavailableColumns[0] != cfKey {
+		t.Fatalf("shared templates = %+v", views)
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, csvPath, userToken, nil)
+	if got := resp.Body.String(); got != "code\nTPL-D\n" || resp.Header().Get("X-Export-Skipped-Columns") != cfKey {
+		t.Fatalf("shared template csv = %q, headers = %v", got, resp.Header())
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodDelete, fmt.Sprintf("/api/programs/export/templates/%d", template.ID), userToken, nil)
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("delete others template: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+}
@@ -5,13 +5,12 @@ import (
 	"crane-system/database"
 	"crane-system/models"
 	"crane-system/utils"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
-	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -21,26 +20,38 @@ import (
 )
 
 type batchUploadProgramFile struct {
-	Name string `json:"name"`
-	Size int64  `json:"size"`
-	Path string `json:"path"`
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	Path    string `json:"path"`
+	Version string `json:"version,omitempty"` // 布局从文件名中提取的版本号
 }
 
 type batchUploadProgram struct {
 	Name  string                   `json:"name"`
+	Code  string                   `json:"code,omitempty"` // 布局从文件名中提取的程序编号
 	Files []batchUploadProgramFile `json:"files"`
 }
 
 type batchUploadWorkstation struct {
-	Name     string               `json:"name"`
-	Programs []batchUploadProgram `json:"programs"`
+	Name                      string               `json:"name"`
+	SuggestedProductionLineID *uint                `json:"suggested_production_line_id,omitempty"`
+	SuggestedVehicleModelID   *uint                `json:"suggested_vehicle_model_id,omitempty"`
+	Programs                  []batchUploadProgram `json:"programs"`
+}
+
+// batchUploadSkippedEntry 记录压缩包中未能按布局识别的文件。
+type batchUploadSkippedEntry struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
 }
 
 type batchUploadPreview struct {
-	PreviewID     string                   `json:"preview_id"`
-	Workstations  []batchUploadWorkstation `json:"workstations"`
-	TotalPrograms int                      `json:"total_programs"`
-	TotalFiles    int                      `json:"total_files"`
+	PreviewID     string                    `json:"preview_id"`
+	Layout        string                    `json:"layout"`
+	Workstations  []batchUploadWorkstation  `json:"workstations"`
+	TotalPrograms int                       `json:"total_programs"`
+	TotalFiles    int                       `json:"total_files"`
+	Skipped       []batchUploadSkippedEntry `json:"skipped"`
 }
 
 type batchUploadPreviewState struct {
@@ -57,27 +68,33 @@ type batchImportMapping struct {
 	VehicleModelID   *uint  `json:"vehicle_model_id"`
 }
 
+// batchImportTaskStatus 是 /tasks/:task_id/status 返回的任务进度，由后台任务记录转换而来。
 type batchImportTaskStatus struct {
-	Status       string    `json:"status"`
-	OwnerUser    uint      `json:"-"`
-	Total        int       `json:"total"`
-	Processed    int       `json:"processed"`
-	Success      int       `json:"success"`
-	Failed       int       `json:"failed"`
-	Progress     float64   `json:"progress"`
-	CurrentItem  string    `json:"current_item"`
-	ErrorMessage string    `json:"error_message"`
-	ExpiresAt    time.Time `json:"expires_at,omitempty"`
+	Status       string                `json:"status"`
+	OwnerUser    uint                  `json:"-"`
+	Total        int                   `json:"total"`
+	Processed    int                   `json:"processed"`
+	Success      int                   `json:"success"`
+	Failed       int                   `json:"failed"`
+	Progress     float64               `json:"progress"`
+	CurrentItem  string                `json:"current_item"`
+	ErrorMessage string                `json:"error_message"`
+	Items        []batchTaskItemResult `json:"items,omitempty"`
+	ExpiresAt    time.Time             `json:"expires_at,omitempty"`
+}
+
+// batchTaskItemResult 记录任务中单条数据的处理结果，供前端逐条展示失败原因。
+type batchTaskItemResult struct {
+	Row    int    `json:"row,omitempty"`
+	Key    string `json:"key"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
 }
 
-const batchTaskStatusTTL = 30 * time.Minute
 const batchImportInitialVersion = "v1"
 const batchPreviewTTL = 30 * time.Minute
 
 var (
-	batchTaskMu     sync.RWMutex
-	batchTaskSeq    int64 = 1
-	batchTasks            = map[int64]*batchImportTaskStatus{}
 	batchPreviewMu  sync.Mutex
 	batchPreviewSeq int64 = 1
 	batchPreviews         = map[string]*batchUploadPreviewState{}
@@ -167,70 +184,6 @@ func consumeBatchPreview(previewID string, ownerUser uint) (batchUploadPreview,
 	return state.Preview, state.TempDir, nil
 }
 
-func cleanupExpiredBatchTasksLocked(now time.Time) {
-	for taskID, status := range batchTasks {
-		if status.ExpiresAt.IsZero() || status.ExpiresAt.After(now) {
-			continue
-		}
-		delete(batchTasks, taskID)
-	}
-}
-
-func createBatchTask(total int, ownerUser uint) int64 {
-	batchTaskMu.Lock()
-	defer batchTaskMu.Unlock()
-
-	cleanupExpiredBatchTasksLocked(time.Now())
-	taskID := batchTaskSeq
-	batchTaskSeq++
-	batchTasks[taskID] = &batchImportTaskStatus{
-		Status:    "processing",
-		OwnerUser: ownerUser,
-		Total:     total,
-		ExpiresAt: time.Now().Add(batchTaskStatusTTL),
-	}
-	return taskID
-}
-
-func updateBatchTask(taskID int64, updater func(status *batchImportTaskStatus)) {
-	batchTaskMu.Lock()
-	defer batchTaskMu.Unlock()
-
-	status, ok := batchTasks[taskID]
-	if !ok {
-		return
-	}
-	updater(status)
-	status.ExpiresAt = time.Now().Add(batchTaskStatusTTL)
-}
-
-func snapshotBatchTask(taskID int64) (batchImportTaskStatus, bool) {
-	batchTaskMu.Lock()
-	defer batchTaskMu.Unlock()
-
-	cleanupExpiredBatchTasksLocked(time.Now())
-	status, ok := batchTasks[taskID]
-	if !ok {
-		return batchImportTaskStatus{}, false
-	}
-	return *status, true
-}
-
-func snapshotBatchTaskForUser(taskID int64, userID uint, isAdmin bool) (batchImportTaskStatus, bool, bool) {
-	batchTaskMu.Lock()
-	defer batchTaskMu.Unlock()
-
-	cleanupExpiredBatchTasksLocked(time.Now())
-	status, ok := batchTasks[taskID]
-	if !ok {
-		return batchImportTaskStatus{}, false, false
-	}
-	if !isAdmin && status.OwnerUser != userID {
-		return batchImportTaskStatus{}, true, false
-	}
-	return *status, true, true
-}
-
 func BatchUploadPrograms(c *gin.Context) {
 	allowedLineIDs, statusCode, message := resolveAuthorizedLineIDs(c, lineActionManage)
 	if statusCode != 0 {
@@ -265,6 +218,12 @@ func BatchUploadPrograms(c *gin.Context) {
 		}
 	}
 
+	layout, err := loadBatchImportLayout(database.DB, c.PostForm("layout"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	fileHeader, err := c.FormFile("file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "???zip??"})
@@ -329,45 +288,14 @@ func BatchUploadPrograms(c *gin.Context) {
 		return
 	}
 
-	workstations := map[string]map[string][]batchUploadProgramFile{}
-	totalFiles := 0
-
+	entries := make([]batchArchiveEntry, 0, len(zr.File))
 	for _, f := range zr.File {
 		if f.FileInfo().IsDir() {
 			continue
 		}
-		parts := strings.Split(strings.TrimPrefix(filepath.ToSlash(f.Name), "/"), "/")
-		if len(parts) < 3 {
-			continue
-		}
-		workstation := strings.TrimSpace(parts[0])
-		program := strings.TrimSpace(parts[1])
-		if workstation == "" || program == "" {
-			continue
-		}
-
-		if _, ok := workstations[workstation]; !ok {
-			workstations[workstation] = map[string][]batchUploadProgramFile{}
-		}
-		workstations[workstation][program] = append(workstations[workstation][program], batchUploadProgramFile{
-			Name: filepath.Base(f.Name),
-			Size: int64(f.UncompressedSize64),
-			Path: filepath.ToSlash(f.Name),
-		})
-		totalFiles++
+		entries = append(entries, batchArchiveEntry{Path: filepath.ToSlash(f.Name), Size: int64(f.UncompressedSize64)})
 	}
-
-	preview := batchUploadPreview{TotalFiles: totalFiles}
-	for wsName, programs := range workstations {
-		ws := batchUploadWorkstation{Name: wsName}
-		for progName, files := range programs {
-			ws.Programs = append(ws.Programs, batchUploadProgram{Name: progName, Files: files})
-			preview.TotalPrograms++
-		}
-		sort.Slice(ws.Programs, func(i, j int) bool { return ws.Programs[i].Name < ws.Programs[j].Name })
-		preview.Workstations = append(preview.Workstations, ws)
-	}
-	sort.Slice(preview.Workstations, func(i, j int) bool { return preview.Workstations[i].Name < preview.Workstations[j].Name })
+	preview := buildBatchUploadPreview(database.DB, layout, entries)
 
 	preview.PreviewID = createBatchPreview(preview, tempDir, userID)
 	cleanupTempDir = false
@@ -378,6 +306,7 @@ func BatchImportPrograms(c *gin.Context) {
 	var payload struct {
 		PreviewID string               `json:"preview_id"`
 		Mappings  []batchImportMapping `json:"mappings"`
+		DryRun    bool                 `json:"dry_run"`
 	}
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -431,6 +360,27 @@ func BatchImportPrograms(c *gin.Context) {
 		}
 	}
 
+	// 计划基于预览时保存的压缩包计算；dry-run 只返回计划，不消费预览
+	archive, archiveFiles, err := openBatchImportArchive(tempDir)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	plan, err := planBatchImport(c, preview, archiveFiles, mappingByName)
+	_ = archive.Close()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成导入计划失败"})
+		return
+	}
+	if payload.DryRun {
+		c.JSON(http.StatusOK, gin.H{
+			"preview_id": payload.PreviewID,
+			"summary":    summarizeBatchImportPlan(plan),
+			"items":      plan,
+		})
+		return
+	}
+
 	preview, tempDir, err = consumeBatchPreview(payload.PreviewID, userID)
 	if err != nil {
 		switch err.Error() {
@@ -444,121 +394,156 @@ func BatchImportPrograms(c *gin.Context) {
 		return
 	}
 
-	taskID := createBatchTask(preview.TotalPrograms, userID)
-	go runBatchImportTask(taskID, preview, tempDir, mappingByName, userID)
+	record, err := createBatchImportRecord(userID, preview.Layout, plan, tempDir)
+	_ = os.RemoveAll(tempDir)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存导入任务失败"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"task_id": taskID})
+	job, err := enqueueJob(jobTypeBatchImport, userID, batchImportJobPayload{ImportID: record.ID}, len(plan))
+	if err != nil {
+		failPendingBatchImportItems(record.ID, "提交导入任务失败")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交导入任务失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"task_id": job.ID, "import_id": record.ID, "summary": summarizeBatchImportPlan(plan)})
 }
 
-func runBatchImportTask(taskID int64, preview batchUploadPreview, tempDir string, mappingByName map[string]batchImportMapping, uploadedBy uint) {
-	defer func() {
-		if tempDir != "" {
-			_ = os.RemoveAll(tempDir)
-		}
-	}()
+// batchImportJobPayload 是批量导入任务的参数，执行时按导入记录中待处理的条目重新生成计划，
+// 因此执行实例中断后任务可以由其他实例接着处理剩余条目。
+type batchImportJobPayload struct {
+	ImportID uint `json:"import_id"`
+}
 
-	zipPath := filepath.Join(tempDir, "batch.zip")
-	zr, err := zip.OpenReader(zipPath)
+func runBatchImportJob(run *jobRun) error {
+	var payload batchImportJobPayload
+	if err := json.Unmarshal(run.job.Payload, &payload); err != nil {
+		return err
+	}
+	var record models.BatchImportTask
+	if err := database.DB.First(&record, payload.ImportID).Error; err != nil {
+		return fmt.Errorf("导入记录 %d 不存在", payload.ImportID)
+	}
+	var owner models.User
+	if err := database.DB.First(&owner, run.job.OwnerID).Error; err != nil {
+		return errors.New("任务提交人不存在")
+	}
+	var items []models.BatchImportTaskItem
+	if err := database.DB.Where("task_id = ? AND status = ?", record.ID, batchImportItemPending).Order("item_no ASC").Find(&items).Error; err != nil {
+		return err
+	}
+
+	request, _ := http.NewRequest(http.MethodPost, "/api/programs/batch-import", nil)
+	c, _ := newUserContext(owner, request)
+	plan, err := planStoredBatchImportItems(c, record, items)
 	if err != nil {
-		updateBatchTask(taskID, func(status *batchImportTaskStatus) {
-			status.Status = "failed"
-			status.ErrorMessage = "???????????"
-		})
-		return
+		for _, item := range items {
+			saveBatchImportItemResult(item.ID, "", err)
+		}
+		finishBatchImportRecord(record.ID)
+		return err
 	}
-	defer zr.Close()
-	if err := validateZipArchiveLimits(zr.File); err != nil {
-		updateBatchTask(taskID, func(status *batchImportTaskStatus) {
-			status.Status = "failed"
-			if errors.Is(err, errUploadTooLarge) {
-				status.ErrorMessage = "upload too large"
-			} else {
-				status.ErrorMessage = err.Error()
-			}
-		})
+	return runBatchImportTask(run, record, plan, items, owner.ID)
+}
+
+// abandonBatchImportJob 把中断任务中仍未处理的条目记为失败，使导入记录可以重试。
+func abandonBatchImportJob(job models.Job) {
+	var payload batchImportJobPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
 		return
 	}
+	failPendingBatchImportItems(payload.ImportID, "任务执行中断")
+}
 
-	archiveFiles := make(map[string]*zip.File, len(zr.File))
-	for _, file := range zr.File {
-		archiveFiles[filepath.ToSlash(file.Name)] = file
+// runBatchImportTask 按计划逐条导入；items 与 plan 一一对应，每条结果同时写入任务进度和导入记录。
+// 取消时剩余条目保持待处理，导入记录按失败汇总，可通过重试继续。
+func runBatchImportTask(run *jobRun, record models.BatchImportTask, plan []batchImportPlanItem, items []models.BatchImportTaskItem, uploadedBy uint) error {
+	zr, archiveFiles, err := openBatchImportArchive(record.ArchiveDir)
+	if err != nil {
+		for _, item := range items {
+			saveBatchImportItemResult(item.ID, "", err)
+		}
+		finishBatchImportRecord(record.ID)
+		return err
 	}
 
-	for _, ws := range preview.Workstations {
-		mapping, ok := mappingByName[ws.Name]
-		if !ok || mapping.ProductionLineID == nil {
-			for range ws.Programs {
-				updateBatchTask(taskID, func(status *batchImportTaskStatus) {
-					status.Processed++
-					status.Failed++
-					status.Progress = float64(status.Processed) * 100 / float64(max(status.Total, 1))
-					if status.ErrorMessage == "" {
-						status.ErrorMessage = fmt.Sprintf("?? %s ???????", ws.Name)
-					}
-				})
-			}
-			continue
+	for index, item := range plan {
+		if err := run.ctx.Err(); err != nil {
+			_ = zr.Close()
+			finishBatchImportRecord(record.ID)
+			return err
 		}
-
-		for _, prog := range ws.Programs {
-			nextSeq := 1
-			updateBatchTask(taskID, func(status *batchImportTaskStatus) {
-				status.CurrentItem = fmt.Sprintf("%s/%s", ws.Name, prog.Name)
-				nextSeq = status.Processed + 1
-			})
-
-			err := importBatchProgramFiles(archiveFiles, prog, mapping, uploadedBy, nextSeq)
-			updateBatchTask(taskID, func(status *batchImportTaskStatus) {
-				if err != nil {
-					status.Failed++
-					if status.ErrorMessage == "" {
-						status.ErrorMessage = err.Error()
-					}
-				} else {
-					status.Success++
-				}
-				status.Processed++
-				status.Progress = float64(status.Processed) * 100 / float64(max(status.Total, 1))
-			})
+		key := fmt.Sprintf("%s/%s", item.Workstation, item.Program)
+		run.setCurrent(key)
+
+		result := batchTaskItemResult{Row: items[index].ItemNo, Key: key, Status: item.Action}
+		programCode := item.ProgramCode
+		var err error
+		switch item.Action {
+		case batchPlanCreate:
+			programCode, err = importBatchProgramFiles(archiveFiles, item.source, item.mapping, uploadedBy, run.job.Processed+1)
+		case batchPlanNewVersion:
+			err = importBatchProgramVersion(archiveFiles, item, uploadedBy)
+		case batchPlanConflict:
+			err = errors.New(item.Reason)
 		}
+		if err != nil {
+			result.Status = "error"
+			result.Error = err.Error()
+		}
+		saveBatchImportItemResult(items[index].ID, programCode, err)
+		run.recordItem(key, result)
 	}
 
-	snapshot, ok := snapshotBatchTask(taskID)
-	if !ok {
-		return
+	_ = zr.Close()
+	finishBatchImportRecord(record.ID)
+	if run.job.Failed > 0 && run.job.Success == 0 {
+		return errJobItemsFailed
 	}
-	if snapshot.Failed > 0 && snapshot.Success == 0 {
-		updateBatchTask(taskID, func(status *batchImportTaskStatus) {
-			status.Status = "failed"
-			if status.ErrorMessage == "" {
-				status.ErrorMessage = "???????????????"
-			}
-		})
-		return
+	return nil
+}
+
+// batchImportProgramCode 产线配置了编号模板时按模板生成编号，否则沿用 BATCH-<时间戳>-<序号> 临时编号。
+// 编号需要在建目录前确定，因此单独开事务占用流水号，导入失败时留下的空号不再复用。
+func batchImportProgramCode(lineID, vehicleModelID uint, sequence int) (string, error) {
+	rule, err := loadProgramCodeRule(database.DB, lineID)
+	if err != nil {
+		return "", err
+	}
+	if rule.Pattern == "" {
+		return fmt.Sprintf("BATCH-%d-%d", time.Now().UnixNano(), sequence), nil
 	}
 
-	updateBatchTask(taskID, func(status *batchImportTaskStatus) {
-		status.Status = "completed"
-		status.Progress = 100
+	var code string
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		generated, err := generateProgramCode(tx, rule, vehicleModelID, time.Now())
+		if err != nil {
+			return err
+		}
+		code = generated
+		return nil
 	})
+	return code, err
 }
 
-func importBatchProgramFiles(archiveFiles map[string]*zip.File, prog batchUploadProgram, mapping batchImportMapping, uploadedBy uint, sequence int) error {
+func importBatchProgramFiles(archiveFiles map[string]*zip.File, prog batchUploadProgram, mapping batchImportMapping, uploadedBy uint, sequence int) (string, error) {
 	if mapping.ProductionLineID == nil {
-		return fmt.Errorf("???????")
+		return "", fmt.Errorf("???????")
 	}
 	if len(prog.Files) == 0 {
-		return fmt.Errorf("?? %s ???????", prog.Name)
+		return "", fmt.Errorf("?? %s ???????", prog.Name)
 	}
 
 	uploadDir := utils.UploadDir()
 	if err := utils.EnsureDirectoryExists(uploadDir); err != nil {
-		return err
+		return "", err
 	}
 
 	var productionLine models.ProductionLine
 	if err := database.DB.First(&productionLine, *mapping.ProductionLineID).Error; err != nil {
-		return fmt.Errorf("????????")
+		return "", fmt.Errorf("????????")
 	}
 
 	vehicleModelName := ""
@@ -566,20 +551,30 @@ func importBatchProgramFiles(archiveFiles map[string]*zip.File, prog batchUpload
 	if mapping.VehicleModelID != nil {
 		var vehicleModel models.VehicleModel
 		if err := database.DB.First(&vehicleModel, *mapping.VehicleModelID).Error; err != nil {
-			return fmt.Errorf("???????")
+			return "", fmt.Errorf("???????")
 		}
 		vehicleModelID = vehicleModel.ID
 		vehicleModelName = vehicleModel.Name
 	}
 
 	version := batchImportInitialVersion
-	code := fmt.Sprintf("BATCH-%d-%d", time.Now().UnixNano(), sequence)
+	if declared, _ := batchProgramDeclaredVersion(prog); declared != "" {
+		version = declared
+	}
+	code := prog.Code
+	if code == "" {
+		generated, err := batchImportProgramCode(productionLine.ID, vehicleModelID, sequence)
+		if err != nil {
+			return "", err
+		}
+		code = generated
+	}
 	programPath := utils.GenerateProgramPath(uploadDir, vehicleModelName, productionLine.Name, code, prog.Name, version)
 	if !utils.IsSafePath(uploadDir, programPath) {
-		return fmt.Errorf("??????????")
+		return "", fmt.Errorf("??????????")
 	}
 	if err := utils.EnsureDirectoryExists(programPath); err != nil {
-		return err
+		return "", err
 	}
 
 	createdFilePaths := make([]string, 0, len(prog.Files))
@@ -589,19 +584,27 @@ func importBatchProgramFiles(archiveFiles map[string]*zip.File, prog batchUpload
 		}
 	}()
 
+	statusConfig, err := loadProgramStatusConfig(database.DB, productionLine.ID)
+	if err != nil {
+		return "", err
+	}
+
 	program := models.Program{
 		Name:             prog.Name,
 		Code:             code,
 		ProductionLineID: *mapping.ProductionLineID,
 		VehicleModelID:   vehicleModelID,
 		Version:          version,
-		Status:           "in_progress",
+		Status:           statusConfig.initialStatus(),
 	}
 
-	err := database.DB.Transaction(func(tx *gorm.DB) error {
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Create(&program).Error; err != nil {
 			return err
 		}
+		if err := recordProgramStatusChange(tx, program.ID, "", program.Status, uploadedBy); err != nil {
+			return err
+		}
 
 		seenTargetPaths := map[string]struct{}{}
 		var latestFile models.ProgramFile
@@ -669,11 +672,11 @@ func importBatchProgramFiles(archiveFiles map[string]*zip.File, prog batchUpload
 			_ = os.Remove(filePath)
 		}
 		_ = os.RemoveAll(programPath)
-		return err
+		return "", err
 	}
 
 	createdFilePaths = nil
-	return nil
+	return code, nil
 }
 
 func writeBatchImportFile(archiveFile *zip.File, targetPath string) error {
@@ -695,39 +698,6 @@ func writeBatchImportFile(archiveFile *zip.File, targetPath string) error {
 	return writer.Close()
 }
 
-func GetTaskStatus(c *gin.Context) {
-	taskID, err := strconv.ParseInt(c.Param("task_id"), 10, 64)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "??ID????"})
-		return
-	}
-
-	userIDValue, ok := c.Get("user_id")
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "?????"})
-		return
-	}
-	userID, ok := userIDValue.(uint)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "??????"})
-		return
-	}
-	roleValue, _ := c.Get("user_role")
-	role, _ := roleValue.(string)
-
-	snapshot, exists, allowed := snapshotBatchTaskForUser(taskID, userID, role == "admin")
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "?????"})
-		return
-	}
-	if !allowed {
-		c.JSON(http.StatusForbidden, gin.H{"error": "???"})
-		return
-	}
-
-	c.JSON(http.StatusOK, snapshot)
-}
-
 func max(a, b int) int {
 	if a > b {
 		return a
@@ -0,0 +1,468 @@
+package controllers
+
+import (
+	"archive/zip"
+	"crane-system/database"
+	"crane-system/models"
+	"crane-system/utils"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	batchPlanCreate     = "create"
+	batchPlanNewVersion = "new_version"
+	batchPlanUnchanged  = "unchanged"
+	batchPlanConflict   = "conflict"
+
+	batchPlanFileAdded     = "added"
+	batchPlanFileChanged   = "changed"
+	batchPlanFileUnchanged = "unchanged"
+)
+
+var batchImportVersionPattern = regexp.MustCompile(`^[vV](\d+)$`)
+
+type batchImportPlanFile struct {
+	Name           string `json:"name"`
+	Path           string `json:"path"`
+	Size           int64  `json:"size"`
+	Hash           string `json:"hash"`
+	Status         string `json:"status"` // added / changed / unchanged，相对现有程序当前版本
+	ExistingFileID uint   `json:"existing_file_id,omitempty"`
+}
+
+// batchImportPlanItem 是压缩包中一个程序的处理计划；dry-run 直接返回，正式导入按计划执行。
+type batchImportPlanItem struct {
+	Workstation      string                `json:"workstation"`
+	Program          string                `json:"program"`
+	Action           string                `json:"action"`
+	ProductionLineID uint                  `json:"production_line_id"`
+	ProgramID        uint                  `json:"program_id,omitempty"`
+	ProgramCode      string                `json:"program_code,omitempty"`
+	CurrentVersion   string                `json:"current_version,omitempty"`
+	NextVersion      string                `json:"next_version,omitempty"`
+	Reason           string                `json:"reason,omitempty"`
+	Files            []batchImportPlanFile `json:"files"`
+	RemovedFiles     []string              `json:"removed_files,omitempty"` // 当前版本有而压缩包中没有的文件
+
+	source          batchUploadProgram
+	mapping         batchImportMapping
+	declaredVersion string
+}
+
+func (item *batchImportPlanItem) conflict(reason string) {
+	item.Action = batchPlanConflict
+	item.Reason = reason
+}
+
+func hashZipEntry(file *zip.File) (string, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	hasher := sha256.New()
+	if err := copyWithLimit(hasher, reader, maxUploadSize()); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// hashStoredProgramFile 计算已上传文件的哈希；文件缺失时返回空串，按内容已变化处理。
+func hashStoredProgramFile(file models.ProgramFile) string {
+	uploadDir := utils.UploadDir()
+	fullPath := filepath.Join(uploadDir, file.FilePath)
+	if !utils.IsSafePath(uploadDir, fullPath) {
+		return ""
+	}
+	reader, err := os.Open(fullPath)
+	if err != nil {
+		return ""
+	}
+	defer reader.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// nextBatchImportVersion 在 vN 形式的版本号上递增，其他格式按已有版本数顺延，并跳过已存在的版本号。
+func nextBatchImportVersion(tx *gorm.DB, program models.Program) (string, error) {
+	var versions []string
+	if err := tx.Model(&models.ProgramVersion{}).Where("program_id = ?", program.ID).Pluck("version", &versions).Error; err != nil {
+		return "", err
+	}
+	existing := make(map[string]struct{}, len(versions))
+	highest := len(versions)
+	for _, version := range versions {
+		existing[version] = struct{}{}
+		if match := batchImportVersionPattern.FindStringSubmatch(version); match != nil {
+			if number, err := strconv.Atoi(match[1]); err == nil && number > highest {
+				highest = number
+			}
+		}
+	}
+	for number := highest + 1; ; number++ {
+		candidate := fmt.Sprintf("v%d", number)
+		if _, taken := existing[candidate]; !taken {
+			return candidate, nil
+		}
+	}
+}
+
+// batchProgramDeclaredVersion 返回布局从文件名中提取的版本号；各文件版本不一致时 consistent 为 false。
+func batchProgramDeclaredVersion(prog batchUploadProgram) (version string, consistent bool) {
+	for i, file := range prog.Files {
+		if i == 0 {
+			version = file.Version
+		} else if file.Version != version {
+			return "", false
+		}
+	}
+	return version, true
+}
+
+// planBatchImport 把压缩包中的每个程序与映射产线上的现有程序（按名称或编号）匹配，
+// 并按文件哈希判断是新建、追加新版本、无变化还是冲突。不写入任何数据。
+func planBatchImport(c *gin.Context, preview batchUploadPreview, archiveFiles map[string]*zip.File, mappingByName map[string]batchImportMapping) ([]batchImportPlanItem, error) {
+	items := make([]batchImportPlanItem, 0, preview.TotalPrograms)
+	claimed := map[uint][]int{}
+
+	for _, ws := range preview.Workstations {
+		mapping := mappingByName[ws.Name]
+		for _, prog := range ws.Programs {
+			item := batchImportPlanItem{Workstation: ws.Name, Program: prog.Name, source: prog, mapping: mapping}
+			if mapping.ProductionLineID == nil {
+				item.conflict("工位未映射生产线")
+				items = append(items, item)
+				continue
+			}
+			item.ProductionLineID = *mapping.ProductionLineID
+
+			names := map[string]struct{}{}
+			for _, file := range prog.Files {
+				planFile := batchImportPlanFile{Name: utils.SanitizeFilename(file.Name), Path: file.Path, Size: file.Size, Status: batchPlanFileAdded}
+				archiveFile, ok := archiveFiles[filepath.ToSlash(file.Path)]
+				if !ok {
+					item.conflict("压缩包中缺少文件 " + file.Path)
+				} else if hash, err := hashZipEntry(archiveFile); err != nil {
+					item.conflict("读取文件失败 " + file.Path)
+				} else {
+					planFile.Hash = hash
+				}
+				if _, duplicated := names[planFile.Name]; duplicated {
+					item.conflict("程序中包含重名文件 " + planFile.Name)
+				}
+				names[planFile.Name] = struct{}{}
+				item.Files = append(item.Files, planFile)
+			}
+			if len(prog.Files) == 0 {
+				item.conflict("程序下没有文件")
+			}
+			if version, consistent := batchProgramDeclaredVersion(prog); !consistent {
+				item.conflict("文件名中的版本号不一致")
+			} else {
+				item.declaredVersion = version
+			}
+			if item.Action == batchPlanConflict {
+				items = append(items, item)
+				continue
+			}
+
+			if err := resolveBatchImportPlanTarget(c, &item); err != nil {
+				return nil, err
+			}
+			if item.ProgramID != 0 {
+				claimed[item.ProgramID] = append(claimed[item.ProgramID], len(items))
+			}
+			items = append(items, item)
+		}
+	}
+
+	for _, indexes := range claimed {
+		if len(indexes) < 2 {
+			continue
+		}
+		for _, index := range indexes {
+			items[index].conflict("压缩包中有多个程序匹配到同一现有程序")
+		}
+	}
+	return items, nil
+}
+
+func resolveBatchImportPlanTarget(c *gin.Context, item *batchImportPlanItem) error {
+	matchCode := item.Program
+	if item.source.Code != "" {
+		matchCode = item.source.Code
+	}
+	var candidates []models.Program
+	if err := database.DB.
+		Where("production_line_id = ? AND (name = ? OR code = ?)", item.ProductionLineID, item.Program, matchCode).
+		Find(&candidates).Error; err != nil {
+		return err
+	}
+	targets := map[uint]models.Program{}
+	for _, candidate := range candidates {
+		target, targetID, _, err := resolveProgramTarget(database.DB, candidate.ID)
+		if err != nil {
+			return err
+		}
+		targets[targetID] = target
+	}
+
+	switch len(targets) {
+	case 0:
+		if item.source.Code != "" {
+			var count int64
+			if err := database.DB.Model(&models.Program{}).Where("code = ?", item.source.Code).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				item.conflict("编号 " + item.source.Code + " 已被其他产线的程序使用")
+				return nil
+			}
+			item.ProgramCode = item.source.Code
+		}
+		item.Action = batchPlanCreate
+		item.NextVersion = batchImportInitialVersion
+		if item.declaredVersion != "" {
+			item.NextVersion = item.declaredVersion
+		}
+		return nil
+	case 1:
+	default:
+		codes := make([]string, 0, len(targets))
+		for _, target := range targets {
+			codes = append(codes, target.Code)
+		}
+		sort.Strings(codes)
+		item.conflict("匹配到多个现有程序: " + strings.Join(codes, ", "))
+		return nil
+	}
+
+	var target models.Program
+	for _, program := range targets {
+		target = program
+	}
+	item.ProgramID = target.ID
+	item.ProgramCode = target.Code
+	item.CurrentVersion = target.Version
+	if item.mapping.VehicleModelID != nil && target.VehicleModelID != 0 && target.VehicleModelID != *item.mapping.VehicleModelID {
+		item.conflict("车型与现有程序不一致")
+		return nil
+	}
+	if allowed, _, _ := checkProgramScopeAction(c, target.ID, target.ProductionLineID, target.VehicleModelID, lineActionUpload); !allowed {
+		item.conflict("无权向现有程序上传文件")
+		return nil
+	}
+
+	var existingFiles []models.ProgramFile
+	if err := database.DB.Where("program_id = ? AND version = ?", target.ID, target.Version).Order("id DESC").Find(&existingFiles).Error; err != nil {
+		return err
+	}
+	existingByName := make(map[string]models.ProgramFile, len(existingFiles))
+	for _, file := range existingFiles {
+		if _, exists := existingByName[file.FileName]; !exists {
+			existingByName[file.FileName] = file
+		}
+	}
+
+	changed := false
+	for i := range item.Files {
+		existing, ok := existingByName[item.Files[i].Name]
+		if !ok {
+			changed = true
+			continue
+		}
+		delete(existingByName, item.Files[i].Name)
+		item.Files[i].ExistingFileID = existing.ID
+		if hashStoredProgramFile(existing) == item.Files[i].Hash {
+			item.Files[i].Status = batchPlanFileUnchanged
+		} else {
+			item.Files[i].Status = batchPlanFileChanged
+			changed = true
+		}
+	}
+	for name := range existingByName {
+		item.RemovedFiles = append(item.RemovedFiles, name)
+		changed = true
+	}
+	sort.Strings(item.RemovedFiles)
+
+	if !changed {
+		item.Action = batchPlanUnchanged
+		return nil
+	}
+	nextVersion := item.declaredVersion
+	if nextVersion != "" {
+		var taken int64
+		if err := database.DB.Model(&models.ProgramVersion{}).Where("program_id = ? AND version = ?", target.ID, nextVersion).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			item.conflict("版本 " + nextVersion + " 已存在但文件内容不同")
+			return nil
+		}
+	} else {
+		var err error
+		if nextVersion, err = nextBatchImportVersion(database.DB, target); err != nil {
+			return err
+		}
+	}
+	item.Action = batchPlanNewVersion
+	item.NextVersion = nextVersion
+	return nil
+}
+
+func summarizeBatchImportPlan(items []batchImportPlanItem) map[string]int {
+	summary := map[string]int{batchPlanCreate: 0, batchPlanNewVersion: 0, batchPlanUnchanged: 0, batchPlanConflict: 0}
+	for _, item := range items {
+		summary[item.Action]++
+	}
+	return summary
+}
+
+// importBatchProgramVersion 把压缩包中的文件作为现有程序的新版本写入，并设为当前版本。
+func importBatchProgramVersion(archiveFiles map[string]*zip.File, item batchImportPlanItem, uploadedBy uint) error {
+	uploadDir := utils.UploadDir()
+	if err := utils.EnsureDirectoryExists(uploadDir); err != nil {
+		return err
+	}
+
+	var program models.Program
+	if err := database.DB.Preload("ProductionLine").Preload("VehicleModel").First(&program, item.ProgramID).Error; err != nil {
+		return fmt.Errorf("程序不存在")
+	}
+	version := item.NextVersion
+	programPath := utils.GenerateProgramPath(uploadDir, program.VehicleModel.Name, program.ProductionLine.Name, program.Code, program.Name, version)
+	if !utils.IsSafePath(uploadDir, programPath) {
+		return fmt.Errorf("文件路径不合法")
+	}
+	// 并发导入可能已在同一目录写入了文件，回滚时只清理本次创建的目录
+	_, statErr := os.Stat(programPath)
+	createdDir := os.IsNotExist(statErr)
+	if err := utils.EnsureDirectoryExists(programPath); err != nil {
+		return err
+	}
+
+	var createdFilePaths []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Program{}, program.ID).Error; err != nil {
+			return err
+		}
+		var taken int64
+		if err := tx.Model(&models.ProgramVersion{}).Where("program_id = ? AND version = ?", program.ID, version).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			return fmt.Errorf("版本 %s 已存在，请重新预览", version)
+		}
+
+		latestFile, paths, err := writeBatchProgramFiles(tx, archiveFiles, item.source, program.ID, programPath, version, uploadedBy, "批量导入新版本")
+		createdFilePaths = paths
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.ProgramVersion{}).Where("program_id = ?", program.ID).Update("is_current", false).Error; err != nil {
+			return err
+		}
+		versionRecord := models.ProgramVersion{
+			ProgramID:  program.ID,
+			Version:    version,
+			FileID:     latestFile.ID,
+			UploadedBy: uploadedBy,
+			ChangeLog:  "批量导入新版本",
+			IsCurrent:  true,
+		}
+		if err := tx.Create(&versionRecord).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Program{}).Where("id = ?", program.ID).Update("version", version).Error
+	})
+	if err != nil {
+		for _, filePath := range createdFilePaths {
+			_ = os.Remove(filePath)
+		}
+		if createdDir {
+			_ = os.Remove(programPath)
+		}
+		return err
+	}
+	return nil
+}
+
+// openBatchImportArchive 打开预览时保存的压缩包，并按路径索引其中的文件。
+func openBatchImportArchive(tempDir string) (*zip.ReadCloser, map[string]*zip.File, error) {
+	zr, err := zip.OpenReader(filepath.Join(tempDir, "batch.zip"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("读取压缩包失败")
+	}
+	if err := validateZipArchiveLimits(zr.File); err != nil {
+		_ = zr.Close()
+		if errors.Is(err, errUploadTooLarge) {
+			return nil, nil, errUploadTooLarge
+		}
+		return nil, nil, err
+	}
+	archiveFiles := make(map[string]*zip.File, len(zr.File))
+	for _, file := range zr.File {
+		archiveFiles[filepath.ToSlash(file.Name)] = file
+	}
+	return zr, archiveFiles, nil
+}
+
+// writeBatchProgramFiles 把程序的全部文件写入 programPath 并登记为指定版本，返回最后一个文件和已写入的路径。
+func writeBatchProgramFiles(tx *gorm.DB, archiveFiles map[string]*zip.File, prog batchUploadProgram, programID uint, programPath, version string, uploadedBy uint, description string) (models.ProgramFile, []string, error) {
+	uploadDir := utils.UploadDir()
+	var latestFile models.ProgramFile
+	createdFilePaths := make([]string, 0, len(prog.Files))
+	for _, importedFile := range prog.Files {
+		archiveFile, ok := archiveFiles[filepath.ToSlash(importedFile.Path)]
+		if !ok {
+			return latestFile, createdFilePaths, fmt.Errorf("压缩包中缺少文件 %s", importedFile.Path)
+		}
+		targetPath := filepath.Join(programPath, utils.SanitizeFilename(importedFile.Name))
+		if !utils.IsSafePath(uploadDir, targetPath) {
+			return latestFile, createdFilePaths, fmt.Errorf("文件路径不合法")
+		}
+		if err := writeBatchImportFile(archiveFile, targetPath); err != nil {
+			return latestFile, createdFilePaths, err
+		}
+		createdFilePaths = append(createdFilePaths, targetPath)
+
+		relativePath, err := utils.GetRelativePath(uploadDir, targetPath)
+		if err != nil {
+			return latestFile, createdFilePaths, err
+		}
+		programFile := models.ProgramFile{
+			ProgramID:   programID,
+			FileName:    filepath.Base(targetPath),
+			FilePath:    relativePath,
+			FileSize:    importedFile.Size,
+			FileType:    filepath.Ext(importedFile.Name),
+			Version:     version,
+			UploadedBy:  uploadedBy,
+			Description: description,
+		}
+		if err := tx.Create(&programFile).Error; err != nil {
+			return latestFile, createdFilePaths, err
+		}
+		latestFile = programFile
+	}
+	return latestFile, createdFilePaths, nil
+}
@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"crane-system/config"
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+	"crane-system/services"
+	"crane-system/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupBatchImportPlanTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	{
+		api.POST("/batch/upload", BatchUploadPrograms)
+		api.POST("/batch/import", BatchImportPrograms)
+		api.GET("/batch-import-layouts", GetBatchImportLayouts)
+		api.GET("/batch-imports/:id", GetBatchImport)
+		api.POST("/batch-imports/:id/retry", RetryBatchImport)
+		api.GET("/batch-imports/:id/results.csv", ExportBatchImportResults)
+		api.POST("/batch-import-layouts", CreateBatchImportLayout)
+	}
+	return r
+}
+
+func uploadBatchImportZip(t *testing.T, r http.Handler, token, layout string, files map[string]string) batchUploadPreview {
+	t.Helper()
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("create zip entry: %v", err)
+		}
+		_, _ = w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if layout != "" {
+		_ = writer.WriteField("layout", layout)
+	}
+	part, _ := writer.CreateFormFile("file", "batch.zip")
+	_, _ = part.Write(archive.Bytes())
+	_ = writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/batch/upload", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("batch upload: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	return decodeProductionLineCustomFieldResponse[batchUploadPreview](t, resp)
+}
+
+// useBatchImportTestStorage 把上传目录和导入保留目录指向临时目录。
+func useBatchImportTestStorage(t *testing.T) {
+	t.Helper()
+	original := config.AppConfig.Storage
+	config.AppConfig.Storage.UploadsDir = t.TempDir()
+	config.AppConfig.Storage.BatchImportDir = t.TempDir()
+	t.Cleanup(func() { config.AppConfig.Storage = original })
+}
+
+// seedBatchImportProgram 创建带 v1 版本文件的现有程序，文件内容写入上传目录。
+func seedBatchImportProgram(t *testing.T, lineID uint, name, code string, files map[string]string) models.Program {
+	t.Helper()
+	program := models.Program{Name: name, Code: code, ProductionLineID: lineID, Version: "v1", Status: "completed"}
+	if err := database.DB.Create(&program).Error; err != nil {
+		t.Fatalf("create program: %v", err)
+	}
+	var lastFileID uint
+	for fileName, content := range files {
+		relativePath := filepath.Join("seed", code, fileName)
+		fullPath := filepath.Join(config.AppConfig.Storage.UploadsDir, relativePath)
+		_ = os.MkdirAll(filepath.Dir(fullPath), 0o755)
+		if err := os.WriteFile(fullPath, []byte(content), 0o644); err != nil {
+			t.Fatalf("write seed file: %v", err)
+		}
+		file := models.ProgramFile{ProgramID: program.ID, FileName: fileName, FilePath: relativePath, FileSize: int64(len(content)), Version: "v1"}
+		if err := database.DB.Create(&file).Error; err != nil {
+			t.Fatalf("create program file: %v", err)
+		}
+		lastFileID = file.ID
+	}
+	if err := database.DB.Create(&models.ProgramVersion{ProgramID: program.ID, Version: "v1", FileID: lastFileID, IsCurrent: true}).Error; err != nil {
+		t.Fatalf("create program version: %v", err)
+	}
+	return program
+}
+
+func TestBatchImportDryRunPlansAgainstExistingPrograms(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	adminToken, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupBatchImportPlanTestRouter()
+
+	useBatchImportTestStorage(t)
+
+	seedBatchImportProgram(t, line.ID, "同步程序", "P-SAME", map[string]string{"a.txt": "same"})
+	changed := seedBatchImportProgram(t, line.ID, "变更程序", "P-CHG", map[string]string{"a.txt": "old", "gone.txt": "x"})
+	seedBatchImportProgram(t, line.ID, "重名", "P-DUP1", nil)
+	seedBatchImportProgram(t, line.ID, "重名", "P-DUP2", nil)
+
+	preview := uploadBatchImportZip(t, r, adminToken, "", map[string]string{
+		"WS/P-SAME/a.txt": "same",
+		"WS/变更程序/a.txt":   "new",
+		"WS/变更程序/b.txt":   "added",
+		"WS/新程序/x.txt":    "fresh",
+		"WS/重名/y.txt":     "dup",
+	})
+	payload := map[string]any{
+		"preview_id": preview.PreviewID,
+		"mappings":   []map[string]any{{"workstation_name": "WS", "production_line_id": line.ID}},
+		"dry_run":    true,
+	}
+
+	type planResponse struct {
+		Summary map[string]int        `json:"summary"`
+		Items   []batchImportPlanItem `json:"items"`
+	}
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/batch/import", adminToken, payload)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("dry run: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	plan := decodeProductionLineCustomFieldResponse[planResponse](t, resp)
+	if plan.Summary[batchPlanCreate] != 1 || plan.Summary[batchPlanNewVersion] != 1 || plan.Summary[batchPlanUnchanged] != 1 || plan.Summary[batchPlanConflict] != 1 {
+		t.Fatalf("summary = %+v", plan.Summary)
+	}
+	for _, item := range plan.Items {
+		if item.Program == "变更程序" {
+			if item.NextVersion != "v2" || len(item.RemovedFiles) != 1 || item.RemovedFiles[0] != "gone.txt" {
+				t.Fatalf("new version item = %+v", item)
+			}
+			statuses := map[string]string{}
+			for _, file := range item.Files {
+				statuses[file.Name] = file.Status
+			}
+			if statuses["a.txt"] != batchPlanFileChanged || statuses["b.txt"] != batchPlanFileAdded {
+				t.Fatalf("file statuses = %+v", statuses)
+			}
+		}
+	}
+	var programCount int64
+	database.DB.Model(&models.Program{}).Count(&programCount)
+	if programCount != 4 {
+		t.Fatalf("dry run wrote programs: count = %d", programCount)
+	}
+
+	// 预览未被消费，可以按同一计划正式导入
+	payload["dry_run"] = false
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/batch/import", adminToken, payload)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("import: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	taskID := int64(decodeProductionLineCustomFieldResponse[map[string]any](t, resp)["task_id"].(float64))
+	status := waitProgramExcelImportTask(t, taskID)
+	if status.Status != "completed" || status.Success != 3 || status.Failed != 1 || len(status.Items) != 4 {
+		t.Fatalf("task status = %+v", status)
+	}
+
+	var reloaded models.Program
+	database.DB.First(&reloaded, changed.ID)
+	var current models.ProgramVersion
+	database.DB.Where("program_id = ? AND is_current = ?", changed.ID, true).First(&current)
+	if reloaded.Version != "v2" || current.Version != "v2" {
+		t.Fatalf("changed program version = %q, current = %q", reloaded.Version, current.Version)
+	}
+	var newFiles int64
+	database.DB.Model(&models.ProgramFile{}).Where("program_id = ? AND version = ?", changed.ID, "v2").Count(&newFiles)
+	if newFiles != 2 {
+		t.Fatalf("v2 files = %d", newFiles)
+	}
+	database.DB.Model(&models.Program{}).Count(&programCount)
+	if programCount != 5 {
+		t.Fatalf("program count after import = %d", programCount)
+	}
+}
+
+func TestBatchImportDryRunRespectsProgramDeny(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	_, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	services.InvalidateAllCache()
+	r := setupBatchImportPlanTestRouter()
+	useBatchImportTestStorage(t)
+
+	locked := seedBatchImportProgram(t, line.ID, "锁定程序", "P-LOCKED", map[string]string{"a.txt": "old"})
+	seedBatchImportProgram(t, line.ID, "普通程序", "P-OPEN", map[string]string{"a.txt": "old"})
+	editor := models.User{Name: "Editor", Password: "hashed", EmployeeID: "EDIT-001", Role: "engineer", Status: "active"}
+	if err := database.DB.Create(&editor).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := services.SavePermissionRuleChanges(services.PermissionSubject{Type: models.PermissionSubjectUser, ID: editor.ID}, []services.PermissionRuleChange{
+		{ResourceType: models.PermissionResourceProductionLine, ResourceID: line.ID, Action: models.PermissionActionManage, Decision: models.PermissionDecisionAllow},
+		{ResourceType: models.PermissionResourceProgram, ResourceID: locked.ID, Action: models.PermissionActionUpload, Decision: models.PermissionDecisionDeny},
+		{ResourceType: models.PermissionResourceProgram, ResourceID: locked.ID, Action: models.PermissionActionManage, Decision: models.PermissionDecisionDeny},
+	}); err != nil {
+		t.Fatalf("save user rules: %v", err)
+	}
+	token := createUserTokenForTest(t, editor.ID, "engineer")
+
+	preview := uploadBatchImportZip(t, r, token, "", map[string]string{
+		"WS/P-LOCKED/a.txt": "new",
+		"WS/P-OPEN/a.txt":   "new",
+	})
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/batch/import", token, map[string]any{
+		"preview_id": preview.PreviewID,
+		"mappings":   []map[string]any{{"workstation_name": "WS", "production_line_id": line.ID}},
+		"dry_run":    true,
+	})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("dry run: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	plan := decodeProductionLineCustomFieldResponse[struct {
+		Items []batchImportPlanItem `json:"items"`
+	}](t, resp)
+	actions := map[string]string{}
+	for _, item := range plan.Items {
+		actions[item.ProgramCode] = item.Action
+	}
+	if actions["P-LOCKED"] != batchPlanConflict || actions["P-OPEN"] != batchPlanNewVersion {
+		t.Fatalf("expected program deny to block only the locked program, got %+v", plan.Items)
+	}
+}
+
+func TestImportBatchProgramVersionConflictKeepsWinningFiles(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	_, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	useBatchImportTestStorage(t)
+	program := seedBatchImportProgram(t, line.ID, "并发程序", "P-RACE", map[string]string{"a.txt": "old"})
+
+	// 另一次导入已经写入了 v2 的文件和版本记录
+	programPath := utils.GenerateProgramPath(utils.UploadDir(), "", line.Name, program.Code, program.Name, "v2")
+	if err := os.MkdirAll(programPath, 0o755); err != nil {
+		t.Fatalf("create program dir: %v", err)
+	}
+	winnerFile := filepath.Join(programPath, "a.txt")
+	if err := os.WriteFile(winnerFile, []byte("winner"), 0o644); err != nil {
+		t.Fatalf("write winner file: %v", err)
+	}
+	if err := database.DB.Create(&models.ProgramVersion{ProgramID: program.ID, Version: "v2"}).Error; err != nil {
+		t.Fatalf("create winner version: %v", err)
+	}
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, _ := zw.Create("WS/并发程序/a.txt")
+	_, _ = w.Write([]byte("loser"))
+	_ = zw.Close()
+	zr, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	item := batchImportPlanItem{
+		ProgramID:   program.ID,
+		NextVersion: "v2",
+		source: batchUploadProgram{Name: program.Name, Files: []batchUploadProgramFile{
+			{Name: "a.txt", Size: 5, Path: "WS/并发程序/a.txt"},
+		}},
+	}
+	err = importBatchProgramVersion(map[string]*zip.File{"WS/并发程序/a.txt": zr.File[0]}, item, 1)
+	if err == nil {
+		t.Fatal("expected version conflict")
+	}
+	content, readErr := os.ReadFile(winnerFile)
+	if readErr != nil || string(content) != "winner" {
+		t.Fatalf("winning import's file was removed or overwritten: %q %v", content, readErr)
+	}
+}
@@ -104,7 +104,7 @@ func UploadFile(c *gin.Context) {
 		return
 	}
 	program := targetProgram
-	if !authorizeLineAction(c, program.ProductionLineID, lineActionUpload) {
+	if !authorizeProgramScopeAction(c, program.ID, program.ProductionLineID, program.VehicleModelID, lineActionUpload) {
 		return
 	}
 
@@ -265,7 +265,7 @@ func DownloadFile(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "程序不存在"})
 		return
 	}
-	if !authorizeLineAction(c, program.ProductionLineID, lineActionDownload) {
+	if !authorizeProgramScopeAction(c, program.ID, program.ProductionLineID, program.VehicleModelID, lineActionDownload) {
 		return
 	}
 
@@ -400,7 +400,7 @@ func GetProgramFiles(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "program not found"})
 		return
 	}
-	if !authorizeLineAction(c, targetProgram.ProductionLineID, lineActionView) {
+	if !authorizeProgramScopeAction(c, targetProgram.ID, targetProgram.ProductionLineID, targetProgram.VehicleModelID, lineActionView) {
 		return
 	}
 
@@ -513,9 +513,10 @@ func DeleteFile(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "?????"})
 		return
 	}
-	if !authorizeLineAction(c, program.ProductionLineID, lineActionManage) {
+	if !authorizeProgramScopeAction(c, program.ID, program.ProductionLineID, program.VehicleModelID, lineActionManage) {
 		return
 	}
+	recordAuditChange(c, "program_file", file.ID, file, nil)
 
 	uploadDir := utils.UploadDir()
 	filePath := filepath.Join(uploadDir, file.FilePath)
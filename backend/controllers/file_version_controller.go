@@ -29,7 +29,7 @@ func GetProgramVersions(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "?????"})
 		return
 	}
-	if !authorizeLineAction(c, targetProgram.ProductionLineID, lineActionView) {
+	if !authorizeProgramScopeAction(c, targetProgram.ID, targetProgram.ProductionLineID, targetProgram.VehicleModelID, lineActionView) {
 		return
 	}
 
@@ -69,7 +69,7 @@ func CreateVersion(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "?????"})
 		return
 	}
-	if !authorizeLineAction(c, targetProgram.ProductionLineID, lineActionManage) {
+	if !authorizeProgramScopeAction(c, targetProgram.ID, targetProgram.ProductionLineID, targetProgram.VehicleModelID, lineActionManage) {
 		return
 	}
 
@@ -151,7 +151,7 @@ func UpdateVersion(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "?????"})
 		return
 	}
-	if !authorizeLineAction(c, targetProgram.ProductionLineID, lineActionManage) {
+	if !authorizeProgramScopeAction(c, targetProgram.ID, targetProgram.ProductionLineID, targetProgram.VehicleModelID, lineActionManage) {
 		return
 	}
 
@@ -190,7 +190,7 @@ func ActivateVersion(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "?????"})
 		return
 	}
-	if !authorizeLineAction(c, targetProgram.ProductionLineID, lineActionManage) {
+	if !authorizeProgramScopeAction(c, targetProgram.ID, targetProgram.ProductionLineID, targetProgram.VehicleModelID, lineActionManage) {
 		return
 	}
 
@@ -240,7 +240,7 @@ func DownloadProgramLatestVersion(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "?????"})
 		return
 	}
-	if !authorizeLineAction(c, targetProgram.ProductionLineID, lineActionDownload) {
+	if !authorizeProgramScopeAction(c, targetProgram.ID, targetProgram.ProductionLineID, targetProgram.VehicleModelID, lineActionDownload) {
 		return
 	}
 	program := targetProgram
@@ -300,7 +300,7 @@ func DownloadVersionFiles(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "?????"})
 		return
 	}
-	if !authorizeLineAction(c, targetProgram.ProductionLineID, lineActionDownload) {
+	if !authorizeProgramScopeAction(c, targetProgram.ID, targetProgram.ProductionLineID, targetProgram.VehicleModelID, lineActionDownload) {
 		return
 	}
 	program := targetProgram
@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"crane-system/database"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultImportWatchStableSeconds = 30
+
+type importWatchFolderRequest struct {
+	Name             *string `json:"name"`
+	Path             *string `json:"path"`
+	Layout           *string `json:"layout"`
+	ProductionLineID *uint   `json:"production_line_id"`
+	VehicleModelID   *uint   `json:"vehicle_model_id"`
+	Enabled          *bool   `json:"enabled"`
+	StableSeconds    *int    `json:"stable_seconds"`
+}
+
+func (req importWatchFolderRequest) apply(folder *models.ImportWatchFolder) {
+	if req.Name != nil {
+		folder.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Path != nil {
+		folder.Path = strings.TrimSpace(*req.Path)
+	}
+	if req.Layout != nil {
+		folder.Layout = strings.TrimSpace(*req.Layout)
+	}
+	if req.ProductionLineID != nil {
+		folder.ProductionLineID = *req.ProductionLineID
+	}
+	if req.VehicleModelID != nil {
+		if *req.VehicleModelID == 0 {
+			folder.VehicleModelID = nil
+		} else {
+			modelID := *req.VehicleModelID
+			folder.VehicleModelID = &modelID
+		}
+	}
+	if req.Enabled != nil {
+		folder.Enabled = *req.Enabled
+	}
+	if req.StableSeconds != nil {
+		folder.StableSeconds = *req.StableSeconds
+	}
+}
+
+// validateImportWatchFolder 校验配置，并把目录规范为绝对路径。
+func validateImportWatchFolder(c *gin.Context, folder *models.ImportWatchFolder) (int, string) {
+	if folder.Name == "" || len([]rune(folder.Name)) > 100 {
+		return http.StatusBadRequest, "名称不能为空且不超过100个字符"
+	}
+	resolved, err := resolveImportWatchPath(folder.Path)
+	if err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+	folder.Path = resolved
+	if _, err := loadBatchImportLayout(database.DB, folder.Layout); err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+	if folder.StableSeconds < 0 || folder.StableSeconds > 86400 {
+		return http.StatusBadRequest, "静置时间需在0到86400秒之间"
+	}
+	if folder.ProductionLineID == 0 {
+		return http.StatusBadRequest, "请选择默认生产线"
+	}
+	if allowed, status, message := checkLineAction(c, folder.ProductionLineID, lineActionManage); !allowed {
+		return status, message
+	}
+	if folder.VehicleModelID != nil {
+		var count int64
+		if err := database.DB.Model(&models.VehicleModel{}).Where("id = ?", *folder.VehicleModelID).Count(&count).Error; err != nil || count == 0 {
+			return http.StatusBadRequest, "车型不存在"
+		}
+	}
+	return 0, ""
+}
+
+func loadImportWatchFolder(c *gin.Context) (models.ImportWatchFolder, bool) {
+	var folder models.ImportWatchFolder
+	folderID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "监视目录ID格式错误"})
+		return folder, false
+	}
+	if err := database.DB.First(&folder, folderID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "监视目录不存在"})
+		return folder, false
+	}
+	return folder, true
+}
+
+func GetImportWatchFolders(c *gin.Context) {
+	var folders []models.ImportWatchFolder
+	if err := database.DB.Preload("ProductionLine").Preload("Owner").Order("id ASC").Find(&folders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, folders)
+}
+
+// CreateImportWatchFolder 创建人即为后台导入时使用的身份。
+func CreateImportWatchFolder(c *gin.Context) {
+	var req importWatchFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	folder := models.ImportWatchFolder{OwnerID: currentUserID(c), Enabled: true, StableSeconds: defaultImportWatchStableSeconds}
+	req.apply(&folder)
+	if status, msg := validateImportWatchFolder(c, &folder); msg != "" {
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	var count int64
+	if err := database.DB.Model(&models.ImportWatchFolder{}).Where("path = ?", folder.Path).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "该目录已被监视"})
+		return
+	}
+
+	if err := database.DB.Create(&folder).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建监视目录失败"})
+		return
+	}
+	c.JSON(http.StatusCreated, folder)
+}
+
+func UpdateImportWatchFolder(c *gin.Context) {
+	folder, ok := loadImportWatchFolder(c)
+	if !ok {
+		return
+	}
+	var req importWatchFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.apply(&folder)
+	if status, msg := validateImportWatchFolder(c, &folder); msg != "" {
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	if err := database.DB.Model(&folder).
+		Select("name", "path", "layout", "production_line_id", "vehicle_model_id", "enabled", "stable_seconds").
+		Updates(&folder).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新监视目录失败"})
+		return
+	}
+	c.JSON(http.StatusOK, folder)
+}
+
+// DeleteImportWatchFolder 同时删除文件处理记录；目录中的文件和已导入的程序保持不变。
+func DeleteImportWatchFolder(c *gin.Context) {
+	folder, ok := loadImportWatchFolder(c)
+	if !ok {
+		return
+	}
+	tx := database.DB.Begin()
+	if err := tx.Where("folder_id = ?", folder.ID).Delete(&models.ImportWatchFile{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除监视目录失败"})
+		return
+	}
+	if err := tx.Delete(&folder).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除监视目录失败"})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除监视目录失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "监视目录已删除"})
+}
+
+// ScanImportWatchFolder 立即扫描一次目录，不受启用状态限制，返回本次扫描汇总。
+func ScanImportWatchFolder(c *gin.Context) {
+	folder, ok := loadImportWatchFolder(c)
+	if !ok {
+		return
+	}
+	result, err := scanImportWatchFolder(folder, time.Now())
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GetImportWatchFiles 返回目录的文件处理记录，可按 status 过滤，例如查看被隔离的文件。
+func GetImportWatchFiles(c *gin.Context) {
+	folder, ok := loadImportWatchFolder(c)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "200"))
+	if limit < 1 || limit > 1000 {
+		limit = 200
+	}
+	query := database.DB.Where("folder_id = ?", folder.ID)
+	if status := strings.TrimSpace(c.Query("status")); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var files []models.ImportWatchFile
+	if err := query.Order("updated_at DESC, id DESC").Limit(limit).Find(&files).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"crane-system/config"
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupImportWatchTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	{
+		api.POST("/import-watch-folders", CreateImportWatchFolder)
+		api.PUT("/import-watch-folders/:id", UpdateImportWatchFolder)
+		api.POST("/import-watch-folders/:id/scan", ScanImportWatchFolder)
+		api.GET("/import-watch-folders/:id/files", GetImportWatchFiles)
+	}
+	return r
+}
+
+func writeImportWatchTestFile(t *testing.T, root, rel, content string, modTime time.Time) {
+	t.Helper()
+	fullPath := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(fullPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := os.Chtimes(fullPath, modTime, modTime); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+}
+
+func TestImportWatchFolderImportsChangesAndQuarantinesUnmatchedFiles(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	adminToken, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupImportWatchTestRouter()
+	useBatchImportTestStorage(t)
+
+	root := t.TempDir()
+	original := config.AppConfig.ImportWatch
+	config.AppConfig.ImportWatch.Roots = []string{root}
+	t.Cleanup(func() { config.AppConfig.ImportWatch = original })
+	watchDir := filepath.Join(root, "line1")
+	if err := os.MkdirAll(watchDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/import-watch-folders", adminToken, map[string]any{
+		"name": "外部目录", "path": t.TempDir(), "production_line_id": line.ID,
+	})
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("outside roots: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/import-watch-folders", adminToken, map[string]any{
+		"name": "一线", "path": watchDir, "production_line_id": line.ID, "stable_seconds": 60,
+	})
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create folder: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	folder := decodeProductionLineCustomFieldResponse[models.ImportWatchFolder](t, resp)
+	if !folder.Enabled || folder.OwnerID == 0 {
+		t.Fatalf("folder = %+v", folder)
+	}
+	scan := func() importWatchScanResult {
+		t.Helper()
+		resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, fmt.Sprintf("/api/import-watch-folders/%d/scan", folder.ID), adminToken, nil)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("scan: status = %d, body = %s", resp.Code, resp.Body.String())
+		}
+		return decodeProductionLineCustomFieldResponse[importWatchScanResult](t, resp)
+	}
+
+	// 新程序目录和无法匹配布局的散落文件
+	past := time.Now().Add(-time.Hour)
+	writeImportWatchTestFile(t, watchDir, "WS/P1/a.nc", "one", past)
+	writeImportWatchTestFile(t, watchDir, "loose.nc", "x", past)
+	result := scan()
+	if result.Programs != 1 || result.Quarantined != 1 || result.ImportID == nil || result.Summary[batchPlanCreate] != 1 {
+		t.Fatalf("first scan = %+v", result)
+	}
+	var program models.Program
+	if err := database.DB.Where("name = ? AND production_line_id = ?", "P1", line.ID).First(&program).Error; err != nil || program.Version != "v1" {
+		t.Fatalf("imported program = %+v, err = %v", program, err)
+	}
+	if _, err := os.Stat(filepath.Join(watchDir, "loose.nc")); !os.IsNotExist(err) {
+		t.Fatalf("unmatched file not moved: %v", err)
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/import-watch-folders/%d/files?status=quarantined", folder.ID), adminToken, nil)
+	quarantined := decodeProductionLineCustomFieldResponse[[]models.ImportWatchFile](t, resp)
+	if len(quarantined) != 1 || quarantined[0].RelPath != "loose.nc" {
+		t.Fatalf("quarantined files = %+v", quarantined)
+	}
+	if _, err := os.Stat(filepath.Join(watchDir, filepath.FromSlash(quarantined[0].QuarantinePath))); err != nil {
+		t.Fatalf("quarantined file missing: %v", err)
+	}
+
+	// 没有变化时不再导入
+	if result := scan(); result.Programs != 0 || result.ImportID != nil {
+		t.Fatalf("idle scan = %+v", result)
+	}
+
+	// 修改后的文件导入为新版本；仍在写入的新程序留待下次扫描
+	writeImportWatchTestFile(t, watchDir, "WS/P1/a.nc", "second", past.Add(time.Minute))
+	writeImportWatchTestFile(t, watchDir, "WS/P2/b.nc", "fresh", time.Now())
+	result = scan()
+	if result.Programs != 1 || result.Pending != 1 || result.Summary[batchPlanNewVersion] != 1 {
+		t.Fatalf("change scan = %+v", result)
+	}
+	if err := database.DB.First(&program, program.ID).Error; err != nil || program.Version != "v2" {
+		t.Fatalf("program after change = %+v, err = %v", program, err)
+	}
+	var stored models.ImportWatchFolder
+	database.DB.First(&stored, folder.ID)
+	if stored.LastStatus != "imported" || stored.LastImportID == nil || *stored.LastImportID != *result.ImportID {
+		t.Fatalf("folder after scan = %+v", stored)
+	}
+}
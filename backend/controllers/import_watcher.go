@@ -0,0 +1,424 @@
+package controllers
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"crane-system/config"
+	"crane-system/database"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// importWatchQuarantineDir 是监视目录下存放无法匹配文件的子目录，扫描时跳过。
+const importWatchQuarantineDir = "_quarantine"
+
+const (
+	importWatchFileImported    = "imported"
+	importWatchFileUnchanged   = "unchanged"
+	importWatchFileFailed      = "failed"
+	importWatchFileQuarantined = "quarantined"
+)
+
+var importWatchLocks sync.Map // folderID -> *sync.Mutex，同一目录不并发扫描
+
+// importWatchScanResult 是一次扫描的汇总。
+type importWatchScanResult struct {
+	Scanned     int            `json:"scanned"`
+	Pending     int            `json:"pending"` // 尚未静置、留待下次扫描的文件
+	Programs    int            `json:"programs"`
+	Quarantined int            `json:"quarantined"`
+	ImportID    *uint          `json:"import_id,omitempty"`
+	Summary     map[string]int `json:"summary,omitempty"`
+}
+
+type importWatchDiskFile struct {
+	rel     string
+	size    int64
+	modTime time.Time
+	changed bool
+	pending bool
+}
+
+// StartImportWatcher 启动监视目录的后台扫描；未配置 IMPORT_WATCH_ROOTS 时不启用。
+func StartImportWatcher() func() {
+	if len(config.AppConfig.ImportWatch.Roots) == 0 {
+		return func() {}
+	}
+	interval := config.AppConfig.ImportWatch.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				scanEnabledImportWatchFolders(now)
+			}
+		}
+	}()
+	return cancel
+}
+
+func scanEnabledImportWatchFolders(now time.Time) {
+	var folders []models.ImportWatchFolder
+	if err := database.DB.Where("enabled = ?", true).Find(&folders).Error; err != nil {
+		slog.Error("查询监视目录失败", "error", err)
+		return
+	}
+	for _, folder := range folders {
+		if _, err := scanImportWatchFolder(folder, now); err != nil {
+			slog.Warn("监视目录扫描失败", "folder_id", folder.ID, "error", err)
+		}
+	}
+}
+
+// resolveImportWatchPath 返回监视目录的绝对路径，目录必须存在且位于 IMPORT_WATCH_ROOTS 之下。
+func resolveImportWatchPath(dir string) (string, error) {
+	roots := config.AppConfig.ImportWatch.Roots
+	if len(roots) == 0 {
+		return "", errors.New("未配置 IMPORT_WATCH_ROOTS，目录监视导入未启用")
+	}
+	absolute, err := filepath.Abs(strings.TrimSpace(dir))
+	if err != nil || strings.TrimSpace(dir) == "" {
+		return "", errors.New("监视目录无效")
+	}
+	allowed := false
+	for _, root := range roots {
+		rootAbsolute, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(rootAbsolute, absolute)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", errors.New("监视目录必须位于 IMPORT_WATCH_ROOTS 之下")
+	}
+	if info, err := os.Stat(absolute); err != nil || !info.IsDir() {
+		return "", errors.New("监视目录不存在或不是目录")
+	}
+	return absolute, nil
+}
+
+// scanImportWatchFolder 扫描一次目录并导入变化的程序，结果记录在目录的 last_* 字段上。
+func scanImportWatchFolder(folder models.ImportWatchFolder, now time.Time) (importWatchScanResult, error) {
+	lockValue, _ := importWatchLocks.LoadOrStore(folder.ID, &sync.Mutex{})
+	lock := lockValue.(*sync.Mutex)
+	if !lock.TryLock() {
+		return importWatchScanResult{}, errors.New("目录正在扫描")
+	}
+	defer lock.Unlock()
+
+	result, err := runImportWatchScan(folder, now)
+	updates := map[string]any{"last_scan_at": now, "last_status": "idle", "last_error": ""}
+	switch {
+	case err != nil:
+		updates["last_status"] = "error"
+		updates["last_error"] = err.Error()
+	case result.ImportID != nil:
+		updates["last_status"] = "imported"
+		updates["last_import_id"] = *result.ImportID
+	}
+	database.DB.Model(&models.ImportWatchFolder{}).Where("id = ?", folder.ID).Updates(updates)
+	return result, err
+}
+
+func runImportWatchScan(folder models.ImportWatchFolder, now time.Time) (importWatchScanResult, error) {
+	var result importWatchScanResult
+	root, err := resolveImportWatchPath(folder.Path)
+	if err != nil {
+		return result, err
+	}
+
+	var owner models.User
+	if err := database.DB.First(&owner, folder.OwnerID).Error; err != nil {
+		return result, errors.New("监视目录创建人不存在")
+	}
+	if owner.Status != "active" {
+		return result, errors.New("监视目录创建人已被禁用")
+	}
+	request, _ := http.NewRequest(http.MethodPost, "/api/programs/batch-import", nil)
+	ctx, _ := newUserContext(owner, request)
+	if !currentUserHasPermission(ctx, "op:program_create") {
+		return result, errors.New("监视目录创建人没有新建程序权限")
+	}
+	if allowed, _, message := checkLineAction(ctx, folder.ProductionLineID, lineActionManage); !allowed {
+		return result, errors.New(message)
+	}
+	rules, err := loadBatchImportLayout(database.DB, folder.Layout)
+	if err != nil {
+		return result, err
+	}
+
+	var stateList []models.ImportWatchFile
+	if err := database.DB.Where("folder_id = ?", folder.ID).Find(&stateList).Error; err != nil {
+		return result, err
+	}
+	states := make(map[string]models.ImportWatchFile, len(stateList))
+	for _, state := range stateList {
+		states[state.RelPath] = state
+	}
+
+	files, err := walkImportWatchFolder(root, folder, states, now)
+	if err != nil {
+		return result, err
+	}
+	result.Scanned = len(files)
+	entries := make([]batchArchiveEntry, 0, len(files))
+	for _, file := range files {
+		if file.pending {
+			result.Pending++
+		}
+		entries = append(entries, batchArchiveEntry{Path: file.rel, Size: file.size})
+	}
+
+	quarantine := func(file importWatchDiskFile, reason string) {
+		if err := quarantineImportWatchFile(root, folder.ID, states, file, reason, now); err != nil {
+			slog.Warn("隔离监视目录文件失败", "folder_id", folder.ID, "path", file.rel, "error", err)
+			return
+		}
+		result.Quarantined++
+	}
+
+	preview := buildBatchUploadPreview(database.DB, rules, entries)
+	for _, skipped := range preview.Skipped {
+		if file := files[skipped.Path]; !file.pending {
+			quarantine(file, skipped.Reason)
+		}
+	}
+
+	// 只导入有文件变化且全部文件都已静置的程序；导入时带上程序的全部文件，形成完整的新版本
+	changed := batchUploadPreview{Layout: preview.Layout}
+	for _, ws := range preview.Workstations {
+		selected := batchUploadWorkstation{Name: ws.Name, SuggestedProductionLineID: ws.SuggestedProductionLineID, SuggestedVehicleModelID: ws.SuggestedVehicleModelID}
+		for _, prog := range ws.Programs {
+			anyChanged, anyPending := false, false
+			for _, file := range prog.Files {
+				anyChanged = anyChanged || files[file.Path].changed
+				anyPending = anyPending || files[file.Path].pending
+			}
+			if anyChanged && !anyPending {
+				selected.Programs = append(selected.Programs, prog)
+				changed.TotalPrograms++
+				changed.TotalFiles += len(prog.Files)
+			}
+		}
+		if len(selected.Programs) > 0 {
+			changed.Workstations = append(changed.Workstations, selected)
+		}
+	}
+	result.Programs = changed.TotalPrograms
+	if changed.TotalPrograms == 0 {
+		return result, nil
+	}
+
+	tempDir, err := os.MkdirTemp("", "program-watch-*")
+	if err != nil {
+		return result, err
+	}
+	defer os.RemoveAll(tempDir)
+	if err := writeImportWatchArchive(root, filepath.Join(tempDir, "batch.zip"), changed); err != nil {
+		return result, err
+	}
+
+	mappingByName := make(map[string]batchImportMapping, len(changed.Workstations))
+	for _, ws := range changed.Workstations {
+		mappingByName[ws.Name] = importWatchMapping(ctx, folder, ws)
+	}
+	zr, archiveFiles, err := openBatchImportArchive(tempDir)
+	if err != nil {
+		return result, err
+	}
+	plan, err := planBatchImport(ctx, changed, archiveFiles, mappingByName)
+	_ = zr.Close()
+	if err != nil {
+		return result, err
+	}
+	for _, item := range plan {
+		if item.Action != batchPlanConflict {
+			continue
+		}
+		for _, file := range item.source.Files {
+			quarantine(files[file.Path], item.Reason)
+		}
+	}
+	result.Summary = summarizeBatchImportPlan(plan)
+
+	record, err := createBatchImportRecord(owner.ID, rules.layout.Code, plan, tempDir)
+	if err != nil {
+		return result, err
+	}
+	result.ImportID = &record.ID
+	if _, err := runJobInline(jobTypeBatchImport, owner.ID, batchImportJobPayload{ImportID: record.ID}, len(plan)); err != nil {
+		failPendingBatchImportItems(record.ID, "提交导入任务失败")
+	}
+
+	var finished []models.BatchImportTaskItem
+	if err := database.DB.Where("task_id = ?", record.ID).Order("item_no ASC").Find(&finished).Error; err != nil {
+		return result, err
+	}
+	for i, item := range plan {
+		if item.Action == batchPlanConflict || i >= len(finished) {
+			continue
+		}
+		status, reason := importWatchFileImported, ""
+		switch {
+		case finished[i].Status != batchImportItemSuccess:
+			status, reason = importWatchFileFailed, finished[i].Error
+		case item.Action == batchPlanUnchanged:
+			status = importWatchFileUnchanged
+		}
+		for _, file := range item.source.Files {
+			disk := files[file.Path]
+			state := states[disk.rel]
+			state.FolderID, state.RelPath, state.Size, state.ModTime = folder.ID, disk.rel, disk.size, disk.modTime
+			state.Status, state.Reason, state.ImportID, state.QuarantinePath = status, reason, &record.ID, ""
+			if err := database.DB.Save(&state).Error; err != nil {
+				slog.Warn("保存监视文件状态失败", "folder_id", folder.ID, "path", disk.rel, "error", err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// walkImportWatchFolder 列出目录中的普通文件，跳过隔离目录和以 . 开头的临时文件。
+func walkImportWatchFolder(root string, folder models.ImportWatchFolder, states map[string]models.ImportWatchFile, now time.Time) (map[string]importWatchDiskFile, error) {
+	stable := time.Duration(folder.StableSeconds) * time.Second
+	files := map[string]importWatchDiskFile{}
+	err := filepath.WalkDir(root, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if fullPath == root {
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			if filepath.Dir(fullPath) == root && entry.Name() == importWatchQuarantineDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, fullPath)
+		if err != nil {
+			return err
+		}
+		file := importWatchDiskFile{rel: filepath.ToSlash(rel), size: info.Size(), modTime: info.ModTime().Truncate(time.Second)}
+		state, known := states[file.rel]
+		file.changed = !known || state.Status == importWatchFileQuarantined ||
+			state.Size != file.size || !state.ModTime.Truncate(time.Second).Equal(file.modTime)
+		file.pending = now.Sub(info.ModTime()) < stable
+		files[file.rel] = file
+		return nil
+	})
+	return files, err
+}
+
+// quarantineImportWatchFile 把文件移入 _quarantine/<扫描时间>/ 下的同名路径并记录原因。
+func quarantineImportWatchFile(root string, folderID uint, states map[string]models.ImportWatchFile, file importWatchDiskFile, reason string, now time.Time) error {
+	target := path.Join(importWatchQuarantineDir, now.Format("20060102_150405"), file.rel)
+	targetPath := filepath.Join(root, filepath.FromSlash(target))
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(root, filepath.FromSlash(file.rel)), targetPath); err != nil {
+		return err
+	}
+	state := states[file.rel]
+	state.FolderID, state.RelPath, state.Size, state.ModTime = folderID, file.rel, file.size, file.modTime
+	state.Status, state.Reason, state.ImportID, state.QuarantinePath = importWatchFileQuarantined, reason, nil, target
+	if err := database.DB.Save(&state).Error; err != nil {
+		return err
+	}
+	states[file.rel] = state
+	return nil
+}
+
+// writeImportWatchArchive 把待导入程序的文件打包成与批量上传相同结构的压缩包。
+func writeImportWatchArchive(root, zipPath string, preview batchUploadPreview) error {
+	out, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(out)
+	for _, ws := range preview.Workstations {
+		for _, prog := range ws.Programs {
+			for _, file := range prog.Files {
+				if err := addImportWatchArchiveFile(zw, root, file.Path); err != nil {
+					_ = zw.Close()
+					_ = out.Close()
+					return err
+				}
+			}
+		}
+	}
+	if err := zw.Close(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func addImportWatchArchiveFile(zw *zip.Writer, root, rel string) error {
+	reader, err := os.Open(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	writer, err := zw.Create(rel)
+	if err != nil {
+		return err
+	}
+	return copyWithLimit(writer, reader, maxUploadSize())
+}
+
+// importWatchMapping 优先使用布局目录匹配到的产线和车型；匹配到的产线无管理权限时不映射，程序记为冲突。
+func importWatchMapping(c *gin.Context, folder models.ImportWatchFolder, ws batchUploadWorkstation) batchImportMapping {
+	mapping := batchImportMapping{WorkstationName: ws.Name, VehicleModelID: folder.VehicleModelID}
+	lineID := folder.ProductionLineID
+	if ws.SuggestedProductionLineID != nil {
+		lineID = *ws.SuggestedProductionLineID
+	}
+	if ws.SuggestedVehicleModelID != nil {
+		mapping.VehicleModelID = ws.SuggestedVehicleModelID
+	}
+	if lineID != folder.ProductionLineID {
+		if allowed, _, _ := checkLineAction(c, lineID, lineActionManage); !allowed {
+			return mapping
+		}
+	}
+	mapping.ProductionLineID = &lineID
+	return mapping
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"crane-system/database"
+	"crane-system/models"
+	"crane-system/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loadAccessibleJob 加载当前用户提交的任务；管理员可以查看全部。
+func loadAccessibleJob(c *gin.Context) (models.Job, bool) {
+	var job models.Job
+	jobID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "任务ID格式错误"})
+		return job, false
+	}
+	if err := database.DB.Preload("Owner").First(&job, jobID).Error; err != nil ||
+		jobExpired(job, time.Now()) ||
+		(job.OwnerID != currentUserID(c) && !services.IsSystemAdminRole(currentAccountRole(c))) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+		return job, false
+	}
+	return job, true
+}
+
+// GetJobs 返回当前用户最近的后台任务，可按 type、status 过滤；管理员返回全部。
+// 列表不含任务结果，结果通过详情接口获取。
+func GetJobs(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+	query := database.DB.Preload("Owner").Omit("result").Order("id DESC").Limit(limit)
+	if !services.IsSystemAdminRole(currentAccountRole(c)) {
+		query = query.Where("owner_id = ?", currentUserID(c))
+	}
+	if jobType := strings.TrimSpace(c.Query("type")); jobType != "" {
+		query = query.Where("type = ?", jobType)
+	}
+	if status := strings.TrimSpace(c.Query("status")); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var jobs []models.Job
+	if err := query.Find(&jobs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+func GetJob(c *gin.Context) {
+	job, ok := loadAccessibleJob(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelJob 排队中的任务直接取消；执行中的任务在处理完当前条目后停止。
+func CancelJob(c *gin.Context) {
+	job, ok := loadAccessibleJob(c)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	canceled := database.DB.Model(&models.Job{}).
+		Where("id = ? AND status = ?", job.ID, jobStatusQueued).
+		Updates(map[string]any{"status": jobStatusCanceled, "cancel_requested": true, "error_message": "任务已取消", "finished_at": now})
+	if canceled.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "取消任务失败"})
+		return
+	}
+	if canceled.RowsAffected == 0 {
+		requested := database.DB.Model(&models.Job{}).
+			Where("id = ? AND status = ?", job.ID, jobStatusRunning).
+			Update("cancel_requested", true)
+		if requested.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "取消任务失败"})
+			return
+		}
+		if requested.RowsAffected == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "任务已结束"})
+			return
+		}
+	}
+	jobChanges.notify()
+	if err := database.DB.Preload("Owner").First(&job, job.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// GetTaskStatus 以批量导入轮询使用的格式返回任务进度。
+func GetTaskStatus(c *gin.Context) {
+	taskID, err := parseUintParam(c.Param("task_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "任务ID格式错误"})
+		return
+	}
+	var job models.Job
+	if err := database.DB.First(&job, taskID).Error; err != nil || jobExpired(job, time.Now()) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+		return
+	}
+	if job.OwnerID != currentUserID(c) && currentAccountRole(c) != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权查看该任务"})
+		return
+	}
+	c.JSON(http.StatusOK, jobTaskStatus(job))
+}
@@ -0,0 +1,445 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"crane-system/config"
+	"crane-system/database"
+	"crane-system/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	jobStatusQueued    = "queued"
+	jobStatusRunning   = "running"
+	jobStatusCompleted = "completed"
+	jobStatusFailed    = "failed"
+	jobStatusCanceled  = "canceled"
+)
+
+const (
+	jobTypeBatchImport           = "batch_import"
+	jobTypeProgramExcelImport    = "program_excel_import"
+	jobTypeFileMigration         = "file_migration"
+	jobTypeFileMigrationRollback = "file_migration_rollback"
+	jobTypeBackup                = "backup"
+)
+
+// jobRetention 是已结束任务的保留期，过期后任务记录被清理。
+const jobRetention = 7 * 24 * time.Hour
+
+var (
+	jobLeaseDuration     = 2 * time.Minute
+	jobHeartbeatInterval = 15 * time.Second
+	jobPollInterval      = 2 * time.Second
+	jobRetryBackoff      = 30 * time.Second
+	jobWake              = make(chan struct{}, 1)
+)
+
+// jobHandler 描述一种任务类型。maxAttempts 同时限制可重试错误的重试次数和执行实例退出后的接管次数；
+// 接管会重新执行 run，因此只有能从中断处继续的任务才应大于 1。
+type jobHandler struct {
+	maxAttempts int
+	run         func(run *jobRun) error
+	abandon     func(job models.Job) // 执行实例退出且不再接管时调用，可为空
+}
+
+var jobHandlers map[string]jobHandler
+
+func init() {
+	jobHandlers = map[string]jobHandler{
+		jobTypeBatchImport:           {maxAttempts: 3, run: runBatchImportJob, abandon: abandonBatchImportJob},
+		jobTypeProgramExcelImport:    {maxAttempts: 1, run: runProgramExcelImportJob},
+		jobTypeFileMigration:         {maxAttempts: 1, run: runFileMigrationJob},
+		jobTypeFileMigrationRollback: {maxAttempts: 1, run: runFileMigrationRollbackJob},
+		jobTypeBackup:                {maxAttempts: 3, run: runBackupJob},
+	}
+}
+
+// retryableJobError 表示稍后可能成功的失败，未用完重试次数时任务重新排队；其余错误直接记为失败。
+type retryableJobError struct {
+	err error
+}
+
+func (e retryableJobError) Error() string { return e.err.Error() }
+
+func (e retryableJobError) Unwrap() error { return e.err }
+
+var errJobItemsFailed = errors.New("全部条目处理失败")
+
+// jobItemsResult 是逐条处理类任务写入 Job.Result 的内容。
+type jobItemsResult struct {
+	Items []batchTaskItemResult `json:"items"`
+}
+
+// jobRun 是执行中的任务。job 只在执行 goroutine 中修改，ctx 在收到取消请求后结束。
+type jobRun struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	job    models.Job
+	items  []batchTaskItemResult
+}
+
+func newJob(jobType string, ownerID uint, payload any, total int) (models.Job, error) {
+	handler, ok := jobHandlers[jobType]
+	if !ok {
+		return models.Job{}, fmt.Errorf("未知的任务类型 %s", jobType)
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return models.Job{}, err
+	}
+	cleanupExpiredJobs(time.Now())
+	return models.Job{
+		Type:        jobType,
+		Status:      jobStatusQueued,
+		Payload:     raw,
+		Total:       total,
+		OwnerID:     ownerID,
+		MaxAttempts: handler.maxAttempts,
+		RunAfter:    time.Now(),
+	}, nil
+}
+
+// enqueueJob 创建排队中的任务并唤醒本实例的执行者，任意实例的执行者都可以领取。
+func enqueueJob(jobType string, ownerID uint, payload any, total int) (models.Job, error) {
+	job, err := newJob(jobType, ownerID, payload, total)
+	if err != nil {
+		return job, err
+	}
+	if err := database.DB.Create(&job).Error; err != nil {
+		return job, err
+	}
+	jobChanges.notify()
+	select {
+	case jobWake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// runJobInline 创建任务并在当前 goroutine 中执行，供需要同步拿到结果的后台流程使用。
+func runJobInline(jobType string, ownerID uint, payload any, total int) (models.Job, error) {
+	job, err := newJob(jobType, ownerID, payload, total)
+	if err != nil {
+		return job, err
+	}
+	now := time.Now()
+	lockedUntil := now.Add(jobLeaseDuration)
+	job.Status = jobStatusRunning
+	job.LockedBy = jobWorkerID("inline")
+	job.LockedUntil = &lockedUntil
+	job.Attempts = 1
+	job.StartedAt = &now
+	if err := database.DB.Create(&job).Error; err != nil {
+		return job, err
+	}
+	return executeJob(job), nil
+}
+
+func jobWorkerID(name string) string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), name)
+}
+
+// StartJobWorkers 启动本实例的任务执行者；JOB_WORKERS 为 0 时本实例只提交任务。
+func StartJobWorkers() func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < config.AppConfig.Jobs.Workers; i++ {
+		workerID := jobWorkerID(strconv.Itoa(i + 1))
+		go func() {
+			ticker := time.NewTicker(jobPollInterval)
+			defer ticker.Stop()
+			for {
+				runQueuedJobs(ctx, workerID)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				case <-jobWake:
+				}
+			}
+		}()
+	}
+	return cancel
+}
+
+// runQueuedJobs 依次领取并执行可运行的任务，直到队列为空或 ctx 结束。
+func runQueuedJobs(ctx context.Context, workerID string) {
+	for ctx.Err() == nil {
+		job, ok := claimNextJob(workerID, time.Now())
+		if !ok {
+			return
+		}
+		executeJob(job)
+	}
+}
+
+// runnableJobs 限定到已到执行时间的排队任务，以及租约过期、仍可接管的执行中任务。
+func runnableJobs(tx *gorm.DB, now time.Time) *gorm.DB {
+	return tx.Where("((status = ? AND run_after <= ?) OR (status = ? AND locked_until < ? AND attempts < max_attempts))",
+		jobStatusQueued, now, jobStatusRunning, now)
+}
+
+// claimNextJob 用条件更新抢占任务，多个实例同时领取时只有一个成功。
+func claimNextJob(workerID string, now time.Time) (models.Job, bool) {
+	abandonStaleJobs(now)
+
+	var candidates []models.Job
+	if err := runnableJobs(database.DB, now).Order("id ASC").Limit(5).Find(&candidates).Error; err != nil {
+		slog.Error("查询待执行任务失败", "error", err)
+		return models.Job{}, false
+	}
+	for _, candidate := range candidates {
+		claim := runnableJobs(database.DB.Model(&models.Job{}).Where("id = ?", candidate.ID), now).Updates(map[string]any{
+			"status":       jobStatusRunning,
+			"locked_by":    workerID,
+			"locked_until": now.Add(jobLeaseDuration),
+			"attempts":     gorm.Expr("attempts + 1"),
+			"started_at":   now,
+		})
+		if claim.Error != nil || claim.RowsAffected == 0 {
+			continue
+		}
+		jobChanges.notify()
+		var job models.Job
+		if err := database.DB.First(&job, candidate.ID).Error; err != nil {
+			return job, false
+		}
+		return job, true
+	}
+	return models.Job{}, false
+}
+
+// abandonStaleJobs 把执行实例已退出且不能再接管的任务记为失败。
+func abandonStaleJobs(now time.Time) {
+	var stale []models.Job
+	if err := database.DB.Where("status = ? AND locked_until < ? AND attempts >= max_attempts", jobStatusRunning, now).Find(&stale).Error; err != nil {
+		return
+	}
+	for _, job := range stale {
+		result := database.DB.Model(&models.Job{}).
+			Where("id = ? AND status = ? AND locked_until < ?", job.ID, jobStatusRunning, now).
+			Updates(map[string]any{
+				"status":        jobStatusFailed,
+				"error_message": "任务执行中断：执行实例已退出",
+				"finished_at":   now,
+				"locked_by":     "",
+				"locked_until":  nil,
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		jobChanges.notify()
+		if handler, ok := jobHandlers[job.Type]; ok && handler.abandon != nil {
+			handler.abandon(job)
+		}
+	}
+}
+
+// cleanupExpiredJobs 删除超过保留期的已结束任务。
+func cleanupExpiredJobs(now time.Time) {
+	_ = database.DB.Where("status IN ? AND finished_at < ?",
+		[]string{jobStatusCompleted, jobStatusFailed, jobStatusCanceled}, now.Add(-jobRetention)).
+		Delete(&models.Job{}).Error
+}
+
+func jobExpired(job models.Job, now time.Time) bool {
+	return job.FinishedAt != nil && job.FinishedAt.Add(jobRetention).Before(now)
+}
+
+// executeJob 执行已领取的任务并写回最终状态。
+func executeJob(job models.Job) models.Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	run := &jobRun{ctx: ctx, cancel: cancel, job: job}
+	var previous jobItemsResult
+	if len(job.Result) > 0 && json.Unmarshal(job.Result, &previous) == nil {
+		run.items = previous.Items
+	}
+
+	handler, ok := jobHandlers[job.Type]
+	if !ok {
+		run.finish(fmt.Errorf("未知的任务类型 %s", job.Type))
+		return run.job
+	}
+	stop := make(chan struct{})
+	go run.heartbeat(stop)
+	err := func() (err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = fmt.Errorf("任务执行异常: %v", recovered)
+			}
+		}()
+		return handler.run(run)
+	}()
+	close(stop)
+	run.finish(err)
+	return run.job
+}
+
+// heartbeat 定期续租并检查取消请求，覆盖长时间没有进度更新的任务。
+func (r *jobRun) heartbeat(stop <-chan struct{}) {
+	jobID, workerID := r.job.ID, r.job.LockedBy
+	ticker := time.NewTicker(jobHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			database.DB.Model(&models.Job{}).Where("id = ? AND locked_by = ?", jobID, workerID).
+				Update("locked_until", time.Now().Add(jobLeaseDuration))
+			r.checkCancel(jobID)
+		}
+	}
+}
+
+func (r *jobRun) checkCancel(jobID uint) {
+	var job models.Job
+	if err := database.DB.Select("cancel_requested").First(&job, jobID).Error; err == nil && job.CancelRequested {
+		r.cancel()
+	}
+}
+
+// update 修改任务进度并写回数据库，同时续租。租约已被接管或任务已不在执行中时不写入，
+// 并取消本次执行，让被取代的执行者尽快停止。
+func (r *jobRun) update(mutator func(job *models.Job)) {
+	mutator(&r.job)
+	lockedUntil := time.Now().Add(jobLeaseDuration)
+	r.job.LockedUntil = &lockedUntil
+	result := database.DB.Model(&r.job).
+		Where("locked_by = ? AND status = ?", r.job.LockedBy, jobStatusRunning).
+		Select("result", "error_message", "total", "processed", "success", "failed", "progress", "current_item", "locked_until").
+		Updates(&r.job)
+	if result.Error != nil {
+		slog.Warn("保存任务进度失败", "job_id", r.job.ID, "error", result.Error)
+	} else if result.RowsAffected == 0 {
+		slog.Warn("任务租约已失效，停止执行", "job_id", r.job.ID, "worker", r.job.LockedBy)
+		r.cancel()
+		return
+	}
+	jobChanges.notify()
+	r.checkCancel(r.job.ID)
+}
+
+func (r *jobRun) setCurrent(item string) {
+	r.update(func(job *models.Job) {
+		job.CurrentItem = item
+	})
+}
+
+// recordItem 记录一条处理结果并推进进度，label 作为首条错误信息的前缀。
+func (r *jobRun) recordItem(label string, result batchTaskItemResult) {
+	r.items = append(r.items, result)
+	r.update(func(job *models.Job) {
+		if result.Error != "" {
+			job.Failed++
+			if job.ErrorMessage == "" {
+				job.ErrorMessage = fmt.Sprintf("%s: %s", label, result.Error)
+			}
+		} else {
+			job.Success++
+		}
+		job.Processed++
+		job.Progress = float64(job.Processed) * 100 / float64(max(job.Total, 1))
+		job.Result, _ = json.Marshal(jobItemsResult{Items: r.items})
+	})
+}
+
+// setResult 写入任务结果，供非逐条处理的任务使用。
+func (r *jobRun) setResult(result any) {
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	r.update(func(job *models.Job) {
+		job.Result = raw
+	})
+}
+
+// finish 写回最终状态。只有仍持有租约的执行者可以写入；租约已被接管或任务已被标记中断时放弃写入，
+// 以数据库中的状态为准。
+func (r *jobRun) finish(err error) {
+	now := time.Now()
+	job := &r.job
+	workerID := job.LockedBy
+	job.CurrentItem = ""
+	job.LockedBy = ""
+	job.LockedUntil = nil
+	job.FinishedAt = &now
+
+	var retryable retryableJobError
+	switch {
+	case err == nil:
+		job.Status = jobStatusCompleted
+		job.Progress = 100
+	case r.ctx.Err() != nil:
+		job.Status = jobStatusCanceled
+		if job.ErrorMessage == "" {
+			job.ErrorMessage = "任务已取消"
+		}
+	case errors.As(err, &retryable) && job.Attempts < job.MaxAttempts:
+		job.Status = jobStatusQueued
+		job.ErrorMessage = err.Error()
+		job.RunAfter = now.Add(time.Duration(job.Attempts) * jobRetryBackoff)
+		job.FinishedAt = nil
+	default:
+		job.Status = jobStatusFailed
+		if job.ErrorMessage == "" {
+			job.ErrorMessage = err.Error()
+		}
+	}
+	result := database.DB.Model(job).
+		Where("locked_by = ? AND status = ?", workerID, jobStatusRunning).
+		Select("status", "result", "error_message", "processed", "success", "failed", "progress", "current_item",
+			"run_after", "locked_by", "locked_until", "finished_at").
+		Updates(job)
+	if result.Error != nil {
+		slog.Error("保存任务状态失败", "job_id", job.ID, "error", result.Error)
+	} else if result.RowsAffected == 0 {
+		slog.Warn("任务租约已失效，放弃写入最终状态", "job_id", job.ID, "worker", workerID)
+		var current models.Job
+		if err := database.DB.First(&current, job.ID).Error; err == nil {
+			r.job = current
+		}
+	}
+	jobChanges.notify()
+}
+
+// jobTaskStatus 把任务转换为 /tasks/:task_id/status 的兼容格式。
+func jobTaskStatus(job models.Job) batchImportTaskStatus {
+	status := batchImportTaskStatus{
+		Status:       "processing",
+		OwnerUser:    job.OwnerID,
+		Total:        job.Total,
+		Processed:    job.Processed,
+		Success:      job.Success,
+		Failed:       job.Failed,
+		Progress:     job.Progress,
+		CurrentItem:  job.CurrentItem,
+		ErrorMessage: job.ErrorMessage,
+	}
+	switch job.Status {
+	case jobStatusCompleted:
+		status.Status = "completed"
+	case jobStatusFailed, jobStatusCanceled:
+		status.Status = "failed"
+	}
+	var result jobItemsResult
+	if len(job.Result) > 0 && json.Unmarshal(job.Result, &result) == nil {
+		status.Items = result.Items
+	}
+	if job.FinishedAt != nil {
+		status.ExpiresAt = job.FinishedAt.Add(jobRetention)
+	}
+	return status
+}
@@ -0,0 +1,290 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupJobTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	{
+		api.GET("/jobs", GetJobs)
+		api.GET("/jobs/events", StreamJobs)
+		api.GET("/jobs/:id", GetJob)
+		api.GET("/jobs/:id/events", StreamJob)
+		api.POST("/jobs/:id/cancel", CancelJob)
+		api.POST("/batch/upload", BatchUploadPrograms)
+		api.POST("/batch/import", BatchImportPrograms)
+	}
+	return r
+}
+
+// useTestJobHandler 注册仅用于测试的任务类型。
+func useTestJobHandler(t *testing.T, jobType string, handler jobHandler) {
+	t.Helper()
+	jobHandlers[jobType] = handler
+	t.Cleanup(func() { delete(jobHandlers, jobType) })
+}
+
+func TestJobQueueClaimsRetriesAndRecoversStaleJobs(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	var abandoned []uint
+	attempts := 0
+	useTestJobHandler(t, "test_flaky", jobHandler{
+		maxAttempts: 2,
+		run: func(run *jobRun) error {
+			attempts++
+			return retryableJobError{err: errors.New("暂时不可用")}
+		},
+		abandon: func(job models.Job) { abandoned = append(abandoned, job.ID) },
+	})
+
+	job, err := enqueueJob("test_flaky", 1, map[string]string{"k": "v"}, 1)
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	// 多个执行者同时领取，只有一个成功
+	var wg sync.WaitGroup
+	claimed := make(chan models.Job, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if job, ok := claimNextJob(fmt.Sprintf("worker-%d", i), time.Now()); ok {
+				claimed <- job
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(claimed)
+	var winners []models.Job
+	for job := range claimed {
+		winners = append(winners, job)
+	}
+	if len(winners) != 1 || winners[0].Attempts != 1 || winners[0].Status != jobStatusRunning {
+		t.Fatalf("claimed = %+v", winners)
+	}
+
+	// 可重试错误重新排队，到期前不会被领取
+	finished := executeJob(winners[0])
+	if finished.Status != jobStatusQueued || !finished.RunAfter.After(time.Now()) || finished.ErrorMessage == "" {
+		t.Fatalf("after retryable error = %+v", finished)
+	}
+	if _, ok := claimNextJob("worker", time.Now()); ok {
+		t.Fatalf("job claimed before run_after")
+	}
+
+	// 执行实例退出：租约过期且仍有重试次数时被其他实例接管
+	retried, ok := claimNextJob("dead-worker", finished.RunAfter.Add(time.Second))
+	if !ok || retried.Attempts != 2 {
+		t.Fatalf("retry claim = %+v, ok = %v", retried, ok)
+	}
+	if _, ok := claimNextJob("worker", time.Now().Add(jobLeaseDuration+time.Minute)); ok {
+		t.Fatalf("stale job without remaining attempts was reclaimed")
+	}
+	var stored models.Job
+	database.DB.First(&stored, job.ID)
+	if stored.Status != jobStatusFailed || stored.FinishedAt == nil || len(abandoned) != 1 || abandoned[0] != job.ID {
+		t.Fatalf("abandoned job = %+v, hooks = %v", stored, abandoned)
+	}
+	if attempts != 1 {
+		t.Fatalf("handler ran %d times", attempts)
+	}
+}
+
+func TestJobCancellationAndVisibility(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	adminToken, _ := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupJobTestRouter()
+
+	other := models.User{Name: "Other", Password: "hashed", EmployeeID: "EMP-JOB-OTHER", Role: "user", Status: "active"}
+	if err := database.DB.Create(&other).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	otherToken := createUserTokenForTest(t, other.ID, other.Role)
+
+	var cancelID uint
+	useTestJobHandler(t, "test_loop", jobHandler{
+		maxAttempts: 1,
+		run: func(run *jobRun) error {
+			for i := 0; ; i++ {
+				if err := run.ctx.Err(); err != nil {
+					return err
+				}
+				if i == 1 {
+					// 第二条处理前收到取消请求
+					resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, fmt.Sprintf("/api/jobs/%d/cancel", cancelID), adminToken, nil)
+					if resp.Code != http.StatusOK {
+						t.Errorf("cancel running: status = %d, body = %s", resp.Code, resp.Body.String())
+					}
+				}
+				run.recordItem(fmt.Sprintf("第 %d 条", i+1), batchTaskItemResult{Row: i + 1, Key: "k"})
+			}
+		},
+	})
+
+	queued, _ := enqueueJob("test_loop", 1, nil, 10)
+	cancelID = queued.ID
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/jobs/%d", queued.ID), otherToken, nil)
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("other user detail: status = %d", resp.Code)
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, "/api/jobs", otherToken, nil)
+	if jobs := decodeProductionLineCustomFieldResponse[[]models.Job](t, resp); len(jobs) != 0 {
+		t.Fatalf("other user jobs = %+v", jobs)
+	}
+
+	runQueuedJobs(context.Background(), "test")
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/jobs/%d", queued.ID), adminToken, nil)
+	job := decodeProductionLineCustomFieldResponse[models.Job](t, resp)
+	if job.Status != jobStatusCanceled || job.Processed != 2 || job.FinishedAt == nil {
+		t.Fatalf("canceled running job = %+v", job)
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, fmt.Sprintf("/api/jobs/%d/cancel", queued.ID), adminToken, nil)
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("cancel finished: status = %d", resp.Code)
+	}
+
+	// 排队中的任务直接取消，不会再被领取
+	pending, _ := enqueueJob("test_loop", 1, nil, 1)
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, fmt.Sprintf("/api/jobs/%d/cancel", pending.ID), adminToken, nil)
+	if job := decodeProductionLineCustomFieldResponse[models.Job](t, resp); resp.Code != http.StatusOK || job.Status != jobStatusCanceled {
+		t.Fatalf("cancel queued: status = %d, job = %+v", resp.Code, job)
+	}
+	if _, ok := claimNextJob("worker", time.Now()); ok {
+		t.Fatalf("canceled job was claimed")
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, "/api/jobs?type=test_loop&status=canceled", adminToken, nil)
+	if jobs := decodeProductionLineCustomFieldResponse[[]models.Job](t, resp); len(jobs) != 2 || len(jobs[0].Result) != 0 {
+		t.Fatalf("admin jobs = %+v", jobs)
+	}
+}
+
+func TestBatchImportJobResumesAfterWorkerExit(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	adminToken, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupJobTestRouter()
+	useBatchImportTestStorage(t)
+
+	preview := uploadBatchImportZip(t, r, adminToken, "", map[string]string{"WS/A/a.nc": "a", "WS/B/b.nc": "b"})
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/batch/import", adminToken, map[string]any{
+		"preview_id": preview.PreviewID,
+		"mappings":   []map[string]any{{"workstation_name": "WS", "production_line_id": line.ID}},
+	})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("import: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	started := decodeProductionLineCustomFieldResponse[map[string]any](t, resp)
+	jobID := uint(started["task_id"].(float64))
+	importID := uint(started["import_id"].(float64))
+
+	// 模拟执行实例处理完第一条后退出：第一条已成功，租约随后过期
+	job, ok := claimNextJob("dead-worker", time.Now())
+	if !ok || job.ID != jobID {
+		t.Fatalf("claim = %+v, ok = %v", job, ok)
+	}
+	var first models.BatchImportTaskItem
+	database.DB.Where("task_id = ? AND item_no = ?", importID, 1).First(&first)
+	database.DB.Model(&first).Updates(map[string]any{"status": batchImportItemSuccess})
+	database.DB.Model(&models.Job{}).Where("id = ?", jobID).
+		Updates(map[string]any{"locked_until": time.Now().Add(-time.Second), "processed": 1, "success": 1})
+
+	status := waitProgramExcelImportTask(t, int64(jobID))
+	if status.Status != "completed" || status.Processed != 2 || status.Success != 2 || len(status.Items) != 1 || status.Items[0].Key != "WS/B" {
+		t.Fatalf("resumed status = %+v", status)
+	}
+	var record models.BatchImportTask
+	database.DB.First(&record, importID)
+	if record.Status != "completed" || record.Success != 2 {
+		t.Fatalf("record = %+v", record)
+	}
+	database.DB.First(&job, jobID)
+	if job.Attempts != 2 {
+		t.Fatalf("job attempts = %d", job.Attempts)
+	}
+}
+
+func TestJobFinishDoesNotOverwriteTakenOverLease(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	var takeover func()
+	useTestJobHandler(t, "test_slow", jobHandler{
+		maxAttempts: 2,
+		run: func(run *jobRun) error {
+			takeover()
+			return nil
+		},
+	})
+
+	job, err := enqueueJob("test_slow", 1, map[string]string{}, 1)
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	claimed, ok := claimNextJob("worker-a", time.Now())
+	if !ok {
+		t.Fatal("claim failed")
+	}
+	// 执行期间租约过期，被其他执行者接管
+	takeover = func() {
+		database.DB.Model(&models.Job{}).Where("id = ?", job.ID).Updates(map[string]any{"locked_by": "worker-b", "attempts": 2})
+	}
+
+	finished := executeJob(claimed)
+	var stored models.Job
+	database.DB.First(&stored, job.ID)
+	if stored.Status != jobStatusRunning || stored.LockedBy != "worker-b" || stored.FinishedAt != nil {
+		t.Fatalf("stale worker overwrote the new owner's state: %+v", stored)
+	}
+	if finished.Status != jobStatusRunning || finished.LockedBy != "worker-b" {
+		t.Fatalf("expected finish to report the stored state, got %+v", finished)
+	}
+}
+
+func TestJobUpdateStopsWorkerAfterLeaseTakeover(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	var canceledAfterUpdate bool
+	useTestJobHandler(t, "test_slow", jobHandler{
+		maxAttempts: 2,
+		run: func(run *jobRun) error {
+			// 执行期间租约过期，被其他执行者接管
+			database.DB.Model(&models.Job{}).Where("id = ?", run.job.ID).Updates(map[string]any{"locked_by": "worker-b", "attempts": 2})
+			run.recordItem("A", batchTaskItemResult{Key: "A"})
+			canceledAfterUpdate = run.ctx.Err() != nil
+			return run.ctx.Err()
+		},
+	})
+
+	job, err := enqueueJob("test_slow", 1, map[string]string{}, 1)
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	claimed, ok := claimNextJob("worker-a", time.Now())
+	if !ok {
+		t.Fatal("claim failed")
+	}
+	executeJob(claimed)
+
+	if !canceledAfterUpdate {
+		t.Fatal("expected the superseded worker's context to be canceled")
+	}
+	var stored models.Job
+	database.DB.First(&stored, job.ID)
+	if stored.LockedBy != "worker-b" || stored.Processed != 0 || stored.Status != jobStatusRunning {
+		t.Fatalf("stale worker wrote progress over the new owner: %+v", stored)
+	}
+}
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"crane-system/database"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	jobStreamPollInterval      = 2 * time.Second
+	jobStreamKeepaliveInterval = 15 * time.Second
+)
+
+// jobNotifier 在本实例的任务状态变化时唤醒进度流；其他实例上执行的任务靠轮询发现变化。
+type jobNotifier struct {
+	mu          sync.Mutex
+	subscribers map[chan struct{}]struct{}
+}
+
+var jobChanges = &jobNotifier{subscribers: map[chan struct{}]struct{}{}}
+
+func (n *jobNotifier) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	n.mu.Lock()
+	n.subscribers[ch] = struct{}{}
+	n.mu.Unlock()
+	return ch
+}
+
+func (n *jobNotifier) unsubscribe(ch chan struct{}) {
+	n.mu.Lock()
+	delete(n.subscribers, ch)
+	n.mu.Unlock()
+}
+
+// notify 不阻塞：订阅者尚未处理上一次通知时合并为一次。
+func (n *jobNotifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for ch := range n.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// jobEvent 是进度流中推送的任务状态，不含任务参数和结果。
+type jobEvent struct {
+	ID           uint    `json:"id"`
+	Type         string  `json:"type"`
+	Status       string  `json:"status"`
+	Total        int     `json:"total"`
+	Processed    int     `json:"processed"`
+	Success      int     `json:"success"`
+	Failed       int     `json:"failed"`
+	Progress     float64 `json:"progress"`
+	CurrentItem  string  `json:"current_item"`
+	ErrorMessage string  `json:"error_message"`
+}
+
+func newJobEvent(job models.Job) jobEvent {
+	return jobEvent{
+		ID:           job.ID,
+		Type:         job.Type,
+		Status:       job.Status,
+		Total:        job.Total,
+		Processed:    job.Processed,
+		Success:      job.Success,
+		Failed:       job.Failed,
+		Progress:     job.Progress,
+		CurrentItem:  job.CurrentItem,
+		ErrorMessage: job.ErrorMessage,
+	}
+}
+
+func (e jobEvent) finished() bool {
+	return e.Status != jobStatusQueued && e.Status != jobStatusRunning
+}
+
+// serveJobEvents 以 SSE 推送 load 返回的任务中发生变化的部分：执行中为 progress 事件，结束时为 done 事件。
+// untilFinished 为 true 时，load 返回的任务全部结束后关闭连接。
+func serveJobEvents(c *gin.Context, load func() ([]models.Job, error), untilFinished bool) {
+	// 进度流会超过服务器的写超时，单独取消本连接的写截止时间
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	wake := jobChanges.subscribe()
+	defer jobChanges.unsubscribe(wake)
+	poll := time.NewTicker(jobStreamPollInterval)
+	defer poll.Stop()
+	keepalive := time.NewTicker(jobStreamKeepaliveInterval)
+	defer keepalive.Stop()
+
+	sent := map[uint]jobEvent{}
+	for {
+		jobs, err := load()
+		if err != nil {
+			c.SSEvent("error", gin.H{"error": "查询失败"})
+			c.Writer.Flush()
+			return
+		}
+		allFinished := len(jobs) > 0
+		for _, job := range jobs {
+			event := newJobEvent(job)
+			allFinished = allFinished && event.finished()
+			if last, ok := sent[job.ID]; ok && last == event {
+				continue
+			}
+			sent[job.ID] = event
+			if event.finished() {
+				c.SSEvent("done", event)
+			} else {
+				c.SSEvent("progress", event)
+			}
+		}
+		c.Writer.Flush()
+		if untilFinished && allFinished {
+			return
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-wake:
+		case <-poll.C:
+		case <-keepalive.C:
+			_, _ = fmt.Fprint(c.Writer, ": keepalive\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
+
+// StreamJob 推送单个任务的进度，任务结束后关闭连接。
+func StreamJob(c *gin.Context) {
+	job, ok := loadAccessibleJob(c)
+	if !ok {
+		return
+	}
+	serveJobEvents(c, func() ([]models.Job, error) {
+		var latest models.Job
+		if err := database.DB.Omit("result").First(&latest, job.ID).Error; err != nil {
+			return nil, err
+		}
+		return []models.Job{latest}, nil
+	}, true)
+}
+
+// StreamJobs 推送当前用户全部任务的进度：连接时的未结束任务，以及之后新提交或发生变化的任务。
+func StreamJobs(c *gin.Context) {
+	userID := currentUserID(c)
+	since := time.Now().Add(-time.Second)
+	serveJobEvents(c, func() ([]models.Job, error) {
+		var jobs []models.Job
+		err := database.DB.Omit("result").
+			Where("owner_id = ? AND (status IN ? OR updated_at >= ?)", userID, []string{jobStatusQueued, jobStatusRunning}, since).
+			Order("id ASC").Find(&jobs).Error
+		return jobs, err
+	}, false)
+}
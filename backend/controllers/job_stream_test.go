@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"crane-system/database"
+	"crane-system/models"
+)
+
+type jobStreamReader struct {
+	t       *testing.T
+	scanner *bufio.Scanner
+}
+
+func openJobStream(t *testing.T, ctx context.Context, url, token string) (*jobStreamReader, *http.Response) {
+	t.Helper()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return &jobStreamReader{t: t, scanner: bufio.NewScanner(resp.Body)}, resp
+}
+
+// next 读取下一个事件，跳过保活注释；连接关闭时返回空事件名。
+func (r *jobStreamReader) next() (string, jobEvent) {
+	r.t.Helper()
+	var name, data string
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		switch {
+		case line == "" && name != "":
+			var event jobEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				r.t.Fatalf("decode event %q: %v", data, err)
+			}
+			return name, event
+		case strings.HasPrefix(line, "event:"):
+			name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+	return "", jobEvent{}
+}
+
+func TestJobEventStreams(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	adminToken, _ := seedProductionLineCustomFieldAuthData(t, database.DB)
+	originalPoll := jobStreamPollInterval
+	jobStreamPollInterval = 50 * time.Millisecond
+	t.Cleanup(func() { jobStreamPollInterval = originalPoll })
+	server := httptest.NewServer(setupJobTestRouter())
+	defer server.Close()
+
+	other := models.User{Name: "Other", Password: "hashed", EmployeeID: "EMP-STREAM-OTHER", Role: "user", Status: "active"}
+	if err := database.DB.Create(&other).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	otherToken := createUserTokenForTest(t, other.ID, other.Role)
+
+	job := models.Job{Type: jobTypeBatchImport, Status: jobStatusRunning, OwnerID: 1, Total: 3, Processed: 1, RunAfter: time.Now()}
+	if err := database.DB.Create(&job).Error; err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	_, resp := openJobStream(t, context.Background(), fmt.Sprintf("%s/api/jobs/%d/events", server.URL, job.ID), otherToken)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("other user stream: status = %d", resp.StatusCode)
+	}
+
+	userCtx, stopUserStream := context.WithCancel(context.Background())
+	defer stopUserStream()
+	userStream, _ := openJobStream(t, userCtx, server.URL+"/api/jobs/events", adminToken)
+	if name, event := userStream.next(); name != "progress" || event.ID != job.ID || event.Processed != 1 {
+		t.Fatalf("user stream first event = %s %+v", name, event)
+	}
+
+	stream, resp := openJobStream(t, context.Background(), fmt.Sprintf("%s/api/jobs/%d/events", server.URL, job.ID), adminToken)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("content type = %q", ct)
+	}
+	if name, event := stream.next(); name != "progress" || event.Processed != 1 {
+		t.Fatalf("first event = %s %+v", name, event)
+	}
+
+	run := &jobRun{ctx: context.Background(), cancel: func() {}, job: job}
+	run.recordItem("WS/A", batchTaskItemResult{Key: "WS/A"})
+	if name, event := stream.next(); name != "progress" || event.Processed != 2 || event.Success != 1 {
+		t.Fatalf("progress event = %s %+v", name, event)
+	}
+
+	// 其他用户的任务不会出现在当前用户的流中
+	if _, err := enqueueJob(jobTypeBackup, other.ID, backupJobPayload{Kind: backupKindDatabase}, 1); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	run.finish(nil)
+	if name, event := stream.next(); name != "done" || event.Status != jobStatusCompleted || event.Progress != 100 {
+		t.Fatalf("done event = %s %+v", name, event)
+	}
+	if name, _ := stream.next(); name != "" {
+		t.Fatalf("stream not closed after completion, got %s", name)
+	}
+
+	for {
+		name, event := userStream.next()
+		if name == "" {
+			t.Fatalf("user stream closed")
+		}
+		if event.ID != job.ID {
+			t.Fatalf("user stream leaked job %+v", event)
+		}
+		if name == "done" {
+			break
+		}
+	}
+}
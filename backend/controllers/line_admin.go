@@ -12,11 +12,11 @@ import (
 )
 
 func authorizeLineAdminScope(c *gin.Context, productionLineID uint) bool {
-	return writeAuthDecision(c, services.AuthorizeLineAdminScope(currentUserID(c), currentUserRole(c), productionLineID))
+	return writeAuthDecision(c, services.AuthorizeLineAdminScope(currentUserID(c), currentAccountRole(c), productionLineID))
 }
 
 func GetLineAdminAssignments(c *gin.Context) {
-	role := currentUserRole(c)
+	role := currentAccountRole(c)
 	userID := currentUserID(c)
 
 	query := database.DB.Preload("User").Preload("User.Department").Preload("ProductionLine")
@@ -55,7 +55,7 @@ func GetLineAdminAssignments(c *gin.Context) {
 }
 
 func CreateLineAdminAssignment(c *gin.Context) {
-	currentRole := currentUserRole(c)
+	currentRole := currentAccountRole(c)
 	currentUserID := currentUserID(c)
 
 	var req struct {
@@ -93,6 +93,7 @@ func CreateLineAdminAssignment(c *gin.Context) {
 	}
 
 	services.InvalidateUserCache(req.UserID)
+	recordAuditChange(c, "line_admin_assignment", assignment.ID, nil, assignment)
 	c.JSON(http.StatusOK, assignment)
 }
 
@@ -103,7 +104,7 @@ func DeleteLineAdminAssignment(c *gin.Context) {
 		return
 	}
 
-	currentRole := currentUserRole(c)
+	currentRole := currentAccountRole(c)
 	currentUserID := currentUserID(c)
 
 	var assignment models.LineAdminAssignment
@@ -123,6 +124,7 @@ func DeleteLineAdminAssignment(c *gin.Context) {
 	}
 
 	services.InvalidateUserCache(affectedUserID)
+	recordAuditChange(c, "line_admin_assignment", assignment.ID, assignment, nil)
 	c.JSON(http.StatusOK, gin.H{"message": "已取消分配"})
 }
 
@@ -202,7 +204,7 @@ func SaveLinePermissionByAdmin(c *gin.Context) {
 		return
 	}
 
-	currentRole := currentUserRole(c)
+	currentRole := currentAccountRole(c)
 
 	var req struct {
 		UserID      uint  `json:"user_id" binding:"required"`
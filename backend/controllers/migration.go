@@ -1,58 +1,128 @@
 package controllers
 
 import (
+	"crane-system/database"
 	"crane-system/migration"
+	"crane-system/models"
+	"encoding/json"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
+// latestMigrationJob 返回最近一次指定类型的迁移任务。
+func latestMigrationJob(jobType string) (models.Job, bool) {
+	var job models.Job
+	if err := database.DB.Where("type = ?", jobType).Order("id DESC").First(&job).Error; err != nil {
+		return job, false
+	}
+	return job, true
+}
+
+// fileMigrationStatusFromJob 把迁移任务转换为迁移状态，进度明细来自任务结果。
+func fileMigrationStatusFromJob(job models.Job) migration.FileMigrationStatus {
+	var status migration.FileMigrationStatus
+	if len(job.Result) > 0 {
+		_ = json.Unmarshal(job.Result, &status)
+	}
+	switch job.Status {
+	case jobStatusQueued, jobStatusRunning:
+		status.Status = "running"
+	default:
+		status.Status = job.Status
+	}
+	if status.ErrorMsg == "" {
+		status.ErrorMsg = job.ErrorMessage
+	}
+	return status
+}
+
+func migrationJobActive() (bool, error) {
+	var count int64
+	err := database.DB.Model(&models.Job{}).
+		Where("type IN ? AND status IN ?", []string{jobTypeFileMigration, jobTypeFileMigrationRollback}, []string{jobStatusQueued, jobStatusRunning}).
+		Count(&count).Error
+	return count > 0, err
+}
+
 // GetMigrationStatus 获取文件迁移状态
 func GetMigrationStatus(c *gin.Context) {
-	status := migration.GetMigrationStatus()
-	c.JSON(http.StatusOK, status)
+	job, ok := latestMigrationJob(jobTypeFileMigration)
+	if !ok {
+		c.JSON(http.StatusOK, migration.GetMigrationStatus())
+		return
+	}
+	c.JSON(http.StatusOK, fileMigrationStatusFromJob(job))
 }
 
 // StartMigration 开始文件迁移
 func StartMigration(c *gin.Context) {
-	// 检查是否已在运行
-	status := migration.GetMigrationStatus()
-	if status.Status == "running" {
+	// 检查是否已在运行，任务记录在各实例间共享
+	active, err := migrationJobActive()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	if active {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "文件迁移正在进行中"})
 		return
 	}
 
-	// 异步执行迁移
-	go func() {
-		if err := migration.MigrateFilesToNewStructure(); err != nil {
-			// 错误已经在迁移函数中处理，这里只是记录
-			return
-		}
-	}()
+	// 作为后台任务执行迁移
+	job, err := enqueueJob(jobTypeFileMigration, currentUserID(c), struct{}{}, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交迁移任务失败"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "文件迁移已开始，请查看迁移状态",
-		"status":  status,
+		"job_id":  job.ID,
+		"status":  fileMigrationStatusFromJob(job),
 	})
 }
 
 // RollbackMigration 回滚文件迁移
 func RollbackMigration(c *gin.Context) {
-	status := migration.GetMigrationStatus()
-	if status.Status != "completed" {
+	active, err := migrationJobActive()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	job, ok := latestMigrationJob(jobTypeFileMigration)
+	if active || !ok || job.Status != jobStatusCompleted {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "只能回滚已完成的迁移"})
 		return
 	}
 
-	// 异步执行回滚
-	go func() {
-		if err := migration.RollbackMigration(); err != nil {
-			// 错误已经在回滚函数中处理
-			return
-		}
-	}()
+	// 作为后台任务执行回滚
+	rollback, err := enqueueJob(jobTypeFileMigrationRollback, currentUserID(c), struct{}{}, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交回滚任务失败"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "迁移回滚已开始",
+		"job_id":  rollback.ID,
+	})
+}
+
+func runFileMigrationJob(run *jobRun) error {
+	return migration.MigrateFilesToNewStructure(run.ctx, func(status migration.FileMigrationStatus) {
+		raw, _ := json.Marshal(status)
+		run.update(func(job *models.Job) {
+			job.Total = status.TotalFiles
+			job.Processed = status.MigratedFiles + status.FailedFiles
+			job.Success = status.MigratedFiles
+			job.Failed = status.FailedFiles
+			job.Progress = status.Progress
+			job.CurrentItem = status.CurrentFile
+			job.Result = raw
+		})
 	})
 }
+
+func runFileMigrationRollbackJob(run *jobRun) error {
+	return migration.RollbackMigration()
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"crane-system/services"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// permissionConfigMaxBytes 限制导入文档大小。
+const permissionConfigMaxBytes = 10 << 20
+
+// permissionConfigFormat 返回文档格式：format 参数优先，其次按 Content-Type 判断，默认 JSON。
+func permissionConfigFormat(c *gin.Context) string {
+	switch strings.ToLower(strings.TrimSpace(c.Query("format"))) {
+	case "yaml", "yml":
+		return "yaml"
+	case "json":
+		return "json"
+	}
+	if strings.Contains(strings.ToLower(c.ContentType()), "yaml") {
+		return "yaml"
+	}
+	return "json"
+}
+
+// ExportPermissionConfig 导出全部权限配置，format=yaml 时输出 YAML。
+func ExportPermissionConfig(c *gin.Context) {
+	doc, err := services.ExportPermissionConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导出权限配置失败"})
+		return
+	}
+	format := permissionConfigFormat(c)
+	fileName := "permissions-" + time.Now().Format("20060102-150405") + "." + format
+	c.Header("Content-Disposition", "attachment; filename=\""+fileName+"\"; filename*=UTF-8''"+url.QueryEscape(fileName))
+	if format == "yaml" {
+		c.YAML(http.StatusOK, doc)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, doc)
+}
+
+func readPermissionConfigDocument(c *gin.Context) (services.PermissionConfigDocument, bool) {
+	var doc services.PermissionConfigDocument
+	raw, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, permissionConfigMaxBytes))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "文档过大"})
+		return doc, false
+	}
+	if permissionConfigFormat(c) == "yaml" {
+		err = yaml.Unmarshal(raw, &doc)
+	} else {
+		err = json.Unmarshal(raw, &doc)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文档解析失败: " + err.Error()})
+		return doc, false
+	}
+	return doc, true
+}
+
+// PreviewPermissionConfigImport 返回导入文档与当前配置的差异，不保存。
+func PreviewPermissionConfigImport(c *gin.Context) {
+	importPermissionConfig(c, false)
+}
+
+// ApplyPermissionConfigImport 在一个事务中应用导入文档；文档有错误时不做任何变更。
+func ApplyPermissionConfigImport(c *gin.Context) {
+	importPermissionConfig(c, true)
+}
+
+func importPermissionConfig(c *gin.Context, apply bool) {
+	doc, ok := readPermissionConfigDocument(c)
+	if !ok {
+		return
+	}
+	diff, err := services.ImportPermissionConfig(doc, apply)
+	if err != nil {
+		if errors.Is(err, services.ErrUnsupportedPermissionConfigVersion) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导入权限配置失败"})
+		return
+	}
+	if apply && len(diff.Errors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "权限配置文档有错误，未做任何变更", "diff": diff})
+		return
+	}
+	if apply {
+		recordAuditChange(c, "permission_config", doc.Version, nil, diff)
+	}
+	c.JSON(http.StatusOK, diff)
+}
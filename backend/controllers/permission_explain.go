@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"crane-system/database"
+	"crane-system/models"
+	"crane-system/services"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// loadPermissionExplainUser 返回要解释的用户：缺省为当前用户，查看他人需要权限管理页面权限。
+func loadPermissionExplainUser(c *gin.Context) (models.User, bool) {
+	userID := currentUserID(c)
+	if raw := strings.TrimSpace(c.Query("user_id")); raw != "" {
+		parsed, err := parseUintParam(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "用户ID格式错误"})
+			return models.User{}, false
+		}
+		userID = parsed
+	}
+	if userID != currentUserID(c) && !currentUserHasPermission(c, "page:permissions") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+		return models.User{}, false
+	}
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// ExplainPermission 返回一次权限判断的完整求值过程：
+// 传 production_line_id 和 action 解释产线动作，传 code 解释功能权限码。
+func ExplainPermission(c *gin.Context) {
+	user, ok := loadPermissionExplainUser(c)
+	if !ok {
+		return
+	}
+
+	if code := strings.TrimSpace(c.Query("code")); code != "" {
+		explanation, err := services.ExplainFunctionPermission(database.DB, user, code)
+		if err != nil {
+			if errors.Is(err, services.ErrUnknownPermissionCode) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询权限失败"})
+			return
+		}
+		c.JSON(http.StatusOK, explanation)
+		return
+	}
+
+	lineID, err := parseUintParam(c.Query("production_line_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "需要提供 production_line_id 和 action，或 code"})
+		return
+	}
+	action := strings.TrimSpace(c.Query("action"))
+	if action == "" {
+		action = models.PermissionActionView
+	}
+	switch action {
+	case models.PermissionActionView, models.PermissionActionDownload, models.PermissionActionUpload, models.PermissionActionManage:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action参数无效"})
+		return
+	}
+	var line models.ProductionLine
+	if err := database.DB.Select("id").First(&line, lineID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "产线不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	explanation, err := services.ExplainLinePermission(database.DB, user, line.ID, action)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询权限失败"})
+		return
+	}
+	c.JSON(http.StatusOK, explanation)
+}
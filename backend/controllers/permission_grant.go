@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"context"
+	"crane-system/services"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var permissionGrantExpiryInterval = time.Minute
+
+// StartPermissionGrantExpiry 启动临时授权的到期清理，返回的函数用于停止。
+func StartPermissionGrantExpiry() func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(permissionGrantExpiryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				expired, err := services.ExpirePermissionRules(now)
+				if err != nil {
+					slog.Error("清理到期权限规则失败", "error", err)
+				}
+				if len(expired) > 0 {
+					slog.Info("已清理到期权限规则", "count", len(expired))
+				}
+			}
+		}
+	}()
+	return cancel
+}
+
+// ListExpiringPermissionGrants 列出 days 天内到期的临时授权，默认 7 天。
+func ListExpiringPermissionGrants(c *gin.Context) {
+	days, err := parsePositiveIntQuery(c.Query("days"), 7, 90, "days")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	grants, err := services.ExpiringPermissionGrants(time.Now(), time.Duration(days)*24*time.Hour)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询权限失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": grants, "days": days})
+}
+
+// ListProgramPermissionOverrides 列出所有带有程序规则的程序，便于管理员复核例外授权。
+func ListProgramPermissionOverrides(c *gin.Context) {
+	overrides, err := services.ListProgramOverrides(time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询权限失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": overrides})
+}
@@ -5,6 +5,7 @@ import (
 	"crane-system/models"
 	"crane-system/services"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -128,10 +129,16 @@ func savePermissionRulesAndReturnMatrix(c *gin.Context, subject services.Permiss
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	before, err := services.CurrentPermissionRuleDecisions(subject, req.Changes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询权限失败"})
+		return
+	}
 	if err := services.SavePermissionRuleChanges(subject, req.Changes); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	recordAuditChange(c, "permission_rule", fmt.Sprintf("%s:%d:%s", subject.Type, subject.ID, subject.Key), before, req.Changes)
 	lines, ok := loadPermissionRuleMatrixLines(c)
 	if !ok {
 		return
@@ -151,17 +158,36 @@ func validatePermissionRuleChanges(changes []services.PermissionRuleChange) erro
 		if resourceType == "" {
 			resourceType = models.PermissionResourceProductionLine
 		}
-		if resourceType != models.PermissionResourceProductionLine {
+		if resourceType != models.PermissionResourceProductionLine && resourceType != models.PermissionResourceVehicleModel && resourceType != models.PermissionResourceProgram {
 			return errors.New("invalid resource type")
 		}
 		if change.ResourceID == 0 {
 			return errors.New("invalid resource id")
 		}
-		key := resourceType + ":" + strconv.FormatUint(uint64(change.ResourceID), 10) + ":" + change.Action
+		key := resourceType + ":" + strconv.FormatUint(uint64(change.ResourceID), 10) + ":" + strconv.FormatUint(uint64(change.ProductionLineID), 10) + ":" + change.Action
 		if _, ok := seen[key]; ok {
 			return errors.New("duplicate change")
 		}
 		seen[key] = struct{}{}
+		if resourceType == models.PermissionResourceVehicleModel {
+			if err := validateVehicleModelRuleScope(change); err != nil {
+				return err
+			}
+			continue
+		}
+		if change.ProductionLineID != 0 {
+			return errors.New("production_line_id is only valid for vehicle_model rules")
+		}
+		if resourceType == models.PermissionResourceProgram {
+			var program models.Program
+			if err := database.DB.Select("id").First(&program, change.ResourceID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return errors.New("invalid program")
+				}
+				return err
+			}
+			continue
+		}
 		var line models.ProductionLine
 		if err := database.DB.Select("id").First(&line, change.ResourceID).Error; err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -173,6 +199,28 @@ func validatePermissionRuleChanges(changes []services.PermissionRuleChange) erro
 	return nil
 }
 
+// validateVehicleModelRuleScope 校验车型规则的车型和可选的产线范围。
+func validateVehicleModelRuleScope(change services.PermissionRuleChange) error {
+	var vehicleModel models.VehicleModel
+	if err := database.DB.Select("id").First(&vehicleModel, change.ResourceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("invalid vehicle model")
+		}
+		return err
+	}
+	if change.ProductionLineID == 0 {
+		return nil
+	}
+	var line models.ProductionLine
+	if err := database.DB.Select("id").First(&line, change.ProductionLineID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("invalid production line")
+		}
+		return err
+	}
+	return nil
+}
+
 func loadPermissionMatrixUser(c *gin.Context) (models.User, bool) {
 	userID, err := parseUintParam(c.Param("id"))
 	if err != nil {
@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"crane-system/models"
+	"crane-system/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SimulateUserPermissionRules(c *gin.Context) {
+	user, ok := loadPermissionMatrixUser(c)
+	if !ok {
+		return
+	}
+	simulatePermissionRuleChanges(c, services.PermissionSubject{Type: models.PermissionSubjectUser, ID: user.ID})
+}
+
+func SimulateDepartmentPermissionRules(c *gin.Context) {
+	departmentID, ok := loadPermissionMatrixDepartmentID(c)
+	if !ok {
+		return
+	}
+	simulatePermissionRuleChanges(c, services.PermissionSubject{Type: models.PermissionSubjectDepartment, ID: departmentID})
+}
+
+func SimulateRolePermissionRules(c *gin.Context) {
+	subject, ok := loadPermissionMatrixRoleSubject(c)
+	if !ok {
+		return
+	}
+	simulatePermissionRuleChanges(c, subject)
+}
+
+func SimulateDepartmentDefaultPermissionRules(c *gin.Context) {
+	departmentID, ok := loadPermissionMatrixDepartmentID(c)
+	if !ok {
+		return
+	}
+	simulatePermissionRuleChanges(c, services.PermissionSubject{Type: models.PermissionSubjectDepartmentDefault, ID: departmentID})
+}
+
+// simulatePermissionRuleChanges 接受与保存接口相同的请求体，返回受影响用户的权限增减，不保存变更。
+func simulatePermissionRuleChanges(c *gin.Context, subject services.PermissionSubject) {
+	var req savePermissionRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validatePermissionRuleChanges(req.Changes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	simulation, err := services.SimulatePermissionRuleChanges(subject, req.Changes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, simulation)
+}
@@ -237,6 +237,7 @@ func DeleteProductionLine(c *gin.Context) {
 	}
 	if dependency, err := findPermissionRuleDependency([]permissionRuleDependencyCheck{
 		{Where: "resource_type = ? AND resource_id = ?", Args: []any{models.PermissionResourceProductionLine, lineID}, Label: "permission rules"},
+		{Where: "resource_type = ? AND production_line_id = ?", Args: []any{models.PermissionResourceVehicleModel, lineID}, Label: "vehicle model permission rules"},
 	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "dependency check failed"})
 		return
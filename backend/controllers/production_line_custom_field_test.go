@@ -44,7 +44,24 @@ func openProductionLineCustomFieldTestDB(t *testing.T) *gorm.DB {
 		&models.ProgramFile{},
 		&models.ProgramVersion{},
 		&models.ProgramRelation{},
+		&models.ProgramRelationType{},
 		&models.ProgramMapping{},
+		&models.ProgramStatusDefinition{},
+		&models.ProgramStatusTransition{},
+		&models.ProgramStatusHistory{},
+		&models.ProgramCodeRule{},
+		&models.ProgramCodeSequence{},
+		&models.ExportTemplate{},
+		&models.ReportSchedule{},
+		&models.ReportRun{},
+		&models.BatchImportLayout{},
+		&models.BatchImportTask{},
+		&models.BatchImportTaskItem{},
+		&models.ImportWatchFolder{},
+		&models.ImportWatchFile{},
+		&models.Job{},
+		&models.AuditLog{},
+		&models.AccessRequest{},
 		&models.UserPermission{},
 		&models.DepartmentPermission{},
 		&models.RoleDefaultPermission{},
@@ -57,6 +74,7 @@ func openProductionLineCustomFieldTestDB(t *testing.T) *gorm.DB {
 		&models.RoleLinePermission{},
 		&models.LineAdminAssignment{},
 		&models.VehicleModel{},
+		&models.APIToken{},
 	); err != nil {
 		t.Fatalf("auto migrate test db: %v", err)
 	}
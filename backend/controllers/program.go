@@ -3,6 +3,7 @@ package controllers
 import (
 	"crane-system/database"
 	"crane-system/models"
+	"crane-system/services"
 	"crane-system/utils"
 	"encoding/json"
 	"errors"
@@ -101,7 +102,7 @@ func buildProgramParentDataMap(tx *gorm.DB, programIDs []uint) (map[uint]models.
 	return mappingByChildID, parentByID, nil
 }
 
-func buildProgramListResponse(tx *gorm.DB, programs []models.Program, allowedLineIDs map[uint]struct{}) ([]programListItem, error) {
+func buildProgramListResponse(tx *gorm.DB, programs []models.Program, scope *services.ProgramScope) ([]programListItem, error) {
 	response := make([]programListItem, 0, len(programs))
 	if len(programs) == 0 {
 		return response, nil
@@ -131,7 +132,7 @@ func buildProgramListResponse(tx *gorm.DB, programs []models.Program, allowedLin
 		effectiveProgram.OwnFileCount = fileCounts[program.ID]
 
 		if mapping, ok := mappingByChildID[program.ID]; ok {
-			if parent, ok := parentByID[mapping.ParentProgramID]; ok && lineIDAllowed(allowedLineIDs, parent.ProductionLineID) {
+			if parent, ok := parentByID[mapping.ParentProgramID]; ok && scope.Allows(parent.ID, parent.ProductionLineID, parent.VehicleModelID) {
 				effectiveProgram.MappingInfo = &models.ProgramMappingInfo{
 					MappingID:         mapping.ID,
 					ParentProgramID:   parent.ID,
@@ -278,28 +279,22 @@ func GetPrograms(c *gin.Context) {
 		return
 	}
 
-	allowedLineIDs, statusCode, message := resolveAuthorizedLineIDs(c, lineActionView)
+	scope, statusCode, message := resolveAuthorizedProgramScope(c, lineActionView)
 	if statusCode != 0 {
 		c.JSON(statusCode, gin.H{"error": message})
 		return
 	}
 
 	query := database.DB.Model(&models.Program{})
-	if allowedLineIDs != nil {
-		lineIDs := make([]uint, 0, len(allowedLineIDs))
-		for lineID := range allowedLineIDs {
-			lineIDs = append(lineIDs, lineID)
-		}
-		if len(lineIDs) == 0 {
-			if !paged {
-				c.JSON(http.StatusOK, []programListItem{})
-				return
-			}
-			c.JSON(http.StatusOK, gin.H{"items": []programListItem{}, "total": 0, "page": page, "page_size": pageSize})
+	if scope.Empty() {
+		if !paged {
+			c.JSON(http.StatusOK, []programListItem{})
 			return
 		}
-		query = query.Where("production_line_id IN ?", lineIDs)
+		c.JSON(http.StatusOK, gin.H{"items": []programListItem{}, "total": 0, "page": page, "page_size": pageSize})
+		return
 	}
+	query = scope.Apply(query, "")
 	query, filterErr := applyProgramRequestFilters(c, query)
 	if filterErr != nil {
 		c.JSON(filterErr.Status, gin.H{"error": filterErr.Message})
@@ -330,7 +325,7 @@ func GetPrograms(c *gin.Context) {
 		return
 	}
 
-	response, err := buildProgramListResponse(database.DB, programs, allowedLineIDs)
+	response, err := buildProgramListResponse(database.DB, programs, scope)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "????"})
 		return
@@ -379,24 +374,13 @@ func summarizeEnabledProgramCustomFieldValues(values []models.ProgramCustomField
 // ExportProgramsExcel 按当前用户可查看的产线导出程序清单。
 // 普通用户必须叠加产线权限过滤，避免导出未授权产线的数据。
 func ExportProgramsExcel(c *gin.Context) {
-	allowedLineIDs, statusCode, message := resolveAuthorizedLineIDs(c, lineActionView)
+	scope, statusCode, message := resolveAuthorizedProgramScope(c, lineActionView)
 	if statusCode != 0 {
 		c.JSON(statusCode, gin.H{"error": message})
 		return
 	}
 
-	query := database.DB.Model(&models.Program{})
-	if allowedLineIDs != nil {
-		lineIDs := make([]uint, 0, len(allowedLineIDs))
-		for lineID := range allowedLineIDs {
-			lineIDs = append(lineIDs, lineID)
-		}
-		if len(lineIDs) == 0 {
-			query = query.Where("1 = 0")
-		} else {
-			query = query.Where("production_line_id IN ?", lineIDs)
-		}
-	}
+	query := scope.Apply(database.DB.Model(&models.Program{}), "")
 	query, filterErr := applyProgramRequestFilters(c, query)
 	if filterErr != nil {
 		c.JSON(filterErr.Status, gin.H{"error": filterErr.Message})
@@ -490,7 +474,7 @@ func GetProgram(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "?????"})
 		return
 	}
-	if !authorizeLineAction(c, program.ProductionLineID, lineActionView) {
+	if !authorizeProgramScopeAction(c, program.ID, program.ProductionLineID, program.VehicleModelID, lineActionView) {
 		return
 	}
 
@@ -504,7 +488,7 @@ func GetProgram(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "????"})
 			return
 		}
-		allowed, statusCode, message := checkLineAction(c, parentProgram.ProductionLineID, lineActionView)
+		allowed, statusCode, message := checkProgramScopeAction(c, parentProgram.ID, parentProgram.ProductionLineID, parentProgram.VehicleModelID, lineActionView)
 		if !allowed {
 			if statusCode != http.StatusForbidden {
 				c.JSON(statusCode, gin.H{"error": message})
@@ -521,7 +505,7 @@ func GetProgram(c *gin.Context) {
 
 type createProgramRequest struct {
 	Name              string                          `json:"name" binding:"required"`
-	Code              string                          `json:"code" binding:"required"`
+	Code              string                          `json:"code"`
 	ProductionLineID  uint                            `json:"production_line_id" binding:"required"`
 	VehicleModelID    *uint                           `json:"vehicle_model_id"`
 	Description       string                          `json:"description"`
@@ -529,15 +513,6 @@ type createProgramRequest struct {
 	CustomFieldValues *[]programCustomFieldValueInput `json:"custom_field_values"`
 }
 
-func validateProgramStatus(status string) error {
-	switch status {
-	case "in_progress", "completed":
-		return nil
-	default:
-		return errors.New("invalid program status")
-	}
-}
-
 // validateProgramRelations 在写入程序前校验主数据存在性。
 // 这里是程序与产线/车型建立业务关系的最后一道防线。
 func validateProgramRelations(tx *gorm.DB, productionLineID uint, vehicleModelID *uint) error {
@@ -573,19 +548,24 @@ func CreateProgram(c *gin.Context) {
 	req.Name = strings.TrimSpace(req.Name)
 	req.Code = strings.TrimSpace(req.Code)
 	req.Status = strings.TrimSpace(req.Status)
-	if req.Name == "" || req.Code == "" || req.ProductionLineID == 0 {
+	if req.Name == "" || req.ProductionLineID == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid program payload"})
 		return
 	}
-	if req.Status == "" {
-		req.Status = "in_progress"
-	}
-	if err := validateProgramStatus(req.Status); err != nil {
+	if err := validateProgramRelations(database.DB, req.ProductionLineID, req.VehicleModelID); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := validateProgramRelations(database.DB, req.ProductionLineID, req.VehicleModelID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	statusConfig, err := loadProgramStatusConfig(database.DB, req.ProductionLineID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	if req.Status == "" {
+		req.Status = statusConfig.initialStatus()
+	}
+	if !statusConfig.has(req.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errProgramStatusInvalid.Error()})
 		return
 	}
 	if !authorizeLineAction(c, req.ProductionLineID, lineActionManage) {
@@ -604,9 +584,15 @@ func CreateProgram(c *gin.Context) {
 	}
 
 	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := assignProgramCode(tx, &program); err != nil {
+			return err
+		}
 		if err := tx.Create(&program).Error; err != nil {
 			return err
 		}
+		if err := recordProgramStatusChange(tx, program.ID, "", program.Status, currentUserID(c)); err != nil {
+			return err
+		}
 		if req.CustomFieldValues != nil {
 			_, err := replaceProgramCustomFieldValues(tx, program, *req.CustomFieldValues)
 			return err
@@ -614,12 +600,10 @@ func CreateProgram(c *gin.Context) {
 		return nil
 	}); err != nil {
 		switch {
-		case errors.Is(err, errProgramCustomFieldFieldIDRequired),
-			errors.Is(err, errProgramCustomFieldDuplicateFieldID),
-			errors.Is(err, errProgramCustomFieldNotBelongToProductionLine),
-			errors.Is(err, errProgramCustomFieldInvalidSelectValue),
-			errors.Is(err, errProgramCustomFieldDisabled):
+		case isProgramCustomFieldInputError(err):
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case isProgramCodeError(err):
+			c.JSON(programCodeErrorStatusCode(err), gin.H{"error": err.Error()})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "????"})
 		}
@@ -677,9 +661,10 @@ func UpdateProgram(c *gin.Context) {
 		return
 	}
 	originalProductionLineID := program.ProductionLineID
-	if !authorizeLineAction(c, originalProductionLineID, lineActionManage) {
+	if !authorizeProgramScopeAction(c, program.ID, program.ProductionLineID, program.VehicleModelID, lineActionManage) {
 		return
 	}
+	before := program
 
 	var req updateProgramRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -716,16 +701,25 @@ func UpdateProgram(c *gin.Context) {
 		return
 	}
 
-	if req.Status != nil {
-		if err := validateProgramStatus(updates["status"].(string)); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-	}
 	nextProductionLineID := originalProductionLineID
 	if req.ProductionLineID != nil {
 		nextProductionLineID = *req.ProductionLineID
 	}
+	originalStatus := program.Status
+	nextStatus := originalStatus
+	if req.Status != nil {
+		nextStatus = updates["status"].(string)
+	}
+	if req.Status != nil || nextProductionLineID != originalProductionLineID {
+		if err := validateProgramStatusUpdate(database.DB, program, nextProductionLineID, nextStatus, currentAccountRole(c)); err != nil {
+			if isProgramStatusError(err) {
+				c.JSON(programStatusErrorStatusCode(err), gin.H{"error": err.Error()})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			}
+			return
+		}
+	}
 	var nextVehicleModelID *uint
 	if req.VehicleModelID.Set && req.VehicleModelID.Value != nil && *req.VehicleModelID.Value > 0 {
 		nextVehicleModelID = req.VehicleModelID.Value
@@ -736,17 +730,40 @@ func UpdateProgram(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if nextProductionLineID != originalProductionLineID {
-		if !authorizeLineAction(c, nextProductionLineID, lineActionManage) {
+	nextVehicleModelValue := uint(0)
+	if nextVehicleModelID != nil {
+		nextVehicleModelValue = *nextVehicleModelID
+	}
+	// 移动到其他产线或车型时，目标范围同样需要管理权限
+	if nextProductionLineID != originalProductionLineID || nextVehicleModelValue != program.VehicleModelID {
+		if !authorizeProgramScopeAction(c, program.ID, nextProductionLineID, nextVehicleModelValue, lineActionManage) {
+			return
+		}
+	}
+
+	nextCode := program.Code
+	if req.Code != nil {
+		nextCode = updates["code"].(string)
+		if nextCode == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid program payload"})
 			return
 		}
 	}
 
 	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := ensureProgramCodeUniqueOnUpdate(tx, program, nextCode, nextProductionLineID, nextVehicleModelValue); err != nil {
+			return err
+		}
 		if err := tx.Model(&program).Updates(updates).Error; err != nil {
 			return err
 		}
 
+		if nextStatus != originalStatus {
+			if err := recordProgramStatusChange(tx, program.ID, originalStatus, nextStatus, currentUserID(c)); err != nil {
+				return err
+			}
+		}
+
 		updatedProgram := program
 		updatedProgram.ProductionLineID = nextProductionLineID
 		if req.CustomFieldValues != nil {
@@ -761,12 +778,10 @@ func UpdateProgram(c *gin.Context) {
 		return nil
 	}); err != nil {
 		switch {
-		case errors.Is(err, errProgramCustomFieldFieldIDRequired),
-			errors.Is(err, errProgramCustomFieldDuplicateFieldID),
-			errors.Is(err, errProgramCustomFieldNotBelongToProductionLine),
-			errors.Is(err, errProgramCustomFieldInvalidSelectValue),
-			errors.Is(err, errProgramCustomFieldDisabled):
+		case isProgramCustomFieldInputError(err):
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case isProgramCodeError(err):
+			c.JSON(programCodeErrorStatusCode(err), gin.H{"error": err.Error()})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "????"})
 		}
@@ -777,6 +792,7 @@ func UpdateProgram(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "????"})
 		return
 	}
+	recordAuditChange(c, "program", program.ID, before, program)
 	c.JSON(http.StatusOK, program)
 }
 
@@ -793,7 +809,7 @@ func DeleteProgram(c *gin.Context) {
 		if err := tx.First(&program, programID).Error; err != nil {
 			return err
 		}
-		if !authorizeLineAction(c, program.ProductionLineID, lineActionManage) {
+		if !authorizeProgramScopeAction(c, program.ID, program.ProductionLineID, program.VehicleModelID, lineActionManage) {
 			return errors.New("forbidden")
 		}
 
@@ -804,6 +820,7 @@ func DeleteProgram(c *gin.Context) {
 		for _, file := range files {
 			filesToDelete = append(filesToDelete, file.FilePath)
 		}
+		recordAuditChange(c, "program", program.ID, gin.H{"program": program, "files": files}, nil)
 
 		if err := tx.Where("program_id = ?", programID).Delete(&models.ProgramCustomFieldValue{}).Error; err != nil {
 			return err
@@ -820,6 +837,10 @@ func DeleteProgram(c *gin.Context) {
 		if err := tx.Where("source_program_id = ? OR related_program_id = ?", programID, programID).Delete(&models.ProgramRelation{}).Error; err != nil {
 			return err
 		}
+		// 程序规则随程序一起删除
+		if err := tx.Unscoped().Where("resource_type = ? AND resource_id = ?", models.PermissionResourceProgram, programID).Delete(&models.PermissionRule{}).Error; err != nil {
+			return err
+		}
 		return tx.Delete(&program).Error
 	})
 	if txErr != nil {
@@ -833,6 +854,7 @@ func DeleteProgram(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "????"})
 		return
 	}
+	services.InvalidateAllCache()
 
 	uploadDir := utils.UploadDir()
 	for _, filePath := range filesToDelete {
@@ -854,27 +876,21 @@ func GetProgramsByVehicle(c *gin.Context) {
 		return
 	}
 
-	allowedLineIDs, statusCode, message := resolveAuthorizedLineIDs(c, lineActionView)
+	scope, statusCode, message := resolveAuthorizedProgramScope(c, lineActionView)
 	if statusCode != 0 {
 		c.JSON(statusCode, gin.H{"error": message})
 		return
 	}
+	if scope.Empty() {
+		c.JSON(http.StatusOK, []models.Program{})
+		return
+	}
 
 	query := database.DB.
 		Preload("ProductionLine").
 		Preload("ProductionLine.Process").
 		Where("vehicle_model_id = ?", vehicleID)
-	if allowedLineIDs != nil {
-		lineIDs := make([]uint, 0, len(allowedLineIDs))
-		for lineID := range allowedLineIDs {
-			lineIDs = append(lineIDs, lineID)
-		}
-		if len(lineIDs) == 0 {
-			c.JSON(http.StatusOK, []models.Program{})
-			return
-		}
-		query = query.Where("production_line_id IN ?", lineIDs)
-	}
+	query = scope.Apply(query, "")
 
 	var programs []models.Program
 	if err := query.Find(&programs).Error; err != nil {
@@ -895,12 +911,26 @@ func GetProgramRelations(c *gin.Context) {
 		return
 	}
 
-	var relations []models.ProgramRelation
-	if err := database.DB.
+	relationTypes, err := loadProgramRelationTypeCatalog(database.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	typeCodes, err := parseProgramRelationTypeFilter(c.Query("relation_type"), relationTypes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := database.DB.
 		Preload("SourceProgram").
 		Preload("RelatedProgram").
-		Where("source_program_id = ? OR related_program_id = ?", programID, programID).
-		Find(&relations).Error; err != nil {
+		Where("source_program_id = ? OR related_program_id = ?", programID, programID)
+	if len(typeCodes) > 0 {
+		query = query.Where("relation_type IN ?", typeCodes)
+	}
+	var relations []models.ProgramRelation
+	if err := query.Find(&relations).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "????"})
 		return
 	}
@@ -932,7 +962,7 @@ func relationProgramVisible(c *gin.Context, program models.Program) (bool, int,
 	if program.ID == 0 {
 		return false, http.StatusInternalServerError, "????"
 	}
-	allowed, statusCode, message := checkLineAction(c, program.ProductionLineID, lineActionView)
+	allowed, statusCode, message := checkProgramScopeAction(c, program.ID, program.ProductionLineID, program.VehicleModelID, lineActionView)
 	if allowed {
 		return true, 0, ""
 	}
@@ -954,12 +984,60 @@ func CreateRelation(c *gin.Context) {
 	if !authorizeProgramAction(c, database.DB, relation.RelatedProgramID, lineActionManage) {
 		return
 	}
+	if relation.SourceProgramID == relation.RelatedProgramID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能关联程序自身"})
+		return
+	}
+	relation.RelationType = strings.TrimSpace(relation.RelationType)
+	if relation.RelationType == "" {
+		relation.RelationType = programRelationTypeSameProgram
+	}
+	relationTypes, err := loadProgramRelationTypeCatalog(database.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	if _, ok := relationTypes[relation.RelationType]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未定义的关联类型"})
+		return
+	}
+
+	// 对称关联不区分方向，反向已存在也视为重复
+	duplicateQuery := database.DB.Model(&models.ProgramRelation{}).Where("relation_type = ?", relation.RelationType)
+	if relationTypes.directed(relation.RelationType) {
+		duplicateQuery = duplicateQuery.Where("source_program_id = ? AND related_program_id = ?", relation.SourceProgramID, relation.RelatedProgramID)
+	} else {
+		duplicateQuery = duplicateQuery.Where("(source_program_id = ? AND related_program_id = ?) OR (source_program_id = ? AND related_program_id = ?)",
+			relation.SourceProgramID, relation.RelatedProgramID, relation.RelatedProgramID, relation.SourceProgramID)
+	}
+	var duplicateCount int64
+	if err := duplicateQuery.Count(&duplicateCount).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	if duplicateCount > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "该关联已存在"})
+		return
+	}
+
+	if relationTypes.directed(relation.RelationType) {
+		cyclic, err := wouldCreateProgramCycle(database.DB, relation.SourceProgramID, relation.RelatedProgramID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+		if cyclic {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errProgramGraphCycle.Error()})
+			return
+		}
+	}
 
 	if err := database.DB.Create(&relation).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "????"})
 		return
 	}
 
+	recordAuditChange(c, "program_relation", relation.ID, nil, relation)
 	c.JSON(http.StatusCreated, relation)
 }
 
@@ -991,6 +1069,7 @@ func DeleteRelation(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "????"})
 		return
 	}
+	recordAuditChange(c, "program_relation", relation.ID, relation, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "????"})
 }
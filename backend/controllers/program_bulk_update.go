@@ -0,0 +1,398 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"crane-system/database"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	bulkUpdateModeTransaction = "transaction"
+	bulkUpdateModeChunked     = "chunked"
+
+	bulkUpdateMaxPrograms       = 1000
+	bulkUpdateDefaultChunkSize  = 100
+	bulkUpdateMaxChunkSize      = 500
+	bulkUpdateStatusUpdated     = "updated"
+	bulkUpdateStatusFailed      = "failed"
+	bulkUpdateStatusSkipped     = "skipped"
+	bulkUpdateStatusRolledBack  = "rolled_back"
+	bulkUpdateRolledBackMessage = "批次中存在失败项，已整体回滚"
+)
+
+// bulkUpdateProgramPatch 只包含适合批量修改的元数据字段。
+// 名称、编号、产线属于单个程序的身份信息，不开放批量修改。
+type bulkUpdateProgramPatch struct {
+	Status            *string                        `json:"status"`
+	VehicleModelID    optionalVehicleModelIDUpdate   `json:"vehicle_model_id"`
+	Description       *string                        `json:"description"`
+	CustomFieldValues []programCustomFieldValueInput `json:"custom_field_values"`
+}
+
+func (p bulkUpdateProgramPatch) empty() bool {
+	return p.Status == nil && !p.VehicleModelID.Set && p.Description == nil && len(p.CustomFieldValues) == 0
+}
+
+type bulkUpdateProgramsRequest struct {
+	ProgramIDs []uint                 `json:"program_ids"`
+	Patch      bulkUpdateProgramPatch `json:"patch"`
+	Mode       string                 `json:"mode"`
+	ChunkSize  int                    `json:"chunk_size"`
+}
+
+type bulkUpdateProgramResult struct {
+	ProgramID       uint   `json:"program_id"`
+	TargetProgramID uint   `json:"target_program_id,omitempty"`
+	Status          string `json:"status"`
+	Error           string `json:"error,omitempty"`
+}
+
+// hasProgramRequestFilters 判断请求是否携带了 applyProgramRequestFilters 支持的筛选参数。
+func hasProgramRequestFilters(c *gin.Context) bool {
+	for key, values := range c.Request.URL.Query() {
+		if len(values) == 0 || strings.TrimSpace(values[0]) == "" {
+			continue
+		}
+		switch key {
+		case "production_line_id", "vehicle_model_id", "status", "keyword", "date_from", "date_to":
+			return true
+		}
+		if strings.HasPrefix(key, "custom_field_") {
+			return true
+		}
+	}
+	return false
+}
+
+// collectBulkUpdateProgramIDs 按筛选条件查出待更新程序，范围限制在当前用户可见的程序内。
+func collectBulkUpdateProgramIDs(c *gin.Context) ([]uint, int, string) {
+	programScope, statusCode, message := resolveAuthorizedProgramScope(c, lineActionView)
+	if statusCode != 0 {
+		return nil, statusCode, message
+	}
+	if programScope.Empty() {
+		return []uint{}, 0, ""
+	}
+
+	query := programScope.Apply(database.DB.Model(&models.Program{}), "")
+	query, filterErr := applyProgramRequestFilters(c, query)
+	if filterErr != nil {
+		return nil, filterErr.Status, filterErr.Message
+	}
+
+	var ids []uint
+	if err := query.Order("id ASC").Limit(bulkUpdateMaxPrograms+1).Pluck("id", &ids).Error; err != nil {
+		return nil, http.StatusInternalServerError, "查询失败"
+	}
+	return ids, 0, ""
+}
+
+func buildBulkUpdateProgramFields(patch bulkUpdateProgramPatch) map[string]interface{} {
+	updates := map[string]interface{}{}
+	if patch.Status != nil {
+		updates["status"] = strings.TrimSpace(*patch.Status)
+	}
+	if patch.VehicleModelID.Set {
+		if patch.VehicleModelID.Value == nil {
+			updates["vehicle_model_id"] = uint(0)
+		} else {
+			updates["vehicle_model_id"] = *patch.VehicleModelID.Value
+		}
+	}
+	if patch.Description != nil {
+		updates["description"] = *patch.Description
+	}
+	return updates
+}
+
+// checkBulkUpdateStatusTransition 按程序所在产线的状态配置校验流转，同一产线的配置只加载一次。
+func checkBulkUpdateStatusTransition(programID uint, nextStatus, role string, configs map[uint]programStatusConfig) error {
+	var program models.Program
+	if err := database.DB.Select("id", "production_line_id", "status").First(&program, programID).Error; err != nil {
+		return err
+	}
+	config, ok := configs[program.ProductionLineID]
+	if !ok {
+		loaded, err := loadProgramStatusConfig(database.DB, program.ProductionLineID)
+		if err != nil {
+			return err
+		}
+		config = loaded
+		configs[program.ProductionLineID] = config
+	}
+	return config.checkTransition(program.Status, nextStatus, role)
+}
+
+// checkBulkUpdateTargetScope 按程序所在产线和修改后的车型判断管理权限。
+func checkBulkUpdateTargetScope(c *gin.Context, programID, nextVehicleModelID uint) (bool, int, string) {
+	var program models.Program
+	if err := database.DB.Select("id", "production_line_id", "vehicle_model_id").First(&program, programID).Error; err != nil {
+		return false, http.StatusInternalServerError, "查询失败"
+	}
+	if program.VehicleModelID == nextVehicleModelID {
+		return true, 0, ""
+	}
+	return checkProgramScopeAction(c, program.ID, program.ProductionLineID, nextVehicleModelID, lineActionManage)
+}
+
+func applyBulkUpdateToProgram(tx *gorm.DB, programID uint, updates map[string]interface{}, customFieldValues []programCustomFieldValueInput, changedBy uint) error {
+	var program models.Program
+	if err := tx.First(&program, programID).Error; err != nil {
+		return err
+	}
+	originalStatus := program.Status
+	if nextVehicleModelID, ok := updates["vehicle_model_id"].(uint); ok {
+		if err := ensureProgramCodeUniqueOnUpdate(tx, program, program.Code, program.ProductionLineID, nextVehicleModelID); err != nil {
+			return err
+		}
+	}
+	if len(updates) > 0 {
+		if err := tx.Model(&program).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+	if nextStatus, ok := updates["status"].(string); ok && nextStatus != originalStatus {
+		if err := recordProgramStatusChange(tx, program.ID, originalStatus, nextStatus, changedBy); err != nil {
+			return err
+		}
+	}
+	if len(customFieldValues) > 0 {
+		if _, err := mergeProgramCustomFieldValues(tx, program, customFieldValues); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bulkUpdateErrorMessage(err error) string {
+	switch {
+	case isProgramCustomFieldInputError(err), isProgramStatusError(err), isProgramCodeError(err):
+		return err.Error()
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return "程序不存在"
+	default:
+		return "更新失败"
+	}
+}
+
+// BulkUpdatePrograms 批量修改程序状态、车型、描述和自定义字段。
+// 目标程序来自 program_ids 或查询参数筛选（二者互斥）；映射子程序会归并到父程序上更新。
+// mode=transaction 时全部成功才提交；mode=chunked 时按批次提交，单条失败不影响其他程序。
+func BulkUpdatePrograms(c *gin.Context) {
+	var req bulkUpdateProgramsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Mode = strings.TrimSpace(req.Mode)
+	if req.Mode == "" {
+		req.Mode = bulkUpdateModeTransaction
+	}
+	if req.Mode != bulkUpdateModeTransaction && req.Mode != bulkUpdateModeChunked {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid mode"})
+		return
+	}
+	if req.ChunkSize == 0 {
+		req.ChunkSize = bulkUpdateDefaultChunkSize
+	}
+	if req.ChunkSize < 0 || req.ChunkSize > bulkUpdateMaxChunkSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chunk_size"})
+		return
+	}
+	if req.Patch.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "没有需要更新的字段"})
+		return
+	}
+
+	updates := buildBulkUpdateProgramFields(req.Patch)
+	if req.Patch.VehicleModelID.Value != nil && *req.Patch.VehicleModelID.Value > 0 {
+		var vehicleModel models.VehicleModel
+		if err := database.DB.Select("id").First(&vehicleModel, *req.Patch.VehicleModelID.Value).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vehicle model"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+	}
+
+	useFilters := hasProgramRequestFilters(c)
+	if useFilters && len(req.ProgramIDs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "program_ids 与筛选条件不能同时使用"})
+		return
+	}
+	if !useFilters && len(req.ProgramIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请提供 program_ids 或筛选条件"})
+		return
+	}
+
+	programIDs := req.ProgramIDs
+	if useFilters {
+		ids, statusCode, message := collectBulkUpdateProgramIDs(c)
+		if statusCode != 0 {
+			c.JSON(statusCode, gin.H{"error": message})
+			return
+		}
+		programIDs = ids
+	}
+	if len(programIDs) > bulkUpdateMaxPrograms {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "单次最多更新 1000 个程序"})
+		return
+	}
+
+	// 预检查：解析映射目标、去重并逐条校验产线管理权限和状态流转，失败项不进入写入阶段。
+	results := make([]bulkUpdateProgramResult, 0, len(programIDs))
+	pending := make([]int, 0, len(programIDs))
+	seenTargets := map[uint]struct{}{}
+	statusConfigs := map[uint]programStatusConfig{}
+	role := currentAccountRole(c)
+	for _, programID := range programIDs {
+		result := bulkUpdateProgramResult{ProgramID: programID}
+		_, targetProgramID, _, err := resolveProgramTarget(database.DB, programID)
+		if err != nil {
+			result.Status = bulkUpdateStatusFailed
+			result.Error = bulkUpdateErrorMessage(err)
+			results = append(results, result)
+			continue
+		}
+		result.TargetProgramID = targetProgramID
+		if _, exists := seenTargets[targetProgramID]; exists {
+			result.Status = bulkUpdateStatusSkipped
+			result.Error = "目标程序已在本次请求中更新"
+			results = append(results, result)
+			continue
+		}
+		seenTargets[targetProgramID] = struct{}{}
+
+		if allowed, _, message := checkProgramAction(c, database.DB, targetProgramID, lineActionManage); !allowed {
+			result.Status = bulkUpdateStatusFailed
+			result.Error = message
+			results = append(results, result)
+			continue
+		}
+		// 修改车型时，目标车型范围同样需要管理权限
+		if nextVehicleModelID, ok := updates["vehicle_model_id"].(uint); ok {
+			if allowed, _, message := checkBulkUpdateTargetScope(c, targetProgramID, nextVehicleModelID); !allowed {
+				result.Status = bulkUpdateStatusFailed
+				result.Error = message
+				results = append(results, result)
+				continue
+			}
+		}
+		if req.Patch.Status != nil {
+			if err := checkBulkUpdateStatusTransition(targetProgramID, updates["status"].(string), role, statusConfigs); err != nil {
+				result.Status = bulkUpdateStatusFailed
+				result.Error = bulkUpdateErrorMessage(err)
+				results = append(results, result)
+				continue
+			}
+		}
+		results = append(results, result)
+		pending = append(pending, len(results)-1)
+	}
+
+	if req.Mode == bulkUpdateModeTransaction {
+		runBulkUpdateTransaction(c, results, pending, updates, req.Patch.CustomFieldValues)
+		return
+	}
+	runBulkUpdateChunked(c, results, pending, req.ChunkSize, updates, req.Patch.CustomFieldValues)
+}
+
+func runBulkUpdateTransaction(c *gin.Context, results []bulkUpdateProgramResult, pending []int, updates map[string]interface{}, customFieldValues []programCustomFieldValueInput) {
+	failed := countBulkUpdateResults(results, bulkUpdateStatusFailed)
+	if failed == 0 {
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			for _, index := range pending {
+				if err := applyBulkUpdateToProgram(tx, results[index].TargetProgramID, updates, customFieldValues, currentUserID(c)); err != nil {
+					results[index].Status = bulkUpdateStatusFailed
+					results[index].Error = bulkUpdateErrorMessage(err)
+					return err
+				}
+				results[index].Status = bulkUpdateStatusUpdated
+			}
+			return nil
+		})
+		if err == nil {
+			respondBulkUpdateResults(c, http.StatusOK, bulkUpdateModeTransaction, results)
+			return
+		}
+	}
+
+	for _, index := range pending {
+		if results[index].Status != bulkUpdateStatusFailed {
+			results[index].Status = bulkUpdateStatusRolledBack
+			results[index].Error = bulkUpdateRolledBackMessage
+		}
+	}
+	respondBulkUpdateResults(c, http.StatusBadRequest, bulkUpdateModeTransaction, results)
+}
+
+// runBulkUpdateChunked 每个批次一个事务，批次内每条程序使用保存点隔离；
+// 单条校验失败只回滚该条，批次提交失败则整批标记为已回滚。
+func runBulkUpdateChunked(c *gin.Context, results []bulkUpdateProgramResult, pending []int, chunkSize int, updates map[string]interface{}, customFieldValues []programCustomFieldValueInput) {
+	for start := 0; start < len(pending); start += chunkSize {
+		end := start + chunkSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		chunk := pending[start:end]
+
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			for _, index := range chunk {
+				itemErr := tx.Transaction(func(itemTx *gorm.DB) error {
+					return applyBulkUpdateToProgram(itemTx, results[index].TargetProgramID, updates, customFieldValues, currentUserID(c))
+				})
+				if itemErr != nil {
+					results[index].Status = bulkUpdateStatusFailed
+					results[index].Error = bulkUpdateErrorMessage(itemErr)
+					continue
+				}
+				results[index].Status = bulkUpdateStatusUpdated
+			}
+			return nil
+		})
+		if err != nil {
+			for _, index := range chunk {
+				if results[index].Status == bulkUpdateStatusUpdated {
+					results[index].Status = bulkUpdateStatusRolledBack
+					results[index].Error = bulkUpdateRolledBackMessage
+				}
+			}
+		}
+	}
+
+	respondBulkUpdateResults(c, http.StatusOK, bulkUpdateModeChunked, results)
+}
+
+func countBulkUpdateResults(results []bulkUpdateProgramResult, status string) int {
+	count := 0
+	for _, result := range results {
+		if result.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+func respondBulkUpdateResults(c *gin.Context, statusCode int, mode string, results []bulkUpdateProgramResult) {
+	body := gin.H{
+		"mode":      mode,
+		"total":     len(results),
+		"succeeded": countBulkUpdateResults(results, bulkUpdateStatusUpdated),
+		"failed":    countBulkUpdateResults(results, bulkUpdateStatusFailed),
+		"skipped":   countBulkUpdateResults(results, bulkUpdateStatusSkipped),
+		"results":   results,
+	}
+	if statusCode != http.StatusOK {
+		body["error"] = bulkUpdateRolledBackMessage
+	}
+	c.JSON(statusCode, body)
+}
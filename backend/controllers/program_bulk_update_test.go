@@ -0,0 +1,296 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+	"crane-system/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type bulkUpdateProgramsTestResponse struct {
+	Mode      string                    `json:"mode"`
+	Total     int                       `json:"total"`
+	Succeeded int                       `json:"succeeded"`
+	Failed    int                       `json:"failed"`
+	Skipped   int                       `json:"skipped"`
+	Results   []bulkUpdateProgramResult `json:"results"`
+}
+
+func setupProgramBulkUpdateTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	{
+		api.POST("/programs/bulk-update", BulkUpdatePrograms)
+	}
+	return r
+}
+
+// setupProgramBulkUpdateTest 准备两条产线：普通用户只管理 lineA，对 lineB 仅可查看。
+func setupProgramBulkUpdateTest(t *testing.T) (*gin.Engine, string, string, models.ProductionLine, models.ProductionLine) {
+	t.Helper()
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	services.InvalidateAllCache()
+	adminToken, lineA := seedProductionLineCustomFieldAuthData(t, database.DB)
+
+	lineB := models.ProductionLine{Name: "产线B", Code: "LINE-002", Type: "upper", Status: "active", ProcessID: lineA.ProcessID}
+	if err := database.DB.Create(&lineB).Error; err != nil {
+		t.Fatalf("create lineB: %v", err)
+	}
+
+	user := models.User{Name: "Editor", Password: "hashed", EmployeeID: "EMP-BULK-001", Role: "user", Status: "active"}
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	saveProductionLineRuleForTest(t, services.PermissionSubject{Type: models.PermissionSubjectUser, ID: user.ID}, lineA.ID, true, true, true, true)
+	saveProductionLineRuleForTest(t, services.PermissionSubject{Type: models.PermissionSubjectUser, ID: user.ID}, lineB.ID, true, false, false, false)
+
+	return setupProgramBulkUpdateTestRouter(), adminToken, createUserTokenForTest(t, user.ID, "user"), lineA, lineB
+}
+
+func createBulkUpdateTestPrograms(t *testing.T, lineID uint, prefix string, count int) []models.Program {
+	t.Helper()
+	programs := make([]models.Program, 0, count)
+	for i := 0; i < count; i++ {
+		program := models.Program{
+			Name:             fmt.Sprintf("%s-%d", prefix, i),
+			Code:             fmt.Sprintf("%s-%03d", prefix, i),
+			ProductionLineID: lineID,
+			Status:           "in_progress",
+		}
+		if err := database.DB.Create(&program).Error; err != nil {
+			t.Fatalf("create program: %v", err)
+		}
+		programs = append(programs, program)
+	}
+	return programs
+}
+
+func assertBulkUpdateProgramStatus(t *testing.T, programID uint, want string) {
+	t.Helper()
+	var program models.Program
+	if err := database.DB.First(&program, programID).Error; err != nil {
+		t.Fatalf("load program %d: %v", programID, err)
+	}
+	if program.Status != want {
+		t.Fatalf("program %d status = %q, want %q", programID, program.Status, want)
+	}
+}
+
+func TestBulkUpdateProgramsUpdatesStatusAndCustomFieldsByIDs(t *testing.T) {
+	r, adminToken, _, lineA, _ := setupProgramBulkUpdateTest(t)
+	programs := createBulkUpdateTestPrograms(t, lineA.ID, "BULK", 2)
+
+	field := models.ProductionLineCustomField{ProductionLineID: lineA.ID, Name: "工位", FieldType: "text", Enabled: true}
+	otherField := models.ProductionLineCustomField{ProductionLineID: lineA.ID, Name: "备注", FieldType: "text", Enabled: true}
+	if err := database.DB.Create(&field).Error; err != nil {
+		t.Fatalf("create field: %v", err)
+	}
+	if err := database.DB.Create(&otherField).Error; err != nil {
+		t.Fatalf("create other field: %v", err)
+	}
+	existing := models.ProgramCustomFieldValue{ProgramID: programs[0].ID, ProductionLineCustomFieldID: otherField.ID, Value: "保留"}
+	if err := database.DB.Create(&existing).Error; err != nil {
+		t.Fatalf("create existing value: %v", err)
+	}
+
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/programs/bulk-update", adminToken, map[string]any{
+		"program_ids": []uint{programs[0].ID, programs[1].ID},
+		"patch": map[string]any{
+			"status":              "completed",
+			"custom_field_values": []map[string]any{{"field_id": field.ID, "value": "OP10"}},
+		},
+	})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	payload := decodeProductionLineCustomFieldResponse[bulkUpdateProgramsTestResponse](t, resp)
+	if payload.Succeeded != 2 || payload.Failed != 0 {
+		t.Fatalf("unexpected summary: %+v", payload)
+	}
+
+	for _, program := range programs {
+		assertBulkUpdateProgramStatus(t, program.ID, "completed")
+	}
+	var count int64
+	database.DB.Model(&models.ProgramCustomFieldValue{}).Where("production_line_custom_field_id = ? AND value = ?", field.ID, "OP10").Count(&count)
+	if count != 2 {
+		t.Fatalf("custom field values = %d, want 2", count)
+	}
+	database.DB.Model(&models.ProgramCustomFieldValue{}).Where("program_id = ? AND production_line_custom_field_id = ?", programs[0].ID, otherField.ID).Count(&count)
+	if count != 1 {
+		t.Fatalf("expected untouched custom field value to be kept")
+	}
+}
+
+func TestBulkUpdateProgramsTransactionModeRollsBackOnPermissionFailure(t *testing.T) {
+	r, _, userToken, lineA, lineB := setupProgramBulkUpdateTest(t)
+	allowed := createBulkUpdateTestPrograms(t, lineA.ID, "A", 1)[0]
+	denied := createBulkUpdateTestPrograms(t, lineB.ID, "B", 1)[0]
+
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/programs/bulk-update", userToken, map[string]any{
+		"program_ids": []uint{allowed.ID, denied.ID},
+		"patch":       map[string]any{"status": "completed"},
+	})
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	payload := decodeProductionLineCustomFieldResponse[bulkUpdateProgramsTestResponse](t, resp)
+	statuses := map[uint]string{}
+	for _, result := range payload.Results {
+		statuses[result.ProgramID] = result.Status
+	}
+	if statuses[allowed.ID] != bulkUpdateStatusRolledBack || statuses[denied.ID] != bulkUpdateStatusFailed {
+		t.Fatalf("unexpected results: %+v", payload.Results)
+	}
+	assertBulkUpdateProgramStatus(t, allowed.ID, "in_progress")
+	assertBulkUpdateProgramStatus(t, denied.ID, "in_progress")
+}
+
+func TestBulkUpdateProgramsChunkedModeReportsPerProgramResults(t *testing.T) {
+	r, _, userToken, lineA, lineB := setupProgramBulkUpdateTest(t)
+	allowed := createBulkUpdateTestPrograms(t, lineA.ID, "A", 3)
+	denied := createBulkUpdateTestPrograms(t, lineB.ID, "B", 1)[0]
+
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/programs/bulk-update", userToken, map[string]any{
+		"program_ids": []uint{allowed[0].ID, allowed[1].ID, denied.ID, allowed[2].ID, allowed[0].ID},
+		"patch":       map[string]any{"description": "批量修改"},
+		"mode":        "chunked",
+		"chunk_size":  2,
+	})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	payload := decodeProductionLineCustomFieldResponse[bulkUpdateProgramsTestResponse](t, resp)
+	if payload.Total != 5 || payload.Succeeded != 3 || payload.Failed != 1 || payload.Skipped != 1 {
+		t.Fatalf("unexpected summary: %+v", payload)
+	}
+
+	var count int64
+	database.DB.Model(&models.Program{}).Where("description = ?", "批量修改").Count(&count)
+	if count != 3 {
+		t.Fatalf("updated programs = %d, want 3", count)
+	}
+}
+
+func TestBulkUpdateProgramsByFilter(t *testing.T) {
+	r, adminToken, _, lineA, lineB := setupProgramBulkUpdateTest(t)
+	inLine := createBulkUpdateTestPrograms(t, lineA.ID, "A", 2)
+	otherLine := createBulkUpdateTestPrograms(t, lineB.ID, "B", 1)[0]
+
+	path := fmt.Sprintf("/api/programs/bulk-update?production_line_id=%d", lineA.ID)
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, path, adminToken, map[string]any{
+		"patch": map[string]any{"status": "completed"},
+	})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	for _, program := range inLine {
+		assertBulkUpdateProgramStatus(t, program.ID, "completed")
+	}
+	assertBulkUpdateProgramStatus(t, otherLine.ID, "in_progress")
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, path, adminToken, map[string]any{
+		"program_ids": []uint{otherLine.ID},
+		"patch":       map[string]any{"status": "completed"},
+	})
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected filter and ids to be rejected, got %d", resp.Code)
+	}
+}
+
+func TestBulkUpdateProgramsRejectsInvalidPatch(t *testing.T) {
+	r, adminToken, _, lineA, _ := setupProgramBulkUpdateTest(t)
+	program := createBulkUpdateTestPrograms(t, lineA.ID, "A", 1)[0]
+
+	for name, body := range map[string]map[string]any{
+		"empty patch":    {"program_ids": []uint{program.ID}, "patch": map[string]any{}},
+		"invalid status": {"program_ids": []uint{program.ID}, "patch": map[string]any{"status": "unknown"}},
+		"invalid mode":   {"program_ids": []uint{program.ID}, "patch": map[string]any{"status": "completed"}, "mode": "eventually"},
+		"no target":      {"patch": map[string]any{"status": "completed"}},
+	} {
+		resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/programs/bulk-update", adminToken, body)
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, body = %s", name, resp.Code, resp.Body.String())
+		}
+	}
+}
+
+func TestBulkUpdateProgramsRejectsDeniedTargetVehicleModel(t *testing.T) {
+	r, _, userToken, lineA, _ := setupProgramBulkUpdateTest(t)
+	programs := createBulkUpdateTestPrograms(t, lineA.ID, "A", 2)
+
+	restricted := models.VehicleModel{Name: "受限车型", Code: "VM-RESTRICTED", Status: "active"}
+	if err := database.DB.Create(&restricted).Error; err != nil {
+		t.Fatalf("create vehicle model: %v", err)
+	}
+	var user models.User
+	if err := database.DB.Where("employee_id = ?", "EMP-BULK-001").First(&user).Error; err != nil {
+		t.Fatalf("load user: %v", err)
+	}
+	if err := services.SavePermissionRuleChanges(services.PermissionSubject{Type: models.PermissionSubjectUser, ID: user.ID}, []services.PermissionRuleChange{
+		{ResourceType: models.PermissionResourceVehicleModel, ResourceID: restricted.ID, Action: models.PermissionActionManage, Decision: models.PermissionDecisionDeny},
+	}); err != nil {
+		t.Fatalf("save vehicle model rule: %v", err)
+	}
+
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/programs/bulk-update", userToken, map[string]any{
+		"program_ids": []uint{programs[0].ID, programs[1].ID},
+		"patch":       map[string]any{"vehicle_model_id": restricted.ID},
+		"mode":        "chunked",
+	})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	payload := decodeProductionLineCustomFieldResponse[bulkUpdateProgramsTestResponse](t, resp)
+	if payload.Failed != 2 || payload.Succeeded != 0 {
+		t.Fatalf("expected moves into a denied vehicle model to fail, got %+v", payload)
+	}
+	var moved int64
+	database.DB.Model(&models.Program{}).Where("vehicle_model_id = ?", restricted.ID).Count(&moved)
+	if moved != 0 {
+		t.Fatalf("programs moved into denied vehicle model = %d", moved)
+	}
+}
+
+func TestBulkUpdateProgramsByFilterSkipsProgramsHiddenByProgramRules(t *testing.T) {
+	r, _, userToken, lineA, _ := setupProgramBulkUpdateTest(t)
+	programs := createBulkUpdateTestPrograms(t, lineA.ID, "A", 2)
+	hidden := programs[0]
+
+	var user models.User
+	if err := database.DB.Where("employee_id = ?", "EMP-BULK-001").First(&user).Error; err != nil {
+		t.Fatalf("load user: %v", err)
+	}
+	if err := services.SavePermissionRuleChanges(services.PermissionSubject{Type: models.PermissionSubjectUser, ID: user.ID}, []services.PermissionRuleChange{
+		{ResourceType: models.PermissionResourceProgram, ResourceID: hidden.ID, Action: models.PermissionActionView, Decision: models.PermissionDecisionDeny},
+		{ResourceType: models.PermissionResourceProgram, ResourceID: hidden.ID, Action: models.PermissionActionDownload, Decision: models.PermissionDecisionDeny},
+		{ResourceType: models.PermissionResourceProgram, ResourceID: hidden.ID, Action: models.PermissionActionUpload, Decision: models.PermissionDecisionDeny},
+		{ResourceType: models.PermissionResourceProgram, ResourceID: hidden.ID, Action: models.PermissionActionManage, Decision: models.PermissionDecisionDeny},
+	}); err != nil {
+		t.Fatalf("save program rule: %v", err)
+	}
+
+	// 按筛选条件选择程序时与程序列表使用相同的可见范围，被程序规则隐藏的程序不会被选中
+	path := fmt.Sprintf("/api/programs/bulk-update?production_line_id=%d", lineA.ID)
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, path, userToken, map[string]any{
+		"patch": map[string]any{"status": "completed"},
+		"mode":  "chunked",
+	})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	payload := decodeProductionLineCustomFieldResponse[bulkUpdateProgramsTestResponse](t, resp)
+	if payload.Total != 1 || payload.Succeeded != 1 || payload.Results[0].ProgramID != programs[1].ID {
+		t.Fatalf("expected only the visible program to be selected, got %+v", payload)
+	}
+	assertBulkUpdateProgramStatus(t, hidden.ID, "in_progress")
+	assertBulkUpdateProgramStatus(t, programs[1].ID, "completed")
+}
@@ -0,0 +1,524 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"crane-system/database"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errProgramCodeDuplicate     = errors.New("程序编号已存在")
+	errProgramCodePatternNotSet = errors.New("生产线未配置编号规则，请填写程序编号")
+	errProgramCodeExhausted     = errors.New("无法生成不重复的程序编号")
+	errPreviewRollback          = errors.New("preview rollback")
+)
+
+const (
+	programCodeMaxLength       = 100
+	programCodeMaxAttempts     = 20
+	programCodeSequenceMarker  = "{seq}"
+	programCodeNoVehicleModel  = "NA"
+	programCodeMaxSequenceSize = 9
+)
+
+var programCodeTokenPattern = regexp.MustCompile(`\{([a-z_]+)(?::(\d+))?\}`)
+
+func validProgramCodeScope(scope string) bool {
+	switch scope {
+	case models.ProgramCodeScopeNone, models.ProgramCodeScopeLine, models.ProgramCodeScopeLineModel, models.ProgramCodeScopeGlobal:
+		return true
+	default:
+		return false
+	}
+}
+
+// validateProgramCodePattern 校验编号模板。
+// 支持 {line} {model} {yyyy} {yy} {mm} {dd} 和必须出现一次的 {seq:N}（N 为补零位数）。
+func validateProgramCodePattern(pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+	seqCount := 0
+	for _, match := range programCodeTokenPattern.FindAllStringSubmatch(pattern, -1) {
+		switch match[1] {
+		case "line", "model", "yyyy", "yy", "mm", "dd":
+			if match[2] != "" {
+				return fmt.Errorf("编号模板占位符 {%s} 不支持位数", match[1])
+			}
+		case "seq":
+			seqCount++
+			if match[2] != "" {
+				width, err := strconv.Atoi(match[2])
+				if err != nil || width < 1 || width > programCodeMaxSequenceSize {
+					return errors.New("流水号位数必须在 1-9 之间")
+				}
+			}
+		default:
+			return fmt.Errorf("编号模板包含未知占位符 {%s}", match[1])
+		}
+	}
+	if seqCount != 1 {
+		return errors.New("编号模板必须包含且仅包含一个 {seq} 占位符")
+	}
+	rest := programCodeTokenPattern.ReplaceAllString(pattern, "")
+	if strings.ContainsAny(rest, "{}") {
+		return errors.New("编号模板格式错误")
+	}
+	return nil
+}
+
+// renderProgramCodePrefix 把模板中除流水号以外的占位符替换为实际值，流水号位置保留 {seq} 标记。
+func renderProgramCodePrefix(pattern, lineCode, modelCode string, now time.Time) (string, int) {
+	width := 0
+	prefix := programCodeTokenPattern.ReplaceAllStringFunc(pattern, func(token string) string {
+		match := programCodeTokenPattern.FindStringSubmatch(token)
+		switch match[1] {
+		case "line":
+			return lineCode
+		case "model":
+			return modelCode
+		case "yyyy":
+			return now.Format("2006")
+		case "yy":
+			return now.Format("06")
+		case "mm":
+			return now.Format("01")
+		case "dd":
+			return now.Format("02")
+		case "seq":
+			width, _ = strconv.Atoi(match[2])
+			return programCodeSequenceMarker
+		}
+		return token
+	})
+	return prefix, width
+}
+
+func formatProgramCode(prefix string, width int, value int64) string {
+	return strings.Replace(prefix, programCodeSequenceMarker, fmt.Sprintf("%0*d", width, value), 1)
+}
+
+func loadProgramCodeRule(tx *gorm.DB, lineID uint) (models.ProgramCodeRule, error) {
+	var rule models.ProgramCodeRule
+	if err := tx.Where("production_line_id = ?", lineID).First(&rule).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.ProgramCodeRule{ProductionLineID: lineID, UniqueScope: models.ProgramCodeScopeLine}, nil
+		}
+		return models.ProgramCodeRule{}, err
+	}
+	return rule, nil
+}
+
+// lockProgramCodeRule 读取并锁定产线编号规则，未配置时先写入默认规则，使同一产线上的编号校验和分配串行执行。
+// 全局唯一时锁定全部全局规则，跨产线的分配同样串行。需在事务中调用。
+func lockProgramCodeRule(tx *gorm.DB, lineID uint) (models.ProgramCodeRule, error) {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.ProgramCodeRule{
+		ProductionLineID: lineID,
+		UniqueScope:      models.ProgramCodeScopeLine,
+	}).Error; err != nil {
+		return models.ProgramCodeRule{}, err
+	}
+	rule, err := loadProgramCodeRule(tx, lineID)
+	if err != nil {
+		return rule, err
+	}
+	if rule.UniqueScope == models.ProgramCodeScopeGlobal {
+		var rules []models.ProgramCodeRule
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("unique_scope = ? OR production_line_id = ?", models.ProgramCodeScopeGlobal, lineID).
+			Order("id ASC").Find(&rules).Error; err != nil {
+			return rule, err
+		}
+	} else if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("production_line_id = ?", lineID).First(&models.ProgramCodeRule{}).Error; err != nil {
+		return rule, err
+	}
+	// 等待锁期间规则可能被修改，以加锁后的内容为准
+	return loadProgramCodeRule(tx, lineID)
+}
+
+// ensureProgramCodeUnique 按唯一性范围检查编号是否已被其他程序占用，excludeID 用于更新时排除自身。
+func ensureProgramCodeUnique(tx *gorm.DB, scope, code string, lineID, vehicleModelID, excludeID uint) error {
+	query := tx.Model(&models.Program{}).Where("code = ?", code)
+	switch scope {
+	case models.ProgramCodeScopeNone:
+		return nil
+	case models.ProgramCodeScopeLine:
+		query = query.Where("production_line_id = ?", lineID)
+	case models.ProgramCodeScopeLineModel:
+		query = query.Where("production_line_id = ? AND vehicle_model_id = ?", lineID, vehicleModelID)
+	}
+	if excludeID != 0 {
+		query = query.Where("id <> ?", excludeID)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errProgramCodeDuplicate
+	}
+	return nil
+}
+
+// nextProgramCodeSequence 取出前缀的下一个流水号并自增，需在事务中调用以保证并发安全。
+func nextProgramCodeSequence(tx *gorm.DB, lineID uint, prefix string) (int64, error) {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.ProgramCodeSequence{
+		ProductionLineID: lineID,
+		Prefix:           prefix,
+		NextValue:        1,
+	}).Error; err != nil {
+		return 0, err
+	}
+
+	var sequence models.ProgramCodeSequence
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("production_line_id = ? AND prefix = ?", lineID, prefix).
+		First(&sequence).Error; err != nil {
+		return 0, err
+	}
+	value := sequence.NextValue
+	if err := tx.Model(&sequence).Update("next_value", value+1).Error; err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+func loadProgramCodeContext(tx *gorm.DB, lineID, vehicleModelID uint) (string, string, error) {
+	var line models.ProductionLine
+	if err := tx.Select("id", "code").First(&line, lineID).Error; err != nil {
+		return "", "", err
+	}
+	modelCode := programCodeNoVehicleModel
+	if vehicleModelID > 0 {
+		var vehicleModel models.VehicleModel
+		if err := tx.Select("id", "code").First(&vehicleModel, vehicleModelID).Error; err != nil {
+			return "", "", err
+		}
+		modelCode = vehicleModel.Code
+	}
+	return line.Code, modelCode, nil
+}
+
+// generateProgramCode 按产线编号规则生成程序编号，遇到与现有编号冲突时跳过该流水号继续尝试。
+func generateProgramCode(tx *gorm.DB, rule models.ProgramCodeRule, vehicleModelID uint, now time.Time) (string, error) {
+	if rule.Pattern == "" {
+		return "", errProgramCodePatternNotSet
+	}
+	lineCode, modelCode, err := loadProgramCodeContext(tx, rule.ProductionLineID, vehicleModelID)
+	if err != nil {
+		return "", err
+	}
+	prefix, width := renderProgramCodePrefix(rule.Pattern, lineCode, modelCode, now)
+
+	scope := rule.UniqueScope
+	if scope == models.ProgramCodeScopeNone {
+		scope = models.ProgramCodeScopeLine
+	}
+	for attempt := 0; attempt < programCodeMaxAttempts; attempt++ {
+		value, err := nextProgramCodeSequence(tx, rule.ProductionLineID, prefix)
+		if err != nil {
+			return "", err
+		}
+		code := formatProgramCode(prefix, width, value)
+		if len(code) > programCodeMaxLength {
+			return "", errors.New("生成的程序编号超过 100 个字符")
+		}
+		err = ensureProgramCodeUnique(tx, scope, code, rule.ProductionLineID, vehicleModelID, 0)
+		if err == nil {
+			return code, nil
+		}
+		if !errors.Is(err, errProgramCodeDuplicate) {
+			return "", err
+		}
+	}
+	return "", errProgramCodeExhausted
+}
+
+// ensureProgramCodeUniqueOnUpdate 在编号、产线或车型变化影响唯一性范围时校验更新后的编号。
+// 仅修改与范围无关的字段时不校验，避免历史重复数据阻塞其他字段的编辑。
+func ensureProgramCodeUniqueOnUpdate(tx *gorm.DB, program models.Program, nextCode string, nextLineID, nextVehicleModelID uint) error {
+	codeChanged := nextCode != program.Code
+	lineChanged := nextLineID != program.ProductionLineID
+	modelChanged := nextVehicleModelID != program.VehicleModelID
+	if !codeChanged && !lineChanged && !modelChanged {
+		return nil
+	}
+
+	rule, err := lockProgramCodeRule(tx, nextLineID)
+	if err != nil {
+		return err
+	}
+	switch rule.UniqueScope {
+	case models.ProgramCodeScopeLine:
+		if !codeChanged && !lineChanged {
+			return nil
+		}
+	case models.ProgramCodeScopeGlobal:
+		if !codeChanged {
+			return nil
+		}
+	}
+	return ensureProgramCodeUnique(tx, rule.UniqueScope, nextCode, nextLineID, nextVehicleModelID, program.ID)
+}
+
+// assignProgramCode 为新建程序确定编号：未填写时按规则生成，填写时按唯一性范围校验。
+func assignProgramCode(tx *gorm.DB, program *models.Program) error {
+	rule, err := lockProgramCodeRule(tx, program.ProductionLineID)
+	if err != nil {
+		return err
+	}
+	if program.Code == "" {
+		program.Code, err = generateProgramCode(tx, rule, program.VehicleModelID, time.Now())
+		return err
+	}
+	return ensureProgramCodeUnique(tx, rule.UniqueScope, program.Code, program.ProductionLineID, program.VehicleModelID, 0)
+}
+
+func isProgramCodeError(err error) bool {
+	return errors.Is(err, errProgramCodeDuplicate) ||
+		errors.Is(err, errProgramCodePatternNotSet) ||
+		errors.Is(err, errProgramCodeExhausted)
+}
+
+func programCodeErrorStatusCode(err error) int {
+	if errors.Is(err, errProgramCodeDuplicate) {
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+type programCodeRuleRequest struct {
+	Pattern     string `json:"pattern"`
+	UniqueScope string `json:"unique_scope"`
+}
+
+// GetProgramCodeRule 返回产线编号规则；未配置时返回默认规则（不自动生成，产线内唯一）。
+func GetProgramCodeRule(c *gin.Context) {
+	lineID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "生产线ID格式错误"})
+		return
+	}
+	if !authorizeLineAction(c, lineID, lineActionView) {
+		return
+	}
+	if _, err := findProductionLine(lineID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "生产线不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		}
+		return
+	}
+
+	rule, err := loadProgramCodeRule(database.DB, lineID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+func SaveProgramCodeRule(c *gin.Context) {
+	lineID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "生产线ID格式错误"})
+		return
+	}
+	if _, err := findProductionLine(lineID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "生产线不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		}
+		return
+	}
+
+	var req programCodeRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Pattern = strings.TrimSpace(req.Pattern)
+	req.UniqueScope = strings.TrimSpace(req.UniqueScope)
+	if req.UniqueScope == "" {
+		req.UniqueScope = models.ProgramCodeScopeLine
+	}
+	if !validProgramCodeScope(req.UniqueScope) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unique_scope 仅支持 none、line、line_model、global"})
+		return
+	}
+	if err := validateProgramCodePattern(req.Pattern); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule, err := loadProgramCodeRule(database.DB, lineID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	before := rule
+	rule.Pattern = req.Pattern
+	rule.UniqueScope = req.UniqueScope
+	if err := database.DB.Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	recordAuditChange(c, "program_code_rule", lineID, before, rule)
+	c.JSON(http.StatusOK, rule)
+}
+
+// PreviewProgramCode 预览下一个自动生成的编号，不占用流水号。
+func PreviewProgramCode(c *gin.Context) {
+	lineID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "生产线ID格式错误"})
+		return
+	}
+	if !authorizeLineAction(c, lineID, lineActionView) {
+		return
+	}
+	var vehicleModelID uint
+	if raw := strings.TrimSpace(c.Query("vehicle_model_id")); raw != "" {
+		vehicleModelID, err = parseUintParam(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "车型ID格式错误"})
+			return
+		}
+	}
+
+	rule, err := loadProgramCodeRule(database.DB, lineID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	if rule.Pattern == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errProgramCodePatternNotSet.Error()})
+		return
+	}
+
+	var code string
+	// 在回滚的事务中走一遍生成流程，保证预览结果与真实生成逻辑一致
+	txErr := database.DB.Transaction(func(tx *gorm.DB) error {
+		generated, err := generateProgramCode(tx, rule, vehicleModelID, time.Now())
+		if err != nil {
+			return err
+		}
+		code = generated
+		return errPreviewRollback
+	})
+	if !errors.Is(txErr, errPreviewRollback) {
+		if errors.Is(txErr, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "生产线或车型不存在"})
+			return
+		}
+		if isProgramCodeError(txErr) {
+			c.JSON(programCodeErrorStatusCode(txErr), gin.H{"error": txErr.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": code})
+}
+
+type programCodeDuplicateProgram struct {
+	ID               uint   `json:"id"`
+	Name             string `json:"name"`
+	ProductionLineID uint   `json:"production_line_id"`
+	VehicleModelID   uint   `json:"vehicle_model_id"`
+}
+
+type programCodeDuplicateGroup struct {
+	Code             string                        `json:"code"`
+	ProductionLineID uint                          `json:"production_line_id,omitempty"`
+	VehicleModelID   uint                          `json:"vehicle_model_id,omitempty"`
+	Count            int                           `json:"count"`
+	Programs         []programCodeDuplicateProgram `json:"programs"`
+}
+
+// GetProgramCodeDuplicates 报告现有数据中按指定范围重复的程序编号，只统计当前用户可查看的产线。
+func GetProgramCodeDuplicates(c *gin.Context) {
+	scope := strings.TrimSpace(c.DefaultQuery("scope", models.ProgramCodeScopeLine))
+	if !validProgramCodeScope(scope) || scope == models.ProgramCodeScopeNone {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope 仅支持 line、line_model、global"})
+		return
+	}
+
+	programScope, statusCode, message := resolveAuthorizedProgramScope(c, lineActionView)
+	if statusCode != 0 {
+		c.JSON(statusCode, gin.H{"error": message})
+		return
+	}
+	if programScope.Empty() {
+		c.JSON(http.StatusOK, gin.H{"scope": scope, "total": 0, "groups": []programCodeDuplicateGroup{}})
+		return
+	}
+
+	query := programScope.Apply(database.DB.Model(&models.Program{}), "")
+	if lineID := strings.TrimSpace(c.Query("production_line_id")); lineID != "" {
+		query = query.Where("production_line_id = ?", lineID)
+	}
+
+	var programs []models.Program
+	if err := query.Select("id", "name", "code", "production_line_id", "vehicle_model_id").Order("id ASC").Find(&programs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	groupMap := make(map[string]*programCodeDuplicateGroup)
+	keys := make([]string, 0)
+	for _, program := range programs {
+		group := programCodeDuplicateGroup{Code: program.Code}
+		switch scope {
+		case models.ProgramCodeScopeLine:
+			group.ProductionLineID = program.ProductionLineID
+		case models.ProgramCodeScopeLineModel:
+			group.ProductionLineID = program.ProductionLineID
+			group.VehicleModelID = program.VehicleModelID
+		}
+		key := fmt.Sprintf("%s\x00%d\x00%d", group.Code, group.ProductionLineID, group.VehicleModelID)
+		existing, ok := groupMap[key]
+		if !ok {
+			existing = &group
+			groupMap[key] = existing
+			keys = append(keys, key)
+		}
+		existing.Count++
+		existing.Programs = append(existing.Programs, programCodeDuplicateProgram{
+			ID:               program.ID,
+			Name:             program.Name,
+			ProductionLineID: program.ProductionLineID,
+			VehicleModelID:   program.VehicleModelID,
+		})
+	}
+
+	groups := make([]programCodeDuplicateGroup, 0)
+	for _, key := range keys {
+		if groupMap[key].Count > 1 {
+			groups = append(groups, *groupMap[key])
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].Code < groups[j].Code
+	})
+
+	c.JSON(http.StatusOK, gin.H{"scope": scope, "total": len(groups), "groups": groups})
+}
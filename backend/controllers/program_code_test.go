@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func setupProgramCodeTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	{
+		api.GET("/production-lines/:id/program-code-rule", GetProgramCodeRule)
+		api.PUT("/production-lines/:id/program-code-rule", SaveProgramCodeRule)
+		api.GET("/production-lines/:id/program-code-rule/preview", PreviewProgramCode)
+		api.GET("/programs/code-duplicates", GetProgramCodeDuplicates)
+		api.POST("/programs", CreateProgram)
+		api.PUT("/programs/:id", UpdateProgram)
+	}
+	return r
+}
+
+func programCodeRulePath(lineID uint) string {
+	return fmt.Sprintf("/api/production-lines/%d/program-code-rule", lineID)
+}
+
+func TestValidateProgramCodePattern(t *testing.T) {
+	valid := []string{"", "{line}-{model}-{seq:4}", "P{yyyy}{mm}{dd}{seq}", "{yy}-{seq:9}"}
+	for _, pattern := range valid {
+		if err := validateProgramCodePattern(pattern); err != nil {
+			t.Fatalf("pattern %q: unexpected error %v", pattern, err)
+		}
+	}
+	invalid := []string{"{line}-{model}", "{seq}-{seq}", "{line}-{unknown}-{seq}", "{seq:0}", "{seq:10}", "{line:2}-{seq}", "{line-{seq}"}
+	for _, pattern := range invalid {
+		if err := validateProgramCodePattern(pattern); err == nil {
+			t.Fatalf("pattern %q: expected error", pattern)
+		}
+	}
+}
+
+func TestCreateProgramGeneratesCodeFromLineRule(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	token, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	vehicle := models.VehicleModel{Name: "车型A", Code: "VM01"}
+	if err := database.DB.Create(&vehicle).Error; err != nil {
+		t.Fatalf("create vehicle model: %v", err)
+	}
+	r := setupProgramCodeTestRouter()
+
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPut, programCodeRulePath(line.ID), token, map[string]any{
+		"pattern": "{line}-{model}-{seq:4}",
+	})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("save rule: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+
+	previewPath := fmt.Sprintf("%s/preview?vehicle_model_id=%d", programCodeRulePath(line.ID), vehicle.ID)
+	for i := 0; i < 2; i++ {
+		resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, previewPath, token, nil)
+		preview := decodeProductionLineCustomFieldResponse[map[string]string](t, resp)
+		if preview["code"] != "LINE-001-VM01-0001" {
+			t.Fatalf("preview code = %q, body = %s", preview["code"], resp.Body.String())
+		}
+	}
+
+	codes := make([]string, 0, 2)
+	for i := 0; i < 2; i++ {
+		resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/programs", token, map[string]any{
+			"name":               fmt.Sprintf("程序%d", i),
+			"production_line_id": line.ID,
+			"vehicle_model_id":   vehicle.ID,
+		})
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("create program: status = %d, body = %s", resp.Code, resp.Body.String())
+		}
+		codes = append(codes, decodeProductionLineCustomFieldResponse[models.Program](t, resp).Code)
+	}
+	if codes[0] != "LINE-001-VM01-0001" || codes[1] != "LINE-001-VM01-0002" {
+		t.Fatalf("generated codes = %v", codes)
+	}
+
+	// 手工占用下一个流水号后，自动生成应跳过冲突编号
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/programs", token, map[string]any{
+		"name":               "手工程序",
+		"code":               "LINE-001-NA-0001",
+		"production_line_id": line.ID,
+	})
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create manual program: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/programs", token, map[string]any{
+		"name":               "无车型程序",
+		"production_line_id": line.ID,
+	})
+	if code := decodeProductionLineCustomFieldResponse[models.Program](t, resp).Code; code != "LINE-001-NA-0002" {
+		t.Fatalf("generated code without vehicle model = %q", code)
+	}
+}
+
+func TestProgramCodeUniquenessFollowsScope(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	token, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	vehicleA := models.VehicleModel{Name: "车型A", Code: "VMA"}
+	vehicleB := models.VehicleModel{Name: "车型B", Code: "VMB"}
+	if err := database.DB.Create(&vehicleA).Error; err != nil {
+		t.Fatalf("create vehicle model: %v", err)
+	}
+	if err := database.DB.Create(&vehicleB).Error; err != nil {
+		t.Fatalf("create vehicle model: %v", err)
+	}
+	r := setupProgramCodeTestRouter()
+
+	createProgram := func(code string, vehicleModelID uint) *models.Program {
+		resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/programs", token, map[string]any{
+			"name":               "程序",
+			"code":               code,
+			"production_line_id": line.ID,
+			"vehicle_model_id":   vehicleModelID,
+		})
+		if resp.Code != http.StatusCreated {
+			if resp.Code != http.StatusConflict {
+				t.Fatalf("create program: status = %d, body = %s", resp.Code, resp.Body.String())
+			}
+			return nil
+		}
+		program := decodeProductionLineCustomFieldResponse[models.Program](t, resp)
+		return &program
+	}
+
+	// 默认规则：产线内唯一
+	if createProgram("DUP-001", vehicleA.ID) == nil {
+		t.Fatalf("expected first program to be created")
+	}
+	if createProgram("DUP-001", vehicleB.ID) != nil {
+		t.Fatalf("expected duplicate code on the same line to be rejected")
+	}
+
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPut, programCodeRulePath(line.ID), token, map[string]any{"unique_scope": "line_model"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("save rule: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	programB := createProgram("DUP-001", vehicleB.ID)
+	if programB == nil {
+		t.Fatalf("expected same code with another vehicle model to be allowed")
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPut, fmt.Sprintf("/api/programs/%d", programB.ID), token, map[string]any{"vehicle_model_id": vehicleA.ID})
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("moving program into an occupied scope: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPut, fmt.Sprintf("/api/programs/%d", programB.ID), token, map[string]any{"description": "仅修改描述"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unrelated update: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, "/api/programs/code-duplicates?scope=line", token, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("duplicates: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	report := decodeProductionLineCustomFieldResponse[struct {
+		Total  int                         `json:"total"`
+		Groups []programCodeDuplicateGroup `json:"groups"`
+	}](t, resp)
+	if report.Total != 1 || report.Groups[0].Code != "DUP-001" || report.Groups[0].Count != 2 {
+		t.Fatalf("unexpected duplicate report: %+v", report)
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, "/api/programs/code-duplicates?scope=line_model", token, nil)
+	report = decodeProductionLineCustomFieldResponse[struct {
+		Total  int                         `json:"total"`
+		Groups []programCodeDuplicateGroup `json:"groups"`
+	}](t, resp)
+	if report.Total != 0 {
+		t.Fatalf("expected no duplicates within line_model scope: %+v", report)
+	}
+}
+
+func TestAssignProgramCodeSerializesConcurrentCreates(t *testing.T) {
+	// 多个连接同时写入才能复现并发，使用带忙等待的文件数据库而不是内存数据库
+	dsn := filepath.Join(t.TempDir(), "codes.db") + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+		Logger:                                   logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open sqlite db: %v", err)
+	}
+	if err := db.AutoMigrate(&models.ProductionLine{}, &models.Program{}, &models.ProgramCodeRule{}, &models.ProgramCodeSequence{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	line := models.ProductionLine{Name: "并发产线", Code: "LINE-RACE"}
+	if err := db.Create(&line).Error; err != nil {
+		t.Fatalf("create line: %v", err)
+	}
+
+	// 查重后稍作等待，让未加锁时各事务的查重和写入交错
+	const workers = 8
+	var arrived atomic.Int32
+	allChecked := make(chan struct{})
+	if err := db.Callback().Query().After("gorm:query").Register("test:code_check_barrier", func(tx *gorm.DB) {
+		if tx.Statement.Table != "programs" {
+			return
+		}
+		if arrived.Add(1) == workers {
+			close(allChecked)
+		}
+		select {
+		case <-allChecked:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}); err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- db.Transaction(func(tx *gorm.DB) error {
+				program := models.Program{Name: fmt.Sprintf("程序%d", i), Code: "P-RACE", ProductionLineID: line.ID, Version: "v1", Status: "draft"}
+				if err := assignProgramCode(tx, &program); err != nil {
+					return err
+				}
+				return tx.Create(&program).Error
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, errProgramCodeDuplicate):
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	var count int64
+	db.Model(&models.Program{}).Where("code = ?", "P-RACE").Count(&count)
+	if created != 1 || count != 1 {
+		t.Fatalf("created = %d, stored = %d", created, count)
+	}
+}
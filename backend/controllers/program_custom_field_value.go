@@ -20,6 +20,45 @@ type programCustomFieldValueInput struct {
 }
 
 func replaceProgramCustomFieldValues(tx *gorm.DB, program models.Program, inputs []programCustomFieldValueInput) ([]models.ProgramCustomFieldValue, error) {
+	newValues, err := buildProgramCustomFieldValues(tx, program, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where("program_id = ?", program.ID).Delete(&models.ProgramCustomFieldValue{}).Error; err != nil {
+		return nil, err
+	}
+	if len(newValues) > 0 {
+		if err := tx.Create(&newValues).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return newValues, nil
+}
+
+// mergeProgramCustomFieldValues 只覆盖输入中出现的字段，其余字段值保持不变。
+// 批量编辑按字段打补丁时使用，避免误清空程序的其他自定义字段。
+func mergeProgramCustomFieldValues(tx *gorm.DB, program models.Program, inputs []programCustomFieldValueInput) ([]models.ProgramCustomFieldValue, error) {
+	newValues, err := buildProgramCustomFieldValues(tx, program, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range newValues {
+		if err := tx.Where("program_id = ? AND production_line_custom_field_id = ?", program.ID, newValues[i].ProductionLineCustomFieldID).
+			Delete(&models.ProgramCustomFieldValue{}).Error; err != nil {
+			return nil, err
+		}
+		if err := tx.Create(&newValues[i]).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return newValues, nil
+}
+
+func buildProgramCustomFieldValues(tx *gorm.DB, program models.Program, inputs []programCustomFieldValueInput) ([]models.ProgramCustomFieldValue, error) {
 	newValues := make([]models.ProgramCustomFieldValue, 0, len(inputs))
 	seenFieldIDs := make(map[uint]struct{}, len(inputs))
 	for _, input := range inputs {
@@ -66,19 +105,18 @@ func replaceProgramCustomFieldValues(tx *gorm.DB, program models.Program, inputs
 			Value:                       value,
 		})
 	}
-
-	if err := tx.Where("program_id = ?", program.ID).Delete(&models.ProgramCustomFieldValue{}).Error; err != nil {
-		return nil, err
-	}
-	if len(newValues) > 0 {
-		if err := tx.Create(&newValues).Error; err != nil {
-			return nil, err
-		}
-	}
-
 	return newValues, nil
 }
 
+// isProgramCustomFieldInputError 判断错误是否属于调用方输入问题（应返回 400 而不是 500）。
+func isProgramCustomFieldInputError(err error) bool {
+	return errors.Is(err, errProgramCustomFieldFieldIDRequired) ||
+		errors.Is(err, errProgramCustomFieldDuplicateFieldID) ||
+		errors.Is(err, errProgramCustomFieldNotBelongToProductionLine) ||
+		errors.Is(err, errProgramCustomFieldInvalidSelectValue) ||
+		errors.Is(err, errProgramCustomFieldDisabled)
+}
+
 func SaveProgramCustomFieldValues(c *gin.Context) {
 	programID, err := parseUintParam(c.Param("id"))
 	if err != nil {
@@ -107,7 +145,7 @@ func SaveProgramCustomFieldValues(c *gin.Context) {
 		if err := tx.First(&program, targetProgramID).Error; err != nil {
 			return err
 		}
-		if !authorizeLineAction(c, program.ProductionLineID, lineActionManage) {
+		if !authorizeProgramScopeAction(c, program.ID, program.ProductionLineID, program.VehicleModelID, lineActionManage) {
 			return errors.New("forbidden")
 		}
 
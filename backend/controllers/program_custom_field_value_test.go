@@ -1501,14 +1501,21 @@ func TestExportProgramsExcelReturnsAttachment(t *testing.T) {
 	}
 }
 
+func createTaskStatusTestJob(t *testing.T, job models.Job) uint {
+	t.Helper()
+	job.Type = jobTypeBatchImport
+	job.RunAfter = time.Now()
+	if err := database.DB.Create(&job).Error; err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	return job.ID
+}
+
 func TestGetTaskStatusReturnsNotFoundAfterExpiration(t *testing.T) {
 	r, token, _, _ := setupProgramCustomFieldValueTest(t)
 
-	batchTaskMu.Lock()
-	taskID := batchTaskSeq
-	batchTaskSeq++
-	batchTasks[taskID] = &batchImportTaskStatus{Status: "completed", OwnerUser: 1, ExpiresAt: time.Now().Add(-time.Second)}
-	batchTaskMu.Unlock()
+	finishedAt := time.Now().Add(-jobRetention - time.Second)
+	taskID := createTaskStatusTestJob(t, models.Job{Status: jobStatusCompleted, OwnerID: 1, FinishedAt: &finishedAt})
 
 	resp := performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/tasks/%d/status", taskID), token, nil)
 	if resp.Code != http.StatusNotFound {
@@ -1519,11 +1526,7 @@ func TestGetTaskStatusReturnsNotFoundAfterExpiration(t *testing.T) {
 func TestGetTaskStatusReturnsTaskBeforeExpiration(t *testing.T) {
 	r, token, _, _ := setupProgramCustomFieldValueTest(t)
 
-	batchTaskMu.Lock()
-	taskID := batchTaskSeq
-	batchTaskSeq++
-	batchTasks[taskID] = &batchImportTaskStatus{Status: "processing", OwnerUser: 1, Total: 2, Processed: 1, ExpiresAt: time.Now().Add(time.Minute)}
-	batchTaskMu.Unlock()
+	taskID := createTaskStatusTestJob(t, models.Job{Status: jobStatusRunning, OwnerID: 1, Total: 2, Processed: 1})
 
 	resp := performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/tasks/%d/status", taskID), token, nil)
 	if resp.Code != http.StatusOK {
@@ -1547,11 +1550,7 @@ func TestGetTaskStatusRejectsNonOwner(t *testing.T) {
 	}
 	otherToken := createUserTokenForTest(t, otherUser.ID, "user")
 
-	batchTaskMu.Lock()
-	taskID := batchTaskSeq
-	batchTaskSeq++
-	batchTasks[taskID] = &batchImportTaskStatus{Status: "processing", OwnerUser: 1, Total: 2, Processed: 1, ExpiresAt: time.Now().Add(time.Minute)}
-	batchTaskMu.Unlock()
+	taskID := createTaskStatusTestJob(t, models.Job{Status: jobStatusRunning, OwnerID: 1, Total: 2, Processed: 1})
 
 	resp := performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/tasks/%d/status", taskID), otherToken, nil)
 	if resp.Code != http.StatusForbidden {
@@ -1574,11 +1573,8 @@ func TestGetTaskStatusConcurrentPollingIsStable(t *testing.T) {
 	})
 	r.GET("/api/tasks/:task_id/status", GetTaskStatus)
 
-	batchTaskMu.Lock()
-	taskID := batchTaskSeq
-	batchTaskSeq++
-	batchTasks[taskID] = &batchImportTaskStatus{Status: "processing", OwnerUser: 1, Total: 100, Processed: 10, ExpiresAt: time.Now().Add(time.Minute)}
-	batchTaskMu.Unlock()
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	taskID := createTaskStatusTestJob(t, models.Job{Status: jobStatusRunning, OwnerID: 1, Total: 100, Processed: 10})
 
 	const workers = 12
 	const rounds = 20
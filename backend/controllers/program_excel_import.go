@@ -0,0 +1,987 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"crane-system/database"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	programExcelImportActionCreate    = "create"
+	programExcelImportActionUpdate    = "update"
+	programExcelImportActionUnchanged = "unchanged"
+	programExcelImportActionConflict  = "conflict"
+	programExcelImportActionError     = "error"
+
+	programExcelImportMatchAuto = "auto"
+	programExcelImportMatchID   = "id"
+	programExcelImportMatchCode = "code"
+
+	programExcelImportMaxRows = 5000
+)
+
+// programExcelImportReadOnlyKeys 是导出时附带、导入时忽略的统计类列。
+var programExcelImportReadOnlyKeys = map[string]struct{}{
+	"version":       {},
+	"created_at":    {},
+	"file_count":    {},
+	"version_count": {},
+}
+
+// programExcelImportColumn 描述表头与列 key 的对应关系。
+// 表头为自定义字段名称时 FieldName 非空，具体字段按行所在产线解析。
+type programExcelImportColumn struct {
+	Index     int    `json:"index"`
+	Header    string `json:"header"`
+	Key       string `json:"key"`
+	FieldName string `json:"field_name,omitempty"`
+}
+
+type programExcelImportRow struct {
+	Number int
+	Cells  []string
+}
+
+type programExcelImportChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+type programExcelImportItem struct {
+	Row       int                        `json:"row"`
+	Action    string                     `json:"action"`
+	ProgramID uint                       `json:"program_id,omitempty"`
+	Code      string                     `json:"code"`
+	Name      string                     `json:"name"`
+	Changes   []programExcelImportChange `json:"changes,omitempty"`
+	Error     string                     `json:"error,omitempty"`
+
+	// 以下字段仅在执行阶段使用
+	lineID            uint
+	vehicleModelID    uint
+	description       string
+	status            string
+	updates           map[string]interface{}
+	customFieldValues []programCustomFieldValueInput
+}
+
+type programExcelImportPreviewState struct {
+	Sheet     string
+	Columns   []programExcelImportColumn
+	Rows      []programExcelImportRow
+	MatchBy   string
+	OwnerUser uint
+	ExpiresAt time.Time
+}
+
+var (
+	programExcelImportPreviewMu  sync.Mutex
+	programExcelImportPreviewSeq int64 = 1
+	programExcelImportPreviews         = map[string]*programExcelImportPreviewState{}
+)
+
+func cleanupExpiredProgramExcelImportPreviewsLocked(now time.Time) {
+	for previewID, state := range programExcelImportPreviews {
+		if !state.ExpiresAt.After(now) {
+			delete(programExcelImportPreviews, previewID)
+		}
+	}
+}
+
+func createProgramExcelImportPreview(state *programExcelImportPreviewState) string {
+	programExcelImportPreviewMu.Lock()
+	defer programExcelImportPreviewMu.Unlock()
+
+	now := time.Now()
+	cleanupExpiredProgramExcelImportPreviewsLocked(now)
+	previewID := fmt.Sprintf("excel-preview-%d-%d", now.UnixNano(), programExcelImportPreviewSeq)
+	programExcelImportPreviewSeq++
+	state.ExpiresAt = now.Add(batchPreviewTTL)
+	programExcelImportPreviews[previewID] = state
+	return previewID
+}
+
+// consumeProgramExcelImportPreview 取出预览数据，预览只能执行一次。
+func consumeProgramExcelImportPreview(previewID string, ownerUser uint) (*programExcelImportPreviewState, error) {
+	programExcelImportPreviewMu.Lock()
+	defer programExcelImportPreviewMu.Unlock()
+
+	cleanupExpiredProgramExcelImportPreviewsLocked(time.Now())
+	state, ok := programExcelImportPreviews[previewID]
+	if !ok {
+		return nil, fmt.Errorf("preview_not_found")
+	}
+	if state.OwnerUser != ownerUser {
+		return nil, fmt.Errorf("preview_forbidden")
+	}
+	delete(programExcelImportPreviews, previewID)
+	return state, nil
+}
+
+// resolveProgramExcelImportColumns 将表头映射为导出使用的列 key，支持列 key、内置列名称和自定义字段名称三种写法。
+func resolveProgramExcelImportColumns(headers []string, customFields []models.ProductionLineCustomField) ([]programExcelImportColumn, []string) {
+	labelToKey := make(map[string]string)
+	for _, column := range builtinExportColumns() {
+		labelToKey[column.Key] = column.Key
+		labelToKey[column.Label] = column.Key
+	}
+	fieldNames := make(map[string]struct{}, len(customFields))
+	for _, field := range customFields {
+		fieldNames[field.Name] = struct{}{}
+	}
+
+	columns := make([]programExcelImportColumn, 0, len(headers))
+	ignored := make([]string, 0)
+	seenKeys := make(map[string]struct{}, len(headers))
+	for index, raw := range headers {
+		header := strings.TrimSpace(raw)
+		if header == "" {
+			continue
+		}
+		column := programExcelImportColumn{Index: index, Header: header}
+		if key, ok := labelToKey[header]; ok {
+			column.Key = key
+		} else if strings.HasPrefix(header, "cf_") {
+			if _, err := strconv.ParseUint(strings.TrimPrefix(header, "cf_"), 10, 64); err == nil {
+				column.Key = header
+			}
+		} else if _, ok := fieldNames[header]; ok {
+			column.Key = "cf_name:" + header
+			column.FieldName = header
+		}
+
+		if _, readOnly := programExcelImportReadOnlyKeys[column.Key]; column.Key == "" || readOnly {
+			ignored = append(ignored, header)
+			continue
+		}
+		if _, duplicated := seenKeys[column.Key]; duplicated {
+			ignored = append(ignored, header)
+			continue
+		}
+		seenKeys[column.Key] = struct{}{}
+		columns = append(columns, column)
+	}
+	return columns, ignored
+}
+
+// readProgramExcelImportSheet 读取指定 sheet；未指定时优先使用导出文件的 Programs sheet。
+func readProgramExcelImportSheet(f *excelize.File, sheet string) (string, [][]string, error) {
+	if sheet == "" {
+		sheet = f.GetSheetName(0)
+		for _, name := range f.GetSheetList() {
+			if name == "Programs" {
+				sheet = name
+				break
+			}
+		}
+	}
+	index, err := f.GetSheetIndex(sheet)
+	if err != nil || index < 0 {
+		return "", nil, errors.New("sheet 不存在")
+	}
+	rows, err := f.GetRows(sheet)
+	if err != nil {
+		return "", nil, errors.New("读取 sheet 失败")
+	}
+	return sheet, rows, nil
+}
+
+// programExcelImportPlanner 逐行生成导入计划，缓存主数据和产线配置，并记录文件内的重复行。
+type programExcelImportPlanner struct {
+	c             *gin.Context
+	matchBy       string
+	columns       []programExcelImportColumn
+	lines         []models.ProductionLine
+	vehicleModels []models.VehicleModel
+	customFields  []models.ProductionLineCustomField
+	statusConfigs map[uint]programStatusConfig
+	canCreate     bool
+
+	seenPrograms map[uint]int
+	seenCreates  map[string]int
+}
+
+func newProgramExcelImportPlanner(c *gin.Context, columns []programExcelImportColumn, matchBy string) (*programExcelImportPlanner, error) {
+	planner := &programExcelImportPlanner{
+		c:             c,
+		matchBy:       matchBy,
+		columns:       columns,
+		statusConfigs: map[uint]programStatusConfig{},
+		canCreate:     currentUserHasPermission(c, "op:program_create"),
+		seenPrograms:  map[uint]int{},
+		seenCreates:   map[string]int{},
+	}
+	if err := database.DB.Find(&planner.lines).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Find(&planner.vehicleModels).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Where("enabled = ?", true).Find(&planner.customFields).Error; err != nil {
+		return nil, err
+	}
+	return planner, nil
+}
+
+// resolveLine 按编码或名称匹配产线，编码优先；名称不唯一时要求使用编码。
+func (p *programExcelImportPlanner) resolveLine(value string) (models.ProductionLine, error) {
+	var matched []models.ProductionLine
+	for _, line := range p.lines {
+		if line.Code == value {
+			return line, nil
+		}
+		if line.Name == value {
+			matched = append(matched, line)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return models.ProductionLine{}, fmt.Errorf("生产线 %s 不存在", value)
+	case 1:
+		return matched[0], nil
+	default:
+		return models.ProductionLine{}, fmt.Errorf("生产线名称 %s 不唯一，请填写生产线编号", value)
+	}
+}
+
+func (p *programExcelImportPlanner) resolveVehicleModel(value string) (models.VehicleModel, error) {
+	var matched []models.VehicleModel
+	for _, vehicleModel := range p.vehicleModels {
+		if vehicleModel.Code == value {
+			return vehicleModel, nil
+		}
+		if vehicleModel.Name == value {
+			matched = append(matched, vehicleModel)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return models.VehicleModel{}, fmt.Errorf("车型 %s 不存在", value)
+	case 1:
+		return matched[0], nil
+	default:
+		return models.VehicleModel{}, fmt.Errorf("车型名称 %s 不唯一，请填写车型编号", value)
+	}
+}
+
+func (p *programExcelImportPlanner) lineLabel(lineID uint) string {
+	for _, line := range p.lines {
+		if line.ID == lineID {
+			return line.Name
+		}
+	}
+	return ""
+}
+
+func (p *programExcelImportPlanner) vehicleModelLabel(vehicleModelID uint) string {
+	for _, vehicleModel := range p.vehicleModels {
+		if vehicleModel.ID == vehicleModelID {
+			return vehicleModel.Name
+		}
+	}
+	return ""
+}
+
+func (p *programExcelImportPlanner) statusConfig(lineID uint) (programStatusConfig, error) {
+	if config, ok := p.statusConfigs[lineID]; ok {
+		return config, nil
+	}
+	config, err := loadProgramStatusConfig(database.DB, lineID)
+	if err != nil {
+		return programStatusConfig{}, err
+	}
+	p.statusConfigs[lineID] = config
+	return config, nil
+}
+
+// resolveStatus 按状态编码或产线配置中的状态名称匹配。
+func (p *programExcelImportPlanner) resolveStatus(lineID uint, value string) (string, error) {
+	config, err := p.statusConfig(lineID)
+	if err != nil {
+		return "", err
+	}
+	for _, definition := range config.Statuses {
+		if definition.Code == value || definition.Name == value {
+			return definition.Code, nil
+		}
+	}
+	return "", fmt.Errorf("状态 %s 不在该产线的状态配置中", value)
+}
+
+// resolveCustomFields 将自定义字段列解析为目标产线上的字段值。
+// 导出文件包含所有产线的字段列，其他产线的字段留空时忽略，有值时报错。
+func (p *programExcelImportPlanner) resolveCustomFields(lineID uint, cells map[string]string) ([]programCustomFieldValueInput, error) {
+	inputs := make([]programCustomFieldValueInput, 0)
+	for _, column := range p.columns {
+		if !strings.HasPrefix(column.Key, "cf_") {
+			continue
+		}
+		value := cells[column.Key]
+		var field *models.ProductionLineCustomField
+		for i := range p.customFields {
+			candidate := &p.customFields[i]
+			if candidate.ProductionLineID != lineID {
+				continue
+			}
+			if column.FieldName != "" && candidate.Name == column.FieldName {
+				field = candidate
+				break
+			}
+			if column.FieldName == "" && column.Key == fmt.Sprintf("cf_%d", candidate.ID) {
+				field = candidate
+				break
+			}
+		}
+		if field == nil {
+			if value != "" {
+				return nil, fmt.Errorf("自定义字段列 %s 不属于该产线", column.Header)
+			}
+			continue
+		}
+		inputs = append(inputs, programCustomFieldValueInput{FieldID: field.ID, Value: value})
+	}
+	return inputs, nil
+}
+
+// matchProgram 按 ID 或编号匹配已有程序；返回 nil 表示新建。
+func (p *programExcelImportPlanner) matchProgram(cells map[string]string) (*models.Program, error) {
+	idValue := cells["id"]
+	code := cells["code"]
+	useID := p.matchBy == programExcelImportMatchID || (p.matchBy == programExcelImportMatchAuto && idValue != "")
+	if useID {
+		if idValue == "" {
+			return nil, errors.New("程序ID不能为空")
+		}
+		programID, err := parseUintParam(idValue)
+		if err != nil {
+			return nil, errors.New("程序ID格式错误")
+		}
+		var program models.Program
+		if err := database.DB.First(&program, programID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, errors.New("程序ID不存在")
+			}
+			return nil, err
+		}
+		return &program, nil
+	}
+	if code == "" {
+		return nil, nil
+	}
+
+	query := database.DB.Where("code = ?", code)
+	if lineValue := cells["production_line"]; lineValue != "" {
+		line, err := p.resolveLine(lineValue)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("production_line_id = ?", line.ID)
+	}
+	var programs []models.Program
+	if err := query.Limit(2).Find(&programs).Error; err != nil {
+		return nil, err
+	}
+	switch len(programs) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &programs[0], nil
+	default:
+		return nil, errProgramExcelImportAmbiguous
+	}
+}
+
+var (
+	errProgramExcelImportAmbiguous = errors.New("编号匹配到多个程序，请补充生产线或使用程序ID匹配")
+	errProgramExcelImportDuplicate = errors.New("与文件中前面的行重复")
+)
+
+func (p *programExcelImportPlanner) rowCells(row programExcelImportRow) map[string]string {
+	cells := make(map[string]string, len(p.columns))
+	for _, column := range p.columns {
+		value := ""
+		if column.Index < len(row.Cells) {
+			value = strings.TrimSpace(row.Cells[column.Index])
+		}
+		cells[column.Key] = value
+	}
+	return cells
+}
+
+func (p *programExcelImportPlanner) hasColumn(key string) bool {
+	for _, column := range p.columns {
+		if column.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func programExcelImportErrorMessage(err error) string {
+	switch {
+	case isProgramCustomFieldInputError(err), isProgramStatusError(err), isProgramCodeError(err):
+		return err.Error()
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return "程序不存在"
+	default:
+		return err.Error()
+	}
+}
+
+// plan 生成单行的导入计划。冲突表示行本身合法但与现有数据或文件内其他行矛盾，错误表示行内容无效或无权限。
+func (p *programExcelImportPlanner) plan(row programExcelImportRow) programExcelImportItem {
+	cells := p.rowCells(row)
+	item := programExcelImportItem{Row: row.Number, Code: cells["code"], Name: cells["name"]}
+	fail := func(action string, err error) programExcelImportItem {
+		item.Action = action
+		item.Error = programExcelImportErrorMessage(err)
+		return item
+	}
+
+	existing, err := p.matchProgram(cells)
+	if err != nil {
+		if errors.Is(err, errProgramExcelImportAmbiguous) {
+			return fail(programExcelImportActionConflict, err)
+		}
+		return fail(programExcelImportActionError, err)
+	}
+	if existing == nil {
+		return p.planCreate(item, cells, fail)
+	}
+	return p.planUpdate(item, cells, *existing, fail)
+}
+
+func (p *programExcelImportPlanner) planCreate(item programExcelImportItem, cells map[string]string, fail func(string, error) programExcelImportItem) programExcelImportItem {
+	if !p.canCreate {
+		return fail(programExcelImportActionError, errors.New("无新建程序权限"))
+	}
+	if item.Name == "" {
+		return fail(programExcelImportActionError, errors.New("程序名称不能为空"))
+	}
+	if cells["production_line"] == "" {
+		return fail(programExcelImportActionError, errors.New("新建程序需要填写生产线"))
+	}
+	line, err := p.resolveLine(cells["production_line"])
+	if err != nil {
+		return fail(programExcelImportActionError, err)
+	}
+	if allowed, _, message := checkLineAction(p.c, line.ID, lineActionManage); !allowed {
+		return fail(programExcelImportActionError, errors.New(message))
+	}
+	item.lineID = line.ID
+
+	if value := cells["vehicle_model"]; value != "" {
+		vehicleModel, err := p.resolveVehicleModel(value)
+		if err != nil {
+			return fail(programExcelImportActionError, err)
+		}
+		item.vehicleModelID = vehicleModel.ID
+	}
+	item.description = cells["description"]
+	if value := cells["status"]; value != "" {
+		if item.status, err = p.resolveStatus(line.ID, value); err != nil {
+			return fail(programExcelImportActionError, err)
+		}
+	} else {
+		config, err := p.statusConfig(line.ID)
+		if err != nil {
+			return fail(programExcelImportActionError, err)
+		}
+		item.status = config.initialStatus()
+	}
+
+	rule, err := loadProgramCodeRule(database.DB, line.ID)
+	if err != nil {
+		return fail(programExcelImportActionError, err)
+	}
+	if item.Code == "" && rule.Pattern == "" {
+		return fail(programExcelImportActionError, errProgramCodePatternNotSet)
+	}
+	if item.Code != "" {
+		if err := ensureProgramCodeUnique(database.DB, rule.UniqueScope, item.Code, line.ID, item.vehicleModelID, 0); err != nil {
+			return fail(programExcelImportActionConflict, err)
+		}
+		createKey := fmt.Sprintf("%d:%s", line.ID, item.Code)
+		if _, exists := p.seenCreates[createKey]; exists {
+			return fail(programExcelImportActionConflict, errProgramExcelImportDuplicate)
+		}
+		p.seenCreates[createKey] = item.Row
+	}
+
+	customFieldValues, err := p.resolveCustomFields(line.ID, cells)
+	if err != nil {
+		return fail(programExcelImportActionError, err)
+	}
+	if _, err := buildProgramCustomFieldValues(database.DB, models.Program{ProductionLineID: line.ID}, customFieldValues); err != nil {
+		return fail(programExcelImportActionError, err)
+	}
+	for _, input := range customFieldValues {
+		if input.Value != "" {
+			item.customFieldValues = append(item.customFieldValues, input)
+		}
+	}
+	item.Action = programExcelImportActionCreate
+	return item
+}
+
+func (p *programExcelImportPlanner) planUpdate(item programExcelImportItem, cells map[string]string, program models.Program, fail func(string, error) programExcelImportItem) programExcelImportItem {
+	item.ProgramID = program.ID
+	if item.Code == "" {
+		item.Code = program.Code
+	}
+	if item.Name == "" {
+		item.Name = program.Name
+	}
+
+	var mapping models.ProgramMapping
+	if err := database.DB.Where("child_program_id = ?", program.ID).First(&mapping).Error; err == nil {
+		return fail(programExcelImportActionConflict, errors.New("映射子程序的数据来自父程序，请修改父程序"))
+	}
+	if previousRow, exists := p.seenPrograms[program.ID]; exists {
+		return fail(programExcelImportActionConflict, fmt.Errorf("与第 %d 行匹配到同一程序", previousRow))
+	}
+	p.seenPrograms[program.ID] = item.Row
+
+	if allowed, _, message := checkProgramScopeAction(p.c, program.ID, program.ProductionLineID, program.VehicleModelID, lineActionManage); !allowed {
+		return fail(programExcelImportActionError, errors.New(message))
+	}
+
+	updates := map[string]interface{}{}
+	changes := make([]programExcelImportChange, 0)
+	addChange := func(field, from, to string) {
+		changes = append(changes, programExcelImportChange{Field: field, From: from, To: to})
+	}
+
+	item.lineID = program.ProductionLineID
+	if value := cells["production_line"]; value != "" {
+		line, err := p.resolveLine(value)
+		if err != nil {
+			return fail(programExcelImportActionError, err)
+		}
+		if line.ID != program.ProductionLineID {
+			item.lineID = line.ID
+			updates["production_line_id"] = line.ID
+			addChange("production_line", p.lineLabel(program.ProductionLineID), line.Name)
+		}
+	}
+
+	item.vehicleModelID = program.VehicleModelID
+	if p.hasColumn("vehicle_model") {
+		nextVehicleModelID := uint(0)
+		if value := cells["vehicle_model"]; value != "" {
+			vehicleModel, err := p.resolveVehicleModel(value)
+			if err != nil {
+				return fail(programExcelImportActionError, err)
+			}
+			nextVehicleModelID = vehicleModel.ID
+		}
+		if nextVehicleModelID != program.VehicleModelID {
+			item.vehicleModelID = nextVehicleModelID
+			updates["vehicle_model_id"] = nextVehicleModelID
+			addChange("vehicle_model", p.vehicleModelLabel(program.VehicleModelID), p.vehicleModelLabel(nextVehicleModelID))
+		}
+	}
+	// 移动到其他产线或车型时，目标范围同样需要管理权限
+	if item.lineID != program.ProductionLineID || item.vehicleModelID != program.VehicleModelID {
+		if allowed, _, message := checkProgramScopeAction(p.c, program.ID, item.lineID, item.vehicleModelID, lineActionManage); !allowed {
+			return fail(programExcelImportActionError, errors.New(message))
+		}
+	}
+
+	if item.Name != program.Name {
+		updates["name"] = item.Name
+		addChange("name", program.Name, item.Name)
+	}
+	if item.Code != program.Code {
+		updates["code"] = item.Code
+		addChange("code", program.Code, item.Code)
+	}
+	if p.hasColumn("description") && cells["description"] != program.Description {
+		updates["description"] = cells["description"]
+		addChange("description", program.Description, cells["description"])
+	}
+
+	item.status = program.Status
+	if value := cells["status"]; value != "" {
+		status, err := p.resolveStatus(item.lineID, value)
+		if err != nil {
+			return fail(programExcelImportActionError, err)
+		}
+		if status != program.Status {
+			item.status = status
+			updates["status"] = status
+			addChange("status", program.Status, status)
+		}
+	}
+	if item.status != program.Status || item.lineID != program.ProductionLineID {
+		if err := validateProgramStatusUpdate(database.DB, program, item.lineID, item.status, currentAccountRole(p.c)); err != nil {
+			return fail(programExcelImportActionError, err)
+		}
+	}
+	if err := ensureProgramCodeUniqueOnUpdate(database.DB, program, item.Code, item.lineID, item.vehicleModelID); err != nil {
+		return fail(programExcelImportActionConflict, err)
+	}
+
+	customFieldValues, err := p.resolveCustomFields(item.lineID, cells)
+	if err != nil {
+		return fail(programExcelImportActionError, err)
+	}
+	if _, err := buildProgramCustomFieldValues(database.DB, models.Program{ID: program.ID, ProductionLineID: item.lineID}, customFieldValues); err != nil {
+		return fail(programExcelImportActionError, err)
+	}
+	currentValues := map[uint]string{}
+	if item.lineID == program.ProductionLineID {
+		var values []models.ProgramCustomFieldValue
+		if err := database.DB.Where("program_id = ?", program.ID).Find(&values).Error; err != nil {
+			return fail(programExcelImportActionError, err)
+		}
+		for _, value := range values {
+			currentValues[value.ProductionLineCustomFieldID] = value.Value
+		}
+	}
+	for _, input := range customFieldValues {
+		if currentValues[input.FieldID] == input.Value {
+			continue
+		}
+		item.customFieldValues = append(item.customFieldValues, input)
+		addChange(fmt.Sprintf("cf_%d", input.FieldID), currentValues[input.FieldID], input.Value)
+	}
+
+	item.updates = updates
+	item.Changes = changes
+	if len(updates) == 0 && len(item.customFieldValues) == 0 {
+		item.Action = programExcelImportActionUnchanged
+		return item
+	}
+	item.Action = programExcelImportActionUpdate
+	return item
+}
+
+// buildProgramExcelImportPlan 为预览数据的全部行生成导入计划。
+func buildProgramExcelImportPlan(c *gin.Context, state *programExcelImportPreviewState) ([]programExcelImportItem, error) {
+	planner, err := newProgramExcelImportPlanner(c, state.Columns, state.MatchBy)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]programExcelImportItem, 0, len(state.Rows))
+	for _, row := range state.Rows {
+		items = append(items, planner.plan(row))
+	}
+	return items, nil
+}
+
+func summarizeProgramExcelImportPlan(items []programExcelImportItem) map[string]int {
+	summary := map[string]int{
+		programExcelImportActionCreate:    0,
+		programExcelImportActionUpdate:    0,
+		programExcelImportActionUnchanged: 0,
+		programExcelImportActionConflict:  0,
+		programExcelImportActionError:     0,
+	}
+	for _, item := range items {
+		summary[item.Action]++
+	}
+	return summary
+}
+
+// PreviewProgramExcelImport 解析上传的 .xlsx，按导出列格式匹配已有程序并返回新建/更新/冲突预览。
+// 表头可以是列 key（如 cf_12）、导出时的中文列名或自定义字段名称；match_by 可选 auto、id、code。
+func PreviewProgramExcelImport(c *gin.Context) {
+	matchBy := strings.TrimSpace(c.DefaultPostForm("match_by", programExcelImportMatchAuto))
+	if matchBy != programExcelImportMatchAuto && matchBy != programExcelImportMatchID && matchBy != programExcelImportMatchCode {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid match_by"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize()+1024*1024)
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请上传 .xlsx 文件"})
+		return
+	}
+	if fileHeader.Size > maxUploadSize() {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "upload too large"})
+		return
+	}
+	src, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败"})
+		return
+	}
+	defer src.Close()
+
+	f, err := excelize.OpenReader(src)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无法解析 Excel 文件"})
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	sheet, rows, err := readProgramExcelImportSheet(f, strings.TrimSpace(c.PostForm("sheet")))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(rows) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件中没有数据行"})
+		return
+	}
+	if len(rows)-1 > programExcelImportMaxRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("单次最多导入 %d 行", programExcelImportMaxRows)})
+		return
+	}
+
+	var customFields []models.ProductionLineCustomField
+	if err := database.DB.Where("enabled = ?", true).Find(&customFields).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	columns, ignored := resolveProgramExcelImportColumns(rows[0], customFields)
+	hasIdentity := false
+	for _, column := range columns {
+		if column.Key == "id" || column.Key == "code" || column.Key == "name" {
+			hasIdentity = true
+		}
+	}
+	if !hasIdentity {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "表头中缺少程序ID、程序编号或程序名称列"})
+		return
+	}
+
+	state := &programExcelImportPreviewState{
+		Sheet:     sheet,
+		Columns:   columns,
+		MatchBy:   matchBy,
+		OwnerUser: currentUserID(c),
+	}
+	for index, cells := range rows[1:] {
+		blank := true
+		for _, cell := range cells {
+			if strings.TrimSpace(cell) != "" {
+				blank = false
+				break
+			}
+		}
+		if !blank {
+			state.Rows = append(state.Rows, programExcelImportRow{Number: index + 2, Cells: cells})
+		}
+	}
+
+	items, err := buildProgramExcelImportPlan(c, state)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	previewID := createProgramExcelImportPreview(state)
+
+	c.JSON(http.StatusOK, gin.H{
+		"preview_id":      previewID,
+		"sheet":           sheet,
+		"columns":         columns,
+		"ignored_columns": ignored,
+		"total":           len(items),
+		"summary":         summarizeProgramExcelImportPlan(items),
+		"items":           items,
+	})
+}
+
+type applyProgramExcelImportRequest struct {
+	PreviewID   string `json:"preview_id"`
+	SkipInvalid bool   `json:"skip_invalid"`
+}
+
+// ApplyProgramExcelImport 按预览执行导入。执行前基于当前数据重新生成计划，
+// 存在冲突或错误行时需要 skip_invalid=true 才会跳过这些行继续执行。
+func ApplyProgramExcelImport(c *gin.Context) {
+	var req applyProgramExcelImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.TrimSpace(req.PreviewID) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "preview_id is required"})
+		return
+	}
+
+	userID := currentUserID(c)
+	state, err := consumeProgramExcelImportPreview(req.PreviewID, userID)
+	if err != nil {
+		switch err.Error() {
+		case "preview_not_found":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "preview expired or not found"})
+		case "preview_forbidden":
+			c.JSON(http.StatusForbidden, gin.H{"error": "preview does not belong to current user"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		}
+		return
+	}
+
+	items, err := buildProgramExcelImportPlan(c, state)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	summary := summarizeProgramExcelImportPlan(items)
+	if !req.SkipInvalid && summary[programExcelImportActionConflict]+summary[programExcelImportActionError] > 0 {
+		// 预览已被消费，放回去以便用户确认后带 skip_invalid 重试
+		previewID := createProgramExcelImportPreview(state)
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "存在冲突或错误的行，请修正后重新上传，或设置 skip_invalid 跳过这些行",
+			"preview_id": previewID,
+			"summary":    summary,
+			"items":      items,
+		})
+		return
+	}
+
+	job, err := enqueueJob(jobTypeProgramExcelImport, userID, programExcelImportJobPayload{State: *state}, len(items))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交导入任务失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"task_id": job.ID, "summary": summary})
+}
+
+// programExcelImportJobPayload 保存预览中的表格内容，执行时以提交人身份重新生成计划。
+type programExcelImportJobPayload struct {
+	State programExcelImportPreviewState `json:"state"`
+}
+
+func runProgramExcelImportJob(run *jobRun) error {
+	var payload programExcelImportJobPayload
+	if err := json.Unmarshal(run.job.Payload, &payload); err != nil {
+		return err
+	}
+	var owner models.User
+	if err := database.DB.First(&owner, run.job.OwnerID).Error; err != nil {
+		return errors.New("任务提交人不存在")
+	}
+	request, _ := http.NewRequest(http.MethodPost, "/api/programs/excel-import/apply", nil)
+	c, _ := newUserContext(owner, request)
+	items, err := buildProgramExcelImportPlan(c, &payload.State)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if err := run.ctx.Err(); err != nil {
+			return err
+		}
+		label := fmt.Sprintf("第 %d 行", item.Row)
+		run.setCurrent(label)
+
+		result := batchTaskItemResult{Row: item.Row, Key: item.Code, Status: item.Action, Error: item.Error}
+		var err error
+		switch item.Action {
+		case programExcelImportActionCreate:
+			var programID uint
+			programID, err = applyProgramExcelImportCreate(item, owner.ID)
+			if err == nil && item.Code == "" {
+				var program models.Program
+				if database.DB.Select("code").First(&program, programID).Error == nil {
+					result.Key = program.Code
+				}
+			}
+		case programExcelImportActionUpdate:
+			err = applyProgramExcelImportUpdate(item, owner.ID, currentAccountRole(c))
+		}
+		if err != nil {
+			result.Status = programExcelImportActionError
+			result.Error = programExcelImportErrorMessage(err)
+		}
+		run.recordItem(label, result)
+	}
+
+	if run.job.Failed > 0 && run.job.Success == 0 {
+		return errJobItemsFailed
+	}
+	return nil
+}
+
+func applyProgramExcelImportCreate(item programExcelImportItem, userID uint) (uint, error) {
+	program := models.Program{
+		Name:             item.Name,
+		Code:             item.Code,
+		ProductionLineID: item.lineID,
+		VehicleModelID:   item.vehicleModelID,
+		Description:      item.description,
+		Status:           item.status,
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := assignProgramCode(tx, &program); err != nil {
+			return err
+		}
+		if err := tx.Create(&program).Error; err != nil {
+			return err
+		}
+		if err := recordProgramStatusChange(tx, program.ID, "", program.Status, userID); err != nil {
+			return err
+		}
+		if len(item.customFieldValues) > 0 {
+			if _, err := mergeProgramCustomFieldValues(tx, program, item.customFieldValues); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return program.ID, err
+}
+
+// applyProgramExcelImportUpdate 在事务内重新读取程序并复核编号和状态流转，预览之后的并发修改不会被静默覆盖成非法状态。
+func applyProgramExcelImportUpdate(item programExcelImportItem, userID uint, role string) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var program models.Program
+		if err := tx.First(&program, item.ProgramID).Error; err != nil {
+			return err
+		}
+		originalStatus := program.Status
+		originalLineID := program.ProductionLineID
+
+		if item.status != originalStatus || item.lineID != originalLineID {
+			if err := validateProgramStatusUpdate(tx, program, item.lineID, item.status, role); err != nil {
+				return err
+			}
+		}
+		if err := ensureProgramCodeUniqueOnUpdate(tx, program, item.Code, item.lineID, item.vehicleModelID); err != nil {
+			return err
+		}
+		if len(item.updates) > 0 {
+			if err := tx.Model(&program).Updates(item.updates).Error; err != nil {
+				return err
+			}
+		}
+		if nextStatus, ok := item.updates["status"].(string); ok && nextStatus != originalStatus {
+			if err := recordProgramStatusChange(tx, program.ID, originalStatus, nextStatus, userID); err != nil {
+				return err
+			}
+		}
+		if item.lineID != originalLineID {
+			if err := tx.Where("program_id = ?", program.ID).Delete(&models.ProgramCustomFieldValue{}).Error; err != nil {
+				return err
+			}
+		}
+		if len(item.customFieldValues) > 0 {
+			updated := program
+			updated.ProductionLineID = item.lineID
+			if _, err := mergeProgramCustomFieldValues(tx, updated, item.customFieldValues); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+	"crane-system/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+func setupProgramExcelImportTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	{
+		api.POST("/programs/excel-import/preview", PreviewProgramExcelImport)
+		api.POST("/programs/excel-import", ApplyProgramExcelImport)
+		api.GET("/programs/export/excel", ExportProgramsExcelDynamic)
+	}
+	return r
+}
+
+func performProgramExcelImportUpload(t *testing.T, r http.Handler, token string, content []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "programs.xlsx")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	if _, err := part.Write(content); err != nil {
+		t.Fatalf("write form file: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close multipart writer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/programs/excel-import/preview", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	return resp
+}
+
+func buildProgramExcelImportWorkbook(t *testing.T, rows [][]any) []byte {
+	t.Helper()
+	f := excelize.NewFile()
+	defer func() { _ = f.Close() }()
+	_ = f.SetSheetName(f.GetSheetName(0), "Programs")
+	for rowIdx, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, rowIdx+1)
+		if err := f.SetSheetRow("Programs", cell, &row); err != nil {
+			t.Fatalf("write row: %v", err)
+		}
+	}
+	buffer, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatalf("write workbook: %v", err)
+	}
+	return buffer.Bytes()
+}
+
+// waitProgramExcelImportTask 在测试 goroutine 中执行排队的任务，返回任务的进度状态。
+func waitProgramExcelImportTask(t *testing.T, taskID int64) batchImportTaskStatus {
+	t.Helper()
+	runQueuedJobs(context.Background(), "test")
+	var job models.Job
+	if err := database.DB.First(&job, taskID).Error; err != nil {
+		t.Fatalf("load job %d: %v", taskID, err)
+	}
+	if job.Status != jobStatusCompleted && job.Status != jobStatusFailed {
+		t.Fatalf("task %d did not finish: %+v", taskID, job)
+	}
+	return jobTaskStatus(job)
+}
+
+type programExcelImportPreviewResponse struct {
+	PreviewID      string                   `json:"preview_id"`
+	IgnoredColumns []string                 `json:"ignored_columns"`
+	Summary        map[string]int           `json:"summary"`
+	Items          []programExcelImportItem `json:"items"`
+}
+
+func TestProgramExcelImportPreviewAndApply(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	token, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupProgramExcelImportTestRouter()
+
+	field := models.ProductionLineCustomField{ProductionLineID: line.ID, Name: "工位", FieldType: "text", Enabled: true}
+	if err := database.DB.Create(&field).Error; err != nil {
+		t.Fatalf("create custom field: %v", err)
+	}
+	program := models.Program{Name: "旧名称", Code: "P-001", ProductionLineID: line.ID, Status: "in_progress"}
+	if err := database.DB.Create(&program).Error; err != nil {
+		t.Fatalf("create program: %v", err)
+	}
+	if err := database.DB.Create(&models.ProgramCustomFieldValue{ProgramID: program.ID, ProductionLineCustomFieldID: field.ID, Value: "A"}).Error; err != nil {
+		t.Fatalf("create custom field value: %v", err)
+	}
+
+	content := buildProgramExcelImportWorkbook(t, [][]any{
+		{"程序ID", "程序名称", "程序编号", "生产线", "车型", "状态", "描述", "工位", "文件数"},
+		{program.ID, "新名称", "P-001", "产线A", "", "进行中", "已调整", "B", 3},
+		{"", "新程序", "P-NEW", "LINE-001", "", "", "", "C", 0},
+		{"", "重复程序", "P-NEW", "产线A", "", "", "", "", 0},
+		{"", "坏状态", "P-BAD", "产线A", "", "不存在", "", "", 0},
+		{program.ID, "再次修改", "P-001", "产线A", "", "", "", "", 0},
+	})
+
+	resp := performProgramExcelImportUpload(t, r, token, content)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("preview: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	preview := decodeProductionLineCustomFieldResponse[programExcelImportPreviewResponse](t, resp)
+	if preview.Summary["create"] != 1 || preview.Summary["update"] != 1 || preview.Summary["conflict"] != 2 || preview.Summary["error"] != 1 {
+		t.Fatalf("unexpected summary: %+v, items = %+v", preview.Summary, preview.Items)
+	}
+	if len(preview.IgnoredColumns) != 1 || preview.IgnoredColumns[0] != "文件数" {
+		t.Fatalf("ignored columns = %v", preview.IgnoredColumns)
+	}
+	if len(preview.Items[0].Changes) != 3 {
+		t.Fatalf("update changes = %+v", preview.Items[0].Changes)
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/programs/excel-import", token, map[string]any{"preview_id": preview.PreviewID})
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("apply with invalid rows: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	retry := decodeProductionLineCustomFieldResponse[programExcelImportPreviewResponse](t, resp)
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/programs/excel-import", token, map[string]any{"preview_id": retry.PreviewID, "skip_invalid": true})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("apply: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	taskID := decodeProductionLineCustomFieldResponse[struct {
+		TaskID int64 `json:"task_id"`
+	}](t, resp).TaskID
+	task := waitProgramExcelImportTask(t, taskID)
+	if task.Status != "completed" || task.Success != 2 || task.Failed != 3 || len(task.Items) != 5 {
+		t.Fatalf("unexpected task status: %+v", task)
+	}
+	if task.Items[2].Row != 4 || task.Items[2].Status != "conflict" || task.Items[2].Error == "" {
+		t.Fatalf("unexpected row result: %+v", task.Items[2])
+	}
+
+	var updated models.Program
+	if err := database.DB.Preload("CustomFieldValues").First(&updated, program.ID).Error; err != nil {
+		t.Fatalf("load program: %v", err)
+	}
+	if updated.Name != "新名称" || updated.Description != "已调整" || len(updated.CustomFieldValues) != 1 || updated.CustomFieldValues[0].Value != "B" {
+		t.Fatalf("unexpected updated program: %+v", updated)
+	}
+	var created models.Program
+	if err := database.DB.Preload("CustomFieldValues").Where("code = ?", "P-NEW").First(&created).Error; err != nil {
+		t.Fatalf("load created program: %v", err)
+	}
+	if created.Status != "in_progress" || len(created.CustomFieldValues) != 1 || created.CustomFieldValues[0].Value != "C" {
+		t.Fatalf("unexpected created program: %+v", created)
+	}
+}
+
+func TestProgramExcelImportRoundTripIsUnchanged(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	token, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupProgramExcelImportTestRouter()
+
+	field := models.ProductionLineCustomField{ProductionLineID: line.ID, Name: "工位", FieldType: "text", Enabled: true}
+	if err := database.DB.Create(&field).Error; err != nil {
+		t.Fatalf("create custom field: %v", err)
+	}
+	vehicle := models.VehicleModel{Name: "车型A", Code: "VM01"}
+	if err := database.DB.Create(&vehicle).Error; err != nil {
+		t.Fatalf("create vehicle model: %v", err)
+	}
+	for i := 1; i <= 2; i++ {
+		program := models.Program{Name: fmt.Sprintf("程序%d", i), Code: fmt.Sprintf("RT-%03d", i), ProductionLineID: line.ID, VehicleModelID: vehicle.ID, Status: "completed", Description: "说明"}
+		if err := database.DB.Create(&program).Error; err != nil {
+			t.Fatalf("create program: %v", err)
+		}
+		if err := database.DB.Create(&models.ProgramCustomFieldValue{ProgramID: program.ID, ProductionLineCustomFieldID: field.ID, Value: "OP10"}).Error; err != nil {
+			t.Fatalf("create custom field value: %v", err)
+		}
+	}
+
+	exportPath := fmt.Sprintf("/api/programs/export/excel?columns=id,name,code,production_line,vehicle_model,status,description,created_at,cf_%d", field.ID)
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodGet, exportPath, token, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("export: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+
+	resp = performProgramExcelImportUpload(t, r, token, resp.Body.Bytes())
+	if resp.Code != http.StatusOK {
+		t.Fatalf("preview: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	preview := decodeProductionLineCustomFieldResponse[programExcelImportPreviewResponse](t, resp)
+	if preview.Summary["unchanged"] != 2 || len(preview.Items) != 2 {
+		t.Fatalf("expected exported rows to be unchanged: %+v", preview.Items)
+	}
+}
+
+func TestProgramExcelImportRespectsProgramDeny(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	_, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	services.InvalidateAllCache()
+	r := setupProgramExcelImportTestRouter()
+
+	locked := models.Program{Name: "锁定程序", Code: "P-LOCKED", ProductionLineID: line.ID, Status: "in_progress"}
+	open := models.Program{Name: "普通程序", Code: "P-OPEN", ProductionLineID: line.ID, Status: "in_progress"}
+	for _, program := range []*models.Program{&locked, &open} {
+		if err := database.DB.Create(program).Error; err != nil {
+			t.Fatalf("create program: %v", err)
+		}
+	}
+	editor := models.User{Name: "Editor", Password: "hashed", EmployeeID: "EDIT-001", Role: "engineer", Status: "active"}
+	if err := database.DB.Create(&editor).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := services.SavePermissionRuleChanges(services.PermissionSubject{Type: models.PermissionSubjectUser, ID: editor.ID}, []services.PermissionRuleChange{
+		{ResourceType: models.PermissionResourceProductionLine, ResourceID: line.ID, Action: models.PermissionActionManage, Decision: models.PermissionDecisionAllow},
+		{ResourceType: models.PermissionResourceProgram, ResourceID: locked.ID, Action: models.PermissionActionManage, Decision: models.PermissionDecisionDeny},
+	}); err != nil {
+		t.Fatalf("save user rules: %v", err)
+	}
+
+	content := buildProgramExcelImportWorkbook(t, [][]any{
+		{"程序ID", "程序名称", "程序编号", "生产线"},
+		{locked.ID, "锁定程序-改", "P-LOCKED", "产线A"},
+		{open.ID, "普通程序-改", "P-OPEN", "产线A"},
+	})
+	resp := performProgramExcelImportUpload(t, r, createUserTokenForTest(t, editor.ID, "engineer"), content)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("preview: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	preview := decodeProductionLineCustomFieldResponse[programExcelImportPreviewResponse](t, resp)
+	if preview.Items[0].Action != programExcelImportActionError || preview.Items[1].Action != programExcelImportActionUpdate {
+		t.Fatalf("expected program deny to block only the locked row, got %+v", preview.Items)
+	}
+}
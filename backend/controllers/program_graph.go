@@ -0,0 +1,462 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"crane-system/database"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	programGraphEdgeMapping  = "mapping"
+	programGraphEdgeRelation = "relation"
+
+	programGraphDefaultMaxNodes = 200
+	programGraphLimitMaxNodes   = 1000
+	// 创建关联时的环检测上限，超过后按存在环处理，避免异常数据拖垮请求
+	programGraphCycleSearchLimit = 10000
+)
+
+var errProgramGraphCycle = errors.New("该关联会在程序依赖图中形成环")
+
+type programGraphNode struct {
+	ID               uint   `json:"id"`
+	Name             string `json:"name"`
+	Code             string `json:"code"`
+	ProductionLineID uint   `json:"production_line_id"`
+	VehicleModelID   uint   `json:"vehicle_model_id"`
+	Status           string `json:"status"`
+}
+
+// programGraphEdge 统一表示映射（父→子）和程序关联两类边。
+// Directed=false 的关联视为对称关系，不参与环检测。
+type programGraphEdge struct {
+	ID              uint   `json:"id"`
+	Kind            string `json:"kind"`
+	RelationType    string `json:"relation_type,omitempty"`
+	SourceProgramID uint   `json:"source_program_id"`
+	TargetProgramID uint   `json:"target_program_id"`
+	Directed        bool   `json:"directed"`
+}
+
+func (e programGraphEdge) key() string {
+	return fmt.Sprintf("%s:%d", e.Kind, e.ID)
+}
+
+func newProgramGraphNode(program models.Program) programGraphNode {
+	return programGraphNode{
+		ID:               program.ID,
+		Name:             program.Name,
+		Code:             program.Code,
+		ProductionLineID: program.ProductionLineID,
+		VehicleModelID:   program.VehicleModelID,
+		Status:           program.Status,
+	}
+}
+
+// loadProgramGraphEdges 查询与给定程序相邻的全部映射和关联边。
+func loadProgramGraphEdges(tx *gorm.DB, programIDs []uint) ([]programGraphEdge, error) {
+	if len(programIDs) == 0 {
+		return nil, nil
+	}
+
+	var mappings []models.ProgramMapping
+	if err := tx.Where("parent_program_id IN ? OR child_program_id IN ?", programIDs, programIDs).Find(&mappings).Error; err != nil {
+		return nil, err
+	}
+	var relations []models.ProgramRelation
+	if err := tx.Where("source_program_id IN ? OR related_program_id IN ?", programIDs, programIDs).Find(&relations).Error; err != nil {
+		return nil, err
+	}
+	relationTypes, err := loadProgramRelationTypeCatalog(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	edges := make([]programGraphEdge, 0, len(mappings)+len(relations))
+	for _, mapping := range mappings {
+		edges = append(edges, programGraphEdge{
+			ID:              mapping.ID,
+			Kind:            programGraphEdgeMapping,
+			SourceProgramID: mapping.ParentProgramID,
+			TargetProgramID: mapping.ChildProgramID,
+			Directed:        true,
+		})
+	}
+	for _, relation := range relations {
+		edges = append(edges, programGraphEdge{
+			ID:              relation.ID,
+			Kind:            programGraphEdgeRelation,
+			RelationType:    relation.RelationType,
+			SourceProgramID: relation.SourceProgramID,
+			TargetProgramID: relation.RelatedProgramID,
+			Directed:        relationTypes.directed(relation.RelationType),
+		})
+	}
+	return edges, nil
+}
+
+// programGraphVisibility 按程序缓存可见性判断结果，遍历时不可见的程序既不返回也不继续展开。
+type programGraphVisibility struct {
+	c        *gin.Context
+	tx       *gorm.DB
+	programs map[uint]models.Program
+	visible  map[uint]bool
+}
+
+func newProgramGraphVisibility(c *gin.Context, tx *gorm.DB) *programGraphVisibility {
+	return &programGraphVisibility{c: c, tx: tx, programs: map[uint]models.Program{}, visible: map[uint]bool{}}
+}
+
+// resolve 批量加载程序并判断可见性，返回的状态码非 0 时表示鉴权过程本身出错。
+func (v *programGraphVisibility) resolve(programIDs []uint) (int, string) {
+	missing := make([]uint, 0, len(programIDs))
+	for _, id := range programIDs {
+		if _, ok := v.visible[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return 0, ""
+	}
+
+	var programs []models.Program
+	if err := v.tx.Select("id", "name", "code", "production_line_id", "vehicle_model_id", "status").
+		Where("id IN ?", missing).Find(&programs).Error; err != nil {
+		return http.StatusInternalServerError, "查询失败"
+	}
+	for _, id := range missing {
+		v.visible[id] = false
+	}
+	for _, program := range programs {
+		visible, statusCode, message := relationProgramVisible(v.c, program)
+		if statusCode != 0 {
+			return statusCode, message
+		}
+		v.programs[program.ID] = program
+		v.visible[program.ID] = visible
+	}
+	return 0, ""
+}
+
+type programGraph struct {
+	RootProgramID uint               `json:"root_program_id"`
+	Nodes         []programGraphNode `json:"nodes"`
+	Edges         []programGraphEdge `json:"edges"`
+	HasCycle      bool               `json:"has_cycle"`
+	Truncated     bool               `json:"truncated"`
+}
+
+// buildProgramGraph 从根程序出发广度遍历映射和关联，返回当前用户可见的连通分量。
+func buildProgramGraph(c *gin.Context, tx *gorm.DB, rootID uint, maxNodes int) (programGraph, int, string) {
+	graph := programGraph{RootProgramID: rootID, Nodes: []programGraphNode{}, Edges: []programGraphEdge{}}
+	visibility := newProgramGraphVisibility(c, tx)
+	if statusCode, message := visibility.resolve([]uint{rootID}); statusCode != 0 {
+		return graph, statusCode, message
+	}
+	if _, exists := visibility.programs[rootID]; !exists {
+		return graph, http.StatusNotFound, "程序不存在"
+	}
+	if !visibility.visible[rootID] {
+		return graph, http.StatusForbidden, "无权查看该程序"
+	}
+
+	nodeSet := map[uint]struct{}{rootID: {}}
+	edgeSet := map[string]struct{}{}
+	frontier := []uint{rootID}
+	for len(frontier) > 0 {
+		edges, err := loadProgramGraphEdges(tx, frontier)
+		if err != nil {
+			return graph, http.StatusInternalServerError, "查询失败"
+		}
+
+		neighborIDs := make([]uint, 0)
+		for _, edge := range edges {
+			for _, id := range []uint{edge.SourceProgramID, edge.TargetProgramID} {
+				if _, ok := nodeSet[id]; !ok {
+					neighborIDs = append(neighborIDs, id)
+				}
+			}
+		}
+		if statusCode, message := visibility.resolve(neighborIDs); statusCode != 0 {
+			return graph, statusCode, message
+		}
+
+		next := make([]uint, 0)
+		for _, edge := range edges {
+			if !visibility.visible[edge.SourceProgramID] || !visibility.visible[edge.TargetProgramID] {
+				continue
+			}
+			for _, id := range []uint{edge.SourceProgramID, edge.TargetProgramID} {
+				if _, ok := nodeSet[id]; ok {
+					continue
+				}
+				if len(nodeSet) >= maxNodes {
+					graph.Truncated = true
+					continue
+				}
+				nodeSet[id] = struct{}{}
+				next = append(next, id)
+			}
+			_, sourceIncluded := nodeSet[edge.SourceProgramID]
+			_, targetIncluded := nodeSet[edge.TargetProgramID]
+			if _, seen := edgeSet[edge.key()]; !seen && sourceIncluded && targetIncluded {
+				edgeSet[edge.key()] = struct{}{}
+				graph.Edges = append(graph.Edges, edge)
+			}
+		}
+		frontier = next
+	}
+
+	for id := range nodeSet {
+		graph.Nodes = append(graph.Nodes, newProgramGraphNode(visibility.programs[id]))
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool { return graph.Nodes[i].ID < graph.Nodes[j].ID })
+	graph.HasCycle = programGraphHasCycle(graph.Edges)
+	return graph, 0, ""
+}
+
+// programGraphHasCycle 只检查有向边组成的子图，对称关联天然成环，不计入。
+func programGraphHasCycle(edges []programGraphEdge) bool {
+	adjacency := map[uint][]uint{}
+	for _, edge := range edges {
+		if edge.Directed {
+			adjacency[edge.SourceProgramID] = append(adjacency[edge.SourceProgramID], edge.TargetProgramID)
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[uint]int{}
+	var visit func(id uint) bool
+	visit = func(id uint) bool {
+		state[id] = visiting
+		for _, next := range adjacency[id] {
+			switch state[next] {
+			case visiting:
+				return true
+			case unvisited:
+				if visit(next) {
+					return true
+				}
+			}
+		}
+		state[id] = done
+		return false
+	}
+	for id := range adjacency {
+		if state[id] == unvisited && visit(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// wouldCreateProgramCycle 判断新增有向边 source→target 后是否成环，即 target 能否沿有向边回到 source。
+// 环检测基于全量数据，不受当前用户可见范围影响。
+func wouldCreateProgramCycle(tx *gorm.DB, sourceID, targetID uint) (bool, error) {
+	if sourceID == targetID {
+		return true, nil
+	}
+	visited := map[uint]struct{}{targetID: {}}
+	frontier := []uint{targetID}
+	for len(frontier) > 0 {
+		edges, err := loadProgramGraphEdges(tx, frontier)
+		if err != nil {
+			return false, err
+		}
+		inFrontier := make(map[uint]struct{}, len(frontier))
+		for _, id := range frontier {
+			inFrontier[id] = struct{}{}
+		}
+
+		next := make([]uint, 0)
+		for _, edge := range edges {
+			if !edge.Directed {
+				continue
+			}
+			if _, ok := inFrontier[edge.SourceProgramID]; !ok {
+				continue
+			}
+			if edge.TargetProgramID == sourceID {
+				return true, nil
+			}
+			if _, ok := visited[edge.TargetProgramID]; ok {
+				continue
+			}
+			visited[edge.TargetProgramID] = struct{}{}
+			next = append(next, edge.TargetProgramID)
+		}
+		if len(visited) > programGraphCycleSearchLimit {
+			return true, nil
+		}
+		frontier = next
+	}
+	return false, nil
+}
+
+func parseProgramGraphMaxNodes(c *gin.Context) (int, error) {
+	return parsePositiveIntQuery(c.Query("max_nodes"), programGraphDefaultMaxNodes, programGraphLimitMaxNodes, "max_nodes")
+}
+
+// GetProgramGraph 返回程序所在的映射/关联连通分量，节点按产线查看权限过滤。
+func GetProgramGraph(c *gin.Context) {
+	programID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "程序ID格式错误"})
+		return
+	}
+	maxNodes, err := parseProgramGraphMaxNodes(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	graph, statusCode, message := buildProgramGraph(c, database.DB, programID, maxNodes)
+	if statusCode != 0 {
+		c.JSON(statusCode, gin.H{"error": message})
+		return
+	}
+	c.JSON(http.StatusOK, graph)
+}
+
+type programImpactItem struct {
+	Program       programGraphNode `json:"program"`
+	Depth         int              `json:"depth"`
+	Via           string           `json:"via"`
+	RelationType  string           `json:"relation_type,omitempty"`
+	FromProgramID uint             `json:"from_program_id"`
+}
+
+// programImpactNeighbors 返回修改 programID 后会直接受影响的程序：
+// 映射子程序继承父程序数据；对称关联双向影响；有向关联按“source 依赖 related”理解，related 变化影响 source。
+func programImpactNeighbors(edges []programGraphEdge, programID uint) []programGraphEdge {
+	affected := make([]programGraphEdge, 0)
+	for _, edge := range edges {
+		switch {
+		case edge.Kind == programGraphEdgeMapping && edge.SourceProgramID == programID:
+			affected = append(affected, edge)
+		case edge.Kind == programGraphEdgeRelation && !edge.Directed && (edge.SourceProgramID == programID || edge.TargetProgramID == programID):
+			affected = append(affected, edge)
+		case edge.Kind == programGraphEdgeRelation && edge.Directed && edge.TargetProgramID == programID:
+			affected = append(affected, edge)
+		}
+	}
+	return affected
+}
+
+func programImpactOtherEnd(edge programGraphEdge, programID uint) uint {
+	if edge.SourceProgramID == programID {
+		return edge.TargetProgramID
+	}
+	return edge.SourceProgramID
+}
+
+// GetProgramImpact 分析修改程序会波及哪些程序。映射子程序按其父程序分析，因为修改实际写入父程序。
+// 不可见的受影响程序只计数，不返回明细。
+func GetProgramImpact(c *gin.Context) {
+	programID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "程序ID格式错误"})
+		return
+	}
+	maxNodes, err := parseProgramGraphMaxNodes(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !authorizeProgramAction(c, database.DB, programID, lineActionView) {
+		return
+	}
+	_, targetProgramID, _, err := resolveProgramTarget(database.DB, programID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "程序不存在"})
+		return
+	}
+
+	visibility := newProgramGraphVisibility(c, database.DB)
+	affected := make([]programImpactItem, 0)
+	hidden := map[uint]struct{}{}
+	seen := map[uint]struct{}{targetProgramID: {}}
+	if programID != targetProgramID {
+		seen[programID] = struct{}{}
+	}
+	truncated := false
+
+	frontier := []uint{targetProgramID}
+	for depth := 1; len(frontier) > 0; depth++ {
+		edges, err := loadProgramGraphEdges(database.DB, frontier)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+
+		type candidate struct {
+			edge programGraphEdge
+			from uint
+			to   uint
+		}
+		candidates := make([]candidate, 0)
+		ids := make([]uint, 0)
+		for _, from := range frontier {
+			for _, edge := range programImpactNeighbors(edges, from) {
+				to := programImpactOtherEnd(edge, from)
+				if _, ok := seen[to]; ok {
+					continue
+				}
+				seen[to] = struct{}{}
+				candidates = append(candidates, candidate{edge: edge, from: from, to: to})
+				ids = append(ids, to)
+			}
+		}
+		if statusCode, message := visibility.resolve(ids); statusCode != 0 {
+			c.JSON(statusCode, gin.H{"error": message})
+			return
+		}
+
+		next := make([]uint, 0, len(candidates))
+		for _, item := range candidates {
+			// 不可见程序仍继续向下分析，避免因为权限缺口漏算可见的下游程序
+			next = append(next, item.to)
+			if !visibility.visible[item.to] {
+				if _, exists := visibility.programs[item.to]; exists {
+					hidden[item.to] = struct{}{}
+				}
+				continue
+			}
+			if len(affected) >= maxNodes {
+				truncated = true
+				continue
+			}
+			affected = append(affected, programImpactItem{
+				Program:       newProgramGraphNode(visibility.programs[item.to]),
+				Depth:         depth,
+				Via:           item.edge.Kind,
+				RelationType:  item.edge.RelationType,
+				FromProgramID: item.from,
+			})
+		}
+		if len(seen) > programGraphCycleSearchLimit {
+			truncated = true
+			break
+		}
+		frontier = next
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"program_id":        programID,
+		"target_program_id": targetProgramID,
+		"affected":          affected,
+		"hidden_count":      len(hidden),
+		"truncated":         truncated,
+	})
+}
@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+	"crane-system/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupProgramGraphTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	{
+		api.GET("/programs/:id/graph", GetProgramGraph)
+		api.GET("/programs/:id/impact", GetProgramImpact)
+		api.POST("/program-relations", CreateRelation)
+		api.POST("/program-mappings", CreateProgramMappings)
+	}
+	return r
+}
+
+// seedProgramGraphForTest 构造：P1 -映射-> P2 -calls-> P3 ~same_program~ P4，其中 P4 位于另一条产线。
+func seedProgramGraphForTest(t *testing.T, lineID uint) ([]models.Program, models.ProductionLine) {
+	t.Helper()
+	otherLine := models.ProductionLine{Name: "产线B", Code: "LINE-002", Status: "active"}
+	if err := database.DB.Create(&otherLine).Error; err != nil {
+		t.Fatalf("create line: %v", err)
+	}
+	programs := []models.Program{
+		{Name: "P1", Code: "GRAPH-001", ProductionLineID: lineID},
+		{Name: "P2", Code: "GRAPH-002", ProductionLineID: lineID},
+		{Name: "P3", Code: "GRAPH-003", ProductionLineID: lineID},
+		{Name: "P4", Code: "GRAPH-004", ProductionLineID: otherLine.ID},
+	}
+	for i := range programs {
+		if err := database.DB.Create(&programs[i]).Error; err != nil {
+			t.Fatalf("create program: %v", err)
+		}
+	}
+	if err := database.DB.Create(&models.ProgramMapping{ParentProgramID: programs[0].ID, ChildProgramID: programs[1].ID, CreatedBy: 1}).Error; err != nil {
+		t.Fatalf("create mapping: %v", err)
+	}
+	if err := database.DB.Create(&models.ProgramRelationType{Code: "calls", Name: "调用子程序", InverseName: "被调用", Directional: true}).Error; err != nil {
+		t.Fatalf("create relation type: %v", err)
+	}
+	relations := []models.ProgramRelation{
+		{SourceProgramID: programs[1].ID, RelatedProgramID: programs[2].ID, RelationType: "calls"},
+		{SourceProgramID: programs[2].ID, RelatedProgramID: programs[3].ID, RelationType: "same_program"},
+	}
+	for i := range relations {
+		if err := database.DB.Create(&relations[i]).Error; err != nil {
+			t.Fatalf("create relation: %v", err)
+		}
+	}
+	return programs, otherLine
+}
+
+func TestGetProgramGraphFiltersInvisibleNodes(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	adminToken, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	programs, _ := seedProgramGraphForTest(t, line.ID)
+	r := setupProgramGraphTestRouter()
+
+	graphPath := fmt.Sprintf("/api/programs/%d/graph", programs[1].ID)
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodGet, graphPath, adminToken, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	graph := decodeProductionLineCustomFieldResponse[programGraph](t, resp)
+	if len(graph.Nodes) != 4 || len(graph.Edges) != 3 || graph.HasCycle || graph.Truncated {
+		t.Fatalf("unexpected admin graph: %+v", graph)
+	}
+
+	user := models.User{Name: "Viewer", Password: "hashed", EmployeeID: "EMP-GRAPH-001", Role: "user", Status: "active"}
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	saveProductionLineRuleForTest(t, services.PermissionSubject{Type: models.PermissionSubjectUser, ID: user.ID}, line.ID, true, false, false, false)
+	userToken := createUserTokenForTest(t, user.ID, "user")
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, graphPath, userToken, nil)
+	graph = decodeProductionLineCustomFieldResponse[programGraph](t, resp)
+	if len(graph.Nodes) != 3 || len(graph.Edges) != 2 {
+		t.Fatalf("unexpected filtered graph: %+v", graph)
+	}
+	for _, node := range graph.Nodes {
+		if node.ID == programs[3].ID {
+			t.Fatalf("invisible program leaked into graph: %+v", graph)
+		}
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, graphPath+"?max_nodes=2", adminToken, nil)
+	graph = decodeProductionLineCustomFieldResponse[programGraph](t, resp)
+	if len(graph.Nodes) != 2 || !graph.Truncated {
+		t.Fatalf("unexpected truncated graph: %+v", graph)
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/programs/%d/graph", programs[3].ID), userToken, nil)
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("invisible root: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestGetProgramImpactFollowsDependencyDirection(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	adminToken, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	programs, _ := seedProgramGraphForTest(t, line.ID)
+	r := setupProgramGraphTestRouter()
+
+	type impactResponse struct {
+		TargetProgramID uint                `json:"target_program_id"`
+		Affected        []programImpactItem `json:"affected"`
+		HiddenCount     int                 `json:"hidden_count"`
+	}
+
+	// P3 被 P2 调用、与 P4 相同，修改 P3 会波及 P2、P4
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/programs/%d/impact", programs[2].ID), adminToken, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	impact := decodeProductionLineCustomFieldResponse[impactResponse](t, resp)
+	affected := map[uint]programImpactItem{}
+	for _, item := range impact.Affected {
+		affected[item.Program.ID] = item
+	}
+	if len(affected) != 2 || affected[programs[1].ID].RelationType != "calls" || affected[programs[3].ID].Depth != 1 {
+		t.Fatalf("unexpected impact: %+v", impact)
+	}
+
+	// P1 的修改波及映射子程序 P2；P2 依赖的 P3 不受影响
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/programs/%d/impact", programs[0].ID), adminToken, nil)
+	impact = decodeProductionLineCustomFieldResponse[impactResponse](t, resp)
+	if len(impact.Affected) != 1 || impact.Affected[0].Program.ID != programs[1].ID || impact.Affected[0].Via != programGraphEdgeMapping {
+		t.Fatalf("unexpected parent impact: %+v", impact)
+	}
+
+	// 子程序按父程序分析
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/programs/%d/impact", programs[1].ID), adminToken, nil)
+	impact = decodeProductionLineCustomFieldResponse[impactResponse](t, resp)
+	if impact.TargetProgramID != programs[0].ID {
+		t.Fatalf("child impact target = %d, want %d", impact.TargetProgramID, programs[0].ID)
+	}
+
+	user := models.User{Name: "Viewer", Password: "hashed", EmployeeID: "EMP-GRAPH-002", Role: "user", Status: "active"}
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	saveProductionLineRuleForTest(t, services.PermissionSubject{Type: models.PermissionSubjectUser, ID: user.ID}, line.ID, true, false, false, false)
+	userToken := createUserTokenForTest(t, user.ID, "user")
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/programs/%d/impact", programs[2].ID), userToken, nil)
+	impact = decodeProductionLineCustomFieldResponse[impactResponse](t, resp)
+	if len(impact.Affected) != 1 || impact.HiddenCount != 1 {
+		t.Fatalf("unexpected filtered impact: %+v", impact)
+	}
+}
+
+func TestCreateProgramLinksRejectCycles(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	adminToken, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	programs, _ := seedProgramGraphForTest(t, line.ID)
+	r := setupProgramGraphTestRouter()
+
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/program-relations", adminToken, map[string]any{
+		"source_program_id":  programs[2].ID,
+		"related_program_id": programs[0].ID,
+		"relation_type":      "calls",
+	})
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("directed cycle: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/program-relations", adminToken, map[string]any{
+		"source_program_id":  programs[2].ID,
+		"related_program_id": programs[0].ID,
+	})
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("symmetric relation: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	if relation := decodeProductionLineCustomFieldResponse[models.ProgramRelation](t, resp); relation.RelationType != "same_program" {
+		t.Fatalf("default relation type = %q", relation.RelationType)
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/program-relations", adminToken, map[string]any{
+		"source_program_id":  programs[0].ID,
+		"related_program_id": programs[0].ID,
+	})
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("self relation: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, fmt.Sprintf("/api/program-mappings?parent_program_id=%d", programs[2].ID), adminToken, map[string]any{
+		"child_program_ids": []uint{programs[0].ID},
+	})
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("mapping cycle: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+}
@@ -113,7 +113,7 @@ func GetProgramMappingsByParent(c *gin.Context) {
 
 	filteredMappings := make([]models.ProgramMapping, 0, len(mappings))
 	for _, mapping := range mappings {
-		allowed, statusCode, message := checkLineAction(c, mapping.ChildProgram.ProductionLineID, lineActionView)
+		allowed, statusCode, message := checkProgramScopeAction(c, mapping.ChildProgram.ID, mapping.ChildProgram.ProductionLineID, mapping.ChildProgram.VehicleModelID, lineActionView)
 		if !allowed {
 			if statusCode == http.StatusForbidden {
 				continue
@@ -184,7 +184,7 @@ func GetProgramMappingByChild(c *gin.Context) {
 		return
 	}
 
-	allowed, statusCode, message := checkLineAction(c, mapping.ParentProgram.ProductionLineID, lineActionView)
+	allowed, statusCode, message := checkProgramScopeAction(c, mapping.ParentProgram.ID, mapping.ParentProgram.ProductionLineID, mapping.ParentProgram.VehicleModelID, lineActionView)
 	if !allowed {
 		if statusCode == http.StatusForbidden {
 			c.JSON(http.StatusOK, gin.H{})
@@ -242,7 +242,7 @@ func CreateProgramMappings(c *gin.Context) {
 			if err := tx.First(&childProgram, childID).Error; err != nil {
 				return errors.New("??????")
 			}
-			allowed, _, _ := checkLineAction(c, childProgram.ProductionLineID, lineActionManage)
+			allowed, _, _ := checkProgramScopeAction(c, childProgram.ID, childProgram.ProductionLineID, childProgram.VehicleModelID, lineActionManage)
 			if !allowed {
 				return errors.New("forbidden_child_program")
 			}
@@ -251,6 +251,11 @@ func CreateProgramMappings(c *gin.Context) {
 			if err := tx.Where("child_program_id = ?", childID).First(&existingMapping).Error; err == nil {
 				return errors.New("????????")
 			}
+			if cyclic, err := wouldCreateProgramCycle(tx, parentProgram.ID, childID); err != nil {
+				return err
+			} else if cyclic {
+				return errProgramGraphCycle
+			}
 
 			var versionCount int64
 			if err := tx.Model(&models.ProgramVersion{}).Where("program_id = ?", childID).Count(&versionCount).Error; err != nil {
@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+	"crane-system/services"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupProgramPermissionOverrideTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	{
+		api.GET("/programs", GetPrograms)
+		api.GET("/programs/:id", GetProgram)
+		api.PUT("/programs/:id", UpdateProgram)
+		api.GET("/program-mappings/by-parent/:program_id", GetProgramMappingsByParent)
+		api.GET("/program-mappings/by-child/:program_id", GetProgramMappingByChild)
+		api.PUT("/permissions/users/:id/rules", SaveUserPermissionRules)
+		api.GET("/permissions/program-overrides", ListProgramPermissionOverrides)
+	}
+	return r
+}
+
+func TestProgramRulesOverrideLinePermissions(t *testing.T) {
+	db := openProductionLineCustomFieldTestDB(t)
+	database.DB = db
+	adminToken, line := seedProductionLineCustomFieldAuthData(t, db)
+	services.InvalidateAllCache()
+
+	department := models.Department{Name: "编程组", Status: "active"}
+	if err := db.Create(&department).Error; err != nil {
+		t.Fatalf("create department: %v", err)
+	}
+	regular := models.Program{Name: "常规程序", Code: "PRG-REGULAR", ProductionLineID: line.ID}
+	critical := models.Program{Name: "安全程序", Code: "PRG-CRITICAL", ProductionLineID: line.ID}
+	for _, program := range []*models.Program{&regular, &critical} {
+		if err := db.Create(program).Error; err != nil {
+			t.Fatalf("create program: %v", err)
+		}
+	}
+	users := map[string]*models.User{}
+	for _, employeeID := range []string{"SENIOR-001", "SENIOR-002", "JUNIOR-001"} {
+		user := models.User{Name: employeeID, Password: "hashed", EmployeeID: employeeID, Role: "viewer", Status: "active", DepartmentID: &department.ID}
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		users[employeeID] = &user
+	}
+
+	// 整个部门可查看和上传该产线，但安全程序对部门整体拒绝
+	departmentSubject := services.PermissionSubject{Type: models.PermissionSubjectDepartment, ID: department.ID}
+	if err := services.SavePermissionRuleChanges(departmentSubject, []services.PermissionRuleChange{
+		{ResourceType: models.PermissionResourceProductionLine, ResourceID: line.ID, Action: models.PermissionActionView, Decision: models.PermissionDecisionAllow},
+		{ResourceType: models.PermissionResourceProductionLine, ResourceID: line.ID, Action: models.PermissionActionUpload, Decision: models.PermissionDecisionAllow},
+		{ResourceType: models.PermissionResourceProgram, ResourceID: critical.ID, Action: models.PermissionActionView, Decision: models.PermissionDecisionDeny},
+		{ResourceType: models.PermissionResourceProgram, ResourceID: critical.ID, Action: models.PermissionActionUpload, Decision: models.PermissionDecisionDeny},
+	}); err != nil {
+		t.Fatalf("save department rules: %v", err)
+	}
+
+	router := setupProgramPermissionOverrideTestRouter()
+	for _, employeeID := range []string{"SENIOR-001", "SENIOR-002"} {
+		recorder := performProductionLineCustomFieldRequest(t, router, http.MethodPut, fmt.Sprintf("/api/permissions/users/%d/rules", users[employeeID].ID), adminToken, map[string]any{
+			"changes": []map[string]any{
+				{"resource_type": models.PermissionResourceProgram, "resource_id": critical.ID, "action": models.PermissionActionView, "decision": models.PermissionDecisionAllow},
+				{"resource_type": models.PermissionResourceProgram, "resource_id": critical.ID, "action": models.PermissionActionUpload, "decision": models.PermissionDecisionAllow},
+			},
+		})
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("save user program rule: %d %s", recorder.Code, recorder.Body.String())
+		}
+	}
+	recorder := performProductionLineCustomFieldRequest(t, router, http.MethodPut, fmt.Sprintf("/api/permissions/users/%d/rules", users["JUNIOR-001"].ID), adminToken, map[string]any{
+		"changes": []map[string]any{
+			{"resource_type": models.PermissionResourceProgram, "resource_id": critical.ID, "production_line_id": line.ID, "action": models.PermissionActionView, "decision": models.PermissionDecisionAllow},
+		},
+	})
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected program rule with production_line_id to be rejected, got %d", recorder.Code)
+	}
+
+	listCodes := func(token string) map[string]bool {
+		t.Helper()
+		recorder := performProductionLineCustomFieldRequest(t, router, http.MethodGet, "/api/programs", token, nil)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("list programs: %d %s", recorder.Code, recorder.Body.String())
+		}
+		codes := map[string]bool{}
+		for _, item := range decodeProductionLineCustomFieldResponse[[]models.Program](t, recorder) {
+			codes[item.Code] = true
+		}
+		return codes
+	}
+
+	seniorToken := createUserTokenForTest(t, users["SENIOR-001"].ID, "viewer")
+	if codes := listCodes(seniorToken); len(codes) != 2 || !codes["PRG-CRITICAL"] {
+		t.Fatalf("senior programmer should see both programs, got %v", codes)
+	}
+	juniorToken := createUserTokenForTest(t, users["JUNIOR-001"].ID, "viewer")
+	if codes := listCodes(juniorToken); len(codes) != 1 || !codes["PRG-REGULAR"] {
+		t.Fatalf("junior programmer should only see the regular program, got %v", codes)
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, fmt.Sprintf("/api/programs/%d", critical.ID), juniorToken, nil)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("junior programmer should not open the critical program, got %d", recorder.Code)
+	}
+
+	for employeeID, want := range map[string]bool{"SENIOR-001": true, "SENIOR-002": true, "JUNIOR-001": false} {
+		if got := services.CheckProgramScopeAction(users[employeeID].ID, "viewer", critical.ID, line.ID, 0, services.LineActionUpload).Allowed; got != want {
+			t.Fatalf("%s upload to critical program = %v, want %v", employeeID, got, want)
+		}
+		if !services.CheckProgramScopeAction(users[employeeID].ID, "viewer", regular.ID, line.ID, 0, services.LineActionUpload).Allowed {
+			t.Fatalf("%s should keep line upload on the regular program", employeeID)
+		}
+	}
+
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, "/api/permissions/program-overrides", adminToken, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("list program overrides: %d %s", recorder.Code, recorder.Body.String())
+	}
+	overrides := decodeProductionLineCustomFieldResponse[struct {
+		Items []services.ProgramOverride `json:"items"`
+	}](t, recorder).Items
+	if len(overrides) != 1 || overrides[0].ProgramID != critical.ID || overrides[0].ProductionLineName != line.Name {
+		t.Fatalf("expected only the critical program to be listed, got %+v", overrides)
+	}
+	if len(overrides[0].Rules) != 6 {
+		t.Fatalf("expected 6 program rules, got %+v", overrides[0].Rules)
+	}
+	subjects := map[string]bool{}
+	for _, rule := range overrides[0].Rules {
+		subjects[rule.SubjectName] = true
+	}
+	if !subjects["编程组"] || !subjects["SENIOR-001"] || !subjects["SENIOR-002"] {
+		t.Fatalf("expected subject names in overrides, got %v", subjects)
+	}
+
+	// 映射查询同样按程序规则隐藏安全程序
+	child := models.Program{Name: "子程序", Code: "PRG-CHILD", ProductionLineID: line.ID}
+	if err := db.Create(&child).Error; err != nil {
+		t.Fatalf("create child program: %v", err)
+	}
+	for _, mapping := range []models.ProgramMapping{
+		{ParentProgramID: regular.ID, ChildProgramID: critical.ID},
+		{ParentProgramID: critical.ID, ChildProgramID: child.ID},
+	} {
+		if err := db.Create(&mapping).Error; err != nil {
+			t.Fatalf("create mapping: %v", err)
+		}
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, fmt.Sprintf("/api/program-mappings/by-parent/%d", regular.ID), juniorToken, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("list mappings by parent: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if mappings := decodeProductionLineCustomFieldResponse[[]models.ProgramMapping](t, recorder); len(mappings) != 0 {
+		t.Fatalf("junior programmer should not see the critical child mapping, got %+v", mappings)
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, fmt.Sprintf("/api/program-mappings/by-child/%d", child.ID), juniorToken, nil)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "{}" {
+		t.Fatalf("junior programmer should not see the critical parent mapping, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, fmt.Sprintf("/api/program-mappings/by-child/%d", child.ID), seniorToken, nil)
+	if mapping := decodeProductionLineCustomFieldResponse[models.ProgramMapping](t, recorder); mapping.ParentProgramID != critical.ID {
+		t.Fatalf("senior programmer should see the critical parent mapping, got %s", recorder.Body.String())
+	}
+}
+
+func TestUpdateProgramHonorsProgramAndModelRules(t *testing.T) {
+	db := openProductionLineCustomFieldTestDB(t)
+	database.DB = db
+	_, line := seedProductionLineCustomFieldAuthData(t, db)
+	services.InvalidateAllCache()
+
+	restricted := models.VehicleModel{Name: "受限车型", Code: "VM-RESTRICTED", Status: "active"}
+	if err := db.Create(&restricted).Error; err != nil {
+		t.Fatalf("create vehicle model: %v", err)
+	}
+	locked := models.Program{Name: "锁定程序", Code: "PRG-LOCKED", ProductionLineID: line.ID}
+	open := models.Program{Name: "普通程序", Code: "PRG-OPEN", ProductionLineID: line.ID}
+	for _, program := range []*models.Program{&locked, &open} {
+		if err := db.Create(program).Error; err != nil {
+			t.Fatalf("create program: %v", err)
+		}
+	}
+	editor := models.User{Name: "Editor", Password: "hashed", EmployeeID: "EDIT-001", Role: "engineer", Status: "active"}
+	if err := db.Create(&editor).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	// 产线管理权限放开，但锁定程序和受限车型被拒绝
+	if err := services.SavePermissionRuleChanges(services.PermissionSubject{Type: models.PermissionSubjectUser, ID: editor.ID}, []services.PermissionRuleChange{
+		{ResourceType: models.PermissionResourceProductionLine, ResourceID: line.ID, Action: models.PermissionActionManage, Decision: models.PermissionDecisionAllow},
+		{ResourceType: models.PermissionResourceProgram, ResourceID: locked.ID, Action: models.PermissionActionManage, Decision: models.PermissionDecisionDeny},
+		{ResourceType: models.PermissionResourceVehicleModel, ResourceID: restricted.ID, Action: models.PermissionActionManage, Decision: models.PermissionDecisionDeny},
+	}); err != nil {
+		t.Fatalf("save user rules: %v", err)
+	}
+
+	router := setupProgramPermissionOverrideTestRouter()
+	editorToken := createUserTokenForTest(t, editor.ID, "engineer")
+	recorder := performProductionLineCustomFieldRequest(t, router, http.MethodPut, fmt.Sprintf("/api/programs/%d", locked.ID), editorToken, map[string]any{"name": "改名"})
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected program deny to block edit, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodPut, fmt.Sprintf("/api/programs/%d", open.ID), editorToken, map[string]any{"vehicle_model_id": restricted.ID})
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected move into denied vehicle model to be rejected, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodPut, fmt.Sprintf("/api/programs/%d", open.ID), editorToken, map[string]any{"name": "普通程序-改"})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected edit of allowed program to succeed, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
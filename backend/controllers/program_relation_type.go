@@ -0,0 +1,272 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"crane-system/database"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const programRelationTypeSameProgram = "same_program"
+
+var programRelationTypeCodePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,49}$`)
+
+// builtinProgramRelationTypes 是始终可用的内置关联类型，兼容历史上写死的 same_program。
+func builtinProgramRelationTypes() []models.ProgramRelationType {
+	return []models.ProgramRelationType{
+		{Code: programRelationTypeSameProgram, Name: "相同程序", Builtin: true},
+	}
+}
+
+// programRelationTypeCatalog 按编码索引内置和自定义的关联类型。
+type programRelationTypeCatalog map[string]models.ProgramRelationType
+
+func loadProgramRelationTypeCatalog(tx *gorm.DB) (programRelationTypeCatalog, error) {
+	var types []models.ProgramRelationType
+	if err := tx.Find(&types).Error; err != nil {
+		return nil, err
+	}
+	catalog := make(programRelationTypeCatalog, len(types)+1)
+	for _, item := range builtinProgramRelationTypes() {
+		catalog[item.Code] = item
+	}
+	for _, item := range types {
+		if _, builtin := catalog[item.Code]; builtin {
+			continue
+		}
+		catalog[item.Code] = item
+	}
+	return catalog, nil
+}
+
+// directed 判断关联是否有方向；未定义的历史类型按对称处理，不参与环检测。
+func (catalog programRelationTypeCatalog) directed(code string) bool {
+	return catalog[code].Directional
+}
+
+// label 返回关联类型名称；fromRelated=true 表示从关联程序一侧看，有向类型使用反向名称。
+func (catalog programRelationTypeCatalog) label(code string, fromRelated bool) string {
+	item, ok := catalog[code]
+	if !ok {
+		if code == "" {
+			return catalog[programRelationTypeSameProgram].Name
+		}
+		return code
+	}
+	if fromRelated && item.Directional && item.InverseName != "" {
+		return item.InverseName
+	}
+	return item.Name
+}
+
+func (catalog programRelationTypeCatalog) sorted() []models.ProgramRelationType {
+	items := make([]models.ProgramRelationType, 0, len(catalog))
+	for _, item := range catalog {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Builtin != items[j].Builtin {
+			return items[i].Builtin
+		}
+		if items[i].SortOrder != items[j].SortOrder {
+			return items[i].SortOrder < items[j].SortOrder
+		}
+		return items[i].Code < items[j].Code
+	})
+	return items
+}
+
+// parseProgramRelationTypeFilter 解析逗号分隔的 relation_type 筛选参数，并校验类型已定义。
+func parseProgramRelationTypeFilter(raw string, catalog programRelationTypeCatalog) ([]string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	codes := make([]string, 0)
+	for _, part := range strings.Split(raw, ",") {
+		code := strings.TrimSpace(part)
+		if code == "" {
+			continue
+		}
+		if _, ok := catalog[code]; !ok {
+			return nil, errors.New("未定义的关联类型: " + code)
+		}
+		codes = append(codes, code)
+		// 早期数据未填写类型，按 same_program 处理
+		if code == programRelationTypeSameProgram {
+			codes = append(codes, "")
+		}
+	}
+	return codes, nil
+}
+
+// GetProgramRelationTypes 返回全部可用的关联类型，内置类型在前。
+func GetProgramRelationTypes(c *gin.Context) {
+	catalog, err := loadProgramRelationTypeCatalog(database.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, catalog.sorted())
+}
+
+type programRelationTypeRequest struct {
+	Code        string  `json:"code"`
+	Name        *string `json:"name"`
+	InverseName *string `json:"inverse_name"`
+	Directional *bool   `json:"directional"`
+	Description *string `json:"description"`
+	SortOrder   *int    `json:"sort_order"`
+}
+
+func CreateProgramRelationType(c *gin.Context) {
+	var req programRelationTypeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	relationType := models.ProgramRelationType{Code: strings.TrimSpace(req.Code)}
+	if !programRelationTypeCodePattern.MatchString(relationType.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "类型编码只能包含小写字母、数字、下划线和连字符，且以字母开头"})
+		return
+	}
+	if req.Name == nil || strings.TrimSpace(*req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "类型名称不能为空"})
+		return
+	}
+	relationType.Name = strings.TrimSpace(*req.Name)
+	if req.InverseName != nil {
+		relationType.InverseName = strings.TrimSpace(*req.InverseName)
+	}
+	if req.Directional != nil {
+		relationType.Directional = *req.Directional
+	}
+	if req.Description != nil {
+		relationType.Description = *req.Description
+	}
+	if req.SortOrder != nil {
+		relationType.SortOrder = *req.SortOrder
+	}
+
+	catalog, err := loadProgramRelationTypeCatalog(database.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	if _, exists := catalog[relationType.Code]; exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "关联类型编码已存在"})
+		return
+	}
+
+	if err := database.DB.Create(&relationType).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建关联类型失败"})
+		return
+	}
+	recordAuditChange(c, "program_relation_type", relationType.ID, nil, relationType)
+	c.JSON(http.StatusCreated, relationType)
+}
+
+// UpdateProgramRelationType 编码创建后不可修改；已被使用的类型不能切换方向，避免绕过环检测。
+func UpdateProgramRelationType(c *gin.Context) {
+	typeID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "关联类型ID格式错误"})
+		return
+	}
+	var relationType models.ProgramRelationType
+	if err := database.DB.First(&relationType, typeID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "关联类型不存在"})
+		return
+	}
+
+	var req programRelationTypeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if code := strings.TrimSpace(req.Code); code != "" && code != relationType.Code {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "关联类型编码不可修改"})
+		return
+	}
+
+	updates := map[string]any{}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "类型名称不能为空"})
+			return
+		}
+		updates["name"] = name
+	}
+	if req.InverseName != nil {
+		updates["inverse_name"] = strings.TrimSpace(*req.InverseName)
+	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.SortOrder != nil {
+		updates["sort_order"] = *req.SortOrder
+	}
+	if req.Directional != nil && *req.Directional != relationType.Directional {
+		if dependency, err := findMasterDataDependency([]masterDataDependencyCheck{
+			{Model: &models.ProgramRelation{}, Where: "relation_type = ?", Args: []any{relationType.Code}, Label: "program relations"},
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "dependency check failed"})
+			return
+		} else if dependency != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": "relation type is in use by " + dependency})
+			return
+		}
+		updates["directional"] = *req.Directional
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未提供可更新字段"})
+		return
+	}
+
+	before := relationType
+	if err := database.DB.Model(&relationType).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新关联类型失败"})
+		return
+	}
+	recordAuditChange(c, "program_relation_type", relationType.ID, before, relationType)
+	c.JSON(http.StatusOK, relationType)
+}
+
+func DeleteProgramRelationType(c *gin.Context) {
+	typeID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "关联类型ID格式错误"})
+		return
+	}
+	var relationType models.ProgramRelationType
+	if err := database.DB.First(&relationType, typeID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "关联类型不存在"})
+		return
+	}
+
+	if dependency, err := findMasterDataDependency([]masterDataDependencyCheck{
+		{Model: &models.ProgramRelation{}, Where: "relation_type = ?", Args: []any{relationType.Code}, Label: "program relations"},
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "dependency check failed"})
+		return
+	} else if dependency != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "relation type is in use by " + dependency})
+		return
+	}
+
+	if err := database.DB.Delete(&models.ProgramRelationType{}, relationType.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除关联类型失败"})
+		return
+	}
+	recordAuditChange(c, "program_relation_type", relationType.ID, relationType, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "关联类型已删除"})
+}
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+func setupProgramRelationTypeTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	{
+		api.GET("/program-relation-types", GetProgramRelationTypes)
+		api.POST("/program-relation-types", CreateProgramRelationType)
+		api.PUT("/program-relation-types/:id", UpdateProgramRelationType)
+		api.DELETE("/program-relation-types/:id", DeleteProgramRelationType)
+		api.GET("/program-relations/program/:program_id", GetProgramRelations)
+		api.POST("/program-relations", CreateRelation)
+	}
+	return r
+}
+
+func TestProgramRelationTypesDriveRelationValidation(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	token, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupProgramRelationTypeTestRouter()
+
+	programs := []models.Program{
+		{Name: "P1", Code: "REL-001", ProductionLineID: line.ID},
+		{Name: "P2", Code: "REL-002", ProductionLineID: line.ID},
+	}
+	for i := range programs {
+		if err := database.DB.Create(&programs[i]).Error; err != nil {
+			t.Fatalf("create program: %v", err)
+		}
+	}
+
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/program-relation-types", token, map[string]any{"code": "Shares Tooling", "name": "共用工装"})
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("invalid code: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/program-relation-types", token, map[string]any{"code": "same_program", "name": "重复"})
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("builtin code: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/program-relation-types", token, map[string]any{"code": "shares-tooling", "name": "共用工装"})
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create symmetric type: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	sharesTooling := decodeProductionLineCustomFieldResponse[models.ProgramRelationType](t, resp)
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/program-relation-types", token, map[string]any{
+		"code": "replaces", "name": "替代", "inverse_name": "被替代", "directional": true,
+	})
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create directional type: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, "/api/program-relation-types", token, nil)
+	types := decodeProductionLineCustomFieldResponse[[]models.ProgramRelationType](t, resp)
+	if len(types) != 3 || !types[0].Builtin || types[0].Code != "same_program" {
+		t.Fatalf("unexpected relation types: %+v", types)
+	}
+
+	createRelation := func(source, related uint, relationType string) int {
+		resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/program-relations", token, map[string]any{
+			"source_program_id":  source,
+			"related_program_id": related,
+			"relation_type":      relationType,
+		})
+		return resp.Code
+	}
+	if code := createRelation(programs[0].ID, programs[1].ID, "unknown"); code != http.StatusBadRequest {
+		t.Fatalf("unknown type: status = %d", code)
+	}
+	if code := createRelation(programs[0].ID, programs[1].ID, "shares-tooling"); code != http.StatusCreated {
+		t.Fatalf("symmetric relation: status = %d", code)
+	}
+	if code := createRelation(programs[1].ID, programs[0].ID, "shares-tooling"); code != http.StatusConflict {
+		t.Fatalf("reversed symmetric duplicate: status = %d", code)
+	}
+	if code := createRelation(programs[0].ID, programs[1].ID, "replaces"); code != http.StatusCreated {
+		t.Fatalf("directional relation: status = %d", code)
+	}
+	if code := createRelation(programs[1].ID, programs[0].ID, "replaces"); code != http.StatusBadRequest {
+		t.Fatalf("directional cycle: status = %d", code)
+	}
+
+	listPath := fmt.Sprintf("/api/program-relations/program/%d", programs[0].ID)
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, listPath+"?relation_type=replaces", token, nil)
+	relations := decodeProductionLineCustomFieldResponse[[]models.ProgramRelation](t, resp)
+	if len(relations) != 1 || relations[0].RelationType != "replaces" {
+		t.Fatalf("filtered relations: %+v", relations)
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, listPath+"?relation_type=missing", token, nil)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("unknown filter: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+
+	typePath := fmt.Sprintf("/api/program-relation-types/%d", sharesTooling.ID)
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPut, typePath, token, map[string]any{"directional": true})
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("toggle direction in use: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPut, typePath, token, map[string]any{"name": "共用夹具"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("rename: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodDelete, typePath, token, nil)
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("delete in use: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestWriteProgramRelationsSheet(t *testing.T) {
+	catalog := programRelationTypeCatalog{
+		"same_program": {Code: "same_program", Name: "相同程序", Builtin: true},
+		"replaces":     {Code: "replaces", Name: "替代", InverseName: "被替代", Directional: true},
+	}
+	relations := []models.ProgramRelation{
+		{
+			RelationType:   "replaces",
+			SourceProgram:  models.Program{Code: "P-002", Name: "新程序", ProductionLine: models.ProductionLine{Name: "产线A"}},
+			RelatedProgram: models.Program{Code: "P-001", Name: "旧程序", ProductionLine: models.ProductionLine{Name: "产线B"}},
+			Description:    "换型",
+		},
+		{
+			SourceProgram:  models.Program{Code: "P-003"},
+			RelatedProgram: models.Program{Code: "P-004"},
+		},
+	}
+
+	f := excelize.NewFile()
+	defer func() { _ = f.Close() }()
+	writeProgramRelationsSheet(f, relations, catalog)
+
+	rows, err := f.GetRows("程序关联")
+	if err != nil {
+		t.Fatalf("read rows: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+	if got := fmt.Sprint(rows[1]); got != "[P-002 新程序 产线A 替代 有向 P-001 旧程序 产线B 换型]" {
+		t.Fatalf("directional row = %s", got)
+	}
+	if rows[2][3] != "相同程序" || rows[2][4] != "对称" {
+		t.Fatalf("legacy relation row = %v", rows[2])
+	}
+}
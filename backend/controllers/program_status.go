@@ -0,0 +1,511 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"crane-system/database"
+	"crane-system/models"
+	"crane-system/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errProgramStatusInvalid            = errors.New("程序状态不在产线状态集中")
+	errProgramStatusTransitionDenied   = errors.New("不允许从当前状态流转到目标状态")
+	errProgramStatusTransitionRoleDeny = errors.New("当前角色无权执行该状态流转")
+	errProgramStatusInUse              = errors.New("仍有程序处于该状态")
+)
+
+const programStatusCodeMaxLength = 20
+
+// defaultProgramStatusDefinitions 是产线未单独配置时的内置状态集。
+// in_progress/completed 保留用于兼容历史数据，新建程序默认仍为 in_progress。
+func defaultProgramStatusDefinitions(lineID uint) []models.ProgramStatusDefinition {
+	defaults := []struct {
+		code      string
+		name      string
+		initial   bool
+		completed bool
+	}{
+		{"not_started", "未开始", false, false},
+		{"in_progress", "进行中", true, false},
+		{"programming", "编程中", false, false},
+		{"simulation", "仿真中", false, false},
+		{"onsite_debugging", "现场调试", false, false},
+		{"completed", "已完成", false, true},
+		{"accepted", "已验收", false, true},
+		{"frozen", "已冻结", false, true},
+		{"retired", "已退役", false, false},
+	}
+
+	statuses := make([]models.ProgramStatusDefinition, 0, len(defaults))
+	for i, item := range defaults {
+		statuses = append(statuses, models.ProgramStatusDefinition{
+			ProductionLineID:  lineID,
+			Code:              item.code,
+			Name:              item.name,
+			SortOrder:         i + 1,
+			IsInitial:         item.initial,
+			CountsAsCompleted: item.completed,
+		})
+	}
+	return statuses
+}
+
+// programStatusConfig 是某条产线生效的状态集合和流转规则。
+type programStatusConfig struct {
+	ProductionLineID uint                             `json:"production_line_id"`
+	Customized       bool                             `json:"customized"`
+	Statuses         []models.ProgramStatusDefinition `json:"statuses"`
+	Transitions      []models.ProgramStatusTransition `json:"transitions"`
+}
+
+func loadProgramStatusConfig(tx *gorm.DB, lineID uint) (programStatusConfig, error) {
+	config := programStatusConfig{ProductionLineID: lineID, Transitions: []models.ProgramStatusTransition{}}
+	if err := tx.Where("production_line_id = ?", lineID).Order("sort_order asc, id asc").Find(&config.Statuses).Error; err != nil {
+		return programStatusConfig{}, err
+	}
+	if len(config.Statuses) == 0 {
+		config.Statuses = defaultProgramStatusDefinitions(lineID)
+		return config, nil
+	}
+
+	config.Customized = true
+	if err := tx.Where("production_line_id = ?", lineID).Order("id asc").Find(&config.Transitions).Error; err != nil {
+		return programStatusConfig{}, err
+	}
+	return config, nil
+}
+
+func (cfg programStatusConfig) has(status string) bool {
+	for _, definition := range cfg.Statuses {
+		if definition.Code == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg programStatusConfig) initialStatus() string {
+	for _, definition := range cfg.Statuses {
+		if definition.IsInitial {
+			return definition.Code
+		}
+	}
+	if len(cfg.Statuses) > 0 {
+		return cfg.Statuses[0].Code
+	}
+	return "in_progress"
+}
+
+// checkTransition 校验 from→to 是否允许。
+// 未配置流转规则时状态集合内可任意切换；当前状态不在集合内（历史数据）时允许迁回集合。
+func (cfg programStatusConfig) checkTransition(from, to, role string) error {
+	if from == to {
+		return nil
+	}
+	if !cfg.has(to) {
+		return errProgramStatusInvalid
+	}
+	if len(cfg.Transitions) == 0 || from == "" || !cfg.has(from) {
+		return nil
+	}
+
+	matched := false
+	for _, transition := range cfg.Transitions {
+		if transition.FromStatus != from || transition.ToStatus != to {
+			continue
+		}
+		matched = true
+		if programStatusTransitionRoleAllowed(transition.RequiredRoles, role) {
+			return nil
+		}
+	}
+	if matched {
+		return errProgramStatusTransitionRoleDeny
+	}
+	return errProgramStatusTransitionDenied
+}
+
+func programStatusTransitionRoleAllowed(requiredRoles, role string) bool {
+	if strings.TrimSpace(requiredRoles) == "" || services.IsSystemAdminRole(role) {
+		return true
+	}
+	for _, required := range strings.Split(requiredRoles, ",") {
+		if strings.TrimSpace(required) == role {
+			return true
+		}
+	}
+	return false
+}
+
+// validateProgramStatusUpdate 校验程序更新后的状态：修改状态时按目标产线的流转规则校验；
+// 仅迁移产线时，原状态若属于原产线状态集，则目标产线也必须包含该状态。
+func validateProgramStatusUpdate(tx *gorm.DB, program models.Program, nextLineID uint, nextStatus, role string) error {
+	nextConfig, err := loadProgramStatusConfig(tx, nextLineID)
+	if err != nil {
+		return err
+	}
+	if nextStatus != program.Status {
+		return nextConfig.checkTransition(program.Status, nextStatus, role)
+	}
+	if nextLineID == program.ProductionLineID || nextConfig.has(nextStatus) {
+		return nil
+	}
+	currentConfig, err := loadProgramStatusConfig(tx, program.ProductionLineID)
+	if err != nil {
+		return err
+	}
+	if currentConfig.has(program.Status) {
+		return errProgramStatusInvalid
+	}
+	return nil
+}
+
+func isProgramStatusError(err error) bool {
+	return errors.Is(err, errProgramStatusInvalid) ||
+		errors.Is(err, errProgramStatusTransitionDenied) ||
+		errors.Is(err, errProgramStatusTransitionRoleDeny)
+}
+
+func programStatusErrorStatusCode(err error) int {
+	if errors.Is(err, errProgramStatusTransitionRoleDeny) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// recordProgramStatusChange 记录状态流转历史并刷新程序的最近变更时间，需在写入程序的同一事务中调用。
+func recordProgramStatusChange(tx *gorm.DB, programID uint, from, to string, changedBy uint) error {
+	history := models.ProgramStatusHistory{
+		ProgramID:  programID,
+		FromStatus: from,
+		ToStatus:   to,
+		ChangedBy:  changedBy,
+	}
+	if err := tx.Create(&history).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.Program{}).Where("id = ?", programID).Update("status_changed_at", history.CreatedAt).Error
+}
+
+// programStatusCatalog 缓存所有产线的状态定义，供导出和统计按产线解析状态名称与完成口径。
+type programStatusCatalog struct {
+	lines    map[uint][]models.ProgramStatusDefinition
+	defaults []models.ProgramStatusDefinition
+}
+
+func loadProgramStatusCatalog(tx *gorm.DB) (programStatusCatalog, error) {
+	catalog := programStatusCatalog{
+		lines:    map[uint][]models.ProgramStatusDefinition{},
+		defaults: defaultProgramStatusDefinitions(0),
+	}
+	var definitions []models.ProgramStatusDefinition
+	if err := tx.Order("sort_order asc, id asc").Find(&definitions).Error; err != nil {
+		return programStatusCatalog{}, err
+	}
+	for _, definition := range definitions {
+		catalog.lines[definition.ProductionLineID] = append(catalog.lines[definition.ProductionLineID], definition)
+	}
+	return catalog, nil
+}
+
+func (catalog programStatusCatalog) definitions(lineID uint) []models.ProgramStatusDefinition {
+	if definitions, ok := catalog.lines[lineID]; ok {
+		return definitions
+	}
+	return catalog.defaults
+}
+
+func (catalog programStatusCatalog) find(lineID uint, status string) (models.ProgramStatusDefinition, bool) {
+	for _, definition := range catalog.definitions(lineID) {
+		if definition.Code == status {
+			return definition, true
+		}
+	}
+	return models.ProgramStatusDefinition{}, false
+}
+
+func (catalog programStatusCatalog) completed(lineID uint, status string) bool {
+	definition, ok := catalog.find(lineID, status)
+	return ok && definition.CountsAsCompleted
+}
+
+func (catalog programStatusCatalog) label(lineID uint, status string) string {
+	if definition, ok := catalog.find(lineID, status); ok {
+		return definition.Name
+	}
+	for _, definition := range catalog.defaults {
+		if definition.Code == status {
+			return definition.Name
+		}
+	}
+	return status
+}
+
+// orderedStatusCodes 返回给定状态编码按默认状态集顺序、再按编码排序的结果，用于统计表列顺序稳定。
+func (catalog programStatusCatalog) orderedStatusCodes(codes map[string]struct{}) []string {
+	rank := make(map[string]int, len(catalog.defaults))
+	for i, definition := range catalog.defaults {
+		rank[definition.Code] = i
+	}
+	ordered := make([]string, 0, len(codes))
+	for code := range codes {
+		ordered = append(ordered, code)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		ri, iok := rank[ordered[i]]
+		rj, jok := rank[ordered[j]]
+		if iok != jok {
+			return iok
+		}
+		if iok && ri != rj {
+			return ri < rj
+		}
+		return ordered[i] < ordered[j]
+	})
+	return ordered
+}
+
+type programStatusDefinitionInput struct {
+	Code              string `json:"code"`
+	Name              string `json:"name"`
+	SortOrder         int    `json:"sort_order"`
+	IsInitial         bool   `json:"is_initial"`
+	CountsAsCompleted bool   `json:"counts_as_completed"`
+}
+
+type programStatusTransitionInput struct {
+	FromStatus    string   `json:"from_status"`
+	ToStatus      string   `json:"to_status"`
+	RequiredRoles []string `json:"required_roles"`
+}
+
+type saveProgramStatusConfigRequest struct {
+	Statuses    []programStatusDefinitionInput `json:"statuses"`
+	Transitions []programStatusTransitionInput `json:"transitions"`
+}
+
+func buildProgramStatusConfig(lineID uint, req saveProgramStatusConfigRequest) ([]models.ProgramStatusDefinition, []models.ProgramStatusTransition, error) {
+	statuses := make([]models.ProgramStatusDefinition, 0, len(req.Statuses))
+	seenCodes := make(map[string]struct{}, len(req.Statuses))
+	initialCount := 0
+	for _, input := range req.Statuses {
+		code := strings.TrimSpace(input.Code)
+		name := strings.TrimSpace(input.Name)
+		if code == "" || name == "" {
+			return nil, nil, errors.New("状态编码和名称不能为空")
+		}
+		if len(code) > programStatusCodeMaxLength {
+			return nil, nil, errors.New("状态编码不能超过 20 个字符")
+		}
+		if _, exists := seenCodes[code]; exists {
+			return nil, nil, errors.New("状态编码不能重复")
+		}
+		seenCodes[code] = struct{}{}
+		if input.IsInitial {
+			initialCount++
+		}
+		statuses = append(statuses, models.ProgramStatusDefinition{
+			ProductionLineID:  lineID,
+			Code:              code,
+			Name:              name,
+			SortOrder:         input.SortOrder,
+			IsInitial:         input.IsInitial,
+			CountsAsCompleted: input.CountsAsCompleted,
+		})
+	}
+	if initialCount > 1 {
+		return nil, nil, errors.New("只能设置一个初始状态")
+	}
+	if len(statuses) == 0 && len(req.Transitions) > 0 {
+		return nil, nil, errors.New("未配置状态时不能配置流转规则")
+	}
+
+	transitions := make([]models.ProgramStatusTransition, 0, len(req.Transitions))
+	seenTransitions := make(map[string]struct{}, len(req.Transitions))
+	for _, input := range req.Transitions {
+		from := strings.TrimSpace(input.FromStatus)
+		to := strings.TrimSpace(input.ToStatus)
+		if _, ok := seenCodes[from]; !ok {
+			return nil, nil, errors.New("流转规则引用了不存在的状态: " + from)
+		}
+		if _, ok := seenCodes[to]; !ok {
+			return nil, nil, errors.New("流转规则引用了不存在的状态: " + to)
+		}
+		if from == to {
+			return nil, nil, errors.New("流转规则的起止状态不能相同")
+		}
+		key := from + "->" + to
+		if _, exists := seenTransitions[key]; exists {
+			return nil, nil, errors.New("流转规则不能重复: " + key)
+		}
+		seenTransitions[key] = struct{}{}
+
+		roles := make([]string, 0, len(input.RequiredRoles))
+		for _, role := range input.RequiredRoles {
+			if role = strings.TrimSpace(role); role != "" {
+				roles = append(roles, role)
+			}
+		}
+		transitions = append(transitions, models.ProgramStatusTransition{
+			ProductionLineID: lineID,
+			FromStatus:       from,
+			ToStatus:         to,
+			RequiredRoles:    strings.Join(roles, ","),
+		})
+	}
+	return statuses, transitions, nil
+}
+
+// GetProductionLineProgramStatuses 返回产线生效的程序状态集和流转规则；未自定义时返回内置默认状态集。
+func GetProductionLineProgramStatuses(c *gin.Context) {
+	lineID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "生产线ID格式错误"})
+		return
+	}
+	if !authorizeLineAction(c, lineID, lineActionView) {
+		return
+	}
+	if _, err := findProductionLine(lineID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "生产线不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		}
+		return
+	}
+
+	config, err := loadProgramStatusConfig(database.DB, lineID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, config)
+}
+
+// SaveProductionLineProgramStatuses 整体替换产线的状态集和流转规则，statuses 为空表示恢复默认状态集。
+// 仍有程序处于被移除的状态时拒绝保存，避免程序状态脱离产线配置。
+func SaveProductionLineProgramStatuses(c *gin.Context) {
+	lineID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "生产线ID格式错误"})
+		return
+	}
+	if _, err := findProductionLine(lineID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "生产线不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		}
+		return
+	}
+
+	var req saveProgramStatusConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	statuses, transitions, err := buildProgramStatusConfig(lineID, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var removedInUse []string
+	var before programStatusConfig
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		current, err := loadProgramStatusConfig(tx, lineID)
+		if err != nil {
+			return err
+		}
+		before = current
+		next := programStatusConfig{Statuses: statuses}
+		if len(statuses) == 0 {
+			next.Statuses = defaultProgramStatusDefinitions(lineID)
+		}
+		removed := make([]string, 0)
+		for _, definition := range current.Statuses {
+			if !next.has(definition.Code) {
+				removed = append(removed, definition.Code)
+			}
+		}
+		if len(removed) > 0 {
+			if err := tx.Model(&models.Program{}).
+				Where("production_line_id = ? AND status IN ?", lineID, removed).
+				Distinct().Pluck("status", &removedInUse).Error; err != nil {
+				return err
+			}
+			if len(removedInUse) > 0 {
+				return errProgramStatusInUse
+			}
+		}
+
+		if err := tx.Where("production_line_id = ?", lineID).Delete(&models.ProgramStatusTransition{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("production_line_id = ?", lineID).Delete(&models.ProgramStatusDefinition{}).Error; err != nil {
+			return err
+		}
+		if len(statuses) > 0 {
+			if err := tx.Create(&statuses).Error; err != nil {
+				return err
+			}
+		}
+		if len(transitions) > 0 {
+			if err := tx.Create(&transitions).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		if errors.Is(err, errProgramStatusInUse) {
+			sort.Strings(removedInUse)
+			c.JSON(http.StatusConflict, gin.H{"error": "仍有程序处于待移除的状态，无法保存", "statuses": removedInUse})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+
+	config, err := loadProgramStatusConfig(database.DB, lineID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	recordAuditChange(c, "program_status_config", lineID, before, config)
+	c.JSON(http.StatusOK, config)
+}
+
+// GetProgramStatusHistory 返回程序的状态流转记录，映射子程序返回父程序的记录。
+func GetProgramStatusHistory(c *gin.Context) {
+	programID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "程序ID格式错误"})
+		return
+	}
+	program, targetProgramID, _, err := resolveProgramTarget(database.DB, programID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "程序不存在"})
+		return
+	}
+	if !authorizeProgramScopeAction(c, program.ID, program.ProductionLineID, program.VehicleModelID, lineActionView) {
+		return
+	}
+
+	var histories []models.ProgramStatusHistory
+	if err := database.DB.Preload("Operator").
+		Where("program_id = ?", targetProgramID).
+		Order("created_at asc, id asc").
+		Find(&histories).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, histories)
+}
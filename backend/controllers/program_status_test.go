@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+	"crane-system/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+func setupProgramStatusTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	{
+		api.GET("/production-lines/:id/program-statuses", GetProductionLineProgramStatuses)
+		api.PUT("/production-lines/:id/program-statuses", SaveProductionLineProgramStatuses)
+		api.POST("/programs", CreateProgram)
+		api.PUT("/programs/:id", UpdateProgram)
+		api.GET("/programs/:id/status-history", GetProgramStatusHistory)
+	}
+	return r
+}
+
+func programStatusPath(lineID uint) string {
+	return fmt.Sprintf("/api/production-lines/%d/program-statuses", lineID)
+}
+
+// saveProgramStatusConfigForTest 配置一条简化的流转链：not_started → programming → accepted，验收需要 quality 角色。
+func saveProgramStatusConfigForTest(t *testing.T, r *gin.Engine, token string, lineID uint) {
+	t.Helper()
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPut, programStatusPath(lineID), token, map[string]any{
+		"statuses": []map[string]any{
+			{"code": "not_started", "name": "未开始", "sort_order": 1, "is_initial": true},
+			{"code": "programming", "name": "编程中", "sort_order": 2},
+			{"code": "accepted", "name": "已验收", "sort_order": 3, "counts_as_completed": true},
+		},
+		"transitions": []map[string]any{
+			{"from_status": "not_started", "to_status": "programming"},
+			{"from_status": "programming", "to_status": "accepted", "required_roles": []string{"quality"}},
+		},
+	})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("save status config: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestGetProductionLineProgramStatusesReturnsDefaults(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	token, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupProgramStatusTestRouter()
+
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodGet, programStatusPath(line.ID), token, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	config := decodeProductionLineCustomFieldResponse[programStatusConfig](t, resp)
+	if config.Customized || len(config.Statuses) != len(defaultProgramStatusDefinitions(line.ID)) {
+		t.Fatalf("unexpected default config: %+v", config)
+	}
+	if config.initialStatus() != "in_progress" {
+		t.Fatalf("default initial status = %q", config.initialStatus())
+	}
+}
+
+func TestProgramStatusTransitionsFollowLineConfig(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	adminToken, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupProgramStatusTestRouter()
+	saveProgramStatusConfigForTest(t, r, adminToken, line.ID)
+
+	user := models.User{Name: "Programmer", Password: "hashed", EmployeeID: "EMP-STATUS-001", Role: "user", Status: "active"}
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	saveProductionLineRuleForTest(t, services.PermissionSubject{Type: models.PermissionSubjectUser, ID: user.ID}, line.ID, true, true, true, true)
+	userToken := createUserTokenForTest(t, user.ID, "user")
+
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/programs", userToken, map[string]any{
+		"name":               "程序A",
+		"code":               "PROG-STATUS-001",
+		"production_line_id": line.ID,
+	})
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create program: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	program := decodeProductionLineCustomFieldResponse[models.Program](t, resp)
+	if program.Status != "not_started" {
+		t.Fatalf("initial status = %q, want not_started", program.Status)
+	}
+	programPath := fmt.Sprintf("/api/programs/%d", program.ID)
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPut, programPath, userToken, map[string]any{"status": "accepted"})
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "不允许从当前状态流转到目标状态") {
+		t.Fatalf("skip transition: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPut, programPath, userToken, map[string]any{"status": "in_progress"})
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "程序状态不在产线状态集中") {
+		t.Fatalf("unknown status: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPut, programPath, userToken, map[string]any{"status": "programming"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("allowed transition: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPut, programPath, userToken, map[string]any{"status": "accepted"})
+	if resp.Code != http.StatusForbidden || !strings.Contains(resp.Body.String(), "当前角色无权执行该状态流转") {
+		t.Fatalf("role-restricted transition: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPut, programPath, adminToken, map[string]any{"status": "accepted"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("admin transition: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, programPath+"/status-history", userToken, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("history: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	histories := decodeProductionLineCustomFieldResponse[[]models.ProgramStatusHistory](t, resp)
+	if len(histories) != 3 {
+		t.Fatalf("history count = %d, want 3: %+v", len(histories), histories)
+	}
+	if histories[0].FromStatus != "" || histories[0].ToStatus != "not_started" || histories[2].ToStatus != "accepted" {
+		t.Fatalf("unexpected history: %+v", histories)
+	}
+
+	var stored models.Program
+	if err := database.DB.First(&stored, program.ID).Error; err != nil {
+		t.Fatalf("load program: %v", err)
+	}
+	if stored.StatusChangedAt == nil {
+		t.Fatalf("expected status_changed_at to be set")
+	}
+}
+
+func TestSaveProductionLineProgramStatusesRejectsRemovingUsedStatus(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	token, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupProgramStatusTestRouter()
+
+	program := models.Program{Name: "程序A", Code: "PROG-STATUS-002", ProductionLineID: line.ID, Status: "simulation"}
+	if err := database.DB.Create(&program).Error; err != nil {
+		t.Fatalf("create program: %v", err)
+	}
+
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPut, programStatusPath(line.ID), token, map[string]any{
+		"statuses": []map[string]any{{"code": "not_started", "name": "未开始", "is_initial": true}},
+	})
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodPut, programStatusPath(line.ID), token, map[string]any{
+		"statuses":    []map[string]any{{"code": "a", "name": "A"}},
+		"transitions": []map[string]any{{"from_status": "a", "to_status": "missing"}},
+	})
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("unknown transition target: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestWriteCompletionStatsSheetHonoursLineStatusConfig(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	_, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	if err := database.DB.Create(&models.ProgramStatusDefinition{ProductionLineID: line.ID, Code: "signed_off", Name: "已签收", CountsAsCompleted: true}).Error; err != nil {
+		t.Fatalf("create status definition: %v", err)
+	}
+	if err := database.DB.Create(&models.ProgramStatusDefinition{ProductionLineID: line.ID, Code: "drafting", Name: "草拟"}).Error; err != nil {
+		t.Fatalf("create status definition: %v", err)
+	}
+
+	catalog, err := loadProgramStatusCatalog(database.DB)
+	if err != nil {
+		t.Fatalf("load catalog: %v", err)
+	}
+	lineRef := models.ProductionLine{ID: line.ID, Name: line.Name}
+	vehicle := models.VehicleModel{ID: 7, Name: "车型A"}
+	programs := []models.Program{
+		{ID: 1, ProductionLineID: line.ID, VehicleModelID: vehicle.ID, Status: "signed_off", ProductionLine: lineRef, VehicleModel: vehicle},
+		{ID: 2, ProductionLineID: line.ID, VehicleModelID: vehicle.ID, Status: "drafting", ProductionLine: lineRef, VehicleModel: vehicle},
+		{ID: 3, ProductionLineID: line.ID, VehicleModelID: vehicle.ID, Status: "drafting", ProductionLine: lineRef, VehicleModel: vehicle},
+		{ID: 4, ProductionLineID: line.ID, VehicleModelID: vehicle.ID, Status: "completed", ProductionLine: lineRef, VehicleModel: vehicle},
+	}
+
+	f := excelize.NewFile()
+	defer func() { _ = f.Close() }()
+	writeCompletionStatsSheet(f, programs, catalog)
+
+	// completed 不在该产线的自定义状态集中，不计为完成
+	if value, _ := f.GetCellValue("完成率统计", "B2"); value != "25%" {
+		t.Fatalf("cell rate = %q, want 25%%", value)
+	}
+	rows, err := f.GetRows("完成率统计")
+	if err != nil {
+		t.Fatalf("read rows: %v", err)
+	}
+	if len(rows) < 7 {
+		t.Fatalf("unexpected distribution rows: %v", rows)
+	}
+	// 状态分布表头：内置状态在前，自定义状态按编码排序
+	if got := fmt.Sprint(rows[5]); got != "[状态分布 已完成 草拟 已签收 合计]" {
+		t.Fatalf("distribution header = %s", got)
+	}
+	if got := fmt.Sprint(rows[6][1:]); got != "[1 2 1 4]" {
+		t.Fatalf("distribution counts = %s", got)
+	}
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule 是解析后的 5 段 cron 表达式：分 时 日 月 周。
+// 日和周同时受限时满足其一即可，与标准 cron 一致。
+type cronSchedule struct {
+	minutes  [60]bool
+	hours    [24]bool
+	days     [32]bool
+	months   [13]bool
+	weekdays [7]bool
+	dayAny   bool
+	weekAny  bool
+}
+
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseCronExpr 支持 *、数字、a-b 区间、/n 步长和逗号列表，周字段 0 和 7 都表示周日。
+func parseCronExpr(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if alias, ok := cronAliases[strings.ToLower(expr)]; ok {
+		expr = alias
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron 表达式需要 5 个字段（分 时 日 月 周）")
+	}
+
+	schedule := &cronSchedule{dayAny: fields[2] == "*", weekAny: fields[4] == "*"}
+	if err := parseCronField(fields[0], 0, 59, schedule.minutes[:]); err != nil {
+		return nil, fmt.Errorf("分钟字段: %w", err)
+	}
+	if err := parseCronField(fields[1], 0, 23, schedule.hours[:]); err != nil {
+		return nil, fmt.Errorf("小时字段: %w", err)
+	}
+	if err := parseCronField(fields[2], 1, 31, schedule.days[:]); err != nil {
+		return nil, fmt.Errorf("日字段: %w", err)
+	}
+	if err := parseCronField(fields[3], 1, 12, schedule.months[:]); err != nil {
+		return nil, fmt.Errorf("月字段: %w", err)
+	}
+	var weekdays [8]bool
+	if err := parseCronField(fields[4], 0, 7, weekdays[:]); err != nil {
+		return nil, fmt.Errorf("周字段: %w", err)
+	}
+	copy(schedule.weekdays[:], weekdays[:7])
+	schedule.weekdays[0] = schedule.weekdays[0] || weekdays[7]
+	return schedule, nil
+}
+
+func parseCronField(field string, min, max int, set []bool) error {
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			value, err := strconv.Atoi(part[idx+1:])
+			if err != nil || value <= 0 {
+				return fmt.Errorf("无效的步长 %q", part)
+			}
+			rangePart, step = part[:idx], value
+		}
+
+		start, end := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			from, err1 := strconv.Atoi(bounds[0])
+			to, err2 := strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil || from > to {
+				return fmt.Errorf("无效的区间 %q", part)
+			}
+			start, end = from, to
+		default:
+			value, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return fmt.Errorf("无效的值 %q", part)
+			}
+			start = value
+			if step == 1 {
+				end = value
+			}
+		}
+		if start < min || end > max {
+			return fmt.Errorf("%q 超出范围 %d-%d", part, min, max)
+		}
+		for value := start; value <= end; value += step {
+			set[value] = true
+		}
+	}
+	return nil
+}
+
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	dayMatch, weekMatch := s.days[t.Day()], s.weekdays[int(t.Weekday())]
+	switch {
+	case s.dayAny && s.weekAny:
+		return true
+	case s.dayAny:
+		return weekMatch
+	case s.weekAny:
+		return dayMatch
+	default:
+		return dayMatch || weekMatch
+	}
+}
+
+// next 返回严格晚于 after 的下一个触发时间（按 after 所在时区计算）；5 年内无匹配时返回零值。
+func (s *cronSchedule) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
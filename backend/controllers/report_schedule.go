@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"crane-system/database"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxReportRetries = 10
+
+type reportScheduleRequest struct {
+	Name             *string `json:"name"`
+	ExportTemplateID *uint   `json:"export_template_id"`
+	Format           *string `json:"format"`
+	IncludeStats     *bool   `json:"include_stats"`
+	CronExpr         *string `json:"cron_expr"`
+	DeliveryType     *string `json:"delivery_type"`
+	DeliveryTarget   *string `json:"delivery_target"`
+	MaxRetries       *int    `json:"max_retries"`
+	Enabled          *bool   `json:"enabled"`
+}
+
+func (req reportScheduleRequest) apply(schedule *models.ReportSchedule) {
+	if req.Name != nil {
+		schedule.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.ExportTemplateID != nil {
+		if *req.ExportTemplateID == 0 {
+			schedule.ExportTemplateID = nil
+		} else {
+			templateID := *req.ExportTemplateID
+			schedule.ExportTemplateID = &templateID
+		}
+	}
+	if req.Format != nil {
+		schedule.Format = strings.ToLower(strings.TrimSpace(*req.Format))
+	}
+	if req.IncludeStats != nil {
+		schedule.IncludeStats = *req.IncludeStats
+	}
+	if req.CronExpr != nil {
+		schedule.CronExpr = strings.TrimSpace(*req.CronExpr)
+	}
+	if req.DeliveryType != nil {
+		schedule.DeliveryType = strings.TrimSpace(*req.DeliveryType)
+	}
+	if req.DeliveryTarget != nil {
+		schedule.DeliveryTarget = strings.TrimSpace(*req.DeliveryTarget)
+	}
+	if req.MaxRetries != nil {
+		schedule.MaxRetries = *req.MaxRetries
+	}
+	if req.Enabled != nil {
+		schedule.Enabled = *req.Enabled
+	}
+}
+
+// validateReportSchedule 校验计划配置并计算下一次执行时间。
+func validateReportSchedule(schedule *models.ReportSchedule) string {
+	if schedule.Name == "" || len([]rune(schedule.Name)) > 100 {
+		return "计划名称不能为空且不超过100个字符"
+	}
+	switch schedule.Format {
+	case "xlsx":
+	case exportStreamFormatCSV, exportStreamFormatJSONL:
+		if schedule.IncludeStats {
+			return "只有 xlsx 格式支持附带完成率统计"
+		}
+	default:
+		return "导出格式只能是 xlsx、csv 或 jsonl"
+	}
+	cron, err := parseCronExpr(schedule.CronExpr)
+	if err != nil {
+		return err.Error()
+	}
+	if schedule.MaxRetries < 0 || schedule.MaxRetries > maxReportRetries {
+		return "重试次数需在 0-10 之间"
+	}
+
+	switch schedule.DeliveryType {
+	case models.ReportDeliveryDirectory:
+		if _, err := resolveReportDirectory(schedule.DeliveryTarget); err != nil {
+			return err.Error()
+		}
+	case models.ReportDeliveryEmail:
+		recipients := splitReportRecipients(schedule.DeliveryTarget)
+		if len(recipients) == 0 {
+			return "至少填写一个收件人"
+		}
+		for _, recipient := range recipients {
+			if _, err := mail.ParseAddress(recipient); err != nil {
+				return "收件人地址无效: " + recipient
+			}
+		}
+	case models.ReportDeliveryWebhook:
+		if err := checkReportWebhookTarget(schedule.DeliveryTarget); err != nil {
+			return err.Error()
+		}
+	default:
+		return "投递方式只能是 directory、email 或 webhook"
+	}
+
+	if schedule.ExportTemplateID != nil {
+		var count int64
+		if err := database.DB.Model(&models.ExportTemplate{}).
+			Where("id = ? AND (owner_id = ? OR shared = ?)", *schedule.ExportTemplateID, schedule.OwnerID, true).
+			Count(&count).Error; err != nil || count == 0 {
+			return "导出模板不存在或计划创建人无权使用"
+		}
+	}
+
+	schedule.NextRunAt = nil
+	if schedule.Enabled {
+		next := cron.next(time.Now())
+		if next.IsZero() {
+			return "cron 表达式没有后续触发时间"
+		}
+		schedule.NextRunAt = &next
+	}
+	return ""
+}
+
+// loadManageableReportSchedule 加载当前用户创建的计划；管理员可以管理全部计划。
+func loadManageableReportSchedule(c *gin.Context) (models.ReportSchedule, bool) {
+	var schedule models.ReportSchedule
+	scheduleID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "计划ID格式错误"})
+		return schedule, false
+	}
+	role := currentAccountRole(c)
+	if err := database.DB.First(&schedule, scheduleID).Error; err != nil ||
+		(schedule.OwnerID != currentUserID(c) && role != "admin" && role != "system_admin") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "报表计划不存在"})
+		return schedule, false
+	}
+	return schedule, true
+}
+
+// GetReportSchedules 返回当前用户的报表计划；管理员返回全部。
+func GetReportSchedules(c *gin.Context) {
+	query := database.DB.Preload("Owner").Preload("ExportTemplate").Order("id ASC")
+	if role := currentAccountRole(c); role != "admin" && role != "system_admin" {
+		query = query.Where("owner_id = ?", currentUserID(c))
+	}
+	var schedules []models.ReportSchedule
+	if err := query.Find(&schedules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, schedules)
+}
+
+func CreateReportSchedule(c *gin.Context) {
+	var req reportScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	schedule := models.ReportSchedule{OwnerID: currentUserID(c), Format: "xlsx", MaxRetries: 3, Enabled: true}
+	req.apply(&schedule)
+	if msg := validateReportSchedule(&schedule); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if err := database.DB.Create(&schedule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建报表计划失败"})
+		return
+	}
+	recordAuditChange(c, "report_schedule", schedule.ID, nil, schedule)
+	c.JSON(http.StatusCreated, schedule)
+}
+
+func UpdateReportSchedule(c *gin.Context) {
+	schedule, ok := loadManageableReportSchedule(c)
+	if !ok {
+		return
+	}
+	var req reportScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before := schedule
+	req.apply(&schedule)
+	if msg := validateReportSchedule(&schedule); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if err := database.DB.Model(&schedule).
+		Select("name", "export_template_id", "format", "include_stats", "cron_expr", "delivery_type", "delivery_target", "max_retries", "enabled", "next_run_at").
+		Updates(&schedule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新报表计划失败"})
+		return
+	}
+	recordAuditChange(c, "report_schedule", schedule.ID, before, schedule)
+	c.JSON(http.StatusOK, schedule)
+}
+
+// DeleteReportSchedule 删除计划及其执行记录。
+func DeleteReportSchedule(c *gin.Context) {
+	schedule, ok := loadManageableReportSchedule(c)
+	if !ok {
+		return
+	}
+	if err := database.DB.Where("schedule_id = ?", schedule.ID).Delete(&models.ReportRun{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除报表计划失败"})
+		return
+	}
+	if err := database.DB.Delete(&models.ReportSchedule{}, schedule.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除报表计划失败"})
+		return
+	}
+	recordAuditChange(c, "report_schedule", schedule.ID, schedule, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "报表计划已删除"})
+}
+
+// RunReportSchedule 立即执行一次计划，执行在后台进行，通过执行历史查看结果。
+func RunReportSchedule(c *gin.Context) {
+	schedule, ok := loadManageableReportSchedule(c)
+	if !ok {
+		return
+	}
+	run, err := createReportRun(schedule, "manual", 1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建执行记录失败"})
+		return
+	}
+	go executeReportRun(schedule, run, time.Now())
+	c.JSON(http.StatusAccepted, run)
+}
+
+// GetReportScheduleRuns 返回计划的执行历史，最新的在前。
+func GetReportScheduleRuns(c *gin.Context) {
+	schedule, ok := loadManageableReportSchedule(c)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+	var runs []models.ReportRun
+	if err := database.DB.Where("schedule_id = ?", schedule.ID).Order("id DESC").Limit(limit).Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
@@ -0,0 +1,296 @@
+package controllers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"crane-system/config"
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupReportScheduleTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	{
+		api.GET("/report-schedules", GetReportSchedules)
+		api.POST("/report-schedules", CreateReportSchedule)
+		api.PUT("/report-schedules/:id", UpdateReportSchedule)
+		api.POST("/report-schedules/:id/run", RunReportSchedule)
+		api.GET("/report-schedules/:id/runs", GetReportScheduleRuns)
+	}
+	return r
+}
+
+func TestParseCronExprNext(t *testing.T) {
+	base := time.Date(2026, 10, 18, 10, 0, 0, 0, time.Local) // 周日
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"0 8 * * 1", time.Date(2026, 10, 19, 8, 0, 0, 0, time.Local)},
+		{"*/15 * * * *", time.Date(2026, 10, 18, 10, 15, 0, 0, time.Local)},
+		{"30 9 1 * *", time.Date(2026, 11, 1, 9, 30, 0, 0, time.Local)},
+		{"0 0 20 * 1", time.Date(2026, 10, 19, 0, 0, 0, 0, time.Local)},
+		{"0 12 * * 7", time.Date(2026, 10, 18, 12, 0, 0, 0, time.Local)},
+		{"@daily", time.Date(2026, 10, 19, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tc := range cases {
+		schedule, err := parseCronExpr(tc.expr)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tc.expr, err)
+		}
+		if got := schedule.next(base); !got.Equal(tc.want) {
+			t.Fatalf("%q next = %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "0 8 * * 1-9", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := parseCronExpr(expr); err == nil {
+			t.Fatalf("expected %q to be rejected", expr)
+		}
+	}
+	if schedule, _ := parseCronExpr("0 0 31 2 *"); !schedule.next(base).IsZero() {
+		t.Fatalf("expected no next run for Feb 31")
+	}
+}
+
+// startFakeSMTPServer 启动只接收邮件的本地 SMTP 替身，返回地址和收到的邮件内容。
+func startFakeSMTPServer(t *testing.T) (string, string, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	messages := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				reply := func(line string) { _, _ = io.WriteString(conn, line+"\r\n") }
+				reply("220 localhost ESMTP")
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					command := strings.ToUpper(strings.TrimSpace(line))
+					switch {
+					case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+						reply("250 localhost")
+					case command == "DATA":
+						reply("354 end with <CRLF>.<CRLF>")
+						var data strings.Builder
+						for {
+							dataLine, err := reader.ReadString('\n')
+							if err != nil || dataLine == ".\r\n" {
+								break
+							}
+							data.WriteString(dataLine)
+						}
+						messages <- data.String()
+						reply("250 queued")
+					case command == "QUIT":
+						reply("221 bye")
+						return
+					default:
+						reply("250 OK")
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port, messages
+}
+
+func waitReportRun(t *testing.T, runID uint) models.ReportRun {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var run models.ReportRun
+		if err := database.DB.First(&run, runID).Error; err == nil && run.Status != reportRunRunning {
+			return run
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("report run %d did not finish", runID)
+	return models.ReportRun{}
+}
+
+func TestReportScheduleDeliveriesAndRetries(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	adminToken, line := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupReportScheduleTestRouter()
+
+	originalReport := config.AppConfig.Report
+	reportDir := t.TempDir()
+	smtpHost, smtpPort, messages := startFakeSMTPServer(t)
+	config.AppConfig.Report = config.ReportSection{
+		Dir: reportDir, SMTPHost: smtpHost, SMTPPort: smtpPort, SMTPFrom: "reports@example.com",
+		WebhookAllowedHosts: []string{"127.0.0.1"},
+	}
+	t.Cleanup(func() { config.AppConfig.Report = originalReport })
+
+	if err := database.DB.Create(&models.Program{Name: "程序", Code: "RPT-001", ProductionLineID: line.ID, Status: "completed"}).Error; err != nil {
+		t.Fatalf("create program: %v", err)
+	}
+
+	createSchedule := func(body map[string]any) (int, models.ReportSchedule) {
+		resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/report-schedules", adminToken, body)
+		if resp.Code != http.StatusCreated {
+			return resp.Code, models.ReportSchedule{}
+		}
+		return resp.Code, decodeProductionLineCustomFieldResponse[models.ReportSchedule](t, resp)
+	}
+	if code, _ := createSchedule(map[string]any{"name": "越界", "cron_expr": "0 8 * * 1", "delivery_type": "directory", "delivery_target": "../outside"}); code != http.StatusBadRequest {
+		t.Fatalf("traversal target: status = %d", code)
+	}
+	if code, _ := createSchedule(map[string]any{"name": "坏表达式", "cron_expr": "0 8 * *", "delivery_type": "directory"}); code != http.StatusBadRequest {
+		t.Fatalf("invalid cron: status = %d", code)
+	}
+	if code, _ := createSchedule(map[string]any{"name": "统计", "format": "csv", "include_stats": true, "cron_expr": "0 8 * * 1", "delivery_type": "directory"}); code != http.StatusBadRequest {
+		t.Fatalf("csv with stats: status = %d", code)
+	}
+
+	// 目录投递：到期后由调度执行并推进下一次时间
+	code, dirSchedule := createSchedule(map[string]any{
+		"name": "周报", "include_stats": true, "cron_expr": "0 8 * * 1", "delivery_type": "directory", "delivery_target": "weekly",
+	})
+	if code != http.StatusCreated || dirSchedule.NextRunAt == nil || dirSchedule.NextRunAt.Weekday() != time.Monday {
+		t.Fatalf("create directory schedule: status = %d, schedule = %+v", code, dirSchedule)
+	}
+	runAt := dirSchedule.NextRunAt.Add(time.Minute)
+	runDueReports(runAt)
+	runDueReports(runAt)
+
+	var runs []models.ReportRun
+	database.DB.Where("schedule_id = ?", dirSchedule.ID).Find(&runs)
+	if len(runs) != 1 || runs[0].Status != reportRunSuccess || runs[0].Trigger != "schedule" {
+		t.Fatalf("directory runs = %+v", runs)
+	}
+	if _, err := os.Stat(filepath.Join(reportDir, "weekly", runs[0].FileName)); err != nil {
+		t.Fatalf("report file missing: %v", err)
+	}
+	var reloaded models.ReportSchedule
+	database.DB.First(&reloaded, dirSchedule.ID)
+	if reloaded.NextRunAt == nil || !reloaded.NextRunAt.After(runAt) || reloaded.LastStatus != reportRunSuccess {
+		t.Fatalf("schedule not advanced: %+v", reloaded)
+	}
+
+	// 邮件投递：手动执行，本地 SMTP 替身收到附件
+	code, mailSchedule := createSchedule(map[string]any{
+		"name": "CSV日报", "format": "csv", "cron_expr": "@daily", "delivery_type": "email", "delivery_target": "a@example.com, b@example.com",
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("create email schedule: status = %d", code)
+	}
+	resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, fmt.Sprintf("/api/report-schedules/%d/run", mailSchedule.ID), adminToken, nil)
+	if resp.Code != http.StatusAccepted {
+		t.Fatalf("manual run: status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	if run := waitReportRun(t, decodeProductionLineCustomFieldResponse[models.ReportRun](t, resp).ID); run.Status != reportRunSuccess {
+		t.Fatalf("email run = %+v", run)
+	}
+	select {
+	case message := <-messages:
+		if !strings.Contains(message, "To: a@example.com, b@example.com") || !strings.Contains(message, "text/csv") || !strings.Contains(message, ".csv") {
+			t.Fatalf("unexpected email: %s", message)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("email not delivered")
+	}
+
+	// webhook 投递：第一次失败登记重试，重试成功
+	var calls atomic.Int32
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if req.Header.Get("X-Report-Schedule-ID") == "" || req.ContentLength == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer webhook.Close()
+	code, hookSchedule := createSchedule(map[string]any{
+		"name": "推送", "format": "jsonl", "cron_expr": "0 * * * *", "delivery_type": "webhook", "delivery_target": webhook.URL, "max_retries": 1,
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("create webhook schedule: status = %d", code)
+	}
+	runDueReports(hookSchedule.NextRunAt.Add(time.Second))
+	var failed models.ReportRun
+	database.DB.Where("schedule_id = ?", hookSchedule.ID).First(&failed)
+	if failed.Status != reportRunFailed || failed.NextRetryAt == nil || !strings.Contains(failed.Error, "502") {
+		t.Fatalf("failed run = %+v", failed)
+	}
+	runDueReports(failed.NextRetryAt.Add(time.Second))
+
+	resp = performProductionLineCustomFieldRequest(t, r, http.MethodGet, fmt.Sprintf("/api/report-schedules/%d/runs", hookSchedule.ID), adminToken, nil)
+	history := decodeProductionLineCustomFieldResponse[[]models.ReportRun](t, resp)
+	if len(history) != 2 || history[0].Trigger != "retry" || history[0].Attempt != 2 || history[0].Status != reportRunSuccess || history[1].NextRetryAt != nil {
+		t.Fatalf("webhook history = %+v", history)
+	}
+}
+
+func TestReportScheduleRejectsInternalWebhookTargets(t *testing.T) {
+	database.DB = openProductionLineCustomFieldTestDB(t)
+	adminToken, _ := seedProductionLineCustomFieldAuthData(t, database.DB)
+	r := setupReportScheduleTestRouter()
+
+	originalReport := config.AppConfig.Report
+	config.AppConfig.Report = config.ReportSection{Dir: t.TempDir()}
+	t.Cleanup(func() { config.AppConfig.Report = originalReport })
+
+	for _, target := range []string{"http://127.0.0.1:9/hook", "http://localhost/hook", "http://169.254.169.254/latest/meta-data", "http://10.1.2.3/hook", "http://[::1]/hook"} {
+		resp := performProductionLineCustomFieldRequest(t, r, http.MethodPost, "/api/report-schedules", adminToken, map[string]any{
+			"name": "内网", "format": "jsonl", "cron_expr": "0 * * * *", "delivery_type": "webhook", "delivery_target": target,
+		})
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("target %s: status = %d, body = %s", target, resp.Code, resp.Body.String())
+		}
+	}
+
+	// 保存后才指向内网的地址在投递时同样被拒绝
+	var calls atomic.Int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer internal.Close()
+	schedule := models.ReportSchedule{ID: 1, DeliveryType: models.ReportDeliveryWebhook, DeliveryTarget: internal.URL}
+	if err := deliverReportToWebhook(schedule, models.ReportRun{ID: 1}, "report.jsonl", []byte("{}"), "application/x-ndjson"); err == nil || calls.Load() != 0 {
+		t.Fatalf("internal webhook delivered: err = %v, calls = %d", err, calls.Load())
+	}
+
+	config.AppConfig.Report.WebhookAllowedHosts = []string{"127.0.0.1"}
+	if err := deliverReportToWebhook(schedule, models.ReportRun{ID: 1}, "report.jsonl", []byte("{}"), "application/x-ndjson"); err != nil || calls.Load() != 1 {
+		t.Fatalf("allowed webhook: err = %v, calls = %d", err, calls.Load())
+	}
+}
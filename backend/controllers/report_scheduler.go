@@ -0,0 +1,443 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"crane-system/config"
+	"crane-system/database"
+	"crane-system/models"
+	"crane-system/utils"
+)
+
+const (
+	reportRunRunning = "running"
+	reportRunSuccess = "success"
+	reportRunFailed  = "failed"
+)
+
+var (
+	reportSchedulerInterval = time.Minute
+	reportHTTPClient        = &http.Client{Timeout: 30 * time.Second, Transport: newReportWebhookTransport()}
+	// reportRetryBackoff 返回第 attempt 次失败后到下一次重试的间隔。
+	reportRetryBackoff = func(attempt int) time.Duration {
+		return time.Duration(attempt) * 5 * time.Minute
+	}
+)
+
+// StartReportScheduler 启动定时报表的后台轮询，返回的函数用于停止轮询。
+func StartReportScheduler() func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(reportSchedulerInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				runDueReports(now)
+			}
+		}
+	}()
+	return cancel
+}
+
+// runDueReports 执行到期的计划和到期的重试。
+// 先用条件更新抢占再执行，多实例部署时同一次触发只会被一个实例执行。
+func runDueReports(now time.Time) {
+	var schedules []models.ReportSchedule
+	if err := database.DB.Where("enabled = ? AND next_run_at IS NOT NULL AND next_run_at <= ?", true, now).Find(&schedules).Error; err != nil {
+		slog.Error("查询到期报表计划失败", "error", err)
+		return
+	}
+	for _, schedule := range schedules {
+		result := database.DB.Model(&models.ReportSchedule{}).
+			Where("id = ? AND next_run_at <= ?", schedule.ID, now).
+			Update("next_run_at", nextReportRunAt(schedule.CronExpr, now))
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		if run, err := createReportRun(schedule, "schedule", 1); err == nil {
+			executeReportRun(schedule, run, now)
+		}
+	}
+
+	var retries []models.ReportRun
+	if err := database.DB.Where("status = ? AND next_retry_at IS NOT NULL AND next_retry_at <= ?", reportRunFailed, now).Find(&retries).Error; err != nil {
+		slog.Error("查询待重试报表失败", "error", err)
+		return
+	}
+	for _, failed := range retries {
+		result := database.DB.Model(&models.ReportRun{}).
+			Where("id = ? AND next_retry_at IS NOT NULL", failed.ID).
+			Update("next_retry_at", nil)
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		var schedule models.ReportSchedule
+		if err := database.DB.First(&schedule, failed.ScheduleID).Error; err != nil || !schedule.Enabled {
+			continue
+		}
+		if run, err := createReportRun(schedule, "retry", failed.Attempt+1); err == nil {
+			executeReportRun(schedule, run, now)
+		}
+	}
+}
+
+// nextReportRunAt 计算 after 之后的下一次触发时间；表达式无效或无后续触发时返回 nil。
+func nextReportRunAt(expr string, after time.Time) *time.Time {
+	schedule, err := parseCronExpr(expr)
+	if err != nil {
+		return nil
+	}
+	next := schedule.next(after)
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+func createReportRun(schedule models.ReportSchedule, trigger string, attempt int) (models.ReportRun, error) {
+	now := time.Now()
+	run := models.ReportRun{ScheduleID: schedule.ID, Trigger: trigger, Attempt: attempt, Status: reportRunRunning, StartedAt: &now}
+	if err := database.DB.Create(&run).Error; err != nil {
+		slog.Error("创建报表执行记录失败", "schedule_id", schedule.ID, "error", err)
+		return run, err
+	}
+	return run, nil
+}
+
+// executeReportRun 生成并投递报表，失败且未超过重试次数时以 triggeredAt 为基准登记下一次重试时间。
+func executeReportRun(schedule models.ReportSchedule, run models.ReportRun, triggeredAt time.Time) models.ReportRun {
+	fileName, content, contentType, err := generateScheduledReport(schedule)
+	if err == nil {
+		err = deliverScheduledReport(schedule, run, fileName, content, contentType)
+	}
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.FileName = fileName
+	run.FileSize = int64(len(content))
+	if err != nil {
+		run.Status = reportRunFailed
+		run.Error = err.Error()
+		if run.Attempt <= schedule.MaxRetries {
+			retryAt := triggeredAt.Add(reportRetryBackoff(run.Attempt))
+			run.NextRetryAt = &retryAt
+		}
+		slog.Warn("定时报表执行失败", "schedule_id", schedule.ID, "attempt", run.Attempt, "error", err)
+	} else {
+		run.Status = reportRunSuccess
+	}
+
+	if err := database.DB.Save(&run).Error; err != nil {
+		slog.Error("保存报表执行记录失败", "run_id", run.ID, "error", err)
+	}
+	database.DB.Model(&models.ReportSchedule{}).Where("id = ?", schedule.ID).Updates(map[string]any{
+		"last_run_at": finished,
+		"last_status": run.Status,
+	})
+	return run
+}
+
+// generateScheduledReport 以计划创建人的身份调用导出接口，权限过滤和列校验与在线导出完全一致。
+func generateScheduledReport(schedule models.ReportSchedule) (string, []byte, string, error) {
+	var owner models.User
+	if err := database.DB.First(&owner, schedule.OwnerID).Error; err != nil {
+		return "", nil, "", fmt.Errorf("计划创建人不存在")
+	}
+	if owner.Status != "active" {
+		return "", nil, "", fmt.Errorf("计划创建人已被禁用")
+	}
+
+	query := url.Values{}
+	if schedule.ExportTemplateID != nil {
+		query.Set("template", strconv.FormatUint(uint64(*schedule.ExportTemplateID), 10))
+	}
+	handler := ExportProgramsExcelDynamic
+	switch schedule.Format {
+	case exportStreamFormatCSV:
+		handler = ExportProgramsCSV
+		query.Set("bom", "true")
+	case exportStreamFormatJSONL:
+		handler = ExportProgramsJSONL
+	default:
+		if schedule.IncludeStats {
+			query.Set("include_stats", "true")
+		}
+	}
+
+	request, err := http.NewRequest(http.MethodGet, "/api/programs/export?"+query.Encode(), nil)
+	if err != nil {
+		return "", nil, "", err
+	}
+	ctx, recorder := newUserContext(owner, request)
+	if !currentUserHasPermission(ctx, "op:program_export") {
+		return "", nil, "", fmt.Errorf("计划创建人没有导出权限")
+	}
+
+	handler(ctx)
+	if recorder.Code != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(recorder.Body.Bytes(), &body)
+		return "", nil, "", fmt.Errorf("导出失败(%d): %s", recorder.Code, body.Error)
+	}
+
+	fileName := fmt.Sprintf("%s_%s.%s", utils.SanitizeFilename(schedule.Name), time.Now().Format("20060102_1504"), reportFileExtension(schedule.Format))
+	return fileName, recorder.Body.Bytes(), recorder.Header().Get("Content-Type"), nil
+}
+
+func reportFileExtension(format string) string {
+	if format == exportStreamFormatCSV || format == exportStreamFormatJSONL {
+		return format
+	}
+	return "xlsx"
+}
+
+func deliverScheduledReport(schedule models.ReportSchedule, run models.ReportRun, fileName string, content []byte, contentType string) error {
+	switch schedule.DeliveryType {
+	case models.ReportDeliveryDirectory:
+		return deliverReportToDirectory(schedule.DeliveryTarget, fileName, content)
+	case models.ReportDeliveryEmail:
+		return deliverReportByEmail(schedule, fileName, content, contentType)
+	case models.ReportDeliveryWebhook:
+		return deliverReportToWebhook(schedule, run, fileName, content, contentType)
+	default:
+		return fmt.Errorf("未知的投递方式: %s", schedule.DeliveryType)
+	}
+}
+
+// resolveReportDirectory 把计划中的子目录解析为 REPORTS_DIR 下的绝对路径，拒绝越界路径。
+func resolveReportDirectory(target string) (string, error) {
+	if strings.TrimSpace(utils.ReportDir()) == "" {
+		return "", fmt.Errorf("REPORTS_DIR 未配置")
+	}
+	base := filepath.Clean(utils.ReportDir())
+	if filepath.IsAbs(target) {
+		return "", fmt.Errorf("投递目录必须是 REPORTS_DIR 下的相对路径")
+	}
+	dir := filepath.Join(base, target)
+	if !utils.IsSafePath(base, dir) {
+		return "", fmt.Errorf("投递目录超出 REPORTS_DIR")
+	}
+	return dir, nil
+}
+
+// deliverReportToDirectory 先写临时文件再重命名，避免下游程序读到半个文件。
+func deliverReportToDirectory(target, fileName string, content []byte) error {
+	dir, err := resolveReportDirectory(target)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建投递目录失败: %w", err)
+	}
+	tempPath := filepath.Join(dir, "."+fileName+".tmp")
+	if err := os.WriteFile(tempPath, content, 0644); err != nil {
+		return fmt.Errorf("写入报表失败: %w", err)
+	}
+	if err := os.Rename(tempPath, filepath.Join(dir, fileName)); err != nil {
+		_ = os.Remove(tempPath)
+		return fmt.Errorf("写入报表失败: %w", err)
+	}
+	return nil
+}
+
+func splitReportRecipients(target string) []string {
+	recipients := make([]string, 0)
+	for _, part := range strings.Split(target, ",") {
+		if address := strings.TrimSpace(part); address != "" {
+			recipients = append(recipients, address)
+		}
+	}
+	return recipients
+}
+
+func deliverReportByEmail(schedule models.ReportSchedule, fileName string, content []byte, contentType string) error {
+	cfg := config.AppConfig.Report
+	if cfg.SMTPHost == "" || cfg.SMTPFrom == "" {
+		return fmt.Errorf("SMTP 未配置")
+	}
+	recipients := splitReportRecipients(schedule.DeliveryTarget)
+	message, err := buildReportEmail(cfg.SMTPFrom, recipients, schedule.Name, fileName, content, contentType)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if cfg.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	if err := smtp.SendMail(net.JoinHostPort(cfg.SMTPHost, cfg.SMTPPort), auth, cfg.SMTPFrom, recipients, message); err != nil {
+		return fmt.Errorf("发送邮件失败: %w", err)
+	}
+	return nil
+}
+
+// buildReportEmail 组装带附件的 multipart/mixed 邮件。
+func buildReportEmail(from string, recipients []string, subject, fileName string, content []byte, contentType string) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	textPart, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	writeBase64Lines(textPart, []byte(fmt.Sprintf("定时报表「%s」已生成，详见附件 %s。", subject, fileName)))
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	attachment, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": fileName})},
+	})
+	if err != nil {
+		return nil, err
+	}
+	writeBase64Lines(attachment, content)
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.BEncoding.Encode("utf-8", "定时报表: "+subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+func writeBase64Lines(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		_, _ = w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	_, _ = w.Write([]byte(encoded + "\r\n"))
+}
+
+var errReportWebhookAddressDenied = errors.New("webhook 地址不能指向内网、回环或链路本地地址")
+
+// reportWebhookHostAllowed 判断主机是否在 REPORT_WEBHOOK_ALLOWED_HOSTS 中，允许的主机可以是内网地址。
+func reportWebhookHostAllowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range config.AppConfig.Report.WebhookAllowedHosts {
+		if allowed == host {
+			return true
+		}
+	}
+	return false
+}
+
+func publicWebhookIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// resolveReportWebhookHost 解析不在允许列表中的 webhook 主机，任一地址不是公网地址即拒绝。
+func resolveReportWebhookHost(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return nil, fmt.Errorf("webhook 地址 %s 无法解析", host)
+	}
+	for _, addr := range addrs {
+		if !publicWebhookIP(addr.IP) {
+			return nil, errReportWebhookAddressDenied
+		}
+	}
+	return addrs, nil
+}
+
+// checkReportWebhookTarget 保存计划时校验 webhook 地址。
+func checkReportWebhookTarget(target string) error {
+	parsed, err := url.Parse(target)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("webhook 地址必须是 http(s) URL")
+	}
+	if reportWebhookHostAllowed(parsed.Hostname()) {
+		return nil
+	}
+	_, err = resolveReportWebhookHost(context.Background(), parsed.Hostname())
+	return err
+}
+
+// newReportWebhookTransport 在建立连接时重新解析并校验地址，保存后 DNS 改指向内网或重定向到内网都会被拒绝。
+// 不走环境代理，否则校验的是代理地址而不是实际目标。
+func newReportWebhookTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		var dialer net.Dialer
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if reportWebhookHostAllowed(host) {
+			return dialer.DialContext(ctx, network, address)
+		}
+		addrs, err := resolveReportWebhookHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error
+		for _, addr := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+	return transport
+}
+
+// deliverReportToWebhook 以请求体直接 POST 报表文件，非 2xx 响应视为失败。
+func deliverReportToWebhook(schedule models.ReportSchedule, run models.ReportRun, fileName string, content []byte, contentType string) error {
+	request, err := http.NewRequest(http.MethodPost, schedule.DeliveryTarget, bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("webhook 地址无效: %w", err)
+	}
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	request.Header.Set("X-Report-Schedule-ID", strconv.FormatUint(uint64(schedule.ID), 10))
+	request.Header.Set("X-Report-Run-ID", strconv.FormatUint(uint64(run.ID), 10))
+
+	response, err := reportHTTPClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("调用 webhook 失败: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook 返回状态码 %d", response.StatusCode)
+	}
+	return nil
+}
@@ -238,13 +238,12 @@ func UpdateUser(c *gin.Context) {
 	}
 
 	userID, _ := c.Get("user_id")
-	userRoleValue, _ := c.Get("user_role")
-	userRole, _ := userRoleValue.(string)
-	isAdmin := services.IsSystemAdminRole(userRole)
+	isAdmin := services.IsSystemAdminRole(currentAccountRole(c))
 	if !isAdmin && userID != user.ID {
 		c.JSON(http.StatusForbidden, gin.H{"error": "?????"})
 		return
 	}
+	before := user
 
 	var payload map[string]json.RawMessage
 	if err := c.ShouldBindJSON(&payload); err != nil {
@@ -271,6 +270,9 @@ func UpdateUser(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "??????"})
 		return
 	}
+	after := user
+	after.Department = nil
+	recordAuditChange(c, "user", user.ID, before, after)
 
 	c.JSON(http.StatusOK, user)
 }
@@ -413,6 +415,11 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 
+	var before models.User
+	if err := database.DB.First(&before, targetID).Error; err == nil {
+		recordAuditChange(c, "user", before.ID, before, nil)
+	}
+
 	result := database.DB.Delete(&models.User{}, targetID)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
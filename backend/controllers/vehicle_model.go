@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func GetVehicleModels(c *gin.Context) {
@@ -42,17 +43,13 @@ func GetVehicleModels(c *gin.Context) {
 
 	var vehicleModels []models.VehicleModel
 	query := database.DB
-	allowedLineIDs, statusCode, message := resolveAuthorizedLineIDs(c, lineActionView)
+	programScope, statusCode, message := resolveAuthorizedProgramScope(c, lineActionView)
 	if statusCode != 0 {
 		c.JSON(statusCode, gin.H{"error": message})
 		return
 	}
-	if allowedLineIDs != nil {
-		lineIDs := make([]uint, 0, len(allowedLineIDs))
-		for lineID := range allowedLineIDs {
-			lineIDs = append(lineIDs, lineID)
-		}
-		if len(lineIDs) == 0 {
+	if programScope != nil {
+		if programScope.Empty() {
 			if !paged {
 				c.JSON(http.StatusOK, []models.VehicleModel{})
 				return
@@ -61,11 +58,12 @@ func GetVehicleModels(c *gin.Context) {
 			return
 		}
 
+		visiblePrograms := programScope.Apply(database.DB.Model(&models.Program{}).Select("programs.id"), "programs.")
 		if scope == "selector" {
 			visibleModelIDs := database.DB.Raw(`
 				SELECT programs.vehicle_model_id AS id
 				FROM programs
-				WHERE programs.production_line_id IN ?
+				WHERE programs.id IN (?)
 					AND programs.vehicle_model_id <> 0
 					AND programs.deleted_at IS NULL
 				UNION
@@ -78,10 +76,10 @@ func GetVehicleModels(c *gin.Context) {
 						WHERE programs.vehicle_model_id = vehicle_models.id
 							AND programs.deleted_at IS NULL
 					)
-			`, lineIDs)
+			`, visiblePrograms)
 			query = query.Where("id IN (?)", visibleModelIDs)
 		} else {
-			subQuery := database.DB.Model(&models.Program{}).Select("DISTINCT vehicle_model_id").Where("production_line_id IN ? AND vehicle_model_id <> 0", lineIDs)
+			subQuery := database.DB.Model(&models.Program{}).Select("DISTINCT vehicle_model_id").Where("id IN (?) AND vehicle_model_id <> 0", visiblePrograms)
 			query = query.Where("id IN (?)", subQuery)
 		}
 	}
@@ -147,23 +145,21 @@ func GetVehicleModel(c *gin.Context) {
 		return
 	}
 
-	allowedLineIDs, statusCode, message := resolveAuthorizedLineIDs(c, lineActionView)
+	programScope, statusCode, message := resolveAuthorizedProgramScope(c, lineActionView)
 	if statusCode != 0 {
 		c.JSON(statusCode, gin.H{"error": message})
 		return
 	}
 
 	query := database.DB
-	if allowedLineIDs != nil {
-		lineIDs := make([]uint, 0, len(allowedLineIDs))
-		for lineID := range allowedLineIDs {
-			lineIDs = append(lineIDs, lineID)
-		}
-		if len(lineIDs) == 0 {
+	if programScope != nil {
+		if programScope.Empty() {
 			c.JSON(http.StatusNotFound, gin.H{"error": "vehicle model not found"})
 			return
 		}
-		query = query.Preload("Programs", "production_line_id IN ?", lineIDs)
+		query = query.Preload("Programs", func(db *gorm.DB) *gorm.DB {
+			return programScope.Apply(db, "")
+		})
 	} else {
 		query = query.Preload("Programs")
 	}
@@ -174,7 +170,7 @@ func GetVehicleModel(c *gin.Context) {
 		return
 	}
 
-	if allowedLineIDs != nil && len(vehicleModel.Programs) == 0 {
+	if programScope != nil && len(vehicleModel.Programs) == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "vehicle model not found"})
 		return
 	}
@@ -274,6 +270,15 @@ func DeleteVehicleModel(c *gin.Context) {
 		c.JSON(http.StatusConflict, gin.H{"error": "vehicle model is in use by " + dependency})
 		return
 	}
+	if dependency, err := findPermissionRuleDependency([]permissionRuleDependencyCheck{
+		{Where: "resource_type = ? AND resource_id = ?", Args: []any{models.PermissionResourceVehicleModel, vehicleModelID}, Label: "permission rules"},
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "dependency check failed"})
+		return
+	} else if dependency != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "vehicle model is in use by " + dependency})
+		return
+	}
 
 	result := database.DB.Delete(&models.VehicleModel{}, vehicleModelID)
 	if result.Error != nil {
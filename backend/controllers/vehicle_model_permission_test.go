@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+	"crane-system/services"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupVehicleModelPermissionTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+	{
+		api.GET("/programs", GetPrograms)
+		api.GET("/programs/:id", GetProgram)
+		api.PUT("/permissions/users/:id/rules", SaveUserPermissionRules)
+	}
+	return r
+}
+
+func TestVehicleModelRulesScopeProgramListingAndDetail(t *testing.T) {
+	db := openProductionLineCustomFieldTestDB(t)
+	database.DB = db
+	adminToken, lineA := seedProductionLineCustomFieldAuthData(t, db)
+	services.InvalidateAllCache()
+
+	lineB := models.ProductionLine{Name: "产线B", Code: "LINE-002", Type: "upper", Status: "active"}
+	if err := db.Create(&lineB).Error; err != nil {
+		t.Fatalf("create line: %v", err)
+	}
+	modelX := models.VehicleModel{Name: "车型X", Code: "VM-X"}
+	modelY := models.VehicleModel{Name: "车型Y", Code: "VM-Y"}
+	if err := db.Create(&modelX).Error; err != nil {
+		t.Fatalf("create vehicle model: %v", err)
+	}
+	if err := db.Create(&modelY).Error; err != nil {
+		t.Fatalf("create vehicle model: %v", err)
+	}
+	programs := map[string]models.Program{}
+	for _, line := range []models.ProductionLine{lineA, lineB} {
+		for _, model := range []models.VehicleModel{modelX, modelY} {
+			program := models.Program{Name: line.Code + "/" + model.Code, Code: line.Code + "-" + model.Code, ProductionLineID: line.ID, VehicleModelID: model.ID}
+			if err := db.Create(&program).Error; err != nil {
+				t.Fatalf("create program: %v", err)
+			}
+			programs[program.Name] = program
+		}
+	}
+
+	supplier := models.User{Name: "Supplier", Password: "hashed", EmployeeID: "SUP-001", Role: "viewer", Status: "active"}
+	engineer := models.User{Name: "Engineer", Password: "hashed", EmployeeID: "ENG-001", Role: "viewer", Status: "active"}
+	if err := db.Create(&supplier).Error; err != nil {
+		t.Fatalf("create supplier: %v", err)
+	}
+	if err := db.Create(&engineer).Error; err != nil {
+		t.Fatalf("create engineer: %v", err)
+	}
+
+	router := setupVehicleModelPermissionTestRouter()
+	// 供应商只按车型 X 授权，不限产线
+	recorder := performProductionLineCustomFieldRequest(t, router, http.MethodPut, fmt.Sprintf("/api/permissions/users/%d/rules", supplier.ID), adminToken, map[string]any{
+		"changes": []map[string]any{
+			{"resource_type": models.PermissionResourceVehicleModel, "resource_id": modelX.ID, "action": models.PermissionActionView, "decision": models.PermissionDecisionAllow},
+		},
+	})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("save supplier rules: %d %s", recorder.Code, recorder.Body.String())
+	}
+	// 工程师可看产线 A，但产线 A 上的车型 Y 被拒绝
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodPut, fmt.Sprintf("/api/permissions/users/%d/rules", engineer.ID), adminToken, map[string]any{
+		"changes": []map[string]any{
+			{"resource_type": models.PermissionResourceProductionLine, "resource_id": lineA.ID, "action": models.PermissionActionView, "decision": models.PermissionDecisionAllow},
+			{"resource_type": models.PermissionResourceVehicleModel, "resource_id": modelY.ID, "production_line_id": lineA.ID, "action": models.PermissionActionView, "decision": models.PermissionDecisionDeny},
+		},
+	})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("save engineer rules: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodPut, fmt.Sprintf("/api/permissions/users/%d/rules", engineer.ID), adminToken, map[string]any{
+		"changes": []map[string]any{
+			{"resource_type": models.PermissionResourceProductionLine, "resource_id": lineA.ID, "production_line_id": lineB.ID, "action": models.PermissionActionView, "decision": models.PermissionDecisionAllow},
+		},
+	})
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected line rule with production_line_id to be rejected, got %d", recorder.Code)
+	}
+
+	listNames := func(token string) map[string]bool {
+		t.Helper()
+		recorder := performProductionLineCustomFieldRequest(t, router, http.MethodGet, "/api/programs", token, nil)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("list programs: %d %s", recorder.Code, recorder.Body.String())
+		}
+		names := map[string]bool{}
+		for _, item := range decodeProductionLineCustomFieldResponse[[]models.Program](t, recorder) {
+			names[item.Name] = true
+		}
+		return names
+	}
+
+	supplierToken := createUserTokenForTest(t, supplier.ID, supplier.Role)
+	supplierNames := listNames(supplierToken)
+	if len(supplierNames) != 2 || !supplierNames["LINE-001/VM-X"] || !supplierNames["LINE-002/VM-X"] {
+		t.Fatalf("supplier should only see vehicle model X on every line, got %v", supplierNames)
+	}
+	engineerToken := createUserTokenForTest(t, engineer.ID, engineer.Role)
+	engineerNames := listNames(engineerToken)
+	if len(engineerNames) != 1 || !engineerNames["LINE-001/VM-X"] {
+		t.Fatalf("engineer should see line A without vehicle model Y, got %v", engineerNames)
+	}
+
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, fmt.Sprintf("/api/programs/%d", programs["LINE-001/VM-Y"].ID), supplierToken, nil)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("supplier should not open vehicle model Y program, got %d", recorder.Code)
+	}
+	recorder = performProductionLineCustomFieldRequest(t, router, http.MethodGet, fmt.Sprintf("/api/programs/%d", programs["LINE-002/VM-X"].ID), supplierToken, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("supplier should open vehicle model X program, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if allowed := services.CheckProgramScopeAction(supplier.ID, supplier.Role, 0, lineB.ID, modelX.ID, services.LineActionDownload).Allowed; allowed {
+		t.Fatal("supplier was only granted view on vehicle model X")
+	}
+}
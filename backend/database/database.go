@@ -99,7 +99,24 @@ func migrationModels() []any {
 		&models.ProgramFile{},
 		&models.ProgramVersion{},
 		&models.ProgramRelation{},
+		&models.ProgramRelationType{},
 		&models.ProgramMapping{},
+		&models.ProgramStatusDefinition{},
+		&models.ProgramStatusTransition{},
+		&models.ProgramStatusHistory{},
+		&models.ProgramCodeRule{},
+		&models.ProgramCodeSequence{},
+		&models.ExportTemplate{},
+		&models.ReportSchedule{},
+		&models.ReportRun{},
+		&models.BatchImportLayout{},
+		&models.BatchImportTask{},
+		&models.BatchImportTaskItem{},
+		&models.ImportWatchFolder{},
+		&models.ImportWatchFile{},
+		&models.Job{},
+		&models.AuditLog{},
+		&models.AccessRequest{},
 		&models.UserPermission{},
 		&models.DepartmentPermission{},
 		&models.RoleDefaultPermission{},
@@ -111,6 +128,7 @@ func migrationModels() []any {
 		&models.UserPermissionOverride{},
 		&models.RoleLinePermission{},
 		&models.LineAdminAssignment{},
+		&models.APIToken{},
 	}
 }
 
@@ -327,8 +345,8 @@ func ValidateSchemaWithDB(db *gorm.DB) error {
 		return fmt.Errorf("schema validation failed: table program_custom_field_values missing index idx_program_custom_field_values_program_field")
 	}
 
-	if !db.Migrator().HasIndex(&models.PermissionRule{}, "idx_permission_rule_scope") {
-		return fmt.Errorf("schema validation failed: table permission_rules missing index idx_permission_rule_scope")
+	if !db.Migrator().HasIndex(&models.PermissionRule{}, "idx_permission_rule_resource_scope") {
+		return fmt.Errorf("schema validation failed: table permission_rules missing index idx_permission_rule_resource_scope")
 	}
 
 	if err := validateCriticalColumnDefinitions(db); err != nil {
@@ -390,6 +408,9 @@ func AutoMigrate() error {
 		if err := ensureTablesWithDB(db); err != nil {
 			return err
 		}
+		if err := dropLegacyPermissionRuleIndex(db); err != nil {
+			return err
+		}
 		if err := recordMigrationStep(db, migrationStepSchemaBootstrap); err != nil {
 			return err
 		}
@@ -422,6 +443,15 @@ func AutoMigrate() error {
 	})
 }
 
+// dropLegacyPermissionRuleIndex 删除不含 production_line_id 的旧唯一索引，
+// 否则同一车型在不同产线上的规则会互相冲突。
+func dropLegacyPermissionRuleIndex(db *gorm.DB) error {
+	if !db.Migrator().HasIndex(&models.PermissionRule{}, "idx_permission_rule_scope") {
+		return nil
+	}
+	return db.Migrator().DropIndex(&models.PermissionRule{}, "idx_permission_rule_scope")
+}
+
 func migrateLegacyPermissionRules() error {
 	return migrateLegacyPermissionRulesWithDB(DB)
 }
@@ -540,6 +570,7 @@ func upsertPermissionRuleSetWithDB(db *gorm.DB, subjectType string, subjectID ui
 			{Name: "subject_key"},
 			{Name: "resource_type"},
 			{Name: "resource_id"},
+			{Name: "production_line_id"},
 			{Name: "action"},
 		},
 		DoUpdates: clause.AssignmentColumns([]string{"decision", "updated_at"}),
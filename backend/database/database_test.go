@@ -45,7 +45,7 @@ func TestAutoMigrateCreatesMissingTables(t *testing.T) {
 		t.Fatal("expected permission_rules table to exist")
 	}
 
-	if !DB.Migrator().HasIndex(&models.PermissionRule{}, "idx_permission_rule_scope") {
+	if !DB.Migrator().HasIndex(&models.PermissionRule{}, "idx_permission_rule_resource_scope") {
 		t.Fatal("expected permission_rules unique scope index to exist")
 	}
 }
@@ -75,6 +75,31 @@ func TestAutoMigrateToleratesExistingPartialVehicleModelTable(t *testing.T) {
 	}
 }
 
+func TestAutoMigrateReplacesLegacyPermissionRuleScopeIndex(t *testing.T) {
+	DB = openMigrationTestDB(t)
+
+	if err := DB.Exec(`CREATE TABLE permission_rules (id integer primary key, subject_type text, subject_id integer, subject_key text, resource_type text, resource_id integer, action text, decision text)`).Error; err != nil {
+		t.Fatalf("create legacy permission_rules table: %v", err)
+	}
+	if err := DB.Exec(`CREATE UNIQUE INDEX idx_permission_rule_scope ON permission_rules (subject_type, subject_id, subject_key, resource_type, resource_id, action)`).Error; err != nil {
+		t.Fatalf("create legacy scope index: %v", err)
+	}
+
+	if err := AutoMigrate(); err != nil {
+		t.Fatalf("auto migrate with legacy scope index: %v", err)
+	}
+
+	if DB.Migrator().HasIndex(&models.PermissionRule{}, "idx_permission_rule_scope") {
+		t.Fatal("expected legacy scope index to be dropped")
+	}
+	for _, lineID := range []uint{1, 2} {
+		rule := models.PermissionRule{SubjectType: models.PermissionSubjectUser, SubjectID: 1, ResourceType: models.PermissionResourceVehicleModel, ResourceID: 1, ProductionLineID: lineID, Action: models.PermissionActionView, Decision: models.PermissionDecisionAllow}
+		if err := DB.Create(&rule).Error; err != nil {
+			t.Fatalf("create vehicle model rule for line %d: %v", lineID, err)
+		}
+	}
+}
+
 func TestAutoMigrateSeedsBaseData(t *testing.T) {
 	DB = openMigrationTestDB(t)
 	previousConfig := config.AppConfig
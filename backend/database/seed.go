@@ -268,6 +268,7 @@ func upsertSeedPermissionRules(tx *gorm.DB, rules []models.PermissionRule) error
 			{Name: "subject_key"},
 			{Name: "resource_type"},
 			{Name: "resource_id"},
+			{Name: "production_line_id"},
 			{Name: "action"},
 		},
 		DoUpdates: clause.AssignmentColumns([]string{"decision", "updated_at"}),
@@ -285,6 +286,7 @@ func insertMissingSeedPermissionRules(tx *gorm.DB, rules []models.PermissionRule
 			{Name: "subject_key"},
 			{Name: "resource_type"},
 			{Name: "resource_id"},
+			{Name: "production_line_id"},
 			{Name: "action"},
 		},
 		DoNothing: true,
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"bytes"
+	"crane-system/models"
+	"crane-system/services"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	// auditBodyLimit 以内的 JSON 请求体在处理函数未提供快照时作为变更后内容记录
+	auditBodyLimit = 64 << 10
+)
+
+// auditRedactedFields 中的字段在记录请求体时被替换。
+var auditRedactedFields = map[string]struct{}{
+	"password":     {},
+	"old_password": {},
+	"new_password": {},
+	"token":        {},
+	"secret":       {},
+}
+
+// RequestID 为每个请求分配请求 ID，客户端或网关已提供时沿用。
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := strings.TrimSpace(c.GetHeader(requestIDHeader))
+		if requestID == "" || len(requestID) > 64 {
+			buf := make([]byte, 16)
+			_, _ = rand.Read(buf)
+			requestID = hex.EncodeToString(buf)
+		}
+		c.Set("request_id", requestID)
+		c.Header(requestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// AuditLog 为变更类请求写入审计日志，包括被拒绝和失败的请求。
+// 处理函数可通过 services.AuditChangeKey 登记资源和前后快照；未登记时按路由推断资源，
+// 并记录脱敏后的 JSON 请求体。
+func AuditLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isMutatingMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+		body := captureAuditBody(c)
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		entry := models.AuditLog{
+			Action:       c.Request.Method + " " + route,
+			Method:       c.Request.Method,
+			Path:         c.Request.URL.Path,
+			ResourceType: auditResourceType(route),
+			ResourceID:   auditResourceID(c),
+			StatusCode:   c.Writer.Status(),
+			After:        body,
+			IP:           c.ClientIP(),
+			RequestID:    c.GetString("request_id"),
+		}
+		if value, ok := c.Get("user"); ok {
+			if user, ok := value.(models.User); ok {
+				entry.ActorID = user.ID
+				entry.ActorName = user.Name
+			}
+		}
+		if value, ok := c.Get(services.AuditChangeKey); ok {
+			if change, ok := value.(services.AuditChange); ok {
+				if change.ResourceType != "" {
+					entry.ResourceType = change.ResourceType
+				}
+				if change.ResourceID != "" {
+					entry.ResourceID = change.ResourceID
+				}
+				entry.Before = services.MarshalAuditSnapshot(change.Before)
+				if change.After != nil {
+					entry.After = services.MarshalAuditSnapshot(change.After)
+				}
+			}
+		}
+		if _, err := services.AppendAuditLog(entry); err != nil {
+			slog.Error("写入审计日志失败", "action", entry.Action, "request_id", entry.RequestID, "error", err)
+		}
+	}
+}
+
+// captureAuditBody 读取并放回 JSON 请求体，返回脱敏后的内容；上传等其他请求体不记录。
+func captureAuditBody(c *gin.Context) json.RawMessage {
+	if c.Request.Body == nil || !strings.HasPrefix(c.ContentType(), "application/json") {
+		return nil
+	}
+	raw, err := io.ReadAll(io.LimitReader(c.Request.Body, auditBodyLimit+1))
+	c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(raw), c.Request.Body))
+	if err != nil || len(raw) == 0 || len(raw) > auditBodyLimit {
+		return nil
+	}
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil
+	}
+	redacted, _ := json.Marshal(redactAuditValue(value))
+	return redacted
+}
+
+func redactAuditValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if _, ok := auditRedactedFields[strings.ToLower(key)]; ok {
+				v[key] = "***"
+				continue
+			}
+			v[key] = redactAuditValue(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactAuditValue(item)
+		}
+	}
+	return value
+}
+
+// auditResourceType 取 /api 之后的第一段路由作为资源类型，例如 /api/files/:id 为 files。
+func auditResourceType(route string) string {
+	route = strings.TrimPrefix(route, "/api")
+	route = strings.TrimPrefix(route, "/")
+	if i := strings.Index(route, "/"); i >= 0 {
+		route = route[:i]
+	}
+	return route
+}
+
+// auditResourceID 优先使用 :id 参数，否则使用路由中的第一个参数。
+func auditResourceID(c *gin.Context) string {
+	if id := c.Param("id"); id != "" {
+		return id
+	}
+	if len(c.Params) > 0 {
+		return c.Params[0].Value
+	}
+	return ""
+}
@@ -5,8 +5,10 @@ import (
 	"crane-system/database"
 	"crane-system/models"
 	"crane-system/services"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
@@ -20,6 +22,19 @@ type Claims struct {
 
 const authTokenCookieName = "auth_token"
 
+// apiTokenScopeContextKey 保存 API 令牌请求的令牌范围，JWT 请求不设置。
+const apiTokenScopeContextKey = "api_token_scope"
+
+// CurrentAPITokenScope 返回当前请求所用 API 令牌的范围，JWT 请求返回 nil。
+func CurrentAPITokenScope(c *gin.Context) *services.APITokenScope {
+	value, exists := c.Get(apiTokenScopeContextKey)
+	if !exists {
+		return nil
+	}
+	scope, _ := value.(*services.APITokenScope)
+	return scope
+}
+
 func tokenFromRequest(c *gin.Context) (string, string) {
 	if cookie, err := c.Cookie(authTokenCookieName); err == nil && cookie != "" {
 		return cookie, ""
@@ -38,7 +53,7 @@ func tokenFromRequest(c *gin.Context) (string, string) {
 	return tokenString, ""
 }
 
-// AuthMiddleware validates either the HttpOnly auth cookie or a legacy Bearer JWT.
+// AuthMiddleware validates the HttpOnly auth cookie, a legacy Bearer JWT, or a Bearer API token.
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString, tokenErr := tokenFromRequest(c)
@@ -47,6 +62,10 @@ func AuthMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		if strings.HasPrefix(tokenString, services.APITokenPrefix) {
+			authenticateAPIToken(c, tokenString)
+			return
+		}
 
 		claims := &Claims{}
 		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
@@ -85,8 +104,40 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// authenticateAPIToken 校验 API 令牌，令牌范围写入上下文，由权限中间件和接口授权继续收窄。
+func authenticateAPIToken(c *gin.Context, tokenString string) {
+	token, user, err := services.AuthenticateAPIToken(tokenString, time.Now(), c.ClientIP())
+	if err != nil {
+		message := "无效的令牌"
+		if errors.Is(err, services.ErrAPITokenExpired) || errors.Is(err, services.ErrAPITokenRevoked) {
+			message = err.Error()
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": message})
+		c.Abort()
+		return
+	}
+	if user.Status != "active" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户已被禁用"})
+		c.Abort()
+		return
+	}
+
+	c.Set("user_id", user.ID)
+	c.Set("user_role", user.Role)
+	c.Set("user", user)
+	c.Set("api_token_id", token.ID)
+	c.Set(apiTokenScopeContextKey, services.NewAPITokenScope(token))
+	c.Next()
+}
+
+// AdminMiddleware 要求管理员角色；API 令牌不能访问管理员接口。
 func AdminMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if CurrentAPITokenScope(c) != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API 令牌不能访问管理员接口"})
+			c.Abort()
+			return
+		}
 		role, exists := c.Get("user_role")
 		if !exists || (role != "admin" && role != "system_admin") {
 			c.JSON(http.StatusForbidden, gin.H{"error": "需要管理员权限"})
@@ -99,6 +150,11 @@ func AdminMiddleware() gin.HandlerFunc {
 
 func RequirePermission(permissionCode string) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if !CurrentAPITokenScope(c).AllowsFunction(permissionCode) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "令牌范围不包含该权限"})
+			c.Abort()
+			return
+		}
 		role, _ := c.Get("user_role")
 		if role == "admin" || role == "system_admin" {
 			c.Next()
@@ -129,6 +185,21 @@ func RequirePermission(permissionCode string) gin.HandlerFunc {
 
 func RequireAnyPermission(permissionCodes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// 令牌请求只能使用令牌范围内的权限码
+		tokenScope := CurrentAPITokenScope(c)
+		scopedCodes := make([]string, 0, len(permissionCodes))
+		for _, code := range permissionCodes {
+			if tokenScope.AllowsFunction(code) {
+				scopedCodes = append(scopedCodes, code)
+			}
+		}
+		if len(scopedCodes) == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "令牌范围不包含该权限"})
+			c.Abort()
+			return
+		}
+		permissionCodes = scopedCodes
+
 		role, _ := c.Get("user_role")
 		if role == "admin" || role == "system_admin" {
 			c.Next()
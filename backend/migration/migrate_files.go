@@ -2,6 +2,7 @@ package migration
 
 import (
 	"archive/zip"
+	"context"
 	"crane-system/database"
 	"crane-system/models"
 	"crane-system/utils"
@@ -21,7 +22,7 @@ type FileMigrationStatus struct {
 	FailedFiles   int     `json:"failed_files"`
 	Progress      float64 `json:"progress"`
 	CurrentFile   string  `json:"current_file"`
-	Status        string  `json:"status"` // "running", "completed", "failed"
+	Status        string  `json:"status"` // "running", "completed", "failed", "canceled"
 	StartTime     string  `json:"start_time"`
 	EndTime       string  `json:"end_time,omitempty"`
 	ErrorMsg      string  `json:"error_msg,omitempty"`
@@ -86,16 +87,23 @@ func updateMigrationStatus(mutator func(status *FileMigrationStatus)) {
 	mutator(migrationStatus)
 }
 
-// MigrateFilesToNewStructure 迁移文件到新的目录结构
-func MigrateFilesToNewStructure() error {
+// MigrateFilesToNewStructure 迁移文件到新的目录结构。
+// 每次状态变化都会回调 report（可为空）；ctx 结束时停止迁移剩余文件。
+func MigrateFilesToNewStructure(ctx context.Context, report func(FileMigrationStatus)) error {
 	if !startMigration(time.Now()) {
 		return fmt.Errorf("文件迁移正在进行中")
 	}
+	update := func(mutator func(status *FileMigrationStatus)) {
+		updateMigrationStatus(mutator)
+		if report != nil {
+			report(GetMigrationStatus())
+		}
+	}
 
 	// 确保备份目录存在
 	backupDir := filepath.Join(utils.BackupDir(), "file_migration", time.Now().Format("20060102_150405"))
 	if err := utils.EnsureDirectoryExists(backupDir); err != nil {
-		updateMigrationStatus(func(status *FileMigrationStatus) {
+		update(func(status *FileMigrationStatus) {
 			status.Status = "failed"
 			status.ErrorMsg = fmt.Sprintf("创建备份目录失败: %v", err)
 			status.EndTime = time.Now().Format(time.RFC3339)
@@ -110,7 +118,7 @@ func MigrateFilesToNewStructure() error {
 
 	if utils.FileExists(utils.UploadDir()) {
 		if err := createZipBackup(utils.UploadDir(), backupPath); err != nil {
-			updateMigrationStatus(func(status *FileMigrationStatus) {
+			update(func(status *FileMigrationStatus) {
 				status.Status = "failed"
 				status.ErrorMsg = fmt.Sprintf("创建备份失败: %v", err)
 				status.EndTime = time.Now().Format(time.RFC3339)
@@ -123,7 +131,7 @@ func MigrateFilesToNewStructure() error {
 	// 查询所有需要迁移的文件
 	var files []models.ProgramFile
 	if err := database.DB.Find(&files).Error; err != nil {
-		updateMigrationStatus(func(status *FileMigrationStatus) {
+		update(func(status *FileMigrationStatus) {
 			status.Status = "failed"
 			status.ErrorMsg = fmt.Sprintf("查询文件记录失败: %v", err)
 			status.EndTime = time.Now().Format(time.RFC3339)
@@ -132,11 +140,11 @@ func MigrateFilesToNewStructure() error {
 	}
 
 	totalFiles := len(files)
-	updateMigrationStatus(func(status *FileMigrationStatus) {
+	update(func(status *FileMigrationStatus) {
 		status.TotalFiles = totalFiles
 	})
 	if totalFiles == 0 {
-		updateMigrationStatus(func(status *FileMigrationStatus) {
+		update(func(status *FileMigrationStatus) {
 			status.Status = "completed"
 			status.EndTime = time.Now().Format(time.RFC3339)
 			status.Progress = 100
@@ -149,14 +157,21 @@ func MigrateFilesToNewStructure() error {
 
 	// 逐个迁移文件
 	for i, file := range files {
-		updateMigrationStatus(func(status *FileMigrationStatus) {
+		if err := ctx.Err(); err != nil {
+			update(func(status *FileMigrationStatus) {
+				status.Status = "canceled"
+				status.EndTime = time.Now().Format(time.RFC3339)
+			})
+			return err
+		}
+		update(func(status *FileMigrationStatus) {
 			status.CurrentFile = file.FileName
 			status.Progress = float64(i) / float64(totalFiles) * 100
 		})
 
 		if isAlreadyMigrated(file.FilePath) {
 			log.Printf("文件 %s 已经是新格式，跳过", file.FileName)
-			updateMigrationStatus(func(status *FileMigrationStatus) {
+			update(func(status *FileMigrationStatus) {
 				status.MigratedFiles++
 				status.Progress = float64(i+1) / float64(totalFiles) * 100
 			})
@@ -165,14 +180,14 @@ func MigrateFilesToNewStructure() error {
 
 		if err := migrateSingleFile(&file, backupDir); err != nil {
 			log.Printf("迁移文件 %s 失败: %v", file.FileName, err)
-			updateMigrationStatus(func(status *FileMigrationStatus) {
+			update(func(status *FileMigrationStatus) {
 				status.FailedFiles++
 				status.ErrorMsg = fmt.Sprintf("迁移文件 %s 失败: %v", file.FileName, err)
 				status.Progress = float64(i+1) / float64(totalFiles) * 100
 			})
 		} else {
 			log.Printf("文件 %s 迁移成功", file.FileName)
-			updateMigrationStatus(func(status *FileMigrationStatus) {
+			update(func(status *FileMigrationStatus) {
 				status.MigratedFiles++
 				status.Progress = float64(i+1) / float64(totalFiles) * 100
 			})
@@ -180,7 +195,7 @@ func MigrateFilesToNewStructure() error {
 	}
 
 	finalStatus := GetMigrationStatus()
-	updateMigrationStatus(func(status *FileMigrationStatus) {
+	update(func(status *FileMigrationStatus) {
 		status.Status = "completed"
 		status.EndTime = time.Now().Format(time.RFC3339)
 		status.Progress = 100
@@ -318,13 +333,9 @@ func createZipBackup(sourceDir, targetPath string) error {
 	})
 }
 
-// RollbackMigration 回滚迁移
+// RollbackMigration 用最近一次迁移的备份回滚；迁移是否已完成由调用方根据任务记录判断，
+// 本进程内的状态在重启后会丢失。
 func RollbackMigration() error {
-	status := GetMigrationStatus()
-	if status.Status != "completed" {
-		return fmt.Errorf("只能回滚已完成的迁移")
-	}
-
 	// 查找最新的备份文件
 	backupDir := filepath.Join(utils.BackupDir(), "file_migration")
 	files, err := os.ReadDir(backupDir)
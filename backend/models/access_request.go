@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+const (
+	AccessRequestPending  = "pending"
+	AccessRequestApproved = "approved"
+	AccessRequestRejected = "rejected"
+	AccessRequestCanceled = "canceled"
+)
+
+// AccessRequest 是用户对某条产线动作权限的申请，由该产线的产线管理员审批。
+// 批准后以申请人为主体写入 PermissionRule，审计日志中记录申请 ID。
+type AccessRequest struct {
+	ID               uint       `gorm:"primarykey" json:"id"`
+	CreatedAt        time.Time  `gorm:"index" json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	RequesterID      uint       `gorm:"not null;index" json:"requester_id"`
+	ProductionLineID uint       `gorm:"not null;index" json:"production_line_id"`
+	Actions          string     `gorm:"size:100;not null" json:"actions"` // 逗号分隔，view / download / upload
+	Reason           string     `gorm:"size:500" json:"reason"`
+	ValidUntil       *time.Time `json:"valid_until"` // 申请的到期时间，为空表示长期
+	Status           string     `gorm:"size:20;not null;index" json:"status"`
+	ReviewerID       *uint      `gorm:"index" json:"reviewer_id"`
+	ReviewedAt       *time.Time `json:"reviewed_at"`
+	ReviewComment    string     `gorm:"size:500" json:"review_comment"`
+
+	Requester      User           `gorm:"foreignKey:RequesterID" json:"requester,omitempty"`
+	ProductionLine ProductionLine `gorm:"foreignKey:ProductionLineID" json:"production_line,omitempty"`
+	Reviewer       *User          `gorm:"foreignKey:ReviewerID" json:"reviewer,omitempty"`
+}
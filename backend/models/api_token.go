@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// 账号类型：服务账号不能用密码登录，只能通过 API 令牌访问。
+const (
+	UserAccountTypeHuman   = "human"
+	UserAccountTypeService = "service"
+)
+
+// APIToken 是长期有效的个人访问令牌或服务账号令牌，数据库只保存令牌的 SHA-256 哈希。
+// 令牌的实际权限是范围（功能权限码、产线动作和可选的产线）与所有者当前权限的交集。
+type APIToken struct {
+	ID                uint       `gorm:"primarykey" json:"id"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	UserID            uint       `gorm:"not null;index" json:"user_id"`                        // 所有者，个人令牌为本人，服务账号令牌为服务账号
+	Name              string     `gorm:"size:100;not null" json:"name"`                        // 令牌用途说明
+	TokenPrefix       string     `gorm:"size:16;not null" json:"token_prefix"`                 // 明文前缀，便于识别令牌
+	TokenHash         string     `gorm:"size:64;not null;uniqueIndex" json:"-"`                // 令牌哈希
+	FunctionCodes     []string   `gorm:"type:text;serializer:json" json:"function_codes"`      // 允许使用的功能权限码
+	LineActions       []string   `gorm:"type:text;serializer:json" json:"line_actions"`        // 允许的产线动作，manage 隐含其他动作
+	ProductionLineIDs []uint     `gorm:"type:text;serializer:json" json:"production_line_ids"` // 限定的产线，为空表示不限
+	ExpiresAt         *time.Time `gorm:"index" json:"expires_at"`                              // 为空表示不过期
+	LastUsedAt        *time.Time `json:"last_used_at"`
+	LastUsedIP        string     `gorm:"size:64" json:"last_used_ip"`
+	RevokedAt         *time.Time `json:"revoked_at"`
+	CreatedBy         uint       `json:"created_by"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrAuditLogImmutable = errors.New("审计日志不可修改或删除")
+
+// AuditLog 是只追加的操作审计记录。Sequence 连续递增且唯一，
+// Hash 覆盖本条内容和上一条的 Hash，任何修改、删除或插入都会使后续校验失败。
+type AuditLog struct {
+	ID           uint            `gorm:"primarykey" json:"id"`
+	Sequence     uint64          `gorm:"not null;uniqueIndex" json:"sequence"`
+	CreatedAt    time.Time       `gorm:"index" json:"created_at"`
+	ActorID      uint            `gorm:"index" json:"actor_id"`
+	ActorName    string          `gorm:"size:100" json:"actor_name"`
+	Action       string          `gorm:"size:200;index" json:"action"` // 方法 + 路由模板，例如 DELETE /api/files/:id
+	Method       string          `gorm:"size:10" json:"method"`
+	Path         string          `gorm:"size:500" json:"path"`
+	ResourceType string          `gorm:"size:80;index" json:"resource_type"`
+	ResourceID   string          `gorm:"size:200;index" json:"resource_id"`
+	StatusCode   int             `json:"status_code"`
+	Before       json.RawMessage `gorm:"type:longtext" json:"before,omitempty"`
+	After        json.RawMessage `gorm:"type:longtext" json:"after,omitempty"`
+	IP           string          `gorm:"size:64" json:"ip"`
+	RequestID    string          `gorm:"size:64;index" json:"request_id"`
+	PrevHash     string          `gorm:"size:64" json:"prev_hash"`
+	Hash         string          `gorm:"size:64;not null" json:"hash"`
+}
+
+func (AuditLog) BeforeUpdate(*gorm.DB) error { return ErrAuditLogImmutable }
+
+func (AuditLog) BeforeDelete(*gorm.DB) error { return ErrAuditLogImmutable }
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// BatchImportLayout 描述批量导入压缩包的目录结构，导入时按编码选择。
+// Segments 依次对应文件所在的前几级目录，更深的子目录归入同一程序；
+// FilePattern 匹配文件名，可用命名分组 workstation / program / code / version 提取信息。
+type BatchImportLayout struct {
+	ID                 uint      `gorm:"primarykey" json:"id"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+	Code               string    `gorm:"size:50;not null;uniqueIndex" json:"code"`
+	Name               string    `gorm:"size:100;not null" json:"name"`
+	Segments           []string  `gorm:"type:text;serializer:json" json:"segments"` // 目录角色：line / vehicle_model / workstation / program / ignore
+	FilePattern        string    `gorm:"size:500" json:"file_pattern"`              // 留空表示不限制文件名
+	DefaultWorkstation string    `gorm:"size:100" json:"default_workstation"`       // 目录和文件名都不含工位时使用
+	Description        string    `gorm:"type:text" json:"description"`
+
+	Builtin bool `gorm:"-" json:"builtin"` // 内置布局不落库，不可修改或删除
+}
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// BatchImportTask 持久化一次批量导入及其保留的压缩包，失败条目可在保留期内重试。
+type BatchImportTask struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	OwnerID    uint       `gorm:"index;not null" json:"owner_id"`
+	Layout     string     `gorm:"size:50" json:"layout"`
+	Status     string     `gorm:"size:20;index" json:"status"` // running / completed / partial / failed
+	Total      int        `json:"total"`
+	Success    int        `json:"success"`
+	Failed     int        `json:"failed"`
+	Attempts   int        `json:"attempts"`
+	ArchiveDir string     `gorm:"size:500" json:"-"` // 全部成功或过期后清空
+	ExpiresAt  time.Time  `json:"expires_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+
+	Retryable bool                  `gorm:"-" json:"retryable"`
+	Items     []BatchImportTaskItem `gorm:"foreignKey:TaskID" json:"items,omitempty"`
+	Owner     User                  `gorm:"foreignKey:OwnerID" json:"owner,omitempty"`
+}
+
+// BatchImportTaskItem 是批量导入中一个程序的处理结果；Plan 保存重试所需的导入计划。
+type BatchImportTaskItem struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	TaskID      uint      `gorm:"index;not null" json:"task_id"`
+	ItemNo      int       `json:"item_no"` // 条目在本次导入中的序号，重试时不变
+	Workstation string    `gorm:"size:255" json:"workstation"`
+	Program     string    `gorm:"size:255" json:"program"`
+	ProgramCode string    `gorm:"size:100" json:"program_code"`
+	Action      string    `gorm:"size:20" json:"action"` // 计划的处理方式：create / new_version / unchanged / conflict
+	Status      string    `gorm:"size:20" json:"status"` // pending / success / error
+	Error       string    `gorm:"type:text" json:"error"`
+	Attempts    int       `json:"attempts"`
+	Plan        string    `gorm:"type:text" json:"-"`
+}
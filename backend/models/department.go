@@ -6,6 +6,7 @@ import (
 	"gorm.io/gorm"
 )
 
+// Department 以 ParentID 组成部门树，部门规则和部门默认规则沿树向下继承。
 type Department struct {
 	ID          uint           `gorm:"primarykey" json:"id"`
 	CreatedAt   time.Time      `json:"created_at"`
@@ -14,4 +15,6 @@ type Department struct {
 	Name        string         `gorm:"size:100;not null;unique" json:"name"`
 	Description string         `gorm:"type:text" json:"description"`
 	Status      string         `gorm:"size:20;default:active" json:"status"`
+	ParentID    *uint          `gorm:"index" json:"parent_id"` // 上级部门ID，为空表示顶级部门
+	Children    []Department   `gorm:"-" json:"children,omitempty"`
 }
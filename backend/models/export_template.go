@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// ExportTemplate 是用户保存的程序导出方案，记录列、筛选条件和排序。
+// Shared=true 时所有具备导出权限的用户可以使用，但只有创建人和管理员可以修改。
+type ExportTemplate struct {
+	ID        uint              `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	Name      string            `gorm:"size:100;not null" json:"name"`
+	OwnerID   uint              `gorm:"not null;index" json:"owner_id"`
+	Shared    bool              `gorm:"default:false;index" json:"shared"`
+	Columns   []string          `gorm:"type:text;serializer:json" json:"columns"` // 导出列 key，与 columns 参数一致
+	Filters   map[string]string `gorm:"type:text;serializer:json" json:"filters"` // 筛选参数，与导出接口的查询参数同名
+	SortBy    string            `gorm:"size:30" json:"sort_by"`
+	SortOrder string            `gorm:"size:4" json:"sort_order"`
+
+	Owner User `gorm:"foreignKey:OwnerID" json:"owner,omitempty"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// ImportWatchFolder 是服务器上被定期扫描的导入目录，新增或变化的程序以 Owner 的身份按批量导入流程导入。
+type ImportWatchFolder struct {
+	ID               uint       `gorm:"primarykey" json:"id"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	Name             string     `gorm:"size:100;not null" json:"name"`
+	Path             string     `gorm:"size:500;not null" json:"path"`
+	Layout           string     `gorm:"size:50" json:"layout"`              // 批量导入布局编码
+	ProductionLineID uint       `gorm:"not null" json:"production_line_id"` // 布局未给出可匹配的产线目录时使用
+	VehicleModelID   *uint      `json:"vehicle_model_id"`
+	OwnerID          uint       `gorm:"not null" json:"owner_id"`
+	Enabled          bool       `json:"enabled"`
+	StableSeconds    int        `json:"stable_seconds"` // 文件最后修改后静置多久才导入，避免读到未写完的文件
+	LastScanAt       *time.Time `json:"last_scan_at"`
+	LastStatus       string     `gorm:"size:20" json:"last_status"`
+	LastError        string     `gorm:"type:text" json:"last_error"`
+	LastImportID     *uint      `json:"last_import_id"`
+
+	ProductionLine ProductionLine `gorm:"foreignKey:ProductionLineID" json:"production_line,omitempty"`
+	Owner          User           `gorm:"foreignKey:OwnerID" json:"owner,omitempty"`
+}
+
+// ImportWatchFile 记录监视目录中每个文件最后一次处理时的大小和修改时间，用于识别新增或变化。
+type ImportWatchFile struct {
+	ID             uint      `gorm:"primarykey" json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	FolderID       uint      `gorm:"not null;uniqueIndex:idx_import_watch_file" json:"folder_id"`
+	RelPath        string    `gorm:"size:500;not null;uniqueIndex:idx_import_watch_file" json:"rel_path"`
+	Size           int64     `json:"size"`
+	ModTime        time.Time `json:"mod_time"`
+	Status         string    `gorm:"size:20;index" json:"status"` // imported / unchanged / failed / quarantined
+	Reason         string    `gorm:"type:text" json:"reason"`
+	ImportID       *uint     `json:"import_id"`
+	QuarantinePath string    `gorm:"size:500" json:"quarantine_path"`
+}
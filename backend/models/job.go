@@ -0,0 +1,36 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Job 是持久化的后台任务。执行者通过条件更新抢占任务并定期续租，
+// 租约过期的任务可被其他实例接管，因此重启或多实例部署都不会丢失进度。
+type Job struct {
+	ID              uint            `gorm:"primarykey" json:"id"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+	Type            string          `gorm:"size:50;not null;index" json:"type"`
+	Status          string          `gorm:"size:20;not null;index" json:"status"` // queued / running / completed / failed / canceled
+	Payload         json.RawMessage `gorm:"type:text" json:"payload,omitempty"`
+	Result          json.RawMessage `gorm:"type:longtext" json:"result,omitempty"`
+	ErrorMessage    string          `gorm:"type:text" json:"error_message"`
+	Total           int             `json:"total"`
+	Processed       int             `json:"processed"`
+	Success         int             `json:"success"`
+	Failed          int             `json:"failed"`
+	Progress        float64         `json:"progress"`
+	CurrentItem     string          `gorm:"size:255" json:"current_item"`
+	OwnerID         uint            `gorm:"index" json:"owner_id"`
+	Attempts        int             `json:"attempts"`
+	MaxAttempts     int             `json:"max_attempts"`
+	CancelRequested bool            `json:"cancel_requested"`
+	RunAfter        time.Time       `gorm:"index" json:"run_after"`
+	LockedBy        string          `gorm:"size:100" json:"-"`
+	LockedUntil     *time.Time      `json:"-"`
+	StartedAt       *time.Time      `json:"started_at"`
+	FinishedAt      *time.Time      `json:"finished_at"`
+
+	Owner User `gorm:"foreignKey:OwnerID" json:"owner,omitempty"`
+}
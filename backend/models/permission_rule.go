@@ -14,6 +14,8 @@ const (
 
 	PermissionResourceProductionLine = "production_line"
 	PermissionResourceSystem         = "system"
+	PermissionResourceVehicleModel   = "vehicle_model"
+	PermissionResourceProgram        = "program"
 
 	PermissionDecisionAllow = "allow"
 	PermissionDecisionDeny  = "deny"
@@ -26,16 +28,24 @@ const (
 
 // PermissionRule stores one explicit allow/deny decision for one subject,
 // resource, and action. Missing rows are shown as "按规则" in the UI.
+// ValidFrom/ValidUntil bound temporary grants; nil means unbounded, and
+// rules outside their window are ignored when resolving permissions.
+// vehicle_model rules keep the model in ResourceID; ProductionLineID narrows
+// them to one line (combined line+model) and is 0 for every line. program
+// rules keep the program in ResourceID and override line and model rules.
 type PermissionRule struct {
-	ID           uint           `gorm:"primarykey" json:"id"`
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
-	SubjectType  string         `gorm:"size:40;not null;uniqueIndex:idx_permission_rule_scope" json:"subject_type"`
-	SubjectID    uint           `gorm:"not null;uniqueIndex:idx_permission_rule_scope" json:"subject_id"`
-	SubjectKey   string         `gorm:"size:100;not null;default:'';uniqueIndex:idx_permission_rule_scope" json:"subject_key"`
-	ResourceType string         `gorm:"size:40;not null;uniqueIndex:idx_permission_rule_scope" json:"resource_type"`
-	ResourceID   uint           `gorm:"not null;default:0;uniqueIndex:idx_permission_rule_scope" json:"resource_id"`
-	Action       string         `gorm:"size:80;not null;uniqueIndex:idx_permission_rule_scope" json:"action"`
-	Decision     string         `gorm:"size:20;not null" json:"decision"`
+	ID               uint           `gorm:"primarykey" json:"id"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
+	SubjectType      string         `gorm:"size:40;not null;uniqueIndex:idx_permission_rule_resource_scope" json:"subject_type"`
+	SubjectID        uint           `gorm:"not null;uniqueIndex:idx_permission_rule_resource_scope" json:"subject_id"`
+	SubjectKey       string         `gorm:"size:100;not null;default:'';uniqueIndex:idx_permission_rule_resource_scope" json:"subject_key"`
+	ResourceType     string         `gorm:"size:40;not null;uniqueIndex:idx_permission_rule_resource_scope" json:"resource_type"`
+	ResourceID       uint           `gorm:"not null;default:0;uniqueIndex:idx_permission_rule_resource_scope" json:"resource_id"`
+	ProductionLineID uint           `gorm:"not null;default:0;uniqueIndex:idx_permission_rule_resource_scope" json:"production_line_id,omitempty"`
+	Action           string         `gorm:"size:80;not null;uniqueIndex:idx_permission_rule_resource_scope" json:"action"`
+	Decision         string         `gorm:"size:20;not null" json:"decision"`
+	ValidFrom        *time.Time     `json:"valid_from,omitempty"`
+	ValidUntil       *time.Time     `gorm:"index" json:"valid_until,omitempty"`
 }
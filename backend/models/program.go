@@ -20,6 +20,7 @@ type Program struct {
 	Version          string              `gorm:"size:50" json:"version"`                    // 当前版本
 	Description      string              `gorm:"type:text" json:"description"`              // 描述
 	Status           string              `gorm:"size:20;default:in_progress" json:"status"` // 状态
+	StatusChangedAt  *time.Time          `json:"status_changed_at"`                         // 最近一次状态变更时间
 	MappingInfo      *ProgramMappingInfo `gorm:"-" json:"mapping_info,omitempty"`
 	OwnVersionCount  int64               `gorm:"-" json:"own_version_count"`
 	OwnFileCount     int64               `gorm:"-" json:"own_file_count"`
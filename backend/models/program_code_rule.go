@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// 程序编号唯一性范围。
+const (
+	ProgramCodeScopeNone      = "none"       // 不校验
+	ProgramCodeScopeLine      = "line"       // 同一生产线内唯一
+	ProgramCodeScopeLineModel = "line_model" // 同一生产线 + 车型内唯一
+	ProgramCodeScopeGlobal    = "global"     // 全局唯一
+)
+
+// ProgramCodeRule 是生产线级程序编号规则。
+// Pattern 为空表示不自动生成编号；UniqueScope 同时约束手工填写和自动生成的编号。
+type ProgramCodeRule struct {
+	ID               uint      `gorm:"primarykey" json:"id"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	ProductionLineID uint      `gorm:"not null;uniqueIndex" json:"production_line_id"`
+	Pattern          string    `gorm:"size:200" json:"pattern"`                  // 编号模板，如 {line}-{model}-{seq:4}
+	UniqueScope      string    `gorm:"size:20;default:line" json:"unique_scope"` // 唯一性范围
+}
+
+// ProgramCodeSequence 保存编号模板渲染出的前缀对应的下一个流水号。
+// 同一模板在不同车型、日期下会得到不同前缀，各自独立计数。
+type ProgramCodeSequence struct {
+	ID               uint      `gorm:"primarykey" json:"id"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	ProductionLineID uint      `gorm:"not null;uniqueIndex:idx_program_code_sequences_line_prefix" json:"production_line_id"`
+	Prefix           string    `gorm:"size:200;not null;uniqueIndex:idx_program_code_sequences_line_prefix" json:"prefix"`
+	NextValue        int64     `gorm:"not null;default:1" json:"next_value"`
+}
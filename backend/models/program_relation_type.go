@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// ProgramRelationType 是管理员维护的程序关联类型。
+// Directional=true 时关联有方向（如调用子程序），参与依赖图的环检测；否则为对称关系（如共用工装）。
+type ProgramRelationType struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Code        string    `gorm:"size:50;not null;uniqueIndex" json:"code"` // 类型编码，写入 ProgramRelation.RelationType
+	Name        string    `gorm:"size:100;not null" json:"name"`            // 正向名称，如"调用子程序"
+	InverseName string    `gorm:"size:100" json:"inverse_name"`             // 反向名称，如"被调用"；对称类型可留空
+	Directional bool      `gorm:"default:false" json:"directional"`
+	Description string    `gorm:"type:text" json:"description"`
+	SortOrder   int       `gorm:"default:0" json:"sort_order"`
+
+	Builtin bool `gorm:"-" json:"builtin"` // 内置类型不落库，不可修改或删除
+}
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// ProgramStatusDefinition 是产线级程序状态集合中的一项。
+// 产线没有配置任何状态时使用内置默认状态集，Code 与 Program.Status 对应。
+type ProgramStatusDefinition struct {
+	ID                uint      `gorm:"primarykey" json:"id"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+	ProductionLineID  uint      `gorm:"not null;uniqueIndex:idx_program_status_definitions_line_code" json:"production_line_id"`
+	Code              string    `gorm:"size:20;not null;uniqueIndex:idx_program_status_definitions_line_code" json:"code"` // 状态编码
+	Name              string    `gorm:"size:50;not null" json:"name"`                                                      // 显示名称
+	SortOrder         int       `gorm:"default:0" json:"sort_order"`
+	IsInitial         bool      `gorm:"default:false" json:"is_initial"`          // 新建程序未指定状态时使用
+	CountsAsCompleted bool      `gorm:"default:false" json:"counts_as_completed"` // 统计完成率时计为已完成
+}
+
+// ProgramStatusTransition 描述产线内允许的状态流转。
+// 产线未配置任何流转时，状态集合内可任意切换；RequiredRoles 为空表示具备产线管理权限即可。
+type ProgramStatusTransition struct {
+	ID               uint      `gorm:"primarykey" json:"id"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	ProductionLineID uint      `gorm:"not null;index" json:"production_line_id"`
+	FromStatus       string    `gorm:"size:20;not null" json:"from_status"`
+	ToStatus         string    `gorm:"size:20;not null" json:"to_status"`
+	RequiredRoles    string    `gorm:"size:255" json:"required_roles"` // 逗号分隔的角色编码
+}
+
+// ProgramStatusHistory 记录程序每次状态变更的时间和操作人。
+type ProgramStatusHistory struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+	ProgramID  uint      `gorm:"not null;index" json:"program_id"`
+	FromStatus string    `gorm:"size:20" json:"from_status"`
+	ToStatus   string    `gorm:"size:20;not null" json:"to_status"`
+	ChangedBy  uint      `gorm:"index" json:"changed_by"`
+
+	Operator User `gorm:"foreignKey:ChangedBy" json:"operator,omitempty"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+const (
+	ReportDeliveryDirectory = "directory"
+	ReportDeliveryEmail     = "email"
+	ReportDeliveryWebhook   = "webhook"
+)
+
+// ReportSchedule 按 cron 表达式定时执行导出并投递结果。
+// 导出以创建人身份执行，产线可见范围随创建人权限变化。
+type ReportSchedule struct {
+	ID               uint       `gorm:"primarykey" json:"id"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	Name             string     `gorm:"size:100;not null" json:"name"`
+	OwnerID          uint       `gorm:"not null;index" json:"owner_id"`
+	ExportTemplateID *uint      `gorm:"index" json:"export_template_id"`             // 为空时使用默认列
+	Format           string     `gorm:"size:10;not null;default:xlsx" json:"format"` // xlsx / csv / jsonl
+	IncludeStats     bool       `gorm:"default:false" json:"include_stats"`          // 仅 xlsx，附带完成率统计 sheet
+	CronExpr         string     `gorm:"size:100;not null" json:"cron_expr"`
+	DeliveryType     string     `gorm:"size:20;not null" json:"delivery_type"`
+	DeliveryTarget   string     `gorm:"size:1000" json:"delivery_target"` // 目录为 REPORTS_DIR 下的子目录；邮件为逗号分隔的收件人；webhook 为 URL
+	MaxRetries       int        `json:"max_retries"`
+	Enabled          bool       `json:"enabled"`
+	NextRunAt        *time.Time `gorm:"index" json:"next_run_at"`
+	LastRunAt        *time.Time `json:"last_run_at"`
+	LastStatus       string     `gorm:"size:20" json:"last_status"`
+
+	Owner          User            `gorm:"foreignKey:OwnerID" json:"owner,omitempty"`
+	ExportTemplate *ExportTemplate `gorm:"foreignKey:ExportTemplateID" json:"export_template,omitempty"`
+}
+
+// ReportRun 记录一次报表执行；失败后按退避时间生成下一次重试。
+type ReportRun struct {
+	ID          uint       `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time  `gorm:"index" json:"created_at"`
+	ScheduleID  uint       `gorm:"not null;index" json:"schedule_id"`
+	Trigger     string     `gorm:"size:20;not null" json:"trigger"` // schedule / manual / retry
+	Attempt     int        `gorm:"default:1" json:"attempt"`
+	Status      string     `gorm:"size:20;not null;index" json:"status"` // running / success / failed
+	StartedAt   *time.Time `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	FileName    string     `gorm:"size:255" json:"file_name"`
+	FileSize    int64      `json:"file_size"`
+	Error       string     `gorm:"type:text" json:"error"`
+	NextRetryAt *time.Time `gorm:"index" json:"next_retry_at"` // 待重试时非空，重试开始后清空
+}
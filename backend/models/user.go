@@ -21,6 +21,7 @@ type User struct {
 	RoleID       *uint          `gorm:"index" json:"role_id"`
 	Password     string         `gorm:"size:255;not null" json:"-"`
 	Status       string         `gorm:"size:20;default:active" json:"status"`
+	AccountType  string         `gorm:"size:20;not null;default:human" json:"account_type"` // human / service
 
 	Department *Department `json:"department,omitempty"`
 	RoleRef    *Role       `gorm:"foreignKey:RoleID" json:"role_ref,omitempty"`
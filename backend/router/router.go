@@ -15,6 +15,9 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// requestTimeout 是普通接口的处理时限，进度推送等长连接不受此限制。
+var requestTimeout = 30 * time.Second
+
 func SetupRouter() *gin.Engine {
 	r := gin.Default()
 	r.MaxMultipartMemory = 10 << 20 // 10MB 默认上传限制
@@ -22,15 +25,17 @@ func SetupRouter() *gin.Engine {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     config.AppConfig.CORS.AllowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Request-ID"},
+		ExposeHeaders:    []string{"Content-Length", "X-Request-ID"},
 		AllowCredentials: true,
 	}))
 
 	// 全局限流：每 IP 每秒 100 请求
 	r.Use(middleware.RateLimiter(100, 200))
+	r.Use(middleware.RequestID())
 
 	public := r.Group("/api")
+	public.Use(middleware.AuditLog())
 	{
 		public.GET("/health", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{"status": "ok"})
@@ -40,9 +45,20 @@ func SetupRouter() *gin.Engine {
 		public.POST("/logout", controllers.Logout)
 	}
 
+	// SSE 进度流会长时间保持连接，只做认证和审计，不挂请求超时
+	streams := r.Group("/api")
+	streams.Use(middleware.AuthMiddleware())
+	streams.Use(middleware.AuditLog())
+	{
+		streams.GET("/jobs/events", controllers.StreamJobs)
+		streams.GET("/jobs/:id/events", controllers.StreamJob)
+	}
+
 	protected := r.Group("/api")
 	protected.Use(middleware.AuthMiddleware())
-	protected.Use(middleware.RequestTimeout(30 * time.Second))
+	// 审计在认证之后记录操作人，包括被权限校验拒绝的请求
+	protected.Use(middleware.AuditLog())
+	protected.Use(middleware.RequestTimeout(requestTimeout))
 	{
 		users := protected.Group("/users")
 		{
@@ -55,6 +71,23 @@ func SetupRouter() *gin.Engine {
 			users.PUT("/:id/reset-password", middleware.RequirePermission("op:password_reset"), controllers.ResetPassword)
 		}
 
+		// 个人访问令牌：只管理本人的令牌
+		apiTokens := protected.Group("/api-tokens")
+		{
+			apiTokens.GET("", controllers.ListMyAPITokens)
+			apiTokens.POST("", controllers.CreateMyAPIToken)
+			apiTokens.DELETE("/:id", controllers.RevokeMyAPIToken)
+		}
+
+		serviceAccounts := protected.Group("/service-accounts")
+		{
+			serviceAccounts.GET("", middleware.RequirePermission("page:user_management"), controllers.ListServiceAccounts)
+			serviceAccounts.POST("", middleware.RequirePermission("op:user_create"), controllers.CreateServiceAccount)
+			serviceAccounts.GET("/:id/tokens", middleware.RequirePermission("page:user_management"), controllers.ListServiceAccountTokens)
+			serviceAccounts.POST("/:id/tokens", middleware.RequirePermission("op:user_edit"), controllers.CreateServiceAccountToken)
+			serviceAccounts.DELETE("/:id/tokens/:token_id", middleware.RequirePermission("op:user_edit"), controllers.RevokeServiceAccountToken)
+		}
+
 		lines := protected.Group("/production-lines")
 		{
 			lines.GET("", controllers.GetProductionLines)
@@ -66,6 +99,11 @@ func SetupRouter() *gin.Engine {
 			lines.POST("/:id/custom-fields", middleware.RequirePermission("page:production_lines"), controllers.CreateProductionLineCustomField)
 			lines.PUT("/:id/custom-fields/:fieldId", middleware.RequirePermission("page:production_lines"), controllers.UpdateProductionLineCustomField)
 			lines.DELETE("/:id/custom-fields/:fieldId", middleware.RequirePermission("page:production_lines"), controllers.DeleteProductionLineCustomField)
+			lines.GET("/:id/program-statuses", controllers.GetProductionLineProgramStatuses)
+			lines.PUT("/:id/program-statuses", middleware.RequirePermission("page:production_lines"), controllers.SaveProductionLineProgramStatuses)
+			lines.GET("/:id/program-code-rule", controllers.GetProgramCodeRule)
+			lines.PUT("/:id/program-code-rule", middleware.RequirePermission("page:production_lines"), controllers.SaveProgramCodeRule)
+			lines.GET("/:id/program-code-rule/preview", controllers.PreviewProgramCode)
 		}
 
 		processes := protected.Group("/processes")
@@ -89,12 +127,25 @@ func SetupRouter() *gin.Engine {
 		programs := protected.Group("/programs")
 		{
 			programs.GET("", controllers.GetPrograms)
+			programs.GET("/code-duplicates", controllers.GetProgramCodeDuplicates)
 			programs.GET("/export/columns", middleware.RequirePermission("op:program_export"), controllers.GetExportColumns)
 			programs.GET("/export/preview", middleware.RequirePermission("op:program_export"), controllers.ExportPreview)
 			programs.GET("/export/stats", middleware.RequirePermission("op:program_export"), controllers.ExportStats)
 			programs.GET("/export/excel", middleware.RequirePermission("op:program_export"), controllers.ExportProgramsExcelDynamic)
+			programs.GET("/export/csv", middleware.RequirePermission("op:program_export"), controllers.ExportProgramsCSV)
+			programs.GET("/export/jsonl", middleware.RequirePermission("op:program_export"), controllers.ExportProgramsJSONL)
+			programs.GET("/export/templates", middleware.RequirePermission("op:program_export"), controllers.GetExportTemplates)
+			programs.POST("/export/templates", middleware.RequirePermission("op:program_export"), controllers.CreateExportTemplate)
+			programs.PUT("/export/templates/:id", middleware.RequirePermission("op:program_export"), controllers.UpdateExportTemplate)
+			programs.DELETE("/export/templates/:id", middleware.RequirePermission("op:program_export"), controllers.DeleteExportTemplate)
 			programs.GET("/:id", controllers.GetProgram)
+			programs.GET("/:id/status-history", controllers.GetProgramStatusHistory)
+			programs.GET("/:id/graph", controllers.GetProgramGraph)
+			programs.GET("/:id/impact", controllers.GetProgramImpact)
 			programs.POST("", middleware.RequirePermission("op:program_create"), controllers.CreateProgram)
+			programs.POST("/bulk-update", middleware.RequirePermission("op:program_edit"), controllers.BulkUpdatePrograms)
+			programs.POST("/excel-import/preview", middleware.RequirePermission("op:program_edit"), controllers.PreviewProgramExcelImport)
+			programs.POST("/excel-import", middleware.RequirePermission("op:program_edit"), controllers.ApplyProgramExcelImport)
 			programs.PUT("/:id", middleware.RequirePermission("op:program_edit"), controllers.UpdateProgram)
 			programs.PUT("/:id/custom-field-values", controllers.SaveProgramCustomFieldValues)
 			programs.DELETE("/:id", middleware.RequirePermission("op:program_delete"), controllers.DeleteProgram)
@@ -119,16 +170,26 @@ func SetupRouter() *gin.Engine {
 			permissions.DELETE("/:id", middleware.RequirePermission("page:permissions"), controllers.DeletePermission)
 			permissions.GET("/user/:user_id/matrix", middleware.RequirePermission("page:permissions"), controllers.GetUserPermissionMatrix)
 			permissions.PUT("/user/:user_id/matrix", middleware.RequirePermission("page:permissions"), controllers.SaveUserPermissionMatrix)
+			permissions.GET("/explain", controllers.ExplainPermission)
+			permissions.GET("/rules/expiring", middleware.RequirePermission("page:permissions"), controllers.ListExpiringPermissionGrants)
+			permissions.GET("/program-overrides", middleware.RequirePermission("page:permissions"), controllers.ListProgramPermissionOverrides)
+			permissions.GET("/config/export", middleware.RequirePermission("page:permissions"), controllers.ExportPermissionConfig)
+			permissions.POST("/config/import/preview", middleware.RequirePermission("page:permissions"), controllers.PreviewPermissionConfigImport)
+			permissions.POST("/config/import", middleware.RequirePermission("page:permissions"), controllers.ApplyPermissionConfigImport)
 			permissions.GET("/user/:user_id", controllers.GetUserPermissions)
 			permissions.GET("/user/:user_id/effective", controllers.GetUserEffectivePermissions)
 			permissions.GET("/users/:id/effective-matrix", middleware.RequirePermission("page:permissions"), controllers.GetUserEffectivePermissionMatrix)
 			permissions.PUT("/users/:id/rules", middleware.RequirePermission("page:permissions"), controllers.SaveUserPermissionRules)
+			permissions.POST("/users/:id/rules/simulate", middleware.RequirePermission("page:permissions"), controllers.SimulateUserPermissionRules)
 			permissions.GET("/departments/:id/effective-matrix", middleware.RequirePermission("page:permissions"), controllers.GetDepartmentEffectivePermissionMatrix)
 			permissions.PUT("/departments/:id/rules", middleware.RequirePermission("page:permissions"), controllers.SaveDepartmentPermissionRules)
+			permissions.POST("/departments/:id/rules/simulate", middleware.RequirePermission("page:permissions"), controllers.SimulateDepartmentPermissionRules)
 			permissions.GET("/roles/:role/effective-matrix", middleware.RequirePermission("page:permissions"), controllers.GetRoleEffectivePermissionMatrix)
 			permissions.PUT("/roles/:role/rules", middleware.RequirePermission("page:permissions"), controllers.SaveRolePermissionRules)
+			permissions.POST("/roles/:role/rules/simulate", middleware.RequirePermission("page:permissions"), controllers.SimulateRolePermissionRules)
 			permissions.GET("/departments/:id/default-matrix", middleware.RequirePermission("page:permissions"), controllers.GetDepartmentDefaultPermissionRuleMatrix)
 			permissions.PUT("/departments/:id/default-rules", middleware.RequirePermission("page:permissions"), controllers.SaveDepartmentDefaultPermissionRules)
+			permissions.POST("/departments/:id/default-rules/simulate", middleware.RequirePermission("page:permissions"), controllers.SimulateDepartmentDefaultPermissionRules)
 		}
 
 		deptPermissions := protected.Group("/department-permissions")
@@ -177,6 +238,15 @@ func SetupRouter() *gin.Engine {
 			lineAdmin.PUT("/lines/:id/permissions", controllers.SaveLinePermissionByAdmin)
 		}
 
+		accessRequests := protected.Group("/access-requests")
+		{
+			accessRequests.GET("", controllers.GetAccessRequests)
+			accessRequests.POST("", controllers.CreateAccessRequest)
+			accessRequests.POST("/:id/approve", controllers.ApproveAccessRequest)
+			accessRequests.POST("/:id/reject", controllers.RejectAccessRequest)
+			accessRequests.POST("/:id/cancel", controllers.CancelAccessRequest)
+		}
+
 		versions := protected.Group("/versions")
 		{
 			versions.GET("/program/:program_id", controllers.GetProgramVersions)
@@ -187,12 +257,49 @@ func SetupRouter() *gin.Engine {
 
 		programs.POST("/batch-upload", middleware.RequirePermission("op:program_create"), controllers.BatchUploadPrograms)
 		programs.POST("/batch-import", middleware.RequirePermission("op:program_create"), controllers.BatchImportPrograms)
+		programs.GET("/batch-imports", middleware.RequirePermission("op:program_create"), controllers.GetBatchImports)
+		programs.GET("/batch-imports/:id", middleware.RequirePermission("op:program_create"), controllers.GetBatchImport)
+		programs.POST("/batch-imports/:id/retry", middleware.RequirePermission("op:program_create"), controllers.RetryBatchImport)
+		programs.GET("/batch-imports/:id/results.csv", middleware.RequirePermission("op:program_create"), controllers.ExportBatchImportResults)
+
+		batchLayouts := protected.Group("/batch-import-layouts")
+		{
+			batchLayouts.GET("", controllers.GetBatchImportLayouts)
+			batchLayouts.POST("", middleware.RequirePermission("page:system_management"), controllers.CreateBatchImportLayout)
+			batchLayouts.PUT("/:id", middleware.RequirePermission("page:system_management"), controllers.UpdateBatchImportLayout)
+			batchLayouts.DELETE("/:id", middleware.RequirePermission("page:system_management"), controllers.DeleteBatchImportLayout)
+		}
+
+		importWatch := protected.Group("/import-watch-folders")
+		{
+			importWatch.GET("", middleware.RequirePermission("page:system_management"), controllers.GetImportWatchFolders)
+			importWatch.POST("", middleware.RequirePermission("page:system_management"), controllers.CreateImportWatchFolder)
+			importWatch.PUT("/:id", middleware.RequirePermission("page:system_management"), controllers.UpdateImportWatchFolder)
+			importWatch.DELETE("/:id", middleware.RequirePermission("page:system_management"), controllers.DeleteImportWatchFolder)
+			importWatch.POST("/:id/scan", middleware.RequirePermission("page:system_management"), controllers.ScanImportWatchFolder)
+			importWatch.GET("/:id/files", middleware.RequirePermission("page:system_management"), controllers.GetImportWatchFiles)
+		}
 
 		tasks := protected.Group("/tasks")
 		{
 			tasks.GET("/:task_id/status", controllers.GetTaskStatus)
 		}
 
+		auditLogs := protected.Group("/audit-logs")
+		auditLogs.Use(middleware.RequirePermission("page:system_management"))
+		{
+			auditLogs.GET("", controllers.GetAuditLogs)
+			auditLogs.GET("/export.csv", controllers.ExportAuditLogs)
+			auditLogs.GET("/verify", controllers.VerifyAuditLogs)
+		}
+
+		jobs := protected.Group("/jobs")
+		{
+			jobs.GET("", controllers.GetJobs)
+			jobs.GET("/:id", controllers.GetJob)
+			jobs.POST("/:id/cancel", controllers.CancelJob)
+		}
+
 		mappings := protected.Group("/program-mappings")
 		{
 			mappings.GET("/by-parent/:program_id", controllers.GetProgramMappingsByParent)
@@ -201,6 +308,31 @@ func SetupRouter() *gin.Engine {
 			mappings.DELETE("/:id", middleware.RequirePermission("op:program_delete"), controllers.DeleteProgramMapping)
 		}
 
+		reportSchedules := protected.Group("/report-schedules")
+		{
+			reportSchedules.GET("", middleware.RequirePermission("op:program_export"), controllers.GetReportSchedules)
+			reportSchedules.POST("", middleware.RequirePermission("op:program_export"), controllers.CreateReportSchedule)
+			reportSchedules.PUT("/:id", middleware.RequirePermission("op:program_export"), controllers.UpdateReportSchedule)
+			reportSchedules.DELETE("/:id", middleware.RequirePermission("op:program_export"), controllers.DeleteReportSchedule)
+			reportSchedules.POST("/:id/run", middleware.RequirePermission("op:program_export"), controllers.RunReportSchedule)
+			reportSchedules.GET("/:id/runs", middleware.RequirePermission("op:program_export"), controllers.GetReportScheduleRuns)
+		}
+
+		relationTypes := protected.Group("/program-relation-types")
+		{
+			relationTypes.GET("", controllers.GetProgramRelationTypes)
+			relationTypes.POST("", middleware.RequirePermission("page:system_management"), controllers.CreateProgramRelationType)
+			relationTypes.PUT("/:id", middleware.RequirePermission("page:system_management"), controllers.UpdateProgramRelationType)
+			relationTypes.DELETE("/:id", middleware.RequirePermission("page:system_management"), controllers.DeleteProgramRelationType)
+		}
+
+		relations := protected.Group("/program-relations")
+		{
+			relations.GET("/program/:program_id", controllers.GetProgramRelations)
+			relations.POST("", middleware.RequirePermission("op:program_edit"), controllers.CreateRelation)
+			relations.DELETE("/:id", middleware.RequirePermission("op:program_edit"), controllers.DeleteRelation)
+		}
+
 		backup := protected.Group("/backup")
 		backup.Use(middleware.RequirePermission("op:backup_restore"))
 		{
@@ -225,9 +357,11 @@ func SetupRouter() *gin.Engine {
 		departments := protected.Group("/departments")
 		{
 			departments.GET("", middleware.RequireAnyPermission("page:user_management", "page:permissions", "page:system_management"), controllers.GetDepartments)
+			departments.GET("/tree", middleware.RequireAnyPermission("page:user_management", "page:permissions", "page:system_management"), controllers.GetDepartmentTree)
 			departments.GET("/:id", controllers.GetDepartment)
 			departments.POST("", middleware.RequirePermission("page:system_management"), controllers.CreateDepartment)
 			departments.PUT("/:id", middleware.RequirePermission("page:system_management"), controllers.UpdateDepartment)
+			departments.PUT("/:id/move", middleware.RequirePermission("page:system_management"), controllers.MoveDepartment)
 			departments.DELETE("/:id", middleware.RequirePermission("page:system_management"), controllers.DeleteDepartment)
 		}
 	}
@@ -2,11 +2,23 @@ package router
 
 import (
 	"crane-system/config"
+	"crane-system/database"
+	"crane-system/middleware"
+	"crane-system/models"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 func TestSetupRouterServesFrontendIndexForSPARoutes(t *testing.T) {
@@ -86,3 +98,90 @@ func TestSetupRouterKeepsUnknownAPIRoutesAs404(t *testing.T) {
 		t.Fatalf("expected status 404, got %d body=%s", resp.Code, resp.Body.String())
 	}
 }
+
+func TestSetupRouterKeepsJobStreamsOpenPastRequestTimeout(t *testing.T) {
+	ginMode := os.Getenv("GIN_MODE")
+	t.Cleanup(func() {
+		if ginMode == "" {
+			os.Unsetenv("GIN_MODE")
+			return
+		}
+		os.Setenv("GIN_MODE", ginMode)
+	})
+	os.Setenv("GIN_MODE", "test")
+	originalTimeout := requestTimeout
+	requestTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { requestTimeout = originalTimeout })
+
+	config.AppConfig = &config.Config{
+		App:     config.AppSection{FrontendDist: t.TempDir()},
+		Storage: config.StorageSection{UploadsDir: t.TempDir()},
+		CORS:    config.CORSSection{AllowedOrigins: []string{"http://localhost:3000"}},
+		Auth: config.AuthSection{
+			JWTSecret:       "12345678901234567890123456789012",
+			DefaultPassword: "admin123456",
+		},
+	}
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "router.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open sqlite db: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Job{}); err != nil {
+		t.Fatalf("auto migrate test db: %v", err)
+	}
+	database.DB = db
+
+	user := models.User{Name: "Stream", Password: "hashed", EmployeeID: "EMP-STREAM", Role: "user", Status: "active"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	job := models.Job{Type: "batch_import", Status: "running", OwnerID: user.ID, Total: 2, Processed: 1, RunAfter: time.Now()}
+	if err := db.Create(&job).Error; err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &middleware.Claims{
+		UserID: user.ID,
+		Role:   user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}).SignedString([]byte(config.AppConfig.Auth.JWTSecret))
+	if err != nil {
+		t.Fatalf("sign jwt: %v", err)
+	}
+
+	server := httptest.NewServer(SetupRouter())
+	defer server.Close()
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/jobs/%d/events", server.URL, job.ID), nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	// 超过请求超时后任务才结束，流仍应推送 done 事件
+	time.Sleep(3 * requestTimeout)
+	if err := db.Model(&models.Job{}).Where("id = ?", job.ID).Updates(map[string]any{"status": "completed", "processed": 2}).Error; err != nil {
+		t.Fatalf("finish job: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read stream: %v", err)
+	}
+	if !strings.Contains(string(body), "event:done") {
+		t.Fatalf("stream ended without done event: %s", body)
+	}
+	if strings.Contains(string(body), "请求超时") {
+		t.Fatalf("stream hit request timeout: %s", body)
+	}
+}
@@ -0,0 +1,326 @@
+package services
+
+import (
+	"crane-system/database"
+	"crane-system/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APITokenPrefix 是 API 令牌明文的固定前缀，认证中间件据此区分 API 令牌和 JWT。
+const APITokenPrefix = "crn_"
+
+// apiTokenTouchInterval 内重复使用令牌不再更新最近使用时间，避免每个请求都写库。
+var apiTokenTouchInterval = time.Minute
+
+var (
+	ErrAPITokenInvalid = errors.New("无效的令牌")
+	ErrAPITokenExpired = errors.New("令牌已过期")
+	ErrAPITokenRevoked = errors.New("令牌已被吊销")
+)
+
+// APITokenInput 是创建令牌时请求的范围。
+type APITokenInput struct {
+	Name              string     `json:"name"`
+	FunctionCodes     []string   `json:"function_codes"`
+	LineActions       []string   `json:"line_actions"`
+	ProductionLineIDs []uint     `json:"production_line_ids"`
+	ExpiresAt         *time.Time `json:"expires_at"`
+}
+
+// HashAPIToken 返回令牌明文的 SHA-256 十六进制哈希。令牌本身是高熵随机串，不需要加盐慢哈希。
+func HashAPIToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAPIToken() (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return APITokenPrefix + hex.EncodeToString(raw), nil
+}
+
+// CreateAPIToken 为 owner 创建令牌并返回明文，明文只在创建时返回一次。
+// 范围必须是 owner 当前权限的子集：功能权限码为 owner 拥有的码，产线动作至少在一条范围内产线上可用。
+func CreateAPIToken(owner models.User, input APITokenInput, createdBy uint, now time.Time) (models.APIToken, string, error) {
+	token := models.APIToken{
+		UserID:            owner.ID,
+		Name:              strings.TrimSpace(input.Name),
+		FunctionCodes:     normalizeScopeStrings(input.FunctionCodes),
+		LineActions:       normalizeScopeStrings(input.LineActions),
+		ProductionLineIDs: normalizeScopeIDs(input.ProductionLineIDs),
+		ExpiresAt:         input.ExpiresAt,
+		CreatedBy:         createdBy,
+	}
+	if token.Name == "" {
+		return token, "", errors.New("name is required")
+	}
+	if token.ExpiresAt != nil && !token.ExpiresAt.After(now) {
+		return token, "", errors.New("expires_at must be in the future")
+	}
+	if len(token.FunctionCodes) == 0 && len(token.LineActions) == 0 {
+		return token, "", errors.New("token scope is empty")
+	}
+	if err := validateAPITokenScope(owner, token); err != nil {
+		return token, "", err
+	}
+
+	plain, err := generateAPIToken()
+	if err != nil {
+		return token, "", err
+	}
+	token.TokenPrefix = plain[:len(APITokenPrefix)+8]
+	token.TokenHash = HashAPIToken(plain)
+	if err := database.DB.Create(&token).Error; err != nil {
+		return token, "", err
+	}
+	return token, plain, nil
+}
+
+func validateAPITokenScope(owner models.User, token models.APIToken) error {
+	admin := IsSystemAdminRole(owner.Role)
+	perms, err := GetUserPermissions(owner.ID)
+	if err != nil {
+		return err
+	}
+
+	ownedCodes := map[string]bool{}
+	for _, code := range perms.FunctionCodes {
+		ownedCodes[code] = true
+	}
+	if len(token.FunctionCodes) > 0 {
+		var count int64
+		if err := database.DB.Model(&models.Permission{}).Where("code IN ?", token.FunctionCodes).Count(&count).Error; err != nil {
+			return err
+		}
+		if int(count) != len(token.FunctionCodes) {
+			return errors.New("invalid function code")
+		}
+	}
+	for _, code := range token.FunctionCodes {
+		if !admin && !ownedCodes[code] {
+			return errors.New("function code " + code + " is not granted to the token owner")
+		}
+	}
+
+	lineIDs := token.ProductionLineIDs
+	if len(lineIDs) > 0 {
+		var count int64
+		if err := database.DB.Model(&models.ProductionLine{}).Where("id IN ?", lineIDs).Count(&count).Error; err != nil {
+			return err
+		}
+		if int(count) != len(lineIDs) {
+			return errors.New("invalid production line")
+		}
+	} else {
+		if err := database.DB.Model(&models.ProductionLine{}).Order("id ASC").Pluck("id", &lineIDs).Error; err != nil {
+			return err
+		}
+	}
+	for _, action := range token.LineActions {
+		if !actionValid(action) {
+			return errors.New("invalid line action")
+		}
+		if admin {
+			continue
+		}
+		granted := false
+		for _, lineID := range lineIDs {
+			if CheckLineAction(owner.ID, owner.Role, lineID, LineAction(action)).Allowed {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			return errors.New("line action " + action + " is not granted to the token owner")
+		}
+	}
+	return nil
+}
+
+// AuthenticateAPIToken 校验令牌明文，返回令牌及其所有者，并按间隔更新最近使用时间和来源 IP。
+func AuthenticateAPIToken(plain string, now time.Time, clientIP string) (models.APIToken, models.User, error) {
+	var token models.APIToken
+	if err := database.DB.Where("token_hash = ?", HashAPIToken(plain)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return token, models.User{}, ErrAPITokenInvalid
+		}
+		return token, models.User{}, err
+	}
+	if token.RevokedAt != nil {
+		return token, models.User{}, ErrAPITokenRevoked
+	}
+	if token.ExpiresAt != nil && !token.ExpiresAt.After(now) {
+		return token, models.User{}, ErrAPITokenExpired
+	}
+	var user models.User
+	if err := database.DB.First(&user, token.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return token, user, ErrAPITokenInvalid
+		}
+		return token, user, err
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= apiTokenTouchInterval || token.LastUsedIP != clientIP {
+		if err := database.DB.Model(&models.APIToken{}).Where("id = ?", token.ID).
+			Updates(map[string]any{"last_used_at": now, "last_used_ip": clientIP}).Error; err != nil {
+			return token, user, err
+		}
+		token.LastUsedAt = &now
+		token.LastUsedIP = clientIP
+	}
+	return token, user, nil
+}
+
+// RevokeAPIToken 吊销令牌，已吊销的令牌保持原吊销时间。
+func RevokeAPIToken(tokenID uint, now time.Time) error {
+	return database.DB.Model(&models.APIToken{}).Where("id = ? AND revoked_at IS NULL", tokenID).Update("revoked_at", now).Error
+}
+
+// ListAPITokens 返回用户的全部令牌（含已吊销和已过期的），按创建时间倒序。
+func ListAPITokens(userID uint) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	err := database.DB.Where("user_id = ?", userID).Order("id DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// APITokenScope 是请求所用令牌的范围，nil 表示请求不是通过 API 令牌认证的，不额外限制。
+type APITokenScope struct {
+	functionCodes map[string]struct{}
+	lineActions   map[string]struct{}
+	lineIDs       map[uint]struct{}
+}
+
+func NewAPITokenScope(token models.APIToken) *APITokenScope {
+	scope := &APITokenScope{functionCodes: map[string]struct{}{}, lineActions: map[string]struct{}{}}
+	for _, code := range token.FunctionCodes {
+		scope.functionCodes[code] = struct{}{}
+	}
+	for _, action := range token.LineActions {
+		scope.lineActions[action] = struct{}{}
+	}
+	if len(token.ProductionLineIDs) > 0 {
+		scope.lineIDs = map[uint]struct{}{}
+		for _, lineID := range token.ProductionLineIDs {
+			scope.lineIDs[lineID] = struct{}{}
+		}
+	}
+	return scope
+}
+
+// AllowsFunction 判断令牌范围是否包含功能权限码。
+func (s *APITokenScope) AllowsFunction(code string) bool {
+	if s == nil {
+		return true
+	}
+	_, ok := s.functionCodes[code]
+	return ok
+}
+
+// AllowsAction 判断令牌范围是否包含产线动作，不考虑产线。
+func (s *APITokenScope) AllowsAction(action LineAction) bool {
+	if s == nil {
+		return true
+	}
+	_, canView := s.lineActions[models.PermissionActionView]
+	_, canDownload := s.lineActions[models.PermissionActionDownload]
+	_, canUpload := s.lineActions[models.PermissionActionUpload]
+	_, canManage := s.lineActions[models.PermissionActionManage]
+	return LinePermissionAllowsAction(canView, canDownload, canUpload, canManage, action)
+}
+
+// AllowsLineAction 判断令牌范围是否包含某产线上的动作。
+func (s *APITokenScope) AllowsLineAction(lineID uint, action LineAction) bool {
+	if s == nil {
+		return true
+	}
+	if !s.AllowsAction(action) {
+		return false
+	}
+	if s.lineIDs == nil {
+		return true
+	}
+	_, ok := s.lineIDs[lineID]
+	return ok
+}
+
+// RestrictLineIDs 把用户可访问的产线集合收窄到令牌范围；lineIDs 为 nil 表示用户不受产线限制。
+func (s *APITokenScope) RestrictLineIDs(lineIDs map[uint]struct{}, action LineAction) map[uint]struct{} {
+	if s == nil {
+		return lineIDs
+	}
+	if !s.AllowsAction(action) {
+		return map[uint]struct{}{}
+	}
+	return intersectLineIDs(lineIDs, s.lineIDs)
+}
+
+// RestrictProgramScope 把用户的程序范围收窄到令牌范围。
+func (s *APITokenScope) RestrictProgramScope(scope *ProgramScope, action LineAction) *ProgramScope {
+	if s == nil {
+		return scope
+	}
+	if !s.AllowsAction(action) {
+		return &ProgramScope{LineIDs: map[uint]struct{}{}}
+	}
+	if s.lineIDs == nil {
+		return scope
+	}
+	return scope.RestrictLines(s.lineIDs)
+}
+
+// intersectLineIDs 求两个产线集合的交集，nil 表示不限。
+func intersectLineIDs(a, b map[uint]struct{}) map[uint]struct{} {
+	if b == nil {
+		return a
+	}
+	result := map[uint]struct{}{}
+	for lineID := range b {
+		if a == nil {
+			result[lineID] = struct{}{}
+			continue
+		}
+		if _, ok := a[lineID]; ok {
+			result[lineID] = struct{}{}
+		}
+	}
+	return result
+}
+
+func normalizeScopeStrings(values []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+		result = append(result, value)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func normalizeScopeIDs(values []uint) []uint {
+	seen := map[uint]bool{}
+	result := []uint{}
+	for _, value := range values {
+		if value == 0 || seen[value] {
+			continue
+		}
+		seen[value] = true
+		result = append(result, value)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
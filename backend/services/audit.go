@@ -0,0 +1,167 @@
+package services
+
+import (
+	"crane-system/database"
+	"crane-system/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// AuditChangeKey 是处理函数在 gin.Context 中登记审计快照使用的键。
+const AuditChangeKey = "audit_change"
+
+// AuditChange 由处理函数提供，覆盖审计中间件按路由推断的资源信息。
+type AuditChange struct {
+	ResourceType string
+	ResourceID   string
+	Before       any
+	After        any
+}
+
+const auditAppendAttempts = 5
+
+// auditAppendMu 串行化本实例的写入；多实例并发时依赖 sequence 唯一索引冲突后重试。
+var auditAppendMu sync.Mutex
+
+// auditHashInput 固定参与哈希的字段及顺序。
+type auditHashInput struct {
+	Sequence     uint64 `json:"sequence"`
+	CreatedAt    string `json:"created_at"`
+	ActorID      uint   `json:"actor_id"`
+	ActorName    string `json:"actor_name"`
+	Action       string `json:"action"`
+	Method       string `json:"method"`
+	Path         string `json:"path"`
+	ResourceType string `json:"resource_type"`
+	ResourceID   string `json:"resource_id"`
+	StatusCode   int    `json:"status_code"`
+	Before       string `json:"before"`
+	After        string `json:"after"`
+	IP           string `json:"ip"`
+	RequestID    string `json:"request_id"`
+	PrevHash     string `json:"prev_hash"`
+}
+
+// AuditLogHash 计算审计记录的链式哈希。
+func AuditLogHash(entry models.AuditLog) string {
+	raw, _ := json.Marshal(auditHashInput{
+		Sequence:     entry.Sequence,
+		CreatedAt:    entry.CreatedAt.UTC().Format(time.RFC3339),
+		ActorID:      entry.ActorID,
+		ActorName:    entry.ActorName,
+		Action:       entry.Action,
+		Method:       entry.Method,
+		Path:         entry.Path,
+		ResourceType: entry.ResourceType,
+		ResourceID:   entry.ResourceID,
+		StatusCode:   entry.StatusCode,
+		Before:       string(entry.Before),
+		After:        string(entry.After),
+		IP:           entry.IP,
+		RequestID:    entry.RequestID,
+		PrevHash:     entry.PrevHash,
+	})
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// AppendAuditLog 把记录接到审计链末尾。时间精确到秒，保证数据库往返后哈希不变。
+func AppendAuditLog(entry models.AuditLog) (models.AuditLog, error) {
+	auditAppendMu.Lock()
+	defer auditAppendMu.Unlock()
+
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	entry.CreatedAt = entry.CreatedAt.Truncate(time.Second)
+	var err error
+	for attempt := 0; attempt < auditAppendAttempts; attempt++ {
+		var last models.AuditLog
+		result := database.DB.Select("sequence", "hash").Order("sequence DESC").Limit(1).Find(&last)
+		if result.Error != nil {
+			return entry, result.Error
+		}
+		entry.ID = 0
+		entry.Sequence = last.Sequence + 1
+		entry.PrevHash = last.Hash
+		entry.Hash = AuditLogHash(entry)
+		if err = database.DB.Create(&entry).Error; err == nil {
+			return entry, nil
+		}
+	}
+	return entry, err
+}
+
+// AuditChainReport 是审计链校验结果；BrokenSequence 为首个校验失败的序号。
+type AuditChainReport struct {
+	Valid          bool   `json:"valid"`
+	Checked        int    `json:"checked"`
+	LastSequence   uint64 `json:"last_sequence"`
+	BrokenSequence uint64 `json:"broken_sequence,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+const auditVerifyBatchSize = 500
+
+// VerifyAuditChain 按序号重新计算全部记录的哈希，发现篡改、删除或插入时停止。
+func VerifyAuditChain() (AuditChainReport, error) {
+	report := AuditChainReport{Valid: true}
+	prevHash := ""
+	var lastSequence uint64
+	for {
+		var entries []models.AuditLog
+		if err := database.DB.Where("sequence > ?", lastSequence).Order("sequence ASC").Limit(auditVerifyBatchSize).Find(&entries).Error; err != nil {
+			return report, err
+		}
+		for _, entry := range entries {
+			if reason := auditEntryProblem(entry, lastSequence, prevHash); reason != "" {
+				report.Valid = false
+				report.BrokenSequence = entry.Sequence
+				report.Reason = reason
+				return report, nil
+			}
+			report.Checked++
+			report.LastSequence = entry.Sequence
+			lastSequence = entry.Sequence
+			prevHash = entry.Hash
+		}
+		if len(entries) < auditVerifyBatchSize {
+			return report, nil
+		}
+	}
+}
+
+func auditEntryProblem(entry models.AuditLog, lastSequence uint64, prevHash string) string {
+	switch {
+	case entry.Sequence != lastSequence+1:
+		return "序号不连续，记录可能被删除"
+	case entry.PrevHash != prevHash:
+		return "前序哈希不匹配"
+	case entry.Hash != AuditLogHash(entry):
+		return "记录内容与哈希不匹配"
+	}
+	return ""
+}
+
+const auditSnapshotLimit = 64 << 10
+
+// MarshalAuditSnapshot 序列化快照，无法序列化时返回 nil，超过上限时只保留截断标记。
+func MarshalAuditSnapshot(value any) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	raw, ok := value.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(value); err != nil {
+			return nil
+		}
+	}
+	if len(raw) > auditSnapshotLimit {
+		return json.RawMessage(`{"truncated":true}`)
+	}
+	return raw
+}
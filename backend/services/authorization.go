@@ -61,6 +61,23 @@ func CheckLineAction(userID uint, role string, productionLineID uint, action Lin
 	return Allow()
 }
 
+// CheckProgramScopeAction 按程序、所在产线和车型判断权限，程序规则和车型规则可以在产线权限之上放行或拒绝。
+func CheckProgramScopeAction(userID uint, role string, programID, productionLineID, vehicleModelID uint, action LineAction) AuthDecision {
+	if IsSystemAdminRole(role) {
+		return Allow()
+	}
+	if userID == 0 {
+		return Deny(http.StatusUnauthorized, "未认证")
+	}
+	if role == "line_admin" && IsLineManager(userID, productionLineID) {
+		return Allow()
+	}
+	if !UserHasProgramScopePermission(userID, programID, productionLineID, vehicleModelID, string(action)) {
+		return Deny(http.StatusForbidden, "无权操作")
+	}
+	return Allow()
+}
+
 func ResolveAuthorizedLineIDs(userID uint, role string, action LineAction) (map[uint]struct{}, AuthDecision) {
 	if IsSystemAdminRole(role) {
 		return nil, Allow()
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"crane-system/models"
+	"errors"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDepartmentCycle          = errors.New("不能把部门移动到自身或其下级部门下")
+	ErrDepartmentParentNotFound = errors.New("上级部门不存在")
+)
+
+// DepartmentAncestors 返回部门及其全部上级部门 ID，自身在前、越往后越上级。
+// 数据中出现环时在回到已访问的部门处停止。
+func DepartmentAncestors(tx *gorm.DB, departmentID uint) ([]uint, error) {
+	chain := []uint{}
+	seen := map[uint]bool{}
+	for current := departmentID; current != 0 && !seen[current]; {
+		seen[current] = true
+		var department models.Department
+		if err := tx.Select("id", "parent_id").Where("id = ?", current).Limit(1).Find(&department).Error; err != nil {
+			return nil, err
+		}
+		if department.ID == 0 {
+			break
+		}
+		chain = append(chain, department.ID)
+		if department.ParentID == nil {
+			break
+		}
+		current = *department.ParentID
+	}
+	return chain, nil
+}
+
+// DepartmentDescendants 返回部门及其全部下级部门 ID，按 ID 排序。
+func DepartmentDescendants(tx *gorm.DB, departmentID uint) ([]uint, error) {
+	var departments []models.Department
+	if err := tx.Select("id", "parent_id").Find(&departments).Error; err != nil {
+		return nil, err
+	}
+	children := map[uint][]uint{}
+	for _, department := range departments {
+		if department.ParentID != nil {
+			children[*department.ParentID] = append(children[*department.ParentID], department.ID)
+		}
+	}
+	result := []uint{}
+	seen := map[uint]bool{}
+	queue := []uint{departmentID}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if seen[current] {
+			continue
+		}
+		seen[current] = true
+		result = append(result, current)
+		queue = append(queue, children[current]...)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result, nil
+}
+
+// BuildDepartmentTree 把部门列表组装为树，同级按 ID 排序；上级不在列表中的部门作为根节点。
+func BuildDepartmentTree(departments []models.Department) []models.Department {
+	byParent := map[uint][]models.Department{}
+	present := make(map[uint]bool, len(departments))
+	for _, department := range departments {
+		present[department.ID] = true
+	}
+	roots := []models.Department{}
+	for _, department := range departments {
+		if department.ParentID == nil || !present[*department.ParentID] || *department.ParentID == department.ID {
+			roots = append(roots, department)
+			continue
+		}
+		byParent[*department.ParentID] = append(byParent[*department.ParentID], department)
+	}
+	visited := map[uint]bool{}
+	var attach func(nodes []models.Department) []models.Department
+	attach = func(nodes []models.Department) []models.Department {
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+		result := make([]models.Department, 0, len(nodes))
+		for _, node := range nodes {
+			if visited[node.ID] {
+				continue
+			}
+			visited[node.ID] = true
+			node.Children = attach(byParent[node.ID])
+			result = append(result, node)
+		}
+		return result
+	}
+	return attach(roots)
+}
+
+// ValidateDepartmentParent 检查 parentID 可以作为 departmentID 的上级：上级必须存在，
+// 且不能是部门自身或其下级。departmentID 为 0 表示新建部门。
+func ValidateDepartmentParent(tx *gorm.DB, departmentID uint, parentID *uint) error {
+	if parentID == nil {
+		return nil
+	}
+	var count int64
+	if err := tx.Model(&models.Department{}).Where("id = ?", *parentID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrDepartmentParentNotFound
+	}
+	if departmentID == 0 {
+		return nil
+	}
+	ancestors, err := DepartmentAncestors(tx, *parentID)
+	if err != nil {
+		return err
+	}
+	for _, ancestorID := range ancestors {
+		if ancestorID == departmentID {
+			return ErrDepartmentCycle
+		}
+	}
+	return nil
+}
+
+// MoveDepartment 修改部门的上级，parentID 为 nil 时移动为顶级部门。
+func MoveDepartment(tx *gorm.DB, departmentID uint, parentID *uint) error {
+	if err := ValidateDepartmentParent(tx, departmentID, parentID); err != nil {
+		return err
+	}
+	return tx.Model(&models.Department{}).Where("id = ?", departmentID).Update("parent_id", parentID).Error
+}
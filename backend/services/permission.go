@@ -5,6 +5,7 @@ import (
 	"crane-system/models"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -24,8 +25,19 @@ var permissionActions = []string{
 type CachedPermissions struct {
 	FunctionCodes   []string
 	LinePermissions map[uint]LinePerm
-	ManagedLineIDs  []uint
-	ExpiresAt       time.Time
+	// ModelPermissions 按产线、车型记录存在车型规则时的权限，其余车型沿用 LinePermissions
+	ModelPermissions map[uint]map[uint]LinePerm
+	// ProgramPermissions 按程序记录存在程序规则时的权限
+	ProgramPermissions map[uint]ProgramPerm
+	ManagedLineIDs     []uint
+	ExpiresAt          time.Time
+}
+
+// ProgramPerm 附带程序所在产线和车型，用于计算程序范围。
+type ProgramPerm struct {
+	LinePerm
+	ProductionLineID uint
+	VehicleModelID   uint
 }
 
 type LinePerm struct {
@@ -44,20 +56,30 @@ type PermissionCell struct {
 	EffectiveLabel string `json:"effective_label"`
 	Source         string `json:"source"`
 	SourceLabel    string `json:"source_label"`
+	// ValidUntil 是本主体自身设置的到期时间，仅临时授权有值
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
 }
 
 type PermissionMatrixLine struct {
-	ResourceType string                    `json:"resource_type"`
-	ResourceID   uint                      `json:"resource_id"`
-	ResourceName string                    `json:"resource_name"`
-	Actions      map[string]PermissionCell `json:"actions"`
+	ResourceType string `json:"resource_type"`
+	ResourceID   uint   `json:"resource_id"`
+	ResourceName string `json:"resource_name"`
+	// 车型行的产线范围，0 表示所有产线
+	ProductionLineID   uint                      `json:"production_line_id,omitempty"`
+	ProductionLineName string                    `json:"production_line_name,omitempty"`
+	Actions            map[string]PermissionCell `json:"actions"`
 }
 
+// PermissionRuleChange 是一个单元格的变更；ValidFrom/ValidUntil 为空表示不限时。
+// 车型规则的 ProductionLineID 限定产线，为 0 时适用于所有产线。
 type PermissionRuleChange struct {
-	ResourceType string `json:"resource_type"`
-	ResourceID   uint   `json:"resource_id"`
-	Action       string `json:"action"`
-	Decision     string `json:"decision"`
+	ResourceType     string     `json:"resource_type"`
+	ResourceID       uint       `json:"resource_id"`
+	ProductionLineID uint       `json:"production_line_id,omitempty"`
+	Action           string     `json:"action"`
+	Decision         string     `json:"decision"`
+	ValidFrom        *time.Time `json:"valid_from,omitempty"`
+	ValidUntil       *time.Time `json:"valid_until,omitempty"`
 }
 
 type LinePermissionBits struct {
@@ -85,12 +107,38 @@ type ResolvedLinePermission struct {
 	CanManage        bool
 	Source           string
 	Cells            map[string]PermissionCell
+	// VehicleModels 是该产线上存在车型规则的车型，其余车型沿用产线权限
+	VehicleModels []ModelLinePermission
+	// Programs 是该产线上存在程序规则的程序
+	Programs []ProgramLinePermission
+}
+
+type ProgramLinePermission struct {
+	ProgramID      uint
+	VehicleModelID uint
+	CanView        bool
+	CanDownload    bool
+	CanUpload      bool
+	CanManage      bool
+	Source         string
+	Cells          map[string]PermissionCell
+}
+
+type ModelLinePermission struct {
+	VehicleModelID uint
+	CanView        bool
+	CanDownload    bool
+	CanUpload      bool
+	CanManage      bool
+	Source         string
+	Cells          map[string]PermissionCell
 }
 
 type rawDecision struct {
-	Decision string
-	Source   string
-	Rank     int
+	Decision   string
+	Source     string
+	Rank       int
+	ValidUntil *time.Time
 }
 
 var (
@@ -135,9 +183,30 @@ func ruleKey(resourceID uint, action string) string {
 	return fmt.Sprintf("%d:%s", resourceID, action)
 }
 
-func loadRuleMap(tx *gorm.DB, subjectType string, subjectID uint, subjectKey string, lineIDs []uint) (map[string]rawDecision, error) {
-	result := map[string]rawDecision{}
-	query := tx.Where("subject_type = ? AND resource_type = ? AND resource_id IN ?", subjectType, models.PermissionResourceProductionLine, lineIDs)
+// activePermissionRules 排除未生效和已到期的规则。
+func activePermissionRules(query *gorm.DB, now time.Time) *gorm.DB {
+	return query.Where("(valid_from IS NULL OR valid_from <= ?) AND (valid_until IS NULL OR valid_until > ?)", now, now)
+}
+
+// loadSubjectRules 查询主体在指定产线上当前有效的全部规则；带角色名时同时匹配按角色 ID 和按角色名保存的规则。
+func loadSubjectRules(tx *gorm.DB, subjectType string, subjectID uint, subjectKey string, lineIDs []uint) ([]models.PermissionRule, error) {
+	query := activePermissionRules(tx, time.Now()).Where("subject_type = ? AND resource_type = ? AND resource_id IN ?", subjectType, models.PermissionResourceProductionLine, lineIDs)
+	return findSubjectRules(query, subjectID, subjectKey)
+}
+
+// loadSubjectModelRules 查询主体适用于指定产线的车型规则，包括不限产线的规则。
+func loadSubjectModelRules(tx *gorm.DB, subjectType string, subjectID uint, subjectKey string, lineIDs []uint) ([]models.PermissionRule, error) {
+	query := activePermissionRules(tx, time.Now()).Where("subject_type = ? AND resource_type = ? AND (production_line_id = 0 OR production_line_id IN ?)", subjectType, models.PermissionResourceVehicleModel, lineIDs)
+	return findSubjectRules(query, subjectID, subjectKey)
+}
+
+// loadSubjectProgramRules 查询主体当前有效的全部程序规则。
+func loadSubjectProgramRules(tx *gorm.DB, subjectType string, subjectID uint, subjectKey string) ([]models.PermissionRule, error) {
+	query := activePermissionRules(tx, time.Now()).Where("subject_type = ? AND resource_type = ?", subjectType, models.PermissionResourceProgram)
+	return findSubjectRules(query, subjectID, subjectKey)
+}
+
+func findSubjectRules(query *gorm.DB, subjectID uint, subjectKey string) ([]models.PermissionRule, error) {
 	if strings.TrimSpace(subjectKey) != "" {
 		if subjectID > 0 {
 			query = query.Where("(subject_id = ? AND subject_key IN (?, '')) OR (subject_id = 0 AND subject_key = ?)", subjectID, subjectKey, subjectKey)
@@ -149,16 +218,64 @@ func loadRuleMap(tx *gorm.DB, subjectType string, subjectID uint, subjectKey str
 	}
 
 	var rules []models.PermissionRule
-	if err := query.Find(&rules).Error; err != nil {
+	err := query.Order("id ASC").Find(&rules).Error
+	return rules, err
+}
+
+func loadRuleMap(tx *gorm.DB, subjectType string, subjectID uint, subjectKey string, lineIDs []uint) (map[string]rawDecision, error) {
+	result := map[string]rawDecision{}
+	rules, err := loadSubjectRules(tx, subjectType, subjectID, subjectKey, lineIDs)
+	if err != nil {
 		return nil, err
 	}
 	for _, rule := range rules {
 		key := ruleKey(rule.ResourceID, rule.Action)
-		source := rule.SubjectType
-		if subjectType == models.PermissionSubjectRole && rule.SubjectID == 0 && strings.TrimSpace(rule.SubjectKey) != "" {
-			source = "role_default"
+		decision := subjectRuleDecision(rule, subjectType, subjectID, subjectKey)
+		if existing, ok := result[key]; !ok || decision.Rank > existing.Rank {
+			result[key] = decision
+		}
+	}
+	return result, nil
+}
+
+// modelRuleKey 标识一条车型规则的范围，LineID 为 0 表示所有产线。
+type modelRuleKey struct {
+	ModelID uint
+	LineID  uint
+	Action  string
+}
+
+func loadModelRuleMap(tx *gorm.DB, subjectType string, subjectID uint, subjectKey string, lineIDs []uint) (map[modelRuleKey]rawDecision, error) {
+	result := map[modelRuleKey]rawDecision{}
+	rules, err := loadSubjectModelRules(tx, subjectType, subjectID, subjectKey, lineIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		key := modelRuleKey{ModelID: rule.ResourceID, LineID: rule.ProductionLineID, Action: rule.Action}
+		decision := subjectRuleDecision(rule, subjectType, subjectID, subjectKey)
+		if existing, ok := result[key]; !ok || decision.Rank > existing.Rank {
+			result[key] = decision
 		}
-		decision := rawDecision{Decision: rule.Decision, Source: source, Rank: ruleScopeRank(rule, subjectID, subjectKey)}
+	}
+	return result, nil
+}
+
+// programRuleKey 标识一条程序规则。
+type programRuleKey struct {
+	ProgramID uint
+	Action    string
+}
+
+func loadProgramRuleMap(tx *gorm.DB, subjectType string, subjectID uint, subjectKey string) (map[programRuleKey]rawDecision, error) {
+	result := map[programRuleKey]rawDecision{}
+	rules, err := loadSubjectProgramRules(tx, subjectType, subjectID, subjectKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		key := programRuleKey{ProgramID: rule.ResourceID, Action: rule.Action}
+		decision := subjectRuleDecision(rule, subjectType, subjectID, subjectKey)
 		if existing, ok := result[key]; !ok || decision.Rank > existing.Rank {
 			result[key] = decision
 		}
@@ -166,6 +283,109 @@ func loadRuleMap(tx *gorm.DB, subjectType string, subjectID uint, subjectKey str
 	return result, nil
 }
 
+// loadDepartmentChainRules 按部门链（自身在前）合并部门或部门默认规则，
+// 同一单元格只取最近部门的决策；合并后的层仍按 组合 > 车型 > 产线、程序 > 车型 > 产线 取最具体的规则。
+func loadDepartmentChainRules(tx *gorm.DB, subjectType string, chain []uint, lineIDs []uint) (map[string]rawDecision, map[modelRuleKey]rawDecision, map[programRuleKey]rawDecision, error) {
+	lineRules := map[string]rawDecision{}
+	modelRules := map[modelRuleKey]rawDecision{}
+	programRules := map[programRuleKey]rawDecision{}
+	for _, departmentID := range chain {
+		rules, err := loadRuleMap(tx, subjectType, departmentID, "", lineIDs)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for key, decision := range rules {
+			if _, ok := lineRules[key]; !ok {
+				lineRules[key] = decision
+			}
+		}
+		modelDecisions, err := loadModelRuleMap(tx, subjectType, departmentID, "", lineIDs)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for key, decision := range modelDecisions {
+			if _, ok := modelRules[key]; !ok {
+				modelRules[key] = decision
+			}
+		}
+		programDecisions, err := loadProgramRuleMap(tx, subjectType, departmentID, "")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for key, decision := range programDecisions {
+			if _, ok := programRules[key]; !ok {
+				programRules[key] = decision
+			}
+		}
+	}
+	return lineRules, modelRules, programRules, nil
+}
+
+// programLayerRules 在同一层的产线/车型决策之上叠加程序规则，程序规则最具体。
+func programLayerRules(base map[string]rawDecision, programRules map[programRuleKey]rawDecision, lineID, programID uint) map[string]rawDecision {
+	layer := make(map[string]rawDecision, len(permissionActions))
+	for _, action := range permissionActions {
+		key := ruleKey(lineID, action)
+		if decision, ok := programRules[programRuleKey{ProgramID: programID, Action: action}]; ok {
+			layer[key] = decision
+		} else if decision, ok := base[key]; ok {
+			layer[key] = decision
+		}
+	}
+	return layer
+}
+
+// programRuleTargets 按产线返回有程序规则的程序（只含 id、产线和车型），不在 lineIDs 中的程序忽略。
+func programRuleTargets(tx *gorm.DB, lineIDs []uint, ruleSets ...map[programRuleKey]rawDecision) (map[uint][]models.Program, error) {
+	seen := map[uint]bool{}
+	programIDs := []uint{}
+	for _, rules := range ruleSets {
+		for key := range rules {
+			if !seen[key.ProgramID] {
+				seen[key.ProgramID] = true
+				programIDs = append(programIDs, key.ProgramID)
+			}
+		}
+	}
+	result := map[uint][]models.Program{}
+	if len(programIDs) == 0 {
+		return result, nil
+	}
+	var programs []models.Program
+	if err := tx.Select("id", "production_line_id", "vehicle_model_id").Where("id IN ? AND production_line_id IN ?", programIDs, lineIDs).Order("id ASC").Find(&programs).Error; err != nil {
+		return nil, err
+	}
+	for _, program := range programs {
+		result[program.ProductionLineID] = append(result[program.ProductionLineID], program)
+	}
+	return result, nil
+}
+
+func subjectRuleDecision(rule models.PermissionRule, subjectType string, subjectID uint, subjectKey string) rawDecision {
+	source := rule.SubjectType
+	if subjectType == models.PermissionSubjectRole && rule.SubjectID == 0 && strings.TrimSpace(rule.SubjectKey) != "" {
+		source = "role_default"
+	}
+	return rawDecision{Decision: rule.Decision, Source: source, Rank: ruleScopeRank(rule, subjectID, subjectKey), ValidUntil: rule.ValidUntil}
+}
+
+// modelLayerRules 合并同一层的产线规则和车型规则，得到该层在 (产线, 车型) 上的决策：
+// 同时限定产线和车型的规则优先，其次是不限产线的车型规则，最后是产线规则。
+func modelLayerRules(lineRules map[string]rawDecision, modelRules map[modelRuleKey]rawDecision, lineID, modelID uint) map[string]rawDecision {
+	layer := map[string]rawDecision{}
+	for _, action := range permissionActions {
+		key := ruleKey(lineID, action)
+		if decision, ok := modelRules[modelRuleKey{ModelID: modelID, LineID: lineID, Action: action}]; ok {
+			layer[key] = decision
+		} else if decision, ok := modelRules[modelRuleKey{ModelID: modelID, Action: action}]; ok {
+			layer[key] = decision
+		} else if decision, ok := lineRules[key]; ok {
+			layer[key] = decision
+		}
+	}
+	return layer
+}
+
 // loadRoleDefaultRules 加载角色全局默认权限（resource_id=0），并展开到每个产线。
 // role_default 规则存储时 resource_id=0 表示适用于所有产线。
 func loadRoleDefaultRules(tx *gorm.DB, roleID uint, lineIDs []uint) (map[string]rawDecision, error) {
@@ -175,7 +395,7 @@ func loadRoleDefaultRules(tx *gorm.DB, roleID uint, lineIDs []uint) (map[string]
 	}
 
 	var rules []models.PermissionRule
-	if err := tx.Where("subject_type = ? AND subject_id = ? AND subject_key = '' AND resource_type = ? AND resource_id = 0",
+	if err := activePermissionRules(tx, time.Now()).Where("subject_type = ? AND subject_id = ? AND subject_key = '' AND resource_type = ? AND resource_id = 0",
 		"role_default", roleID, models.PermissionResourceProductionLine).Find(&rules).Error; err != nil {
 		return nil, err
 	}
@@ -213,8 +433,8 @@ func ruleScopeRank(rule models.PermissionRule, subjectID uint, subjectKey string
 	return 0
 }
 
-func permissionRuleScopeQuery(tx *gorm.DB, subject PermissionSubject, resourceType string, resourceID uint, action string) *gorm.DB {
-	query := tx.Where("subject_type = ? AND resource_type = ? AND resource_id = ? AND action = ?", subject.Type, resourceType, resourceID, action)
+func permissionRuleScopeQuery(tx *gorm.DB, subject PermissionSubject, resourceType string, resourceID, productionLineID uint, action string) *gorm.DB {
+	query := tx.Where("subject_type = ? AND resource_type = ? AND resource_id = ? AND production_line_id = ? AND action = ?", subject.Type, resourceType, resourceID, productionLineID, action)
 	if subject.Type == models.PermissionSubjectRole && subject.ID > 0 && subject.Key != "" {
 		return query.Where(
 			"(subject_id = ? AND subject_key = ?) OR (subject_id = ? AND subject_key = '') OR (subject_id = 0 AND subject_key = ?)",
@@ -241,6 +461,7 @@ func resolveCell(action string, resourceID uint, setting map[string]rawDecision,
 	if own, ok := setting[key]; ok {
 		cell.Setting = own.Decision
 		cell.SettingLabel = decisionLabel(own.Decision)
+		cell.ValidUntil = own.ValidUntil
 	}
 	for _, source := range sources {
 		if decision, ok := source[key]; ok {
@@ -283,6 +504,10 @@ func linePermFromCells(lineID uint, cells map[string]PermissionCell) ResolvedLin
 }
 
 func ResolveUserProductionLinePermissions(user models.User, productionLines []models.ProductionLine) ([]ResolvedLinePermission, error) {
+	return resolveUserProductionLinePermissionsTx(database.DB, user, productionLines)
+}
+
+func resolveUserProductionLinePermissionsTx(tx *gorm.DB, user models.User, productionLines []models.ProductionLine) ([]ResolvedLinePermission, error) {
 	lineIDs := make([]uint, 0, len(productionLines))
 	for _, line := range productionLines {
 		lineIDs = append(lineIDs, line.ID)
@@ -291,19 +516,36 @@ func ResolveUserProductionLinePermissions(user models.User, productionLines []mo
 		return []ResolvedLinePermission{}, nil
 	}
 
-	userRules, err := loadRuleMap(database.DB, models.PermissionSubjectUser, user.ID, "", lineIDs)
+	userRules, err := loadRuleMap(tx, models.PermissionSubjectUser, user.ID, "", lineIDs)
+	if err != nil {
+		return nil, err
+	}
+	userModelRules, err := loadModelRuleMap(tx, models.PermissionSubjectUser, user.ID, "", lineIDs)
+	if err != nil {
+		return nil, err
+	}
+	userProgramRules, err := loadProgramRuleMap(tx, models.PermissionSubjectUser, user.ID, "")
 	if err != nil {
 		return nil, err
 	}
 
 	departmentRules := map[string]rawDecision{}
 	departmentDefaultRules := map[string]rawDecision{}
+	departmentModelRules := map[modelRuleKey]rawDecision{}
+	departmentDefaultModelRules := map[modelRuleKey]rawDecision{}
+	departmentProgramRules := map[programRuleKey]rawDecision{}
+	departmentDefaultProgramRules := map[programRuleKey]rawDecision{}
 	if user.DepartmentID != nil {
-		departmentRules, err = loadRuleMap(database.DB, models.PermissionSubjectDepartment, *user.DepartmentID, "", lineIDs)
+		// 上级部门的规则沿部门树向下继承，同一资源以最近的部门为准
+		chain, err := DepartmentAncestors(tx, *user.DepartmentID)
+		if err != nil {
+			return nil, err
+		}
+		departmentRules, departmentModelRules, departmentProgramRules, err = loadDepartmentChainRules(tx, models.PermissionSubjectDepartment, chain, lineIDs)
 		if err != nil {
 			return nil, err
 		}
-		departmentDefaultRules, err = loadRuleMap(database.DB, models.PermissionSubjectDepartmentDefault, *user.DepartmentID, "", lineIDs)
+		departmentDefaultRules, departmentDefaultModelRules, departmentDefaultProgramRules, err = loadDepartmentChainRules(tx, models.PermissionSubjectDepartmentDefault, chain, lineIDs)
 		if err != nil {
 			return nil, err
 		}
@@ -313,28 +555,119 @@ func ResolveUserProductionLinePermissions(user models.User, productionLines []mo
 	if user.RoleID != nil {
 		roleID = *user.RoleID
 	}
-	roleRules, err := loadRuleMap(database.DB, models.PermissionSubjectRole, roleID, strings.TrimSpace(user.Role), lineIDs)
+	roleRules, err := loadRuleMap(tx, models.PermissionSubjectRole, roleID, strings.TrimSpace(user.Role), lineIDs)
+	if err != nil {
+		return nil, err
+	}
+	roleModelRules, err := loadModelRuleMap(tx, models.PermissionSubjectRole, roleID, strings.TrimSpace(user.Role), lineIDs)
+	if err != nil {
+		return nil, err
+	}
+	roleProgramRules, err := loadProgramRuleMap(tx, models.PermissionSubjectRole, roleID, strings.TrimSpace(user.Role))
 	if err != nil {
 		return nil, err
 	}
 
 	// 加载角色全局默认权限（resource_id=0），展开到每个产线
-	roleDefaultRules, err := loadRoleDefaultRules(database.DB, roleID, lineIDs)
+	roleDefaultRules, err := loadRoleDefaultRules(tx, roleID, lineIDs)
 	if err != nil {
 		return nil, err
 	}
 
+	modelsByLine := modelRuleLines(lineIDs, userModelRules, departmentModelRules, roleModelRules, departmentDefaultModelRules)
+	programsByLine, err := programRuleTargets(tx, lineIDs, userProgramRules, departmentProgramRules, roleProgramRules, departmentDefaultProgramRules)
+	if err != nil {
+		return nil, err
+	}
 	resolved := make([]ResolvedLinePermission, 0, len(productionLines))
 	for _, line := range productionLines {
 		cells := map[string]PermissionCell{}
 		for _, action := range permissionActions {
 			cells[action] = resolveCell(action, line.ID, userRules, userRules, departmentRules, roleRules, roleDefaultRules, departmentDefaultRules)
 		}
-		resolved = append(resolved, linePermFromCells(line.ID, cells))
+		permission := linePermFromCells(line.ID, cells)
+
+		// 车型规则在同一层内覆盖产线规则，层之间仍按 用户 > 部门 > 角色 > 角色默认 > 部门默认 取第一条；角色默认只有产线规则
+		for _, modelID := range modelsByLine[line.ID] {
+			userModelLayer := modelLayerRules(nil, userModelRules, line.ID, modelID)
+			userLayer := modelLayerRules(userRules, userModelRules, line.ID, modelID)
+			departmentLayer := modelLayerRules(departmentRules, departmentModelRules, line.ID, modelID)
+			roleLayer := modelLayerRules(roleRules, roleModelRules, line.ID, modelID)
+			departmentDefaultLayer := modelLayerRules(departmentDefaultRules, departmentDefaultModelRules, line.ID, modelID)
+			modelCells := map[string]PermissionCell{}
+			for _, action := range permissionActions {
+				modelCells[action] = resolveCell(action, line.ID, userModelLayer, userLayer, departmentLayer, roleLayer, roleDefaultRules, departmentDefaultLayer)
+			}
+			modelPermission := linePermFromCells(line.ID, modelCells)
+			permission.VehicleModels = append(permission.VehicleModels, ModelLinePermission{
+				VehicleModelID: modelID,
+				CanView:        modelPermission.CanView,
+				CanDownload:    modelPermission.CanDownload,
+				CanUpload:      modelPermission.CanUpload,
+				CanManage:      modelPermission.CanManage,
+				Source:         modelPermission.Source,
+				Cells:          modelCells,
+			})
+		}
+
+		// 程序规则在同一层内覆盖车型和产线规则
+		for _, program := range programsByLine[line.ID] {
+			userProgramLayer := programLayerRules(nil, userProgramRules, line.ID, program.ID)
+			userLayer := programLayerRules(modelLayerRules(userRules, userModelRules, line.ID, program.VehicleModelID), userProgramRules, line.ID, program.ID)
+			departmentLayer := programLayerRules(modelLayerRules(departmentRules, departmentModelRules, line.ID, program.VehicleModelID), departmentProgramRules, line.ID, program.ID)
+			roleLayer := programLayerRules(modelLayerRules(roleRules, roleModelRules, line.ID, program.VehicleModelID), roleProgramRules, line.ID, program.ID)
+			departmentDefaultLayer := programLayerRules(modelLayerRules(departmentDefaultRules, departmentDefaultModelRules, line.ID, program.VehicleModelID), departmentDefaultProgramRules, line.ID, program.ID)
+			programCells := map[string]PermissionCell{}
+			for _, action := range permissionActions {
+				programCells[action] = resolveCell(action, line.ID, userProgramLayer, userLayer, departmentLayer, roleLayer, roleDefaultRules, departmentDefaultLayer)
+			}
+			programPermission := linePermFromCells(line.ID, programCells)
+			permission.Programs = append(permission.Programs, ProgramLinePermission{
+				ProgramID:      program.ID,
+				VehicleModelID: program.VehicleModelID,
+				CanView:        programPermission.CanView,
+				CanDownload:    programPermission.CanDownload,
+				CanUpload:      programPermission.CanUpload,
+				CanManage:      programPermission.CanManage,
+				Source:         programPermission.Source,
+				Cells:          programCells,
+			})
+		}
+		resolved = append(resolved, permission)
 	}
 	return resolved, nil
 }
 
+// modelRuleLines 返回每条产线上有车型规则的车型，按车型 ID 排序；不限产线的规则展开到每条产线。
+func modelRuleLines(lineIDs []uint, ruleSets ...map[modelRuleKey]rawDecision) map[uint][]uint {
+	seen := map[uint]map[uint]struct{}{}
+	add := func(lineID, modelID uint) {
+		if seen[lineID] == nil {
+			seen[lineID] = map[uint]struct{}{}
+		}
+		seen[lineID][modelID] = struct{}{}
+	}
+	for _, rules := range ruleSets {
+		for key := range rules {
+			if key.LineID != 0 {
+				add(key.LineID, key.ModelID)
+				continue
+			}
+			for _, lineID := range lineIDs {
+				add(lineID, key.ModelID)
+			}
+		}
+	}
+	result := make(map[uint][]uint, len(seen))
+	for lineID, modelIDs := range seen {
+		for modelID := range modelIDs {
+			result[lineID] = append(result[lineID], modelID)
+		}
+		sort.Slice(result[lineID], func(i, j int) bool { return result[lineID][i] < result[lineID][j] })
+	}
+	return result
+}
+
 func ResolveSubjectMatrix(user models.User, subject PermissionSubject, productionLines []models.ProductionLine) ([]PermissionMatrixLine, error) {
 	resolved, err := ResolveUserProductionLinePermissions(user, productionLines)
 	if err != nil {
@@ -345,6 +678,9 @@ func ResolveSubjectMatrix(user models.User, subject PermissionSubject, productio
 		lineNames[line.ID] = line.Name
 	}
 	matrix := make([]PermissionMatrixLine, 0, len(resolved))
+	var modelRows, programRows []PermissionMatrixLine
+	modelIDs := []uint{}
+	programIDs := []uint{}
 	for _, line := range resolved {
 		matrix = append(matrix, PermissionMatrixLine{
 			ResourceType: models.PermissionResourceProductionLine,
@@ -352,10 +688,170 @@ func ResolveSubjectMatrix(user models.User, subject PermissionSubject, productio
 			ResourceName: lineNames[line.ProductionLineID],
 			Actions:      line.Cells,
 		})
+		for _, model := range line.VehicleModels {
+			modelIDs = append(modelIDs, model.VehicleModelID)
+			modelRows = append(modelRows, PermissionMatrixLine{
+				ResourceType:       models.PermissionResourceVehicleModel,
+				ResourceID:         model.VehicleModelID,
+				ProductionLineID:   line.ProductionLineID,
+				ProductionLineName: lineNames[line.ProductionLineID],
+				Actions:            model.Cells,
+			})
+		}
+		for _, program := range line.Programs {
+			programIDs = append(programIDs, program.ProgramID)
+			programRows = append(programRows, PermissionMatrixLine{
+				ResourceType:       models.PermissionResourceProgram,
+				ResourceID:         program.ProgramID,
+				ProductionLineID:   line.ProductionLineID,
+				ProductionLineName: lineNames[line.ProductionLineID],
+				Actions:            program.Cells,
+			})
+		}
+	}
+	modelNames, err := vehicleModelNames(database.DB, modelIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range modelRows {
+		row.ResourceName = modelNames[row.ResourceID]
+		matrix = append(matrix, row)
+	}
+	names, err := programNames(database.DB, programIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range programRows {
+		row.ResourceName = names[row.ResourceID]
+		matrix = append(matrix, row)
 	}
 	return matrix, nil
 }
 
+// programRuleMatrixRows 把主体自身的程序规则展示为矩阵行，只包含 lineNames 中产线上的程序。
+func programRuleMatrixRows(tx *gorm.DB, rules map[programRuleKey]rawDecision, lineNames map[uint]string) ([]PermissionMatrixLine, error) {
+	lineIDs := make([]uint, 0, len(lineNames))
+	for lineID := range lineNames {
+		lineIDs = append(lineIDs, lineID)
+	}
+	programsByLine, err := programRuleTargets(tx, lineIDs, rules)
+	if err != nil {
+		return nil, err
+	}
+	programs := []models.Program{}
+	programIDs := []uint{}
+	for _, linePrograms := range programsByLine {
+		for _, program := range linePrograms {
+			programs = append(programs, program)
+			programIDs = append(programIDs, program.ID)
+		}
+	}
+	if len(programs) == 0 {
+		return nil, nil
+	}
+	sort.Slice(programs, func(i, j int) bool { return programs[i].ID < programs[j].ID })
+	names, err := programNames(tx, programIDs)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]PermissionMatrixLine, 0, len(programs))
+	for _, program := range programs {
+		layer := programLayerRules(nil, rules, program.ProductionLineID, program.ID)
+		cells := map[string]PermissionCell{}
+		for _, action := range permissionActions {
+			cells[action] = resolveCell(action, program.ProductionLineID, layer, layer)
+		}
+		rows = append(rows, PermissionMatrixLine{
+			ResourceType:       models.PermissionResourceProgram,
+			ResourceID:         program.ID,
+			ResourceName:       names[program.ID],
+			ProductionLineID:   program.ProductionLineID,
+			ProductionLineName: lineNames[program.ProductionLineID],
+			Actions:            cells,
+		})
+	}
+	return rows, nil
+}
+
+func programNames(tx *gorm.DB, programIDs []uint) (map[uint]string, error) {
+	names := map[uint]string{}
+	if len(programIDs) == 0 {
+		return names, nil
+	}
+	var programs []models.Program
+	if err := tx.Select("id", "name").Where("id IN ?", programIDs).Find(&programs).Error; err != nil {
+		return nil, err
+	}
+	for _, program := range programs {
+		names[program.ID] = program.Name
+	}
+	return names, nil
+}
+
+// modelRuleMatrixRows 把主体自身的车型规则按 (车型, 产线) 展示为矩阵行，产线为 0 的行适用于所有产线。
+func modelRuleMatrixRows(tx *gorm.DB, rules map[modelRuleKey]rawDecision, lineNames map[uint]string) ([]PermissionMatrixLine, error) {
+	type modelScope struct {
+		modelID uint
+		lineID  uint
+	}
+	layers := map[modelScope]map[string]rawDecision{}
+	scopes := []modelScope{}
+	modelIDs := []uint{}
+	for key, decision := range rules {
+		scope := modelScope{modelID: key.ModelID, lineID: key.LineID}
+		if layers[scope] == nil {
+			layers[scope] = map[string]rawDecision{}
+			scopes = append(scopes, scope)
+			modelIDs = append(modelIDs, key.ModelID)
+		}
+		layers[scope][ruleKey(key.LineID, key.Action)] = decision
+	}
+	if len(scopes) == 0 {
+		return nil, nil
+	}
+	sort.Slice(scopes, func(i, j int) bool {
+		if scopes[i].modelID != scopes[j].modelID {
+			return scopes[i].modelID < scopes[j].modelID
+		}
+		return scopes[i].lineID < scopes[j].lineID
+	})
+	modelNames, err := vehicleModelNames(tx, modelIDs)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]PermissionMatrixLine, 0, len(scopes))
+	for _, scope := range scopes {
+		cells := map[string]PermissionCell{}
+		for _, action := range permissionActions {
+			cells[action] = resolveCell(action, scope.lineID, layers[scope], layers[scope])
+		}
+		rows = append(rows, PermissionMatrixLine{
+			ResourceType:       models.PermissionResourceVehicleModel,
+			ResourceID:         scope.modelID,
+			ResourceName:       modelNames[scope.modelID],
+			ProductionLineID:   scope.lineID,
+			ProductionLineName: lineNames[scope.lineID],
+			Actions:            cells,
+		})
+	}
+	return rows, nil
+}
+
+func vehicleModelNames(tx *gorm.DB, modelIDs []uint) (map[uint]string, error) {
+	names := map[uint]string{}
+	if len(modelIDs) == 0 {
+		return names, nil
+	}
+	var vehicleModels []models.VehicleModel
+	if err := tx.Select("id", "name").Where("id IN ?", modelIDs).Find(&vehicleModels).Error; err != nil {
+		return nil, err
+	}
+	for _, model := range vehicleModels {
+		names[model.ID] = model.Name
+	}
+	return names, nil
+}
+
 func ResolveSubjectRuleMatrix(subject PermissionSubject, productionLines []models.ProductionLine) ([]PermissionMatrixLine, error) {
 	subject = normalizeSubject(subject)
 	lineIDs := make([]uint, 0, len(productionLines))
@@ -379,7 +875,24 @@ func ResolveSubjectRuleMatrix(subject PermissionSubject, productionLines []model
 		}
 		matrix = append(matrix, PermissionMatrixLine{ResourceType: models.PermissionResourceProductionLine, ResourceID: line.ID, ResourceName: lineNames[line.ID], Actions: cells})
 	}
-	return matrix, nil
+	modelRules, err := loadModelRuleMap(database.DB, subject.Type, subject.ID, subject.Key, lineIDs)
+	if err != nil {
+		return nil, err
+	}
+	modelRows, err := modelRuleMatrixRows(database.DB, modelRules, lineNames)
+	if err != nil {
+		return nil, err
+	}
+	programRules, err := loadProgramRuleMap(database.DB, subject.Type, subject.ID, subject.Key)
+	if err != nil {
+		return nil, err
+	}
+	programRows, err := programRuleMatrixRows(database.DB, programRules, lineNames)
+	if err != nil {
+		return nil, err
+	}
+	matrix = append(matrix, modelRows...)
+	return append(matrix, programRows...), nil
 }
 
 func LoadSubjectLinePermissionBits(subject PermissionSubject, productionLines []models.ProductionLine) ([]LinePermissionBits, error) {
@@ -472,7 +985,17 @@ func SavePermissionRuleChangesTx(tx *gorm.DB, subject PermissionSubject, changes
 		if resourceType == "" {
 			resourceType = models.PermissionResourceProductionLine
 		}
-		if resourceType != models.PermissionResourceProductionLine {
+		switch resourceType {
+		case models.PermissionResourceProductionLine:
+			if change.ProductionLineID != 0 {
+				return errors.New("production_line_id is only valid for vehicle_model rules")
+			}
+		case models.PermissionResourceVehicleModel:
+		case models.PermissionResourceProgram:
+			if change.ProductionLineID != 0 {
+				return errors.New("production_line_id is only valid for vehicle_model rules")
+			}
+		default:
 			return errors.New("invalid resource type")
 		}
 		if change.ResourceID == 0 {
@@ -482,7 +1005,7 @@ func SavePermissionRuleChangesTx(tx *gorm.DB, subject PermissionSubject, changes
 			return errors.New("invalid action")
 		}
 		decision := strings.TrimSpace(change.Decision)
-		where := permissionRuleScopeQuery(tx, subject, resourceType, change.ResourceID, change.Action)
+		where := permissionRuleScopeQuery(tx, subject, resourceType, change.ResourceID, change.ProductionLineID, change.Action)
 		if decision == "unset" || decision == "" {
 			if err := where.Unscoped().Delete(&models.PermissionRule{}).Error; err != nil {
 				return err
@@ -492,10 +1015,13 @@ func SavePermissionRuleChangesTx(tx *gorm.DB, subject PermissionSubject, changes
 		if decision != models.PermissionDecisionAllow && decision != models.PermissionDecisionDeny {
 			return errors.New("invalid decision")
 		}
+		if err := validateRuleWindow(change.ValidFrom, change.ValidUntil); err != nil {
+			return err
+		}
 		if err := where.Unscoped().Delete(&models.PermissionRule{}).Error; err != nil {
 			return err
 		}
-		rule := models.PermissionRule{SubjectType: subject.Type, SubjectID: subject.ID, SubjectKey: subject.Key, ResourceType: resourceType, ResourceID: change.ResourceID, Action: change.Action, Decision: decision}
+		rule := models.PermissionRule{SubjectType: subject.Type, SubjectID: subject.ID, SubjectKey: subject.Key, ResourceType: resourceType, ResourceID: change.ResourceID, ProductionLineID: change.ProductionLineID, Action: change.Action, Decision: decision, ValidFrom: change.ValidFrom, ValidUntil: change.ValidUntil}
 		if err := tx.Create(&rule).Error; err != nil {
 			return err
 		}
@@ -503,6 +1029,44 @@ func SavePermissionRuleChangesTx(tx *gorm.DB, subject PermissionSubject, changes
 	return nil
 }
 
+// validateRuleWindow 要求到期时间晚于生效时间且尚未过去。
+func validateRuleWindow(validFrom, validUntil *time.Time) error {
+	if validUntil == nil {
+		return nil
+	}
+	if validFrom != nil && !validUntil.After(*validFrom) {
+		return errors.New("valid_until must be after valid_from")
+	}
+	if !validUntil.After(time.Now()) {
+		return errors.New("valid_until must be in the future")
+	}
+	return nil
+}
+
+// CurrentPermissionRuleDecisions 返回 changes 涉及的每个单元格当前的决策，没有规则时为 unset。
+func CurrentPermissionRuleDecisions(subject PermissionSubject, changes []PermissionRuleChange) ([]PermissionRuleChange, error) {
+	subject = normalizeSubject(subject)
+	current := make([]PermissionRuleChange, 0, len(changes))
+	for _, change := range changes {
+		resourceType := strings.TrimSpace(change.ResourceType)
+		if resourceType == "" {
+			resourceType = models.PermissionResourceProductionLine
+		}
+		var rules []models.PermissionRule
+		if err := permissionRuleScopeQuery(database.DB, subject, resourceType, change.ResourceID, change.ProductionLineID, change.Action).Limit(1).Find(&rules).Error; err != nil {
+			return nil, err
+		}
+		item := PermissionRuleChange{ResourceType: resourceType, ResourceID: change.ResourceID, ProductionLineID: change.ProductionLineID, Action: change.Action, Decision: "unset"}
+		if len(rules) > 0 {
+			item.Decision = rules[0].Decision
+			item.ValidFrom = rules[0].ValidFrom
+			item.ValidUntil = rules[0].ValidUntil
+		}
+		current = append(current, item)
+	}
+	return current, nil
+}
+
 func SavePermissionRuleChanges(subject PermissionSubject, changes []PermissionRuleChange) error {
 	if err := database.DB.Transaction(func(tx *gorm.DB) error {
 		return SavePermissionRuleChangesTx(tx, subject, changes)
@@ -541,7 +1105,12 @@ func loadUserPermissions(userID uint) (*CachedPermissions, error) {
 	if err := database.DB.Select("id", "department_id", "role", "role_id").First(&user, userID).Error; err != nil {
 		return nil, err
 	}
-	result := &CachedPermissions{FunctionCodes: []string{}, LinePermissions: map[uint]LinePerm{}, ManagedLineIDs: []uint{}, ExpiresAt: time.Now().Add(permCacheTTL)}
+	now := time.Now()
+	expiresAt, err := nextPermissionRuleBoundary(now, now.Add(permCacheTTL))
+	if err != nil {
+		return nil, err
+	}
+	result := &CachedPermissions{FunctionCodes: []string{}, LinePermissions: map[uint]LinePerm{}, ModelPermissions: map[uint]map[uint]LinePerm{}, ProgramPermissions: map[uint]ProgramPerm{}, ManagedLineIDs: []uint{}, ExpiresAt: expiresAt}
 	if err := loadFunctionPermissions(userID, user.RoleID, result); err != nil {
 		return nil, err
 	}
@@ -555,6 +1124,21 @@ func loadUserPermissions(userID uint) (*CachedPermissions, error) {
 	}
 	for _, permission := range resolved {
 		result.LinePermissions[permission.ProductionLineID] = LinePerm{CanView: permission.CanView, CanDownload: permission.CanDownload, CanUpload: permission.CanUpload, CanManage: permission.CanManage, Source: permission.Source}
+		for _, program := range permission.Programs {
+			result.ProgramPermissions[program.ProgramID] = ProgramPerm{
+				LinePerm:         LinePerm{CanView: program.CanView, CanDownload: program.CanDownload, CanUpload: program.CanUpload, CanManage: program.CanManage, Source: program.Source},
+				ProductionLineID: permission.ProductionLineID,
+				VehicleModelID:   program.VehicleModelID,
+			}
+		}
+		if len(permission.VehicleModels) == 0 {
+			continue
+		}
+		byModel := make(map[uint]LinePerm, len(permission.VehicleModels))
+		for _, model := range permission.VehicleModels {
+			byModel[model.VehicleModelID] = LinePerm{CanView: model.CanView, CanDownload: model.CanDownload, CanUpload: model.CanUpload, CanManage: model.CanManage, Source: model.Source}
+		}
+		result.ModelPermissions[permission.ProductionLineID] = byModel
 	}
 	if err := loadManagedLines(userID, result); err != nil {
 		return nil, err
@@ -562,6 +1146,28 @@ func loadUserPermissions(userID uint) (*CachedPermissions, error) {
 	return result, nil
 }
 
+// nextPermissionRuleBoundary 返回 limit 之前最早有临时规则生效或到期的时间，
+// 缓存在这一刻失效，其他实例清理到期规则时本实例的缓存也不会继续使用过期授权。
+func nextPermissionRuleBoundary(now, limit time.Time) (time.Time, error) {
+	for _, column := range []string{"valid_from", "valid_until"} {
+		var rules []models.PermissionRule
+		if err := database.DB.Select(column).Where(column+" > ? AND "+column+" < ?", now, limit).Order(column + " ASC").Limit(1).Find(&rules).Error; err != nil {
+			return limit, err
+		}
+		if len(rules) == 0 {
+			continue
+		}
+		boundary := rules[0].ValidFrom
+		if column == "valid_until" {
+			boundary = rules[0].ValidUntil
+		}
+		if boundary != nil && boundary.Before(limit) {
+			limit = *boundary
+		}
+	}
+	return limit, nil
+}
+
 func loadFunctionPermissions(userID uint, roleID *uint, result *CachedPermissions) error {
 	rolePermSet := map[uint]bool{}
 	if roleID != nil {
@@ -652,6 +1258,30 @@ func UserHasLinePermission(userID uint, lineID uint, action string) bool {
 	if !ok {
 		return false
 	}
+	return linePermAllows(lp, action)
+}
+
+// UserHasProgramScopePermission 判断用户对程序的权限：依次使用程序规则、车型规则和产线权限。
+// programID 为 0 时只按产线和车型判断。
+func UserHasProgramScopePermission(userID uint, programID, lineID, modelID uint, action string) bool {
+	perms, err := GetUserPermissions(userID)
+	if err != nil {
+		return false
+	}
+	if pp, ok := perms.ProgramPermissions[programID]; ok && programID != 0 {
+		return linePermAllows(pp.LinePerm, action)
+	}
+	if lp, ok := perms.ModelPermissions[lineID][modelID]; ok {
+		return linePermAllows(lp, action)
+	}
+	lp, ok := perms.LinePermissions[lineID]
+	if !ok {
+		return false
+	}
+	return linePermAllows(lp, action)
+}
+
+func linePermAllows(lp LinePerm, action string) bool {
 	switch action {
 	case models.PermissionActionView:
 		return lp.CanView || lp.CanManage
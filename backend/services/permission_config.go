@@ -0,0 +1,630 @@
+package services
+
+import (
+	"crane-system/database"
+	"crane-system/models"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PermissionConfigVersion 是权限配置文档的格式版本，导入时拒绝未知版本。
+const PermissionConfigVersion = 1
+
+// roleDefaultSubject 是按角色 ID 保存、对全部产线生效的角色默认规则（resource_id=0）。
+const roleDefaultSubject = "role_default"
+
+var ErrUnsupportedPermissionConfigVersion = errors.New("不支持的权限配置文档版本")
+
+// PermissionConfigDocument 是按自然键描述的全部权限配置：角色名、部门名、产线编号和工号，
+// 可在不同站点间导出导入。
+type PermissionConfigDocument struct {
+	Version    int                         `json:"version" yaml:"version"`
+	ExportedAt time.Time                   `json:"exported_at" yaml:"exported_at"`
+	Roles      []PermissionConfigRole      `json:"roles" yaml:"roles"`
+	Rules      []PermissionConfigRule      `json:"rules" yaml:"rules"`
+	LineAdmins []PermissionConfigLineAdmin `json:"line_admins" yaml:"line_admins"`
+}
+
+type PermissionConfigRole struct {
+	Name        string   `json:"name" yaml:"name"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Status      string   `json:"status,omitempty" yaml:"status,omitempty"`
+	SortOrder   int      `json:"sort_order" yaml:"sort_order"`
+	Permissions []string `json:"permissions" yaml:"permissions"` // 功能权限码
+}
+
+// PermissionConfigRule 中 Subject 按主体类型分别为工号、部门名或角色名；
+// role_default 规则对全部产线生效，Line 为空。VehicleModel 为车型编号，
+// 有值时是车型规则，Line 为空表示适用于所有产线。
+type PermissionConfigRule struct {
+	SubjectType  string     `json:"subject_type" yaml:"subject_type"`
+	Subject      string     `json:"subject" yaml:"subject"`
+	Line         string     `json:"line,omitempty" yaml:"line,omitempty"`
+	VehicleModel string     `json:"vehicle_model,omitempty" yaml:"vehicle_model,omitempty"`
+	Action       string     `json:"action" yaml:"action"`
+	Decision     string     `json:"decision" yaml:"decision"`
+	ValidFrom    *time.Time `json:"valid_from,omitempty" yaml:"valid_from,omitempty"`
+	ValidUntil   *time.Time `json:"valid_until,omitempty" yaml:"valid_until,omitempty"`
+}
+
+type PermissionConfigLineAdmin struct {
+	EmployeeID string `json:"employee_id" yaml:"employee_id"`
+	Line       string `json:"line" yaml:"line"`
+}
+
+// PermissionConfigDiff 是导入文档与当前配置的差异。Errors 非空时不会应用任何变更。
+type PermissionConfigDiff struct {
+	Applied                bool                        `json:"applied"`
+	RolesCreated           []string                    `json:"roles_created"`
+	RolesUpdated           []string                    `json:"roles_updated"`
+	RolePermissionsAdded   []string                    `json:"role_permissions_added"`
+	RolePermissionsRemoved []string                    `json:"role_permissions_removed"`
+	RulesAdded             []PermissionConfigRule      `json:"rules_added"`
+	RulesChanged           []PermissionConfigRule      `json:"rules_changed"`
+	RulesRemoved           []PermissionConfigRule      `json:"rules_removed"`
+	LineAdminsAdded        []PermissionConfigLineAdmin `json:"line_admins_added"`
+	LineAdminsRemoved      []PermissionConfigLineAdmin `json:"line_admins_removed"`
+	Errors                 []string                    `json:"errors"`
+}
+
+func newPermissionConfigDiff() PermissionConfigDiff {
+	return PermissionConfigDiff{
+		RolesCreated:           []string{},
+		RolesUpdated:           []string{},
+		RolePermissionsAdded:   []string{},
+		RolePermissionsRemoved: []string{},
+		RulesAdded:             []PermissionConfigRule{},
+		RulesChanged:           []PermissionConfigRule{},
+		RulesRemoved:           []PermissionConfigRule{},
+		LineAdminsAdded:        []PermissionConfigLineAdmin{},
+		LineAdminsRemoved:      []PermissionConfigLineAdmin{},
+		Errors:                 []string{},
+	}
+}
+
+// permissionConfigKeys 是数据库 ID 与自然键的双向映射。
+type permissionConfigKeys struct {
+	employeeByUser   map[uint]string
+	userByEmployee   map[string]uint
+	deptNameByID     map[uint]string
+	deptIDByName     map[string]uint
+	roleByID         map[uint]models.Role
+	roleByName       map[string]models.Role
+	lineCodeByID     map[uint]string
+	lineIDByCode     map[string]uint
+	modelCodeByID    map[uint]string
+	modelIDByCode    map[string]uint
+	permissionCodeBy map[uint]string
+	permissionIDBy   map[string]uint
+}
+
+func loadPermissionConfigKeys(tx *gorm.DB) (permissionConfigKeys, error) {
+	keys := permissionConfigKeys{
+		employeeByUser: map[uint]string{}, userByEmployee: map[string]uint{},
+		deptNameByID: map[uint]string{}, deptIDByName: map[string]uint{},
+		roleByID: map[uint]models.Role{}, roleByName: map[string]models.Role{},
+		lineCodeByID: map[uint]string{}, lineIDByCode: map[string]uint{},
+		modelCodeByID: map[uint]string{}, modelIDByCode: map[string]uint{},
+		permissionCodeBy: map[uint]string{}, permissionIDBy: map[string]uint{},
+	}
+	var users []models.User
+	if err := tx.Select("id", "employee_id").Find(&users).Error; err != nil {
+		return keys, err
+	}
+	for _, user := range users {
+		if user.EmployeeID != "" {
+			keys.employeeByUser[user.ID] = user.EmployeeID
+			keys.userByEmployee[user.EmployeeID] = user.ID
+		}
+	}
+	var departments []models.Department
+	if err := tx.Select("id", "name").Find(&departments).Error; err != nil {
+		return keys, err
+	}
+	for _, department := range departments {
+		keys.deptNameByID[department.ID] = department.Name
+		keys.deptIDByName[department.Name] = department.ID
+	}
+	var roles []models.Role
+	if err := tx.Find(&roles).Error; err != nil {
+		return keys, err
+	}
+	for _, role := range roles {
+		keys.roleByID[role.ID] = role
+		keys.roleByName[role.Name] = role
+	}
+	var lines []models.ProductionLine
+	if err := tx.Select("id", "code").Find(&lines).Error; err != nil {
+		return keys, err
+	}
+	for _, line := range lines {
+		keys.lineCodeByID[line.ID] = line.Code
+		keys.lineIDByCode[line.Code] = line.ID
+	}
+	var vehicleModels []models.VehicleModel
+	if err := tx.Select("id", "code").Find(&vehicleModels).Error; err != nil {
+		return keys, err
+	}
+	for _, model := range vehicleModels {
+		keys.modelCodeByID[model.ID] = model.Code
+		keys.modelIDByCode[model.Code] = model.ID
+	}
+	var permissions []models.Permission
+	if err := tx.Select("id", "code").Find(&permissions).Error; err != nil {
+		return keys, err
+	}
+	for _, permission := range permissions {
+		keys.permissionCodeBy[permission.ID] = permission.Code
+		keys.permissionIDBy[permission.Code] = permission.ID
+	}
+	return keys, nil
+}
+
+// ExportPermissionConfig 导出当前权限配置；已到期的临时规则和主体已不存在的规则不导出。
+// 程序规则绑定具体程序，不随配置导出，导入时也不会改动。
+func ExportPermissionConfig() (PermissionConfigDocument, error) {
+	keys, err := loadPermissionConfigKeys(database.DB)
+	if err != nil {
+		return PermissionConfigDocument{}, err
+	}
+	return exportPermissionConfigTx(database.DB, keys, time.Now())
+}
+
+func exportPermissionConfigTx(tx *gorm.DB, keys permissionConfigKeys, now time.Time) (PermissionConfigDocument, error) {
+	doc := PermissionConfigDocument{Version: PermissionConfigVersion, ExportedAt: now.UTC().Truncate(time.Second),
+		Roles: []PermissionConfigRole{}, Rules: []PermissionConfigRule{}, LineAdmins: []PermissionConfigLineAdmin{}}
+
+	var roles []models.Role
+	if err := tx.Order("sort_order ASC, id ASC").Find(&roles).Error; err != nil {
+		return doc, err
+	}
+	var rolePermissions []models.RolePermission
+	if err := tx.Order("id ASC").Find(&rolePermissions).Error; err != nil {
+		return doc, err
+	}
+	codesByRole := map[uint][]string{}
+	for _, rp := range rolePermissions {
+		if code, ok := keys.permissionCodeBy[rp.PermissionID]; ok {
+			codesByRole[rp.RoleID] = append(codesByRole[rp.RoleID], code)
+		}
+	}
+	for _, role := range roles {
+		codes := append([]string{}, codesByRole[role.ID]...)
+		sort.Strings(codes)
+		doc.Roles = append(doc.Roles, PermissionConfigRole{Name: role.Name, Description: role.Description, Status: role.Status, SortOrder: role.SortOrder, Permissions: codes})
+	}
+
+	rules, err := exportPermissionConfigRules(tx, keys, now)
+	if err != nil {
+		return doc, err
+	}
+	for _, rule := range rules {
+		doc.Rules = append(doc.Rules, rule.PermissionConfigRule)
+	}
+
+	var assignments []models.LineAdminAssignment
+	if err := tx.Order("id ASC").Find(&assignments).Error; err != nil {
+		return doc, err
+	}
+	for _, assignment := range assignments {
+		employeeID, okUser := keys.employeeByUser[assignment.UserID]
+		line, okLine := keys.lineCodeByID[assignment.ProductionLineID]
+		if okUser && okLine {
+			doc.LineAdmins = append(doc.LineAdmins, PermissionConfigLineAdmin{EmployeeID: employeeID, Line: line})
+		}
+	}
+	sort.Slice(doc.LineAdmins, func(i, j int) bool {
+		if doc.LineAdmins[i].Line != doc.LineAdmins[j].Line {
+			return doc.LineAdmins[i].Line < doc.LineAdmins[j].Line
+		}
+		return doc.LineAdmins[i].EmployeeID < doc.LineAdmins[j].EmployeeID
+	})
+	return doc, nil
+}
+
+// exportedPermissionRule 记录导出规则的范围精度：同一角色按 ID+角色名、按 ID、按角色名
+// 保存的规则对应同一个自然键，只导出生效的那条。
+type exportedPermissionRule struct {
+	PermissionConfigRule
+	rank int
+}
+
+func exportPermissionConfigRules(tx *gorm.DB, keys permissionConfigKeys, now time.Time) ([]exportedPermissionRule, error) {
+	var rules []models.PermissionRule
+	if err := tx.Where("resource_type IN ? AND (valid_until IS NULL OR valid_until > ?)", []string{models.PermissionResourceProductionLine, models.PermissionResourceVehicleModel}, now).
+		Order("id ASC").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	byKey := map[string]exportedPermissionRule{}
+	for _, rule := range rules {
+		item := exportedPermissionRule{PermissionConfigRule: PermissionConfigRule{SubjectType: rule.SubjectType, Action: rule.Action, Decision: rule.Decision, ValidFrom: rule.ValidFrom, ValidUntil: rule.ValidUntil}}
+		ok := false
+		switch rule.SubjectType {
+		case models.PermissionSubjectUser:
+			item.Subject, ok = keys.employeeByUser[rule.SubjectID]
+		case models.PermissionSubjectDepartment, models.PermissionSubjectDepartmentDefault:
+			item.Subject, ok = keys.deptNameByID[rule.SubjectID]
+		case models.PermissionSubjectRole:
+			item.Subject, ok = rule.SubjectKey, rule.SubjectKey != ""
+			if !ok {
+				item.Subject = keys.roleByID[rule.SubjectID].Name
+				ok = item.Subject != ""
+			}
+			item.rank = ruleScopeRank(rule, keys.roleByName[item.Subject].ID, item.Subject)
+		case roleDefaultSubject:
+			item.Subject = keys.roleByID[rule.SubjectID].Name
+			ok = item.Subject != "" && rule.ResourceID == 0
+		}
+		if !ok {
+			continue
+		}
+		if rule.ResourceType == models.PermissionResourceVehicleModel {
+			if rule.SubjectType == roleDefaultSubject {
+				continue
+			}
+			if item.VehicleModel, ok = keys.modelCodeByID[rule.ResourceID]; !ok {
+				continue
+			}
+			if rule.ProductionLineID != 0 {
+				if item.Line, ok = keys.lineCodeByID[rule.ProductionLineID]; !ok {
+					continue
+				}
+			}
+		} else if rule.SubjectType != roleDefaultSubject {
+			if item.Line, ok = keys.lineCodeByID[rule.ResourceID]; !ok {
+				continue
+			}
+		}
+		key := item.key()
+		if existing, found := byKey[key]; !found || item.rank > existing.rank {
+			byKey[key] = item
+		}
+	}
+	result := make([]exportedPermissionRule, 0, len(byKey))
+	for _, item := range byKey {
+		result = append(result, item)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].key() < result[j].key() })
+	return result, nil
+}
+
+func (r PermissionConfigRule) key() string {
+	return strings.Join([]string{r.SubjectType, r.Subject, r.Line, r.VehicleModel, r.Action}, "\x00")
+}
+
+func (r PermissionConfigRule) sameSetting(other PermissionConfigRule) bool {
+	return r.Decision == other.Decision && sameOptionalTime(r.ValidFrom, other.ValidFrom) && sameOptionalTime(r.ValidUntil, other.ValidUntil)
+}
+
+// sameOptionalTime 按秒比较，避免不同数据库的时间精度造成误报。
+func sameOptionalTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Unix() == b.Unix()
+}
+
+// errPermissionConfigPreview 让预览事务总是回滚。
+var errPermissionConfigPreview = errors.New("permission config preview")
+
+// ImportPermissionConfig 比较文档与当前配置，apply 为 true 时在一个事务中应用差异。
+// 角色只新增或更新，不删除；角色功能权限、产线和车型规则、产线管理员分配与文档保持一致。
+func ImportPermissionConfig(doc PermissionConfigDocument, apply bool) (PermissionConfigDiff, error) {
+	if doc.Version < 1 || doc.Version > PermissionConfigVersion {
+		return PermissionConfigDiff{}, ErrUnsupportedPermissionConfigVersion
+	}
+	diff := newPermissionConfigDiff()
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		keys, err := loadPermissionConfigKeys(tx)
+		if err != nil {
+			return err
+		}
+		docRoles, docRules, docAdmins := validatePermissionConfig(doc, keys, now, &diff)
+		if len(diff.Errors) > 0 {
+			return errPermissionConfigPreview
+		}
+		if err := importPermissionConfigRoles(tx, docRoles, keys, &diff); err != nil {
+			return err
+		}
+		if keys, err = loadPermissionConfigKeys(tx); err != nil {
+			return err
+		}
+		if err := importPermissionConfigRules(tx, docRules, keys, now, &diff); err != nil {
+			return err
+		}
+		if err := importPermissionConfigLineAdmins(tx, docAdmins, keys, &diff); err != nil {
+			return err
+		}
+		if !apply {
+			return errPermissionConfigPreview
+		}
+		return nil
+	})
+	if errors.Is(err, errPermissionConfigPreview) {
+		return diff, nil
+	}
+	if err != nil {
+		return diff, err
+	}
+	diff.Applied = true
+	InvalidateAllCache()
+	return diff, nil
+}
+
+// validatePermissionConfig 检查文档中的自然键和取值，问题写入 diff.Errors。
+func validatePermissionConfig(doc PermissionConfigDocument, keys permissionConfigKeys, now time.Time, diff *PermissionConfigDiff) ([]PermissionConfigRole, []PermissionConfigRule, []PermissionConfigLineAdmin) {
+	fail := func(format string, args ...any) {
+		diff.Errors = append(diff.Errors, fmt.Sprintf(format, args...))
+	}
+	roleNames := map[string]bool{}
+	roles := make([]PermissionConfigRole, 0, len(doc.Roles))
+	for i, role := range doc.Roles {
+		role.Name = strings.TrimSpace(role.Name)
+		if role.Name == "" {
+			fail("roles[%d]: 角色名不能为空", i)
+			continue
+		}
+		if roleNames[role.Name] {
+			fail("roles[%d]: 角色 %s 重复", i, role.Name)
+			continue
+		}
+		roleNames[role.Name] = true
+		for _, code := range role.Permissions {
+			if _, ok := keys.permissionIDBy[code]; !ok {
+				fail("roles[%d]: 功能权限 %s 不存在", i, code)
+			}
+		}
+		roles = append(roles, role)
+	}
+	for name := range keys.roleByName {
+		roleNames[name] = true
+	}
+
+	seen := map[string]bool{}
+	rules := make([]PermissionConfigRule, 0, len(doc.Rules))
+	for i, rule := range doc.Rules {
+		rule.Subject = strings.TrimSpace(rule.Subject)
+		rule.Line = strings.TrimSpace(rule.Line)
+		rule.VehicleModel = strings.TrimSpace(rule.VehicleModel)
+		switch rule.SubjectType {
+		case models.PermissionSubjectUser:
+			if _, ok := keys.userByEmployee[rule.Subject]; !ok {
+				fail("rules[%d]: 工号 %s 不存在", i, rule.Subject)
+			}
+		case models.PermissionSubjectDepartment, models.PermissionSubjectDepartmentDefault:
+			if _, ok := keys.deptIDByName[rule.Subject]; !ok {
+				fail("rules[%d]: 部门 %s 不存在", i, rule.Subject)
+			}
+		case models.PermissionSubjectRole, roleDefaultSubject:
+			if !roleNames[rule.Subject] {
+				fail("rules[%d]: 角色 %s 不存在", i, rule.Subject)
+			}
+		default:
+			fail("rules[%d]: 主体类型 %s 无效", i, rule.SubjectType)
+			continue
+		}
+		switch {
+		case rule.SubjectType == roleDefaultSubject:
+			if rule.Line != "" || rule.VehicleModel != "" {
+				fail("rules[%d]: role_default 规则不能指定产线或车型", i)
+			}
+		case rule.VehicleModel != "":
+			if _, ok := keys.modelIDByCode[rule.VehicleModel]; !ok {
+				fail("rules[%d]: 车型 %s 不存在", i, rule.VehicleModel)
+			}
+			if _, ok := keys.lineIDByCode[rule.Line]; rule.Line != "" && !ok {
+				fail("rules[%d]: 产线 %s 不存在", i, rule.Line)
+			}
+		default:
+			if _, ok := keys.lineIDByCode[rule.Line]; !ok {
+				fail("rules[%d]: 产线 %s 不存在", i, rule.Line)
+			}
+		}
+		if !actionValid(rule.Action) {
+			fail("rules[%d]: 动作 %s 无效", i, rule.Action)
+		}
+		if rule.Decision != models.PermissionDecisionAllow && rule.Decision != models.PermissionDecisionDeny {
+			fail("rules[%d]: 决策 %s 无效", i, rule.Decision)
+		}
+		if rule.ValidUntil != nil && !rule.ValidUntil.After(now) {
+			// 已到期的规则视为不存在
+			continue
+		}
+		if err := validateRuleWindow(rule.ValidFrom, rule.ValidUntil); err != nil {
+			fail("rules[%d]: %s", i, err.Error())
+		}
+		if seen[rule.key()] {
+			fail("rules[%d]: 规则重复", i)
+			continue
+		}
+		seen[rule.key()] = true
+		rules = append(rules, rule)
+	}
+
+	adminSeen := map[PermissionConfigLineAdmin]bool{}
+	admins := make([]PermissionConfigLineAdmin, 0, len(doc.LineAdmins))
+	for i, admin := range doc.LineAdmins {
+		admin.EmployeeID = strings.TrimSpace(admin.EmployeeID)
+		admin.Line = strings.TrimSpace(admin.Line)
+		if _, ok := keys.userByEmployee[admin.EmployeeID]; !ok {
+			fail("line_admins[%d]: 工号 %s 不存在", i, admin.EmployeeID)
+		}
+		if _, ok := keys.lineIDByCode[admin.Line]; !ok {
+			fail("line_admins[%d]: 产线 %s 不存在", i, admin.Line)
+		}
+		if adminSeen[admin] {
+			continue
+		}
+		adminSeen[admin] = true
+		admins = append(admins, admin)
+	}
+	return roles, rules, admins
+}
+
+func importPermissionConfigRoles(tx *gorm.DB, roles []PermissionConfigRole, keys permissionConfigKeys, diff *PermissionConfigDiff) error {
+	for _, item := range roles {
+		status := item.Status
+		if status == "" {
+			status = "active"
+		}
+		role, exists := keys.roleByName[item.Name]
+		if !exists {
+			role = models.Role{Name: item.Name, Description: item.Description, Status: status, SortOrder: item.SortOrder}
+			if err := tx.Create(&role).Error; err != nil {
+				return err
+			}
+			diff.RolesCreated = append(diff.RolesCreated, item.Name)
+		} else if role.Description != item.Description || role.Status != status || role.SortOrder != item.SortOrder {
+			if err := tx.Model(&role).Updates(map[string]any{"description": item.Description, "status": status, "sort_order": item.SortOrder}).Error; err != nil {
+				return err
+			}
+			diff.RolesUpdated = append(diff.RolesUpdated, item.Name)
+		}
+
+		var current []models.RolePermission
+		if err := tx.Where("role_id = ?", role.ID).Find(&current).Error; err != nil {
+			return err
+		}
+		wanted := map[uint]bool{}
+		for _, code := range item.Permissions {
+			wanted[keys.permissionIDBy[code]] = true
+		}
+		for _, rp := range current {
+			if wanted[rp.PermissionID] {
+				delete(wanted, rp.PermissionID)
+				continue
+			}
+			if err := tx.Delete(&models.RolePermission{}, rp.ID).Error; err != nil {
+				return err
+			}
+			diff.RolePermissionsRemoved = append(diff.RolePermissionsRemoved, item.Name+":"+keys.permissionCodeBy[rp.PermissionID])
+		}
+		added := make([]string, 0, len(wanted))
+		for permissionID := range wanted {
+			if err := tx.Create(&models.RolePermission{RoleID: role.ID, PermissionID: permissionID}).Error; err != nil {
+				return err
+			}
+			added = append(added, item.Name+":"+keys.permissionCodeBy[permissionID])
+		}
+		sort.Strings(added)
+		diff.RolePermissionsAdded = append(diff.RolePermissionsAdded, added...)
+	}
+	return nil
+}
+
+func importPermissionConfigRules(tx *gorm.DB, rules []PermissionConfigRule, keys permissionConfigKeys, now time.Time, diff *PermissionConfigDiff) error {
+	current, err := exportPermissionConfigRules(tx, keys, now)
+	if err != nil {
+		return err
+	}
+	currentByKey := make(map[string]PermissionConfigRule, len(current))
+	for _, rule := range current {
+		currentByKey[rule.key()] = rule.PermissionConfigRule
+	}
+	wanted := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		wanted[rule.key()] = true
+		existing, ok := currentByKey[rule.key()]
+		switch {
+		case !ok:
+			diff.RulesAdded = append(diff.RulesAdded, rule)
+		case !existing.sameSetting(rule):
+			diff.RulesChanged = append(diff.RulesChanged, rule)
+		default:
+			continue
+		}
+		if err := writePermissionConfigRule(tx, rule, keys, rule.Decision); err != nil {
+			return err
+		}
+	}
+	for _, rule := range current {
+		if wanted[rule.key()] {
+			continue
+		}
+		diff.RulesRemoved = append(diff.RulesRemoved, rule.PermissionConfigRule)
+		if err := writePermissionConfigRule(tx, rule.PermissionConfigRule, keys, "unset"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePermissionConfigRule 写入或删除（decision 为 unset）一条规则；产线和车型规则复用 SavePermissionRuleChangesTx。
+func writePermissionConfigRule(tx *gorm.DB, rule PermissionConfigRule, keys permissionConfigKeys, decision string) error {
+	if rule.SubjectType == roleDefaultSubject {
+		roleID := keys.roleByName[rule.Subject].ID
+		if err := tx.Unscoped().Where("subject_type = ? AND subject_id = ? AND resource_type = ? AND resource_id = 0 AND action = ?",
+			roleDefaultSubject, roleID, models.PermissionResourceProductionLine, rule.Action).Delete(&models.PermissionRule{}).Error; err != nil {
+			return err
+		}
+		if decision == "unset" {
+			return nil
+		}
+		return tx.Create(&models.PermissionRule{SubjectType: roleDefaultSubject, SubjectID: roleID, ResourceType: models.PermissionResourceProductionLine,
+			Action: rule.Action, Decision: decision, ValidFrom: rule.ValidFrom, ValidUntil: rule.ValidUntil}).Error
+	}
+
+	subject := PermissionSubject{Type: rule.SubjectType}
+	switch rule.SubjectType {
+	case models.PermissionSubjectUser:
+		subject.ID = keys.userByEmployee[rule.Subject]
+	case models.PermissionSubjectDepartment, models.PermissionSubjectDepartmentDefault:
+		subject.ID = keys.deptIDByName[rule.Subject]
+	case models.PermissionSubjectRole:
+		subject.ID = keys.roleByName[rule.Subject].ID
+		subject.Key = rule.Subject
+	}
+	change := PermissionRuleChange{
+		ResourceType: models.PermissionResourceProductionLine,
+		ResourceID:   keys.lineIDByCode[rule.Line],
+		Action:       rule.Action,
+		Decision:     decision,
+		ValidFrom:    rule.ValidFrom,
+		ValidUntil:   rule.ValidUntil,
+	}
+	if rule.VehicleModel != "" {
+		change.ResourceType = models.PermissionResourceVehicleModel
+		change.ResourceID = keys.modelIDByCode[rule.VehicleModel]
+		change.ProductionLineID = keys.lineIDByCode[rule.Line]
+	}
+	return SavePermissionRuleChangesTx(tx, subject, []PermissionRuleChange{change})
+}
+
+func importPermissionConfigLineAdmins(tx *gorm.DB, admins []PermissionConfigLineAdmin, keys permissionConfigKeys, diff *PermissionConfigDiff) error {
+	var assignments []models.LineAdminAssignment
+	if err := tx.Order("id ASC").Find(&assignments).Error; err != nil {
+		return err
+	}
+	wanted := make(map[PermissionConfigLineAdmin]bool, len(admins))
+	for _, admin := range admins {
+		wanted[admin] = true
+	}
+	for _, assignment := range assignments {
+		admin := PermissionConfigLineAdmin{EmployeeID: keys.employeeByUser[assignment.UserID], Line: keys.lineCodeByID[assignment.ProductionLineID]}
+		if wanted[admin] {
+			delete(wanted, admin)
+			continue
+		}
+		if err := tx.Delete(&models.LineAdminAssignment{}, assignment.ID).Error; err != nil {
+			return err
+		}
+		diff.LineAdminsRemoved = append(diff.LineAdminsRemoved, admin)
+	}
+	for _, admin := range admins {
+		if !wanted[admin] {
+			continue
+		}
+		assignment := models.LineAdminAssignment{UserID: keys.userByEmployee[admin.EmployeeID], ProductionLineID: keys.lineIDByCode[admin.Line]}
+		if err := tx.Create(&assignment).Error; err != nil {
+			return err
+		}
+		diff.LineAdminsAdded = append(diff.LineAdminsAdded, admin)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"crane-system/models"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestPermissionConfigRoundTripAndImport(t *testing.T) {
+	db := openPermissionServiceTestDB(t)
+	departmentID := uint(3)
+	role := models.Role{ID: 5, Name: "operator", Status: "active"}
+	user := models.User{ID: 9, Name: "Alice", EmployeeID: "U009", Role: role.Name, RoleID: &role.ID, Password: "x", DepartmentID: &departmentID, Status: "active"}
+	line := models.ProductionLine{ID: 21, Name: "总装线", Code: "L1"}
+	permission := models.Permission{ID: 7, Code: "op:file_upload", Name: "上传文件", Type: "operation"}
+	until := time.Now().Add(24 * time.Hour)
+	for _, row := range []any{
+		&models.Department{ID: departmentID, Name: "制造部"}, &role, &user, &line, &permission,
+		&models.RolePermission{RoleID: role.ID, PermissionID: permission.ID},
+		&models.LineAdminAssignment{UserID: user.ID, ProductionLineID: line.ID},
+	} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatalf("seed %T: %v", row, err)
+		}
+	}
+	rules := []models.PermissionRule{
+		// 同一角色按角色名和按 ID+角色名的规则只导出后者
+		{SubjectType: models.PermissionSubjectRole, SubjectKey: role.Name, Action: models.PermissionActionView, Decision: models.PermissionDecisionDeny},
+		{SubjectType: models.PermissionSubjectRole, SubjectID: role.ID, SubjectKey: role.Name, Action: models.PermissionActionView, Decision: models.PermissionDecisionAllow},
+		{SubjectType: models.PermissionSubjectUser, SubjectID: user.ID, Action: models.PermissionActionDownload, Decision: models.PermissionDecisionAllow, ValidUntil: &until},
+		{SubjectType: models.PermissionSubjectDepartmentDefault, SubjectID: departmentID, Action: models.PermissionActionView, Decision: models.PermissionDecisionAllow},
+	}
+	for i := range rules {
+		rules[i].ResourceType = models.PermissionResourceProductionLine
+		rules[i].ResourceID = line.ID
+		if err := db.Create(&rules[i]).Error; err != nil {
+			t.Fatalf("seed rule: %v", err)
+		}
+	}
+
+	doc, err := ExportPermissionConfig()
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if len(doc.Roles) != 1 || len(doc.Roles[0].Permissions) != 1 || len(doc.Rules) != 3 || len(doc.LineAdmins) != 1 || doc.LineAdmins[0].EmployeeID != "U009" {
+		t.Fatalf("exported = %+v", doc)
+	}
+	for _, rule := range doc.Rules {
+		if rule.SubjectType == models.PermissionSubjectRole && (rule.Subject != role.Name || rule.Decision != models.PermissionDecisionAllow || rule.Line != "L1") {
+			t.Fatalf("role rule = %+v", rule)
+		}
+	}
+
+	// 经 YAML 往返后导入不产生差异
+	raw, err := yaml.Marshal(doc)
+	if err != nil {
+		t.Fatalf("marshal yaml: %v", err)
+	}
+	var parsed PermissionConfigDocument
+	if err := yaml.Unmarshal(raw, &parsed); err != nil {
+		t.Fatalf("unmarshal yaml: %v", err)
+	}
+	diff, err := ImportPermissionConfig(parsed, false)
+	if err != nil {
+		t.Fatalf("preview round trip: %v", err)
+	}
+	if len(diff.Errors)+len(diff.RolesCreated)+len(diff.RolesUpdated)+len(diff.RolePermissionsAdded)+len(diff.RolePermissionsRemoved)+
+		len(diff.RulesAdded)+len(diff.RulesChanged)+len(diff.RulesRemoved)+len(diff.LineAdminsAdded)+len(diff.LineAdminsRemoved) != 0 {
+		t.Fatalf("round trip diff = %+v", diff)
+	}
+
+	// 新角色、修改部门默认、删除单独设置和产线管理员
+	parsed.Roles = append(parsed.Roles, PermissionConfigRole{Name: "contractor", Permissions: []string{"op:file_upload"}})
+	parsed.Rules = []PermissionConfigRule{
+		{SubjectType: models.PermissionSubjectRole, Subject: role.Name, Line: "L1", Action: models.PermissionActionView, Decision: models.PermissionDecisionAllow},
+		{SubjectType: models.PermissionSubjectDepartmentDefault, Subject: "制造部", Line: "L1", Action: models.PermissionActionView, Decision: models.PermissionDecisionDeny},
+		{SubjectType: models.PermissionSubjectRole, Subject: "contractor", Line: "L1", Action: models.PermissionActionUpload, Decision: models.PermissionDecisionAllow},
+		{SubjectType: models.PermissionSubjectUser, Subject: "U404", Line: "L9", Action: models.PermissionActionView, Decision: models.PermissionDecisionAllow},
+	}
+	parsed.LineAdmins = nil
+	diff, err = ImportPermissionConfig(parsed, true)
+	if err != nil || diff.Applied || len(diff.Errors) != 2 {
+		t.Fatalf("invalid import diff = %+v, err = %v", diff, err)
+	}
+
+	parsed.Rules = parsed.Rules[:3]
+	preview, err := ImportPermissionConfig(parsed, false)
+	if err != nil || preview.Applied || len(preview.RolesCreated) != 1 || len(preview.RulesAdded) != 1 || len(preview.RulesChanged) != 1 || len(preview.RulesRemoved) != 1 || len(preview.LineAdminsRemoved) != 1 {
+		t.Fatalf("preview = %+v, err = %v", preview, err)
+	}
+	var roleCount int64
+	db.Model(&models.Role{}).Count(&roleCount)
+	if roleCount != 1 {
+		t.Fatalf("preview persisted roles")
+	}
+
+	applied, err := ImportPermissionConfig(parsed, true)
+	if err != nil || !applied.Applied || len(applied.RolePermissionsAdded) != 1 {
+		t.Fatalf("apply = %+v, err = %v", applied, err)
+	}
+	after, err := ExportPermissionConfig()
+	if err != nil {
+		t.Fatalf("export after import: %v", err)
+	}
+	if len(after.Roles) != 2 || len(after.Rules) != 3 || len(after.LineAdmins) != 0 {
+		t.Fatalf("after import = %+v", after)
+	}
+	if UserHasLinePermission(user.ID, line.ID, models.PermissionActionDownload) {
+		t.Fatalf("removed user rule still effective")
+	}
+}
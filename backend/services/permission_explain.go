@@ -0,0 +1,417 @@
+package services
+
+import (
+	"crane-system/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 权限解释中每一层的结论。
+const (
+	ExplainOutcomeDecided  = "decided"  // 本层规则决定了结果
+	ExplainOutcomeShadowed = "shadowed" // 本层有规则，但被更高优先级的层覆盖
+	ExplainOutcomeNoMatch  = "no_match" // 本层没有匹配的规则
+	ExplainOutcomeSkipped  = "skipped"  // 用户不属于本层主体，例如未分配部门
+)
+
+var ErrUnknownPermissionCode = errors.New("权限码不存在")
+
+// PermissionExplainCandidate 是某一层中匹配到的一条规则。
+type PermissionExplainCandidate struct {
+	RuleID      uint   `json:"rule_id,omitempty"`
+	SubjectType string `json:"subject_type"`
+	SubjectID   uint   `json:"subject_id"`
+	SubjectKey  string `json:"subject_key,omitempty"`
+	ResourceID  uint   `json:"resource_id"`
+	Decision    string `json:"decision"`
+	Rank        int    `json:"rank"`
+	Selected    bool   `json:"selected"`
+	Note        string `json:"note"`
+	// ValidUntil 为临时授权的到期时间
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+}
+
+// PermissionExplainLayer 是按优先级排列的一层规则来源。
+type PermissionExplainLayer struct {
+	Layer      string                       `json:"layer"`
+	Label      string                       `json:"label"`
+	Outcome    string                       `json:"outcome"`
+	Decision   string                       `json:"decision,omitempty"`
+	Note       string                       `json:"note,omitempty"`
+	Candidates []PermissionExplainCandidate `json:"candidates"`
+}
+
+// PermissionCellExplanation 是单个产线动作的逐层求值过程。
+type PermissionCellExplanation struct {
+	Action      string                   `json:"action"`
+	Effective   string                   `json:"effective"`
+	Source      string                   `json:"source"`
+	SourceLabel string                   `json:"source_label"`
+	Layers      []PermissionExplainLayer `json:"layers"`
+}
+
+// PermissionExplanation 是一次权限判断的完整求值过程和最终结论。
+type PermissionExplanation struct {
+	UserID           uint                        `json:"user_id"`
+	UserName         string                      `json:"user_name"`
+	Role             string                      `json:"role"`
+	Target           string                      `json:"target"` // line / function
+	ProductionLineID uint                        `json:"production_line_id,omitempty"`
+	Action           string                      `json:"action,omitempty"`
+	Code             string                      `json:"code,omitempty"`
+	Allowed          bool                        `json:"allowed"`
+	DecidedBy        string                      `json:"decided_by"`
+	Reason           string                      `json:"reason"`
+	Steps            []string                    `json:"steps"`
+	Cells            []PermissionCellExplanation `json:"cells,omitempty"`
+	Layers           []PermissionExplainLayer    `json:"layers,omitempty"`
+}
+
+func newPermissionExplanation(user models.User, target string) PermissionExplanation {
+	return PermissionExplanation{UserID: user.ID, UserName: user.Name, Role: user.Role, Target: target, Steps: []string{}}
+}
+
+func (e *PermissionExplanation) step(format string, args ...any) {
+	e.Steps = append(e.Steps, fmt.Sprintf(format, args...))
+}
+
+func (e *PermissionExplanation) conclude(allowed bool, decidedBy, reason string) {
+	e.Allowed = allowed
+	e.DecidedBy = decidedBy
+	e.Reason = reason
+	e.step("结论：%s（%s）", decisionLabel(decisionOf(allowed)), reason)
+}
+
+func decisionOf(allowed bool) string {
+	if allowed {
+		return models.PermissionDecisionAllow
+	}
+	return models.PermissionDecisionDeny
+}
+
+// explainUserPrecheck 处理与规则无关的判断：账号状态和系统管理员角色。返回 true 表示已得出结论。
+func explainUserPrecheck(e *PermissionExplanation, user models.User) bool {
+	if user.Status != "active" {
+		e.step("账号状态为 %s，认证阶段即被拒绝", user.Status)
+		e.conclude(false, "user_status", "用户已被禁用")
+		return true
+	}
+	if IsSystemAdminRole(user.Role) {
+		e.step("角色 %s 是系统管理员，跳过全部规则", user.Role)
+		e.conclude(true, "system_admin", "系统管理员拥有全部权限")
+		return true
+	}
+	return false
+}
+
+// ExplainLinePermission 按 CheckLineAction 的顺序解释用户对产线动作的判断。
+// 规则从 tx 读取，调用方可在未提交的事务中预先应用变更来模拟结果。
+func ExplainLinePermission(tx *gorm.DB, user models.User, productionLineID uint, action string) (PermissionExplanation, error) {
+	e := newPermissionExplanation(user, "line")
+	e.ProductionLineID = productionLineID
+	e.Action = action
+	if !actionValid(action) {
+		return e, errors.New("invalid action")
+	}
+
+	// 规则单元格即使不参与最终判断也一并返回，方便排查
+	cells := []string{action}
+	if action != models.PermissionActionManage {
+		cells = append(cells, models.PermissionActionManage)
+	}
+	for _, cellAction := range cells {
+		cell, err := explainLineCell(tx, user, productionLineID, cellAction)
+		if err != nil {
+			return e, err
+		}
+		e.Cells = append(e.Cells, cell)
+	}
+
+	if explainUserPrecheck(&e, user) {
+		return e, nil
+	}
+	if user.Role == "line_admin" {
+		var count int64
+		if err := tx.Model(&models.LineAdminAssignment{}).Where("user_id = ? AND production_line_id = ?", user.ID, productionLineID).Count(&count).Error; err != nil {
+			return e, err
+		}
+		if count > 0 {
+			e.step("用户是该产线的产线管理员")
+			e.conclude(true, "line_admin", "产线管理员拥有所管理产线的全部权限")
+			return e, nil
+		}
+		e.step("用户是产线管理员，但未被分配管理该产线")
+	}
+
+	own := e.Cells[0]
+	e.step("%s 动作的规则结果为%s（%s）", action, decisionLabel(own.Effective), own.SourceLabel)
+	if own.Effective == models.PermissionDecisionAllow {
+		e.conclude(true, own.Source, fmt.Sprintf("%s允许 %s", own.SourceLabel, action))
+		return e, nil
+	}
+	if len(e.Cells) > 1 {
+		manage := e.Cells[1]
+		e.step("manage 动作隐含其他动作，其规则结果为%s（%s）", decisionLabel(manage.Effective), manage.SourceLabel)
+		if manage.Effective == models.PermissionDecisionAllow {
+			e.conclude(true, manage.Source, fmt.Sprintf("%s允许 manage，隐含 %s", manage.SourceLabel, action))
+			return e, nil
+		}
+	}
+	if own.Source == "system_default" {
+		e.conclude(false, own.Source, "没有任何规则允许该动作，系统默认拒绝")
+	} else {
+		e.conclude(false, own.Source, fmt.Sprintf("%s拒绝 %s", own.SourceLabel, action))
+	}
+	return e, nil
+}
+
+// explainLineCell 按 ResolveUserProductionLinePermissions 的优先级逐层列出匹配的规则：
+// 单独设置 > 部门规则 > 角色规则 > 角色默认 > 部门默认规则，第一层有规则的结果生效。
+func explainLineCell(tx *gorm.DB, user models.User, productionLineID uint, action string) (PermissionCellExplanation, error) {
+	lineIDs := []uint{productionLineID}
+	roleID := uint(0)
+	if user.RoleID != nil {
+		roleID = *user.RoleID
+	}
+	roleKey := strings.TrimSpace(user.Role)
+
+	var layers []PermissionExplainLayer
+	userRules, err := loadSubjectRules(tx, models.PermissionSubjectUser, user.ID, "", lineIDs)
+	if err != nil {
+		return PermissionCellExplanation{}, err
+	}
+	layers = append(layers, explainRuleLayer(models.PermissionSubjectUser, userRules, action, user.ID, ""))
+
+	var departmentChain []uint
+	if user.DepartmentID != nil {
+		departmentChain, err = DepartmentAncestors(tx, *user.DepartmentID)
+		if err != nil {
+			return PermissionCellExplanation{}, err
+		}
+		layer, err := explainDepartmentLayer(tx, models.PermissionSubjectDepartment, departmentChain, lineIDs, action)
+		if err != nil {
+			return PermissionCellExplanation{}, err
+		}
+		layers = append(layers, layer)
+	} else {
+		layers = append(layers, skippedExplainLayer(models.PermissionSubjectDepartment, "用户未分配部门"))
+	}
+
+	roleRules, err := loadSubjectRules(tx, models.PermissionSubjectRole, roleID, roleKey, lineIDs)
+	if err != nil {
+		return PermissionCellExplanation{}, err
+	}
+	layers = append(layers, explainRuleLayer(models.PermissionSubjectRole, roleRules, action, roleID, roleKey))
+
+	if roleID > 0 {
+		var defaults []models.PermissionRule
+		if err := activePermissionRules(tx, time.Now()).Where("subject_type = ? AND subject_id = ? AND subject_key = '' AND resource_type = ? AND resource_id = 0 AND action = ?",
+			"role_default", roleID, models.PermissionResourceProductionLine, action).Order("id ASC").Find(&defaults).Error; err != nil {
+			return PermissionCellExplanation{}, err
+		}
+		layers = append(layers, explainRuleLayer("role_default", defaults, action, roleID, ""))
+	} else {
+		layers = append(layers, skippedExplainLayer("role_default", "用户未关联角色记录"))
+	}
+
+	if user.DepartmentID != nil {
+		layer, err := explainDepartmentLayer(tx, models.PermissionSubjectDepartmentDefault, departmentChain, lineIDs, action)
+		if err != nil {
+			return PermissionCellExplanation{}, err
+		}
+		layers = append(layers, layer)
+	} else {
+		layers = append(layers, skippedExplainLayer(models.PermissionSubjectDepartmentDefault, "用户未分配部门"))
+	}
+
+	cell := PermissionCellExplanation{Action: action, Effective: models.PermissionDecisionDeny, Source: "system_default", SourceLabel: sourceLabel("system_default"), Layers: layers}
+	decided := false
+	for i := range cell.Layers {
+		layer := &cell.Layers[i]
+		if layer.Decision == "" {
+			continue
+		}
+		if decided {
+			layer.Outcome = ExplainOutcomeShadowed
+			continue
+		}
+		decided = true
+		layer.Outcome = ExplainOutcomeDecided
+		cell.Effective = layer.Decision
+		cell.Source = layer.Layer
+		for _, candidate := range layer.Candidates {
+			// 角色层中按角色名保存的全局规则按角色默认展示，与权限矩阵一致
+			if candidate.Selected && layer.Layer == models.PermissionSubjectRole && candidate.SubjectID == 0 && candidate.SubjectKey != "" {
+				cell.Source = "role_default"
+			}
+		}
+		cell.SourceLabel = sourceLabel(cell.Source)
+	}
+	return cell, nil
+}
+
+// explainDepartmentLayer 按部门链（自身在前）列出部门或部门默认规则，最近一个有规则的部门决定本层结果。
+func explainDepartmentLayer(tx *gorm.DB, layerType string, chain []uint, lineIDs []uint, action string) (PermissionExplainLayer, error) {
+	result := PermissionExplainLayer{Layer: layerType, Label: sourceLabel(layerType), Outcome: ExplainOutcomeNoMatch, Candidates: []PermissionExplainCandidate{}}
+	for depth, departmentID := range chain {
+		rules, err := loadSubjectRules(tx, layerType, departmentID, "", lineIDs)
+		if err != nil {
+			return result, err
+		}
+		layer := explainRuleLayer(layerType, rules, action, departmentID, "")
+		for _, candidate := range layer.Candidates {
+			if depth > 0 {
+				candidate.Note = fmt.Sprintf("继承自上级部门 #%d", departmentID)
+			}
+			if result.Decision != "" {
+				candidate.Selected = false
+			}
+			result.Candidates = append(result.Candidates, candidate)
+		}
+		if result.Decision == "" && layer.Decision != "" {
+			result.Decision = layer.Decision
+			result.Note = layer.Note
+			if depth > 0 {
+				result.Note = fmt.Sprintf("本部门没有规则，取上级部门 #%d 的规则", departmentID)
+			}
+		}
+	}
+	return result, nil
+}
+
+func skippedExplainLayer(layer, note string) PermissionExplainLayer {
+	return PermissionExplainLayer{Layer: layer, Label: sourceLabel(layer), Outcome: ExplainOutcomeSkipped, Note: note, Candidates: []PermissionExplainCandidate{}}
+}
+
+// explainRuleLayer 列出本层对 action 的全部候选规则，并按 loadRuleMap 的规则选出本层结果：
+// 范围越精确 rank 越高，同 rank 取最早的规则。
+func explainRuleLayer(layer string, rules []models.PermissionRule, action string, subjectID uint, subjectKey string) PermissionExplainLayer {
+	result := PermissionExplainLayer{Layer: layer, Label: sourceLabel(layer), Outcome: ExplainOutcomeNoMatch, Candidates: []PermissionExplainCandidate{}}
+	selected := -1
+	for _, rule := range rules {
+		if rule.Action != action {
+			continue
+		}
+		rank := 0
+		if layer != "role_default" {
+			rank = ruleScopeRank(rule, subjectID, subjectKey)
+		}
+		result.Candidates = append(result.Candidates, PermissionExplainCandidate{
+			RuleID:      rule.ID,
+			SubjectType: rule.SubjectType,
+			SubjectID:   rule.SubjectID,
+			SubjectKey:  rule.SubjectKey,
+			ResourceID:  rule.ResourceID,
+			Decision:    rule.Decision,
+			ValidUntil:  rule.ValidUntil,
+			Rank:        rank,
+			Note:        explainCandidateNote(layer, rule, rank),
+		})
+		if selected < 0 || rank > result.Candidates[selected].Rank {
+			selected = len(result.Candidates) - 1
+		}
+	}
+	if selected >= 0 {
+		result.Candidates[selected].Selected = true
+		result.Decision = result.Candidates[selected].Decision
+		if len(result.Candidates) > 1 {
+			result.Note = "本层有多条规则，取匹配范围最精确的一条"
+		}
+	}
+	return result
+}
+
+func explainCandidateNote(layer string, rule models.PermissionRule, rank int) string {
+	switch layer {
+	case "role_default":
+		return "角色全局默认，适用于所有产线"
+	case models.PermissionSubjectRole:
+		switch rank {
+		case 3:
+			return "按角色ID和角色名匹配"
+		case 2:
+			return "按角色ID匹配"
+		case 1:
+			return "按角色名匹配的全局规则"
+		}
+	}
+	return fmt.Sprintf("%s #%d 的规则", sourceLabel(layer), rule.SubjectID)
+}
+
+// ExplainFunctionPermission 按 loadFunctionPermissions 的顺序解释用户是否拥有功能权限码：
+// 用户覆盖优先于角色功能权限，两者都没有时拒绝。
+func ExplainFunctionPermission(tx *gorm.DB, user models.User, code string) (PermissionExplanation, error) {
+	e := newPermissionExplanation(user, "function")
+	e.Code = code
+
+	var permission models.Permission
+	if err := tx.Where("code = ?", code).First(&permission).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return e, ErrUnknownPermissionCode
+		}
+		return e, err
+	}
+
+	overrideLayer := PermissionExplainLayer{Layer: "user_override", Label: "用户功能权限覆盖", Outcome: ExplainOutcomeNoMatch, Candidates: []PermissionExplainCandidate{}}
+	var overrides []models.UserPermissionOverride
+	if err := tx.Where("user_id = ? AND permission_id = ?", user.ID, permission.ID).Find(&overrides).Error; err != nil {
+		return e, err
+	}
+	for _, override := range overrides {
+		overrideLayer.Decision = decisionOf(override.Granted)
+		overrideLayer.Candidates = append(overrideLayer.Candidates, PermissionExplainCandidate{
+			RuleID: override.ID, SubjectType: models.PermissionSubjectUser, SubjectID: user.ID,
+			Decision: overrideLayer.Decision, Selected: true, Note: "用户单独授予或拒绝该功能",
+		})
+	}
+
+	roleLayer := PermissionExplainLayer{Layer: "role_permission", Label: "角色功能权限", Outcome: ExplainOutcomeNoMatch, Candidates: []PermissionExplainCandidate{}}
+	if user.RoleID == nil {
+		roleLayer.Outcome = ExplainOutcomeSkipped
+		roleLayer.Note = "用户未关联角色记录"
+	} else {
+		var grants []models.RolePermission
+		if err := tx.Where("role_id = ? AND permission_id = ?", *user.RoleID, permission.ID).Find(&grants).Error; err != nil {
+			return e, err
+		}
+		for _, grant := range grants {
+			roleLayer.Decision = models.PermissionDecisionAllow
+			roleLayer.Candidates = append(roleLayer.Candidates, PermissionExplainCandidate{
+				RuleID: grant.ID, SubjectType: models.PermissionSubjectRole, SubjectID: grant.RoleID,
+				Decision: models.PermissionDecisionAllow, Selected: true, Note: "角色拥有该功能",
+			})
+		}
+	}
+
+	switch {
+	case overrideLayer.Decision != "":
+		overrideLayer.Outcome = ExplainOutcomeDecided
+		if roleLayer.Decision != "" {
+			roleLayer.Outcome = ExplainOutcomeShadowed
+		}
+	case roleLayer.Decision != "":
+		roleLayer.Outcome = ExplainOutcomeDecided
+	}
+	e.Layers = []PermissionExplainLayer{overrideLayer, roleLayer}
+
+	if explainUserPrecheck(&e, user) {
+		return e, nil
+	}
+	switch {
+	case overrideLayer.Decision != "":
+		allowed := overrideLayer.Decision == models.PermissionDecisionAllow
+		e.step("用户对 %s 有单独覆盖：%s", code, decisionLabel(overrideLayer.Decision))
+		e.conclude(allowed, "user_override", fmt.Sprintf("用户功能权限覆盖%s %s", decisionLabel(overrideLayer.Decision), permission.Name))
+	case roleLayer.Decision != "":
+		e.step("用户没有单独覆盖，角色 %s 拥有 %s", user.Role, code)
+		e.conclude(true, "role_permission", fmt.Sprintf("角色拥有功能 %s", permission.Name))
+	default:
+		e.step("用户没有单独覆盖，角色也未分配 %s", code)
+		e.conclude(false, "system_default", fmt.Sprintf("未被授予功能 %s", permission.Name))
+	}
+	return e, nil
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"crane-system/models"
+	"testing"
+)
+
+func TestExplainLinePermissionTracesEveryLayer(t *testing.T) {
+	db := openPermissionServiceTestDB(t)
+	departmentID := uint(3)
+	role := models.Role{ID: 5, Name: "operator", Status: "active"}
+	user := models.User{ID: 9, Name: "Alice", EmployeeID: "U009", Role: role.Name, RoleID: &role.ID, Password: "x", DepartmentID: &departmentID, Status: "active"}
+	line := models.ProductionLine{ID: 21, Name: "总装线"}
+	for _, row := range []any{&models.Department{ID: departmentID, Name: "制造部"}, &role, &user, &line} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatalf("seed %T: %v", row, err)
+		}
+	}
+	rules := []models.PermissionRule{
+		{SubjectType: models.PermissionSubjectRole, SubjectID: 0, SubjectKey: role.Name, Action: models.PermissionActionView, Decision: models.PermissionDecisionAllow},
+		{SubjectType: models.PermissionSubjectRole, SubjectID: role.ID, SubjectKey: role.Name, Action: models.PermissionActionView, Decision: models.PermissionDecisionDeny},
+		{SubjectType: models.PermissionSubjectDepartment, SubjectID: departmentID, Action: models.PermissionActionView, Decision: models.PermissionDecisionAllow},
+		{SubjectType: models.PermissionSubjectDepartmentDefault, SubjectID: departmentID, Action: models.PermissionActionManage, Decision: models.PermissionDecisionAllow},
+	}
+	for i := range rules {
+		rules[i].ResourceType = models.PermissionResourceProductionLine
+		rules[i].ResourceID = line.ID
+		if err := db.Create(&rules[i]).Error; err != nil {
+			t.Fatalf("seed rule: %v", err)
+		}
+	}
+
+	// 部门规则先于角色规则生效，角色层中按 ID+角色名的拒绝优先于按角色名的允许
+	explanation, err := ExplainLinePermission(db, user, line.ID, models.PermissionActionView)
+	if err != nil {
+		t.Fatalf("explain: %v", err)
+	}
+	view := explanation.Cells[0]
+	if !explanation.Allowed || view.Effective != models.PermissionDecisionAllow || view.Source != models.PermissionSubjectDepartment {
+		t.Fatalf("view explanation = %+v", explanation)
+	}
+	outcomes := map[string]string{}
+	for _, layer := range view.Layers {
+		outcomes[layer.Layer] = layer.Outcome
+	}
+	if outcomes["user"] != ExplainOutcomeNoMatch || outcomes["department"] != ExplainOutcomeDecided || outcomes["role"] != ExplainOutcomeShadowed || outcomes["department_default"] != ExplainOutcomeNoMatch {
+		t.Fatalf("layer outcomes = %v", outcomes)
+	}
+	roleLayer := view.Layers[2]
+	if len(roleLayer.Candidates) != 2 || roleLayer.Decision != models.PermissionDecisionDeny || !roleLayer.Candidates[1].Selected || roleLayer.Candidates[1].Rank != 3 {
+		t.Fatalf("role layer = %+v", roleLayer)
+	}
+
+	// 与实际权限解析结果一致
+	resolved, err := ResolveUserProductionLinePermissions(user, []models.ProductionLine{line})
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	for _, cell := range explanation.Cells {
+		if actual := resolved[0].Cells[cell.Action]; actual.Effective != cell.Effective || actual.Source != cell.Source {
+			t.Fatalf("%s: explain %s/%s, resolve %s/%s", cell.Action, cell.Effective, cell.Source, actual.Effective, actual.Source)
+		}
+	}
+
+	// upload 没有规则，但部门默认允许 manage，manage 隐含 upload
+	explanation, err = ExplainLinePermission(db, user, line.ID, models.PermissionActionUpload)
+	if err != nil {
+		t.Fatalf("explain upload: %v", err)
+	}
+	if !explanation.Allowed || explanation.DecidedBy != models.PermissionSubjectDepartmentDefault || explanation.Cells[0].Source != "system_default" {
+		t.Fatalf("upload explanation = %+v", explanation)
+	}
+
+	// 单独设置的拒绝覆盖部门允许；manage 也需拒绝，否则仍隐含 view
+	if err := SavePermissionRuleChanges(PermissionSubject{Type: models.PermissionSubjectUser, ID: user.ID}, []PermissionRuleChange{
+		{ResourceID: line.ID, Action: models.PermissionActionView, Decision: models.PermissionDecisionDeny},
+		{ResourceID: line.ID, Action: models.PermissionActionManage, Decision: models.PermissionDecisionDeny},
+	}); err != nil {
+		t.Fatalf("save user rule: %v", err)
+	}
+	explanation, err = ExplainLinePermission(db, user, line.ID, models.PermissionActionView)
+	if err != nil {
+		t.Fatalf("explain after deny: %v", err)
+	}
+	if explanation.Allowed || explanation.DecidedBy != models.PermissionSubjectUser || explanation.Cells[0].Layers[1].Outcome != ExplainOutcomeShadowed {
+		t.Fatalf("denied explanation = %+v", explanation)
+	}
+}
+
+func TestExplainLinePermissionFollowsDepartmentAncestors(t *testing.T) {
+	db := openPermissionServiceTestDB(t)
+	workshopID, teamID := uint(4), uint(5)
+	user := models.User{ID: 11, Name: "Bob", EmployeeID: "U011", Role: "viewer", Password: "x", DepartmentID: &teamID, Status: "active"}
+	line := models.ProductionLine{ID: 22, Name: "焊装线"}
+	for _, row := range []any{&models.Department{ID: workshopID, Name: "焊接车间"}, &models.Department{ID: teamID, Name: "A线班组", ParentID: &workshopID}, &user, &line} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatalf("seed %T: %v", row, err)
+		}
+	}
+	if err := SavePermissionRuleChanges(PermissionSubject{Type: models.PermissionSubjectDepartment, ID: workshopID}, []PermissionRuleChange{
+		{ResourceID: line.ID, Action: models.PermissionActionView, Decision: models.PermissionDecisionAllow},
+	}); err != nil {
+		t.Fatalf("save workshop rule: %v", err)
+	}
+
+	explanation, err := ExplainLinePermission(db, user, line.ID, models.PermissionActionView)
+	if err != nil {
+		t.Fatalf("explain: %v", err)
+	}
+	departmentLayer := explanation.Cells[0].Layers[1]
+	if !explanation.Allowed || explanation.DecidedBy != models.PermissionSubjectDepartment || departmentLayer.Outcome != ExplainOutcomeDecided {
+		t.Fatalf("inherited explanation = %+v", explanation)
+	}
+	if len(departmentLayer.Candidates) != 1 || departmentLayer.Candidates[0].SubjectID != workshopID || !departmentLayer.Candidates[0].Selected {
+		t.Fatalf("department layer = %+v", departmentLayer)
+	}
+
+	// 班组自己的拒绝比车间的允许更近
+	if err := SavePermissionRuleChanges(PermissionSubject{Type: models.PermissionSubjectDepartment, ID: teamID}, []PermissionRuleChange{
+		{ResourceID: line.ID, Action: models.PermissionActionView, Decision: models.PermissionDecisionDeny},
+	}); err != nil {
+		t.Fatalf("save team rule: %v", err)
+	}
+	explanation, err = ExplainLinePermission(db, user, line.ID, models.PermissionActionView)
+	if err != nil {
+		t.Fatalf("explain after team deny: %v", err)
+	}
+	departmentLayer = explanation.Cells[0].Layers[1]
+	if explanation.Allowed || len(departmentLayer.Candidates) != 2 || !departmentLayer.Candidates[0].Selected || departmentLayer.Candidates[1].Selected {
+		t.Fatalf("team deny explanation = %+v", explanation)
+	}
+	resolved, err := ResolveUserProductionLinePermissions(user, []models.ProductionLine{line})
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if resolved[0].CanView {
+		t.Fatal("resolution should agree with explanation")
+	}
+}
+
+func TestExplainFunctionPermissionPrefersUserOverride(t *testing.T) {
+	db := openPermissionServiceTestDB(t)
+	role := models.Role{ID: 5, Name: "operator", Status: "active"}
+	user := models.User{ID: 9, Name: "Alice", EmployeeID: "U009", Role: role.Name, RoleID: &role.ID, Password: "x", Status: "active"}
+	permission := models.Permission{ID: 7, Code: "op:file_upload", Name: "上传文件", Type: "operation"}
+	for _, row := range []any{&role, &user, &permission, &models.RolePermission{RoleID: role.ID, PermissionID: permission.ID}} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatalf("seed %T: %v", row, err)
+		}
+	}
+
+	explanation, err := ExplainFunctionPermission(db, user, permission.Code)
+	if err != nil || !explanation.Allowed || explanation.DecidedBy != "role_permission" {
+		t.Fatalf("role grant = %+v, err = %v", explanation, err)
+	}
+
+	if err := db.Create(&models.UserPermissionOverride{UserID: user.ID, PermissionID: permission.ID, Granted: false}).Error; err != nil {
+		t.Fatalf("seed override: %v", err)
+	}
+	explanation, err = ExplainFunctionPermission(db, user, permission.Code)
+	if err != nil || explanation.Allowed || explanation.DecidedBy != "user_override" || explanation.Layers[1].Outcome != ExplainOutcomeShadowed {
+		t.Fatalf("override = %+v, err = %v", explanation, err)
+	}
+	if UserHasPermission(user.ID, permission.Code) != explanation.Allowed {
+		t.Fatalf("explanation disagrees with UserHasPermission")
+	}
+
+	if _, err := ExplainFunctionPermission(db, user, "op:missing"); err != ErrUnknownPermissionCode {
+		t.Fatalf("unknown code err = %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"crane-system/database"
+	"crane-system/models"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// PermissionGrant 是一条规则及其主体、资源名称，用于临时授权和程序规则列表。
+type PermissionGrant struct {
+	models.PermissionRule
+	SubjectName  string `json:"subject_name"`
+	ResourceName string `json:"resource_name"`
+}
+
+// ExpiringPermissionGrants 返回 within 内将要到期的临时规则，按到期时间排序。
+func ExpiringPermissionGrants(now time.Time, within time.Duration) ([]PermissionGrant, error) {
+	var rules []models.PermissionRule
+	if err := database.DB.Where("valid_until > ? AND valid_until <= ?", now, now.Add(within)).
+		Order("valid_until ASC, id ASC").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return describePermissionRules(rules)
+}
+
+// describePermissionRules 为规则补充主体名称和资源（产线、车型或程序）名称。
+func describePermissionRules(rules []models.PermissionRule) ([]PermissionGrant, error) {
+	grants := make([]PermissionGrant, 0, len(rules))
+	if len(rules) == 0 {
+		return grants, nil
+	}
+
+	userIDs, departmentIDs, roleIDs := []uint{}, []uint{}, []uint{}
+	resourceIDs := map[string][]uint{}
+	for _, rule := range rules {
+		switch rule.SubjectType {
+		case models.PermissionSubjectUser:
+			userIDs = append(userIDs, rule.SubjectID)
+		case models.PermissionSubjectDepartment, models.PermissionSubjectDepartmentDefault:
+			departmentIDs = append(departmentIDs, rule.SubjectID)
+		case models.PermissionSubjectRole:
+			roleIDs = append(roleIDs, rule.SubjectID)
+		}
+		resourceIDs[rule.ResourceType] = append(resourceIDs[rule.ResourceType], rule.ResourceID)
+	}
+	var users []models.User
+	var departments []models.Department
+	var roles []models.Role
+	var lines []models.ProductionLine
+	if err := database.DB.Select("id", "name").Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Select("id", "name").Where("id IN ?", departmentIDs).Find(&departments).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Select("id", "name").Where("id IN ?", roleIDs).Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Select("id", "name").Where("id IN ?", resourceIDs[models.PermissionResourceProductionLine]).Find(&lines).Error; err != nil {
+		return nil, err
+	}
+	modelNames, err := vehicleModelNames(database.DB, resourceIDs[models.PermissionResourceVehicleModel])
+	if err != nil {
+		return nil, err
+	}
+	names, err := programNames(database.DB, resourceIDs[models.PermissionResourceProgram])
+	if err != nil {
+		return nil, err
+	}
+	userNames, departmentNames, roleNames, lineNames := map[uint]string{}, map[uint]string{}, map[uint]string{}, map[uint]string{}
+	for _, user := range users {
+		userNames[user.ID] = user.Name
+	}
+	for _, department := range departments {
+		departmentNames[department.ID] = department.Name
+	}
+	for _, role := range roles {
+		roleNames[role.ID] = role.Name
+	}
+	for _, line := range lines {
+		lineNames[line.ID] = line.Name
+	}
+	resourceNames := map[string]map[uint]string{
+		models.PermissionResourceProductionLine: lineNames,
+		models.PermissionResourceVehicleModel:   modelNames,
+		models.PermissionResourceProgram:        names,
+	}
+
+	for _, rule := range rules {
+		grant := PermissionGrant{PermissionRule: rule, ResourceName: resourceNames[rule.ResourceType][rule.ResourceID]}
+		switch rule.SubjectType {
+		case models.PermissionSubjectUser:
+			grant.SubjectName = userNames[rule.SubjectID]
+		case models.PermissionSubjectDepartment, models.PermissionSubjectDepartmentDefault:
+			grant.SubjectName = departmentNames[rule.SubjectID]
+		case models.PermissionSubjectRole:
+			grant.SubjectName = rule.SubjectKey
+			if grant.SubjectName == "" {
+				grant.SubjectName = roleNames[rule.SubjectID]
+			}
+		}
+		grants = append(grants, grant)
+	}
+	return grants, nil
+}
+
+// ExpirePermissionRules 删除到期的临时规则，让受影响用户的权限缓存失效并写入审计日志。
+// 以条件删除判断归属，多实例同时清理时每条规则只由一个实例处理。
+func ExpirePermissionRules(now time.Time) ([]models.PermissionRule, error) {
+	var candidates []models.PermissionRule
+	if err := database.DB.Where("valid_until IS NOT NULL AND valid_until <= ?", now).Order("id ASC").Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	expired := make([]models.PermissionRule, 0, len(candidates))
+	invalidateAll := false
+	for _, rule := range candidates {
+		result := database.DB.Unscoped().Where("id = ? AND valid_until <= ?", rule.ID, now).Delete(&models.PermissionRule{})
+		if result.Error != nil {
+			return expired, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		expired = append(expired, rule)
+		if rule.SubjectType == models.PermissionSubjectUser {
+			InvalidateUserCache(rule.SubjectID)
+		} else {
+			invalidateAll = true
+		}
+		if _, err := AppendAuditLog(models.AuditLog{
+			ActorName:    "system",
+			Action:       "EXPIRE permission_rule",
+			ResourceType: "permission_rule",
+			ResourceID:   fmt.Sprintf("%s:%d:%s", rule.SubjectType, rule.SubjectID, rule.SubjectKey),
+			StatusCode:   http.StatusOK,
+			Before:       MarshalAuditSnapshot(rule),
+		}); err != nil {
+			slog.Error("写入权限到期审计日志失败", "rule_id", rule.ID, "error", err)
+		}
+	}
+	if invalidateAll {
+		InvalidateAllCache()
+	}
+	return expired, nil
+}
+
+// ProgramOverride 是一个带有程序规则的程序及其全部未到期的程序规则。
+type ProgramOverride struct {
+	ProgramID          uint              `json:"program_id"`
+	ProgramName        string            `json:"program_name"`
+	ProgramCode        string            `json:"program_code"`
+	ProductionLineID   uint              `json:"production_line_id"`
+	ProductionLineName string            `json:"production_line_name"`
+	VehicleModelID     uint              `json:"vehicle_model_id"`
+	VehicleModelName   string            `json:"vehicle_model_name"`
+	Rules              []PermissionGrant `json:"rules"`
+}
+
+// ListProgramOverrides 列出所有带有未到期程序规则的程序，按程序 ID 排序。
+func ListProgramOverrides(now time.Time) ([]ProgramOverride, error) {
+	var rules []models.PermissionRule
+	if err := database.DB.Where("resource_type = ? AND (valid_until IS NULL OR valid_until > ?)", models.PermissionResourceProgram, now).
+		Order("resource_id ASC, subject_type ASC, subject_id ASC, action ASC").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	overrides := []ProgramOverride{}
+	if len(rules) == 0 {
+		return overrides, nil
+	}
+	grants, err := describePermissionRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	programIDs := make([]uint, 0, len(rules))
+	for _, rule := range rules {
+		programIDs = append(programIDs, rule.ResourceID)
+	}
+	var programs []models.Program
+	if err := database.DB.Preload("ProductionLine").Preload("VehicleModel").Where("id IN ?", programIDs).Order("id ASC").Find(&programs).Error; err != nil {
+		return nil, err
+	}
+	indexByProgram := make(map[uint]int, len(programs))
+	for _, program := range programs {
+		indexByProgram[program.ID] = len(overrides)
+		overrides = append(overrides, ProgramOverride{
+			ProgramID:          program.ID,
+			ProgramName:        program.Name,
+			ProgramCode:        program.Code,
+			ProductionLineID:   program.ProductionLineID,
+			ProductionLineName: program.ProductionLine.Name,
+			VehicleModelID:     program.VehicleModelID,
+			VehicleModelName:   program.VehicleModel.Name,
+			Rules:              []PermissionGrant{},
+		})
+	}
+	for _, grant := range grants {
+		if index, ok := indexByProgram[grant.ResourceID]; ok {
+			overrides[index].Rules = append(overrides[index].Rules, grant)
+		}
+	}
+	return overrides, nil
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"crane-system/models"
+	"testing"
+	"time"
+)
+
+func TestTemporaryPermissionGrantsExpire(t *testing.T) {
+	db := openPermissionServiceTestDB(t)
+	contractor := models.User{ID: 9, Name: "Alice", EmployeeID: "U009", Role: "operator", Password: "x", Status: "active"}
+	line := models.ProductionLine{ID: 21, Name: "总装线"}
+	for _, row := range []any{&contractor, &line} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatalf("seed %T: %v", row, err)
+		}
+	}
+
+	now := time.Now()
+	until := now.Add(time.Hour)
+	later := now.Add(2 * time.Hour)
+	subject := PermissionSubject{Type: models.PermissionSubjectUser, ID: contractor.ID}
+	if err := SavePermissionRuleChanges(subject, []PermissionRuleChange{
+		{ResourceID: line.ID, Action: models.PermissionActionUpload, Decision: models.PermissionDecisionAllow, ValidUntil: &until},
+		{ResourceID: line.ID, Action: models.PermissionActionView, Decision: models.PermissionDecisionAllow, ValidFrom: &until, ValidUntil: &later},
+	}); err != nil {
+		t.Fatalf("save grants: %v", err)
+	}
+	if !UserHasLinePermission(contractor.ID, line.ID, models.PermissionActionUpload) {
+		t.Fatalf("temporary upload grant not effective")
+	}
+	if UserHasLinePermission(contractor.ID, line.ID, models.PermissionActionView) {
+		t.Fatalf("grant effective before valid_from")
+	}
+	// 缓存不应越过最近一次规则生效或到期的时间
+	if perms, err := GetUserPermissions(contractor.ID); err != nil || perms.ExpiresAt.After(until) {
+		t.Fatalf("cache expires at %v, want <= %v (err %v)", perms.ExpiresAt, until, err)
+	}
+
+	grants, err := ExpiringPermissionGrants(now, 90*time.Minute)
+	if err != nil {
+		t.Fatalf("list expiring: %v", err)
+	}
+	if len(grants) != 1 || grants[0].Action != models.PermissionActionUpload || grants[0].SubjectName != contractor.Name || grants[0].ResourceName != line.Name {
+		t.Fatalf("expiring grants = %+v", grants)
+	}
+
+	if err := SavePermissionRuleChanges(subject, []PermissionRuleChange{
+		{ResourceID: line.ID, Action: models.PermissionActionManage, Decision: models.PermissionDecisionAllow, ValidUntil: &now},
+	}); err == nil {
+		t.Fatalf("expected past valid_until to be rejected")
+	}
+
+	expired, err := ExpirePermissionRules(until.Add(time.Second))
+	if err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+	if len(expired) != 1 || expired[0].Action != models.PermissionActionUpload {
+		t.Fatalf("expired = %+v", expired)
+	}
+	if UserHasLinePermission(contractor.ID, line.ID, models.PermissionActionUpload) {
+		t.Fatalf("expired grant still cached")
+	}
+	var audits []models.AuditLog
+	if err := db.Where("action = ?", "EXPIRE permission_rule").Find(&audits).Error; err != nil || len(audits) != 1 {
+		t.Fatalf("expiry audit = %+v, err %v", audits, err)
+	}
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"crane-system/database"
+	"crane-system/models"
+	"errors"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// PermissionDeltaLine 是一个用户在一条产线（或产线上某车型、某程序）上的实际可用动作变化，动作包含 manage 隐含的动作。
+type PermissionDeltaLine struct {
+	ProductionLineID   uint     `json:"production_line_id"`
+	ProductionLineName string   `json:"production_line_name"`
+	VehicleModelID     uint     `json:"vehicle_model_id,omitempty"`
+	VehicleModelName   string   `json:"vehicle_model_name,omitempty"`
+	ProgramID          uint     `json:"program_id,omitempty"`
+	ProgramName        string   `json:"program_name,omitempty"`
+	Before             []string `json:"before"`
+	After              []string `json:"after"`
+	Gained             []string `json:"gained"`
+	Lost               []string `json:"lost"`
+}
+
+type PermissionDeltaUser struct {
+	UserID     uint                  `json:"user_id"`
+	Name       string                `json:"name"`
+	EmployeeID string                `json:"employee_id"`
+	Role       string                `json:"role"`
+	Lines      []PermissionDeltaLine `json:"lines"`
+}
+
+// PermissionSimulation 是规则变更的模拟结果：EvaluatedUsers 为受该主体规则影响的候选用户数，
+// Users 只列出实际权限发生变化的用户。
+type PermissionSimulation struct {
+	SubjectType    string                `json:"subject_type"`
+	SubjectID      uint                  `json:"subject_id,omitempty"`
+	SubjectKey     string                `json:"subject_key,omitempty"`
+	EvaluatedUsers int                   `json:"evaluated_users"`
+	AffectedUsers  int                   `json:"affected_users"`
+	LostActions    int                   `json:"lost_actions"`
+	GainedActions  int                   `json:"gained_actions"`
+	Users          []PermissionDeltaUser `json:"users"`
+}
+
+// errSimulationRollback 让模拟事务总是回滚。
+var errSimulationRollback = errors.New("simulation rollback")
+
+// SimulatePermissionRuleChanges 在事务中应用规则变更，比较受影响用户在涉及产线上的实际权限后回滚，不保存任何变更。
+func SimulatePermissionRuleChanges(subject PermissionSubject, changes []PermissionRuleChange) (PermissionSimulation, error) {
+	subject = normalizeSubject(subject)
+	simulation := PermissionSimulation{SubjectType: subject.Type, SubjectID: subject.ID, SubjectKey: subject.Key, Users: []PermissionDeltaUser{}}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		lines, err := simulationLines(tx, changes)
+		if err != nil {
+			return err
+		}
+		users, err := subjectCandidateUsers(tx, subject)
+		if err != nil {
+			return err
+		}
+		simulation.EvaluatedUsers = len(users)
+		before, err := effectiveLineActionsByUser(tx, users, lines)
+		if err != nil {
+			return err
+		}
+		if err := SavePermissionRuleChangesTx(tx, subject, changes); err != nil {
+			return err
+		}
+		after, err := effectiveLineActionsByUser(tx, users, lines)
+		if err != nil {
+			return err
+		}
+
+		modelIDs, programIDs := simulationResourceIDs(before, after)
+		modelNames, err := vehicleModelNames(tx, modelIDs)
+		if err != nil {
+			return err
+		}
+		names, err := programNames(tx, programIDs)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			delta := PermissionDeltaUser{UserID: user.ID, Name: user.Name, EmployeeID: user.EmployeeID, Role: user.Role}
+			for _, line := range lines {
+				for _, key := range simulationLineScopes(before[user.ID], after[user.ID], line.ID) {
+					beforeActions := scopedActions(before[user.ID], key)
+					afterActions := scopedActions(after[user.ID], key)
+					gained := actionDifference(afterActions, beforeActions)
+					lost := actionDifference(beforeActions, afterActions)
+					if len(gained) == 0 && len(lost) == 0 {
+						continue
+					}
+					simulation.GainedActions += len(gained)
+					simulation.LostActions += len(lost)
+					delta.Lines = append(delta.Lines, PermissionDeltaLine{
+						ProductionLineID:   line.ID,
+						ProductionLineName: line.Name,
+						VehicleModelID:     key.ModelID,
+						VehicleModelName:   modelNames[key.ModelID],
+						ProgramID:          key.ProgramID,
+						ProgramName:        names[key.ProgramID],
+						Before:             beforeActions,
+						After:              afterActions,
+						Gained:             gained,
+						Lost:               lost,
+					})
+				}
+			}
+			if len(delta.Lines) > 0 {
+				simulation.Users = append(simulation.Users, delta)
+			}
+		}
+		simulation.AffectedUsers = len(simulation.Users)
+		return errSimulationRollback
+	})
+	if errors.Is(err, errSimulationRollback) {
+		err = nil
+	}
+	return simulation, err
+}
+
+// simulationLines 返回变更涉及的产线；不限产线的车型规则涉及所有产线，程序规则涉及程序所在产线。
+func simulationLines(tx *gorm.DB, changes []PermissionRuleChange) ([]models.ProductionLine, error) {
+	ids := make([]uint, 0, len(changes))
+	seen := map[uint]struct{}{}
+	for _, change := range changes {
+		lineID := change.ResourceID
+		if change.ResourceType == models.PermissionResourceProgram {
+			var program models.Program
+			if err := tx.Select("id", "production_line_id").First(&program, change.ResourceID).Error; err != nil {
+				return nil, err
+			}
+			lineID = program.ProductionLineID
+		}
+		if change.ResourceType == models.PermissionResourceVehicleModel {
+			if change.ProductionLineID == 0 {
+				var lines []models.ProductionLine
+				err := tx.Select("id", "name").Order("id ASC").Find(&lines).Error
+				return lines, err
+			}
+			lineID = change.ProductionLineID
+		}
+		if _, ok := seen[lineID]; ok {
+			continue
+		}
+		seen[lineID] = struct{}{}
+		ids = append(ids, lineID)
+	}
+	var lines []models.ProductionLine
+	if len(ids) == 0 {
+		return lines, nil
+	}
+	err := tx.Select("id", "name").Where("id IN ?", ids).Order("id ASC").Find(&lines).Error
+	return lines, err
+}
+
+// programScopeKey 标识产线、产线上的某车型或某程序：ModelID 和 ProgramID 都为 0 表示产线本身，
+// ProgramID 非 0 时 ModelID 是程序所属车型。
+type programScopeKey struct {
+	LineID    uint
+	ModelID   uint
+	ProgramID uint
+}
+
+// scopedActions 依次按程序、车型、产线查找动作，没有更具体的规则时沿用上一级。
+func scopedActions(actions map[programScopeKey][]string, key programScopeKey) []string {
+	if scoped, ok := actions[key]; ok {
+		return scoped
+	}
+	if scoped, ok := actions[programScopeKey{LineID: key.LineID, ModelID: key.ModelID}]; ok {
+		return scoped
+	}
+	return actions[programScopeKey{LineID: key.LineID}]
+}
+
+// simulationLineScopes 返回产线本身以及变更前后在该产线上有车型或程序规则的范围。
+func simulationLineScopes(before, after map[programScopeKey][]string, lineID uint) []programScopeKey {
+	keys := []programScopeKey{{LineID: lineID}}
+	seen := map[programScopeKey]bool{keys[0]: true}
+	for _, actions := range []map[programScopeKey][]string{before, after} {
+		for key := range actions {
+			if key.LineID == lineID && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ModelID != keys[j].ModelID {
+			return keys[i].ModelID < keys[j].ModelID
+		}
+		return keys[i].ProgramID < keys[j].ProgramID
+	})
+	return keys
+}
+
+func simulationResourceIDs(results ...map[uint]map[programScopeKey][]string) ([]uint, []uint) {
+	seenModels := map[uint]bool{}
+	seenPrograms := map[uint]bool{}
+	modelIDs := []uint{}
+	programIDs := []uint{}
+	for _, byUser := range results {
+		for _, actions := range byUser {
+			for key := range actions {
+				if key.ModelID != 0 && !seenModels[key.ModelID] {
+					seenModels[key.ModelID] = true
+					modelIDs = append(modelIDs, key.ModelID)
+				}
+				if key.ProgramID != 0 && !seenPrograms[key.ProgramID] {
+					seenPrograms[key.ProgramID] = true
+					programIDs = append(programIDs, key.ProgramID)
+				}
+			}
+		}
+	}
+	return modelIDs, programIDs
+}
+
+// subjectCandidateUsers 返回可能受该主体规则影响的启用用户；角色同时按角色 ID 和角色名匹配，
+// 与 loadSubjectRules 的匹配范围一致，是否真正受影响由前后对比决定。
+func subjectCandidateUsers(tx *gorm.DB, subject PermissionSubject) ([]models.User, error) {
+	query := tx.Where("status = ?", "active").Order("id ASC")
+	switch subject.Type {
+	case models.PermissionSubjectUser:
+		query = query.Where("id = ?", subject.ID)
+	case models.PermissionSubjectDepartment, models.PermissionSubjectDepartmentDefault:
+		// 部门规则向下继承，下级部门的用户同样可能受影响
+		departmentIDs, err := DepartmentDescendants(tx, subject.ID)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("department_id IN ?", departmentIDs)
+	case models.PermissionSubjectRole:
+		switch {
+		case subject.ID > 0 && subject.Key != "":
+			query = query.Where("role_id = ? OR role = ?", subject.ID, subject.Key)
+		case subject.ID > 0:
+			query = query.Where("role_id = ?", subject.ID)
+		default:
+			query = query.Where("role = ?", subject.Key)
+		}
+	default:
+		return nil, errors.New("invalid subject type")
+	}
+	var users []models.User
+	err := query.Find(&users).Error
+	return users, err
+}
+
+// effectiveLineActionsByUser 按 CheckProgramScopeAction 的语义计算用户在各产线及有车型、程序规则的范围上实际可执行的动作。
+func effectiveLineActionsByUser(tx *gorm.DB, users []models.User, lines []models.ProductionLine) (map[uint]map[programScopeKey][]string, error) {
+	result := make(map[uint]map[programScopeKey][]string, len(users))
+	for _, user := range users {
+		byLine := make(map[programScopeKey][]string, len(lines))
+		result[user.ID] = byLine
+		if IsSystemAdminRole(user.Role) {
+			for _, line := range lines {
+				byLine[programScopeKey{LineID: line.ID}] = append([]string(nil), permissionActions...)
+			}
+			continue
+		}
+		managed := map[uint]bool{}
+		if user.Role == "line_admin" {
+			var assignments []models.LineAdminAssignment
+			if err := tx.Where("user_id = ?", user.ID).Find(&assignments).Error; err != nil {
+				return nil, err
+			}
+			for _, assignment := range assignments {
+				managed[assignment.ProductionLineID] = true
+			}
+		}
+		resolved, err := resolveUserProductionLinePermissionsTx(tx, user, lines)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range resolved {
+			byLine[programScopeKey{LineID: line.ProductionLineID}] = allowedActions(managed[line.ProductionLineID], line.CanView, line.CanDownload, line.CanUpload, line.CanManage)
+			if managed[line.ProductionLineID] {
+				continue
+			}
+			for _, model := range line.VehicleModels {
+				key := programScopeKey{LineID: line.ProductionLineID, ModelID: model.VehicleModelID}
+				byLine[key] = allowedActions(false, model.CanView, model.CanDownload, model.CanUpload, model.CanManage)
+			}
+			for _, program := range line.Programs {
+				key := programScopeKey{LineID: line.ProductionLineID, ModelID: program.VehicleModelID, ProgramID: program.ProgramID}
+				byLine[key] = allowedActions(false, program.CanView, program.CanDownload, program.CanUpload, program.CanManage)
+			}
+		}
+	}
+	return result, nil
+}
+
+func allowedActions(managed, canView, canDownload, canUpload, canManage bool) []string {
+	actions := []string{}
+	for _, action := range permissionActions {
+		if managed || LinePermissionAllowsAction(canView, canDownload, canUpload, canManage, LineAction(action)) {
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
+
+func actionDifference(actions, minus []string) []string {
+	result := []string{}
+	for _, action := range actions {
+		found := false
+		for _, other := range minus {
+			if other == action {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, action)
+		}
+	}
+	return result
+}
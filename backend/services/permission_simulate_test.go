@@ -0,0 +1,64 @@
+package services
+
+import (
+	"crane-system/models"
+	"reflect"
+	"testing"
+)
+
+func TestSimulatePermissionRuleChangesReportsDeltaWithoutSaving(t *testing.T) {
+	db := openPermissionServiceTestDB(t)
+	departmentID := uint(3)
+	operator := models.User{ID: 9, Name: "Alice", EmployeeID: "U009", Role: "operator", Password: "x", DepartmentID: &departmentID, Status: "active"}
+	overridden := models.User{ID: 10, Name: "Bob", EmployeeID: "U010", Role: "operator", Password: "x", DepartmentID: &departmentID, Status: "active"}
+	admin := models.User{ID: 11, Name: "Admin", EmployeeID: "U011", Role: "admin", Password: "x", DepartmentID: &departmentID, Status: "active"}
+	line := models.ProductionLine{ID: 21, Name: "总装线"}
+	for _, row := range []any{&models.Department{ID: departmentID, Name: "制造部"}, &operator, &overridden, &admin, &line} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatalf("seed %T: %v", row, err)
+		}
+	}
+	existing := []models.PermissionRule{
+		{SubjectType: models.PermissionSubjectDepartmentDefault, SubjectID: departmentID, Action: models.PermissionActionView, Decision: models.PermissionDecisionAllow},
+		{SubjectType: models.PermissionSubjectUser, SubjectID: overridden.ID, Action: models.PermissionActionUpload, Decision: models.PermissionDecisionDeny},
+	}
+	for i := range existing {
+		existing[i].ResourceType = models.PermissionResourceProductionLine
+		existing[i].ResourceID = line.ID
+		if err := db.Create(&existing[i]).Error; err != nil {
+			t.Fatalf("seed rule: %v", err)
+		}
+	}
+
+	// 部门默认由仅查看改为上传：Alice 失去查看并获得上传（上传不隐含查看），Bob 的个人拒绝使上传不生效
+	subject := PermissionSubject{Type: models.PermissionSubjectDepartmentDefault, ID: departmentID}
+	simulation, err := SimulatePermissionRuleChanges(subject, []PermissionRuleChange{
+		{ResourceID: line.ID, Action: models.PermissionActionView, Decision: "unset"},
+		{ResourceID: line.ID, Action: models.PermissionActionUpload, Decision: models.PermissionDecisionAllow},
+	})
+	if err != nil {
+		t.Fatalf("simulate: %v", err)
+	}
+	if simulation.EvaluatedUsers != 3 || simulation.AffectedUsers != 2 {
+		t.Fatalf("simulation = %+v", simulation)
+	}
+	alice, bob := simulation.Users[0], simulation.Users[1]
+	if alice.UserID != operator.ID || !reflect.DeepEqual(alice.Lines[0].Gained, []string{models.PermissionActionUpload}) || !reflect.DeepEqual(alice.Lines[0].Lost, []string{models.PermissionActionView}) {
+		t.Fatalf("alice delta = %+v", alice)
+	}
+	if bob.UserID != overridden.ID || len(bob.Lines[0].Gained) != 0 || !reflect.DeepEqual(bob.Lines[0].Lost, []string{models.PermissionActionView}) {
+		t.Fatalf("bob delta = %+v", bob)
+	}
+
+	// 模拟不保存规则
+	var rules []models.PermissionRule
+	if err := db.Where("subject_type = ?", models.PermissionSubjectDepartmentDefault).Find(&rules).Error; err != nil {
+		t.Fatalf("load rules: %v", err)
+	}
+	if len(rules) != 1 || rules[0].Action != models.PermissionActionView {
+		t.Fatalf("rules after simulate = %+v", rules)
+	}
+	if !UserHasLinePermission(operator.ID, line.ID, string(LineActionView)) {
+		t.Fatalf("simulation changed effective permission")
+	}
+}
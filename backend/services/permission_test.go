@@ -30,6 +30,8 @@ func openPermissionServiceTestDB(t *testing.T) *gorm.DB {
 		&models.UserPermissionOverride{},
 		&models.LineAdminAssignment{},
 		&models.PermissionRule{},
+		&models.VehicleModel{},
+		&models.AuditLog{},
 	); err != nil {
 		t.Fatalf("migrate service test db: %v", err)
 	}
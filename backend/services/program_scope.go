@@ -0,0 +1,216 @@
+package services
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ProgramScope 是用户可访问程序的范围：LineIDs 中的产线整体可访问，但 DeniedModels 中的车型除外；
+// GrantedModels 是在未授权产线上按车型放行的车型。程序规则最后覆盖：DeniedPrograms 中的程序总是排除，
+// GrantedPrograms（程序 → 产线）中的程序总是放行。nil 表示不限制（系统管理员）。
+type ProgramScope struct {
+	LineIDs         map[uint]struct{}
+	DeniedModels    map[uint]map[uint]struct{}
+	GrantedModels   map[uint]map[uint]struct{}
+	DeniedPrograms  map[uint]struct{}
+	GrantedPrograms map[uint]uint
+}
+
+// ResolveAuthorizedProgramScope 计算用户执行 action 时可访问的程序范围，产线管理员所管理的产线整体放行。
+func ResolveAuthorizedProgramScope(userID uint, role string, action LineAction) (*ProgramScope, AuthDecision) {
+	lineIDs, decision := ResolveAuthorizedLineIDs(userID, role, action)
+	if !decision.Allowed || lineIDs == nil {
+		return nil, decision
+	}
+	perms, err := GetUserPermissions(userID)
+	if err != nil {
+		return nil, Deny(http.StatusInternalServerError, "查询权限失败")
+	}
+	managed := map[uint]bool{}
+	if role == "line_admin" {
+		for _, lineID := range perms.ManagedLineIDs {
+			managed[lineID] = true
+		}
+	}
+
+	scope := &ProgramScope{
+		LineIDs:         lineIDs,
+		DeniedModels:    map[uint]map[uint]struct{}{},
+		GrantedModels:   map[uint]map[uint]struct{}{},
+		DeniedPrograms:  map[uint]struct{}{},
+		GrantedPrograms: map[uint]uint{},
+	}
+	for lineID, byModel := range perms.ModelPermissions {
+		if managed[lineID] {
+			continue
+		}
+		_, lineAllowed := lineIDs[lineID]
+		for modelID, lp := range byModel {
+			allowed := LinePermissionAllowsAction(lp.CanView, lp.CanDownload, lp.CanUpload, lp.CanManage, action)
+			switch {
+			case lineAllowed && !allowed:
+				addScopeModel(scope.DeniedModels, lineID, modelID)
+			case !lineAllowed && allowed:
+				addScopeModel(scope.GrantedModels, lineID, modelID)
+			}
+		}
+	}
+	for programID, pp := range perms.ProgramPermissions {
+		if managed[pp.ProductionLineID] {
+			continue
+		}
+		baseAllowed := scope.allowsModel(pp.ProductionLineID, pp.VehicleModelID)
+		allowed := LinePermissionAllowsAction(pp.CanView, pp.CanDownload, pp.CanUpload, pp.CanManage, action)
+		switch {
+		case baseAllowed && !allowed:
+			scope.DeniedPrograms[programID] = struct{}{}
+		case !baseAllowed && allowed:
+			scope.GrantedPrograms[programID] = pp.ProductionLineID
+		}
+	}
+	return scope, Allow()
+}
+
+func addScopeModel(target map[uint]map[uint]struct{}, lineID, modelID uint) {
+	if target[lineID] == nil {
+		target[lineID] = map[uint]struct{}{}
+	}
+	target[lineID][modelID] = struct{}{}
+}
+
+// Allows 判断程序是否在范围内，programID 为 0 时只按产线和车型判断。
+func (s *ProgramScope) Allows(programID, lineID, modelID uint) bool {
+	if s == nil {
+		return true
+	}
+	if _, denied := s.DeniedPrograms[programID]; denied && programID != 0 {
+		return false
+	}
+	if _, granted := s.GrantedPrograms[programID]; granted && programID != 0 {
+		return true
+	}
+	return s.allowsModel(lineID, modelID)
+}
+
+func (s *ProgramScope) allowsModel(lineID, modelID uint) bool {
+	if _, ok := s.LineIDs[lineID]; ok {
+		_, denied := s.DeniedModels[lineID][modelID]
+		return !denied
+	}
+	_, granted := s.GrantedModels[lineID][modelID]
+	return granted
+}
+
+// RestrictLines 把范围收窄到 lineIDs 中的产线，nil 范围（不限制）收窄为这些产线整体。
+func (s *ProgramScope) RestrictLines(lineIDs map[uint]struct{}) *ProgramScope {
+	if s == nil {
+		return &ProgramScope{LineIDs: intersectLineIDs(nil, lineIDs)}
+	}
+	restricted := &ProgramScope{
+		LineIDs:         intersectLineIDs(s.LineIDs, lineIDs),
+		DeniedModels:    s.DeniedModels,
+		GrantedModels:   map[uint]map[uint]struct{}{},
+		DeniedPrograms:  s.DeniedPrograms,
+		GrantedPrograms: map[uint]uint{},
+	}
+	for lineID, modelIDs := range s.GrantedModels {
+		if _, ok := lineIDs[lineID]; ok {
+			restricted.GrantedModels[lineID] = modelIDs
+		}
+	}
+	for programID, lineID := range s.GrantedPrograms {
+		if _, ok := lineIDs[lineID]; ok {
+			restricted.GrantedPrograms[programID] = lineID
+		}
+	}
+	return restricted
+}
+
+// Empty 表示范围内没有任何程序。
+func (s *ProgramScope) Empty() bool {
+	return s != nil && len(s.LineIDs) == 0 && len(s.GrantedModels) == 0 && len(s.GrantedPrograms) == 0
+}
+
+// VisibleLineIDs 返回范围内至少有一个车型可访问的产线，按 ID 排序。
+func (s *ProgramScope) VisibleLineIDs() []uint {
+	if s == nil {
+		return nil
+	}
+	lineIDs := make(map[uint]struct{}, len(s.LineIDs)+len(s.GrantedModels))
+	for lineID := range s.LineIDs {
+		lineIDs[lineID] = struct{}{}
+	}
+	for lineID := range s.GrantedModels {
+		lineIDs[lineID] = struct{}{}
+	}
+	for _, lineID := range s.GrantedPrograms {
+		lineIDs[lineID] = struct{}{}
+	}
+	return sortedScopeIDs(lineIDs)
+}
+
+// Apply 把范围追加为程序查询的条件；prefix 为程序表的列前缀，如 "programs."。
+func (s *ProgramScope) Apply(query *gorm.DB, prefix string) *gorm.DB {
+	if s == nil {
+		return query
+	}
+	lineColumn := prefix + "production_line_id"
+	modelColumn := prefix + "vehicle_model_id"
+	conditions := []string{}
+	args := []any{}
+	if len(s.LineIDs) > 0 {
+		condition := lineColumn + " IN ?"
+		args = append(args, sortedScopeIDs(s.LineIDs))
+		for _, lineID := range sortedScopeKeys(s.DeniedModels) {
+			if _, ok := s.LineIDs[lineID]; !ok {
+				continue
+			}
+			condition += " AND NOT (" + lineColumn + " = ? AND " + modelColumn + " IN ?)"
+			args = append(args, lineID, sortedScopeIDs(s.DeniedModels[lineID]))
+		}
+		conditions = append(conditions, "("+condition+")")
+	}
+	for _, lineID := range sortedScopeKeys(s.GrantedModels) {
+		conditions = append(conditions, "("+lineColumn+" = ? AND "+modelColumn+" IN ?)")
+		args = append(args, lineID, sortedScopeIDs(s.GrantedModels[lineID]))
+	}
+	base := "1 = 0"
+	if len(conditions) > 0 {
+		base = strings.Join(conditions, " OR ")
+	}
+	idColumn := prefix + "id"
+	if len(s.DeniedPrograms) > 0 {
+		base = "(" + base + ") AND " + idColumn + " NOT IN ?"
+		args = append(args, sortedScopeIDs(s.DeniedPrograms))
+	}
+	if len(s.GrantedPrograms) > 0 {
+		granted := make(map[uint]struct{}, len(s.GrantedPrograms))
+		for programID := range s.GrantedPrograms {
+			granted[programID] = struct{}{}
+		}
+		base = "(" + base + ") OR " + idColumn + " IN ?"
+		args = append(args, sortedScopeIDs(granted))
+	}
+	return query.Where("("+base+")", args...)
+}
+
+func sortedScopeIDs(ids map[uint]struct{}) []uint {
+	result := make([]uint, 0, len(ids))
+	for id := range ids {
+		result = append(result, id)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+func sortedScopeKeys(ids map[uint]map[uint]struct{}) []uint {
+	result := make([]uint, 0, len(ids))
+	for id := range ids {
+		result = append(result, id)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
@@ -17,6 +17,14 @@ func UploadDir() string {
 	return config.AppConfig.Storage.UploadsDir
 }
 
+func BatchImportDir() string {
+	return config.AppConfig.Storage.BatchImportDir
+}
+
+func ReportDir() string {
+	return config.AppConfig.Report.Dir
+}
+
 // 文件存储结构相关工具函数
 
 // SanitizeFilename 清理文件名，移除不安全字符
@@ -347,6 +347,43 @@ const SystemManagement = () => {
     }
   };
 
+  type JobState = { status: string; error_message?: string };
+
+  // 优先通过进度流等待任务结束，浏览器不支持或连接失败时改为轮询
+  const streamJob = (jobId: number): Promise<JobState | null> =>
+    new Promise((resolve) => {
+      if (typeof EventSource === 'undefined') {
+        resolve(null);
+        return;
+      }
+      const source = new EventSource(
+        `${api.defaults.baseURL}/jobs/${jobId}/events`,
+        { withCredentials: true },
+      );
+      source.addEventListener('done', (event) => {
+        source.close();
+        resolve(JSON.parse((event as MessageEvent).data));
+      });
+      source.onerror = () => {
+        source.close();
+        resolve(null);
+      };
+    });
+
+  const waitForJob = async (jobId: number): Promise<JobState> => {
+    const streamed = await streamJob(jobId);
+    if (streamed) {
+      return streamed;
+    }
+    for (;;) {
+      const response = await api.get(`/jobs/${jobId}`);
+      if (!['queued', 'running'].includes(response.data.status)) {
+        return response.data;
+      }
+      await new Promise((resolve) => setTimeout(resolve, 2000));
+    }
+  };
+
   const createBackup = async (type: string) => {
     setOperationLoading(type);
     try {
@@ -358,9 +395,15 @@ const SystemManagement = () => {
             : '/backup/full';
 
       const response = await api.post(endpoint);
-      message.success(response.data.message);
+      message.info(response.data.message);
 
-      // 刷新备份列表
+      // 备份在后台任务中执行，等待任务结束后刷新列表
+      const job = await waitForJob(response.data.job_id);
+      if (job.status === 'completed') {
+        message.success('备份完成');
+      } else {
+        message.error(job.error_message || '备份失败');
+      }
       await loadBackups();
     } catch (error: any) {
       console.error(`Failed to create ${type} backup:`, error);